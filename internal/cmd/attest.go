@@ -20,30 +20,131 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"sigs.k8s.io/release-utils/util"
 
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/lockfile"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/driver"
 	"sigs.k8s.io/tejolote/pkg/watcher"
 )
 
 type attestOptions struct {
 	waitForBuild     bool
 	sign             bool
+	signingKey       string
 	continueExisting string
 	vcsurl           string
 	encodedExisting  string
 	encodedSnapshots string
+	baselineSnapshot string
+	groupVariants    bool
+	subjectNames     string
+	predicateCommand string
 	artifacts        []string
+	dependencySBOMs  []string
+	dependencyRefs   bool
+	lockfiles        []string
+	latest           bool
+	subjects         []string
+	missingDigest    string
+	watchTimeout     time.Duration
+	requestTimeout   time.Duration
+	snapshotMaxAge   time.Duration
+	maxRetries       int
+	linkSBOMs        bool
+	sbomMapping      map[string]string
+	annotations      []string
+	validUntil       string
+	noNativeStore    bool
+	tolerateErrors   bool
+	include          []string
+	exclude          []string
+	onlyIfChanged    bool
+	compareTo        string
+	outputType       string
+	linkName         string
+	summaryPath      string
+	dryRun           bool
+	recordRemoved    bool
 }
 
+// Types of documents the attest subcommand can output
+const (
+	outputTypeAttestation = "attestation"
+	outputTypeLink        = "link"
+)
+
 func (o *attestOptions) Verify() error {
 	if o.encodedExisting != "" && o.continueExisting != "" {
 		return errors.New("only --encoded-existing or --continue can be set at a time")
 	}
+	if _, err := attestation.GetSubjectTransformer(o.subjectNames); err != nil {
+		return fmt.Errorf("checking subject names: %w", err)
+	}
+	if o.missingDigest != "" && !slices.Contains(watcher.MissingDigestPolicies(), o.missingDigest) {
+		return fmt.Errorf(
+			"invalid --on-missing-digest value %q (available: %s)",
+			o.missingDigest, strings.Join(watcher.MissingDigestPolicies(), ", "),
+		)
+	}
+	if o.requestTimeout < 0 {
+		return errors.New("--request-timeout cannot be negative")
+	}
+	if o.snapshotMaxAge < 0 {
+		return errors.New("--snapshot-max-age cannot be negative")
+	}
+	if o.maxRetries < 0 {
+		return errors.New("--max-retries cannot be negative")
+	}
+	if o.recordRemoved && o.baselineSnapshot == "" {
+		return errors.New("--record-removed needs a --baseline-snapshot to compare to")
+	}
+	if _, err := parseValidUntil(o.validUntil, time.Now()); err != nil {
+		return fmt.Errorf("checking --valid-until: %w", err)
+	}
+	for _, subject := range o.subjects {
+		if _, err := attestation.ParseSubject(subject); err != nil {
+			return fmt.Errorf("checking subjects: %w", err)
+		}
+	}
+	for _, a := range o.annotations {
+		if _, _, err := attestation.ParseAnnotation(a); err != nil {
+			return fmt.Errorf("checking annotations: %w", err)
+		}
+	}
+	filter := run.PathFilter{Include: o.include, Exclude: o.exclude}
+	if err := filter.Validate(); err != nil {
+		return fmt.Errorf("checking --include and --exclude: %w", err)
+	}
+	if o.signingKey != "" && !o.sign {
+		return errors.New("--signing-key requires --sign")
+	}
+	switch o.outputType {
+	case outputTypeAttestation:
+	case outputTypeLink:
+		if o.sign {
+			return errors.New("--sign is not supported with links, sign them with the in-toto tools")
+		}
+		if o.onlyIfChanged {
+			return errors.New("--only-if-changed is not supported with links")
+		}
+		if o.summaryPath != "" {
+			return errors.New("--summary is not supported with links")
+		}
+	default:
+		return fmt.Errorf("invalid --output-type value %q (available: %s, %s)", o.outputType, outputTypeAttestation, outputTypeLink)
+	}
 	return nil
 }
 
@@ -53,7 +154,7 @@ func addAttest(parentCmd *cobra.Command) {
 
 	attestCmd := &cobra.Command{
 		Short: "Attest to a build system run",
-		Long: `tejolote attest buildsys://build-run/identifier
+		Long: `tejolote attest buildsys://build-run/identifier [buildsys://build-run/identifier...]
 	
 The run subcommand os tejolote executes a process intended to
 transform files. Generally this happens as part of a build, patching
@@ -62,12 +163,17 @@ or cloning repositories.
 Tejolote will monitor for changes that occurred during the command
 execution and will attest to them to generate provenance data of
 where they came from.
+
+When more than one spec URL is passed, the artifacts of all the runs
+are attested in a single statement. The builder and invocation of
+each additional run are listed in the mergedRuns key of the
+invocation environment.
 	
 	`,
 		Use:               "attest",
 		SilenceUsage:      false,
 		PersistentPreRunE: initLogging,
-		RunE: func(_ *cobra.Command, args []string) (err error) {
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
 			if len(args) == 0 {
 				return errors.New("build run spec URL not specified")
 			}
@@ -76,33 +182,91 @@ where they came from.
 				return fmt.Errorf("verifying options: %w", err)
 			}
 
-			w, err := watcher.New(args[0])
-			if err != nil {
-				return fmt.Errorf("building watcher")
+			if err := outputOpts.Validate(); err != nil {
+				return fmt.Errorf("verifying output options: %w", err)
 			}
 
-			w.Builder.VCSURL = attestOpts.vcsurl
+			// Without --compare-to, reruns compare to the previous output
+			if attestOpts.compareTo == "" {
+				attestOpts.compareTo = outputOpts.OutputPath
+			}
+			if attestOpts.onlyIfChanged && attestOpts.compareTo == "" {
+				return errors.New("--only-if-changed needs an attestation to compare to in --compare-to or --output")
+			}
 
-			w.Options.WaitForBuild = attestOpts.waitForBuild
-			if !attestOpts.waitForBuild {
-				logrus.Warn("watcher will not wait for build, data may be incomplete")
+			// Set the limits before the drivers create their clients
+			httpclient.DefaultOptions.RequestTimeout = attestOpts.requestTimeout
+			httpclient.DefaultOptions.MaxRetries = attestOpts.maxRetries
+			driver.DefaultGCSOptions.Retries = attestOpts.maxRetries
+
+			specURLs := append([]string{}, args...)
+			if attestOpts.latest {
+				for i := range specURLs {
+					specURLs[i], err = withLatestQuery(specURLs[i])
+					if err != nil {
+						return fmt.Errorf("adding latest flag to spec URL: %w", err)
+					}
+				}
+			}
+			if len(specURLs) > 1 && (attestOpts.dryRun || attestOpts.outputType == outputTypeLink) {
+				return errors.New("only one spec URL can be attested in dry runs and link outputs")
 			}
 
-			// Add artifact monitors to the watcher
-			for _, uri := range attestOpts.artifacts {
-				if err := w.AddArtifactSource(uri); err != nil {
-					return fmt.Errorf("adding artifacts source: %w", err)
+			opts := watcher.DefaultAttestationOptions
+			opts.SpecURLs = specURLs
+			opts.Artifacts = attestOpts.artifacts
+			opts.VCSURL = attestOpts.vcsurl
+			opts.ValidUntil, err = parseValidUntil(attestOpts.validUntil, time.Now())
+			if err != nil {
+				return fmt.Errorf("parsing --valid-until: %w", err)
+			}
+			opts.BaselineSnapshot = attestOpts.baselineSnapshot
+			opts.Sign = attestOpts.sign
+			opts.SigningKey = attestOpts.signingKey
+			opts.Format = outputOpts.Format
+
+			opts.Watcher.WaitForBuild = attestOpts.waitForBuild
+			opts.Watcher.GroupCompressionVariants = attestOpts.groupVariants
+			opts.Watcher.SubjectTransformer = attestOpts.subjectNames
+			opts.Watcher.PredicateTransform = strings.Fields(attestOpts.predicateCommand)
+			opts.Watcher.DependencySBOMs = attestOpts.dependencySBOMs
+			opts.Watcher.DependencyExternalRefs = attestOpts.dependencyRefs
+			opts.Watcher.Lockfiles = attestOpts.lockfiles
+			opts.Watcher.MissingDigestPolicy = attestOpts.missingDigest
+			opts.Watcher.WatchTimeout = attestOpts.watchTimeout
+			opts.Watcher.LinkSBOMs = attestOpts.linkSBOMs
+			opts.Watcher.SBOMMapping = attestOpts.sbomMapping
+			opts.Watcher.Annotations = parseAnnotations(attestOpts.annotations)
+			opts.Watcher.DisableNativeStore = attestOpts.noNativeStore
+			opts.Watcher.TolerateStoreErrors = attestOpts.tolerateErrors
+			opts.Watcher.Filter = run.PathFilter{Include: attestOpts.include, Exclude: attestOpts.exclude}
+			opts.Watcher.SnapshotMaxAge = attestOpts.snapshotMaxAge
+			opts.Watcher.LinkName = attestOpts.linkName
+			opts.Watcher.RecordRemoved = attestOpts.recordRemoved
+			for _, subject := range attestOpts.subjects {
+				s, err := attestation.ParseSubject(subject)
+				if err != nil {
+					return fmt.Errorf("parsing subject: %w", err)
 				}
+				opts.Watcher.Subjects = append(opts.Watcher.Subjects, s)
+			}
+			if !attestOpts.waitForBuild {
+				logrus.Warn("watcher will not wait for build, data may be incomplete")
 			}
 
-			// Get the run from the build system
-			r, err := w.GetRun(args[0])
+			// Get the runs from the build systems
+			ar, err := watcher.NewAttestationRun(opts)
 			if err != nil {
-				return fmt.Errorf("fetching run: %w", err)
+				return err
+			}
+			w, r := ar.Watcher, ar.Run
+
+			if attestOpts.dryRun {
+				return dryRun(w, r)
 			}
 
 			// Watch the run run :)
-			if err := w.Watch(r); err != nil {
+			if err := ar.Watch(cmd.Context()); err != nil {
 				return fmt.Errorf("generating attestation: %w", err)
 			}
 
@@ -111,6 +275,7 @@ where they came from.
 				if err != nil {
 					return fmt.Errorf("marshallling encoded attestation: %w", err)
 				}
+				defer os.Remove(f.Name())
 				defer f.Close()
 				decodedAtt, err := base64.StdEncoding.DecodeString(attestOpts.encodedExisting)
 				if err != nil {
@@ -127,6 +292,7 @@ where they came from.
 				if err != nil {
 					return fmt.Errorf("marshallling encoded snapshots: %w", err)
 				}
+				defer os.Remove(f.Name())
 				defer f.Close()
 				decodedSnaps, err := base64.StdEncoding.DecodeString(attestOpts.encodedSnapshots)
 				if err != nil {
@@ -138,35 +304,41 @@ where they came from.
 				outputOpts.SnapshotStatePath = f.Name()
 			}
 
-			if err = w.LoadAttestation(attestOpts.continueExisting); err != nil {
-				return fmt.Errorf("loading previous attestation")
+			ar.Options.ContinueFrom = attestOpts.continueExisting
+			snapshotsPath := outputOpts.FinalSnapshotStatePath(attestOpts.continueExisting)
+			if watcher.IsRemoteLocation(snapshotsPath) || util.Exists(snapshotsPath) {
+				ar.Options.SnapshotsPath = snapshotsPath
 			}
-
-			if util.Exists(outputOpts.FinalSnapshotStatePath(attestOpts.continueExisting)) {
-				if err := w.LoadSnapshots(
-					outputOpts.FinalSnapshotStatePath(attestOpts.continueExisting),
-				); err != nil {
-					return fmt.Errorf("loading storage snapshots: %w", err)
-				}
+			if err := ar.Load(); err != nil {
+				return err
 			}
 
-			if err := w.CollectArtifacts(r); err != nil {
-				return fmt.Errorf("while collecting run artifacts: %w", err)
+			if attestOpts.outputType == outputTypeLink {
+				return writeLink(w, r, outputOpts)
 			}
 
-			attestation, err := w.AttestRun(r)
+			att, err := ar.Attest()
 			if err != nil {
-				return fmt.Errorf("generating run attestation: %w", err)
+				return err
 			}
 
-			var json []byte
-
-			if attestOpts.sign {
-				json, err = attestation.Sign()
-			} else {
-				json, err = attestation.ToJSON()
+			if attestOpts.onlyIfChanged {
+				unchanged, err := subjectsUnchanged(att, attestOpts.compareTo)
+				if err != nil {
+					return fmt.Errorf("comparing to %s: %w", attestOpts.compareTo, err)
+				}
+				if unchanged {
+					logrus.Infof("Artifacts unchanged since %s, not emitting an attestation", attestOpts.compareTo)
+					if attestOpts.summaryPath != "" {
+						summary := w.Summary(r, att)
+						summary.Unchanged = true
+						return summary.Write(attestOpts.summaryPath)
+					}
+					return nil
+				}
 			}
 
+			json, err := ar.Encode()
 			if err != nil {
 				return fmt.Errorf("serializing attestation: %w", err)
 			}
@@ -175,10 +347,15 @@ where they came from.
 				if err := os.WriteFile(outputOpts.OutputPath, json, os.FileMode(0o644)); err != nil {
 					return fmt.Errorf("writing attestation file: %w", err)
 				}
-				return nil
+			} else {
+				fmt.Println(string(json))
 			}
 
-			fmt.Println(string(json))
+			if attestOpts.summaryPath != "" {
+				summary := w.Summary(r, att)
+				summary.Signed = attestOpts.sign
+				return summary.Write(attestOpts.summaryPath)
+			}
 			return nil
 		},
 	}
@@ -189,7 +366,7 @@ where they came from.
 		&attestOpts.continueExisting,
 		"continue",
 		"",
-		"path to a previously started attestation to continue",
+		"path or URL (file://, gs://, https:// or oci://, optionally prefixed with intoto+) of a previously started attestation to continue",
 	)
 
 	attestCmd.PersistentFlags().BoolVar(
@@ -199,12 +376,92 @@ where they came from.
 		"sign the attestation",
 	)
 
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.signingKey,
+		"signing-key",
+		"",
+		"path to a cosign private key or KMS key reference to sign with instead of keyless signing",
+	)
+
 	attestCmd.PersistentFlags().StringSliceVar(
 		&attestOpts.artifacts,
 		"artifacts",
 		[]string{},
 		"a storage URL to monitor for files",
 	)
+
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.outputType,
+		"output-type",
+		outputTypeAttestation,
+		fmt.Sprintf(
+			"document to output: a SLSA provenance %s or an in-toto %s with the artifacts in the snapshot from tejolote start as materials and those added since as products",
+			outputTypeAttestation, outputTypeLink,
+		),
+	)
+
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.linkName,
+		"link-name",
+		watcher.DefaultLinkName,
+		"name of the step recorded in the link with --output-type=link, it must match the step in the in-toto layout",
+	)
+
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.summaryPath,
+		"summary",
+		"",
+		"write a JSON summary of the attestation (subjects, stores queried, build and signing status, timings) to this path, - writes it to STDERR",
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.dryRun,
+		"dry-run",
+		false,
+		"check the run and artifact sources are reachable and print how many artifacts each has, without attesting, signing or writing files",
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.onlyIfChanged,
+		"only-if-changed",
+		false,
+		"do not emit an attestation when its subjects match those of the --compare-to attestation",
+	)
+
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.compareTo,
+		"compare-to",
+		"",
+		"attestation to compare to with --only-if-changed (defaults to the --output file)",
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.noNativeStore,
+		"no-native-store",
+		false,
+		"do not read the artifact stores of the build system, only those in --artifacts",
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.tolerateErrors,
+		"tolerate-store-errors",
+		false,
+		"skip the artifact stores that fail instead of aborting, at least one has to succeed",
+	)
+
+	attestCmd.PersistentFlags().StringArrayVar(
+		&attestOpts.include,
+		"include",
+		[]string{},
+		"glob pattern of the artifact paths to attest, patterns without a slash match the file name (can be repeated)",
+	)
+
+	attestCmd.PersistentFlags().StringArrayVar(
+		&attestOpts.exclude,
+		"exclude",
+		[]string{},
+		"glob pattern of the artifact paths to leave out, takes precedence over --include (can be repeated)",
+	)
 	attestCmd.PersistentFlags().BoolVar(
 		&attestOpts.waitForBuild,
 		"wait",
@@ -230,8 +487,247 @@ where they came from.
 		"encoded snapshots to continue",
 	)
 
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.baselineSnapshot,
+		"baseline-snapshot",
+		"",
+		"path to a snapshot of the artifacts before the build, only artifacts changed since will be attested",
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.recordRemoved,
+		"record-removed",
+		false,
+		"record the artifacts of the --baseline-snapshot deleted by the build in the removedArtifacts field of the invocation environment",
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.groupVariants,
+		"group-compression-variants",
+		false,
+		"annotate compressed and uncompressed forms of the same artifact as a group",
+	)
+
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.subjectNames,
+		"subject-names",
+		attestation.DefaultSubjectTransformer,
+		fmt.Sprintf(
+			"how to name the attestation subjects (%s)",
+			strings.Join(attestation.SubjectTransformerNames(), ", "),
+		),
+	)
+
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.predicateCommand,
+		"predicate-transform",
+		"",
+		"program to post-process the predicate, it reads the predicate JSON from STDIN and writes the result to STDOUT (runs without a shell)",
+	)
+
+	attestCmd.PersistentFlags().StringSliceVar(
+		&attestOpts.dependencySBOMs,
+		"dependency-sbom",
+		[]string{},
+		"path or URL (file://, gs://, https://) of an SPDX SBOM whose packages are recorded as materials",
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.dependencyRefs,
+		"dependency-external-refs",
+		false,
+		"also record the package manager and persistent ID external references of the --dependency-sbom packages as materials",
+	)
+
+	attestCmd.PersistentFlags().StringSliceVar(
+		&attestOpts.lockfiles,
+		"materials-from-lockfile",
+		[]string{},
+		fmt.Sprintf(
+			"path to a lockfile whose locked dependencies are recorded as materials, the format is detected from its name (%s)",
+			strings.Join(lockfile.Types(), ", "),
+		),
+	)
+
+	attestCmd.PersistentFlags().StringArrayVar(
+		&attestOpts.subjects,
+		"subject",
+		[]string{},
+		"subject to add to the attestation in the form name@algorithm:digest, eg app@sha256:abc... (can be repeated)",
+	)
+
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.validUntil,
+		"valid-until",
+		"",
+		"expiry of the provenance recorded in the predicate, an RFC3339 timestamp or a duration from now (eg 2160h)",
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.linkSBOMs,
+		"link-sboms",
+		false,
+		"annotate artifacts with the SBOM collected next to them (eg app.tar.gz.spdx.json describes app.tar.gz)",
+	)
+
+	attestCmd.PersistentFlags().StringToStringVar(
+		&attestOpts.sbomMapping,
+		"sbom",
+		map[string]string{},
+		"artifact=sbom pairs linking artifacts to SBOMs not following the naming convention (implies --link-sboms)",
+	)
+
+	attestCmd.PersistentFlags().StringArrayVar(
+		&attestOpts.annotations,
+		"annotation",
+		[]string{},
+		"key=value annotation recorded in the predicate invocation environment (can be repeated)",
+	)
+
+	attestCmd.PersistentFlags().DurationVar(
+		&attestOpts.watchTimeout,
+		"watch-timeout",
+		0,
+		"maximum time to wait for the run to finish (0 waits forever)",
+	)
+
+	attestCmd.PersistentFlags().DurationVar(
+		&attestOpts.requestTimeout,
+		"request-timeout",
+		httpclient.DefaultOptions.RequestTimeout,
+		"maximum time to wait for a server to answer a request or send more data (0 disables it)",
+	)
+
+	attestCmd.PersistentFlags().DurationVar(
+		&attestOpts.snapshotMaxAge,
+		"snapshot-max-age",
+		0,
+		"fail if the saved storage snapshots are older than this (0 does not check their age)",
+	)
+
+	attestCmd.PersistentFlags().IntVar(
+		&attestOpts.maxRetries,
+		"max-retries",
+		httpclient.DefaultOptions.MaxRetries,
+		"number of times failed network requests are retried",
+	)
+
+	attestCmd.PersistentFlags().StringVar(
+		&attestOpts.missingDigest,
+		"on-missing-digest",
+		"",
+		fmt.Sprintf(
+			"what to do with artifacts without a digest (%s), by default they are recorded without one",
+			strings.Join(watcher.MissingDigestPolicies(), ", "),
+		),
+	)
+
+	attestCmd.PersistentFlags().BoolVar(
+		&attestOpts.latest,
+		"latest",
+		false,
+		"when the spec URL filters builds (eg gcb://project?filter=...), attest the most recent match",
+	)
+
 	_ = attestCmd.PersistentFlags().MarkHidden("encoded-attestation") //nolint: errcheck
 	_ = attestCmd.PersistentFlags().MarkHidden("encoded-snapshots")   //nolint: errcheck
 
 	parentCmd.AddCommand(attestCmd)
 }
+
+// withLatestQuery adds latest=true to the query of a spec URL so
+// drivers that select runs by filter pick the most recent match
+func withLatestQuery(specURL string) (string, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("latest", "true")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// parseValidUntil reads the --valid-until value, either an RFC3339
+// timestamp or a duration added to now. An empty value returns the
+// zero time.
+func parseValidUntil(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, errors.New("duration must be positive")
+		}
+		return now.Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a duration or an RFC3339 timestamp", value)
+	}
+	return t, nil
+}
+
+// subjectsUnchanged returns true if the subjects of the attestation
+// match those of the attestation in the reference file. A missing
+// reference (eg in the first run of a pipeline) is a change.
+func subjectsUnchanged(att *attestation.Attestation, referencePath string) (bool, error) {
+	data, err := os.ReadFile(referencePath)
+	if errors.Is(err, os.ErrNotExist) {
+		logrus.Infof("Reference attestation %s not found", referencePath)
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("reading reference attestation: %w", err)
+	}
+	reference := attestation.New()
+	if err := reference.Decode(data); err != nil {
+		return false, fmt.Errorf("decoding reference attestation: %w", err)
+	}
+	return attestation.SubjectsEqual(att.Subject, reference.Subject), nil
+}
+
+// writeLink snapshots the stores after the run and writes the in-toto
+// link of the changes since the snapshot taken when the run started
+func writeLink(w *watcher.Watcher, r *run.Run, outputOpts *outputOptions) error {
+	if err := w.Snap(); err != nil {
+		return fmt.Errorf("snapshotting artifact stores: %w", err)
+	}
+	link, err := w.ToLink(r)
+	if err != nil {
+		return fmt.Errorf("generating link: %w", err)
+	}
+	data, err := link.Encode(outputOpts.Format)
+	if err != nil {
+		return fmt.Errorf("serializing link: %w", err)
+	}
+	if outputOpts.OutputPath != "" {
+		if err := os.WriteFile(outputOpts.OutputPath, data, os.FileMode(0o644)); err != nil {
+			return fmt.Errorf("writing link file: %w", err)
+		}
+		return nil
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// dryRun refreshes the run and prints the number of artifacts in each
+// of the stores that would be collected. Nothing is written or signed.
+func dryRun(w *watcher.Watcher, r *run.Run) error {
+	stores, err := w.DryRun(r)
+	status := watcher.BuildStatusFailure
+	switch {
+	case r.IsRunning:
+		status = watcher.BuildStatusRunning
+	case r.IsSuccess:
+		status = watcher.BuildStatusSuccess
+	}
+	fmt.Printf("Run %s: %s\n", r.SpecURL, status)
+	for _, s := range stores {
+		fmt.Printf("  %s: %d artifacts\n", s.SpecURL, s.Artifacts)
+	}
+	if err != nil {
+		return fmt.Errorf("dry run: %w", err)
+	}
+	return nil
+}
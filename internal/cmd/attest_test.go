@@ -0,0 +1,63 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+)
+
+func TestSubjectsUnchanged(t *testing.T) {
+	reference := attestation.New().SLSA()
+	reference.Subject = []attestation.Subject{
+		{Name: "tejolote", Digest: common.DigestSet{"SHA256": "aaa"}},
+	}
+	data, err := reference.ToJSON()
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "previous.intoto.json")
+	require.NoError(t, os.WriteFile(path, data, os.FileMode(0o644)))
+
+	// Same artifacts, different predicate
+	att := attestation.New().SLSA()
+	att.Predicate.BuildType = "rerun"
+	att.Subject = []attestation.Subject{
+		{Name: "tejolote", Digest: common.DigestSet{"SHA256": "aaa"}},
+	}
+	unchanged, err := subjectsUnchanged(att, path)
+	require.NoError(t, err)
+	require.True(t, unchanged)
+
+	att.Subject[0].Digest["SHA256"] = "bbb"
+	unchanged, err = subjectsUnchanged(att, path)
+	require.NoError(t, err)
+	require.False(t, unchanged)
+
+	// Without a reference, everything changed
+	unchanged, err = subjectsUnchanged(att, filepath.Join(t.TempDir(), "missing.json"))
+	require.NoError(t, err)
+	require.False(t, unchanged)
+
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), os.FileMode(0o644)))
+	_, err = subjectsUnchanged(att, path)
+	require.Error(t, err)
+}
@@ -17,15 +17,30 @@ limitations under the License.
 package cmd
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/watcher"
 )
 
 type outputOptions struct {
 	OutputPath        string
 	SnapshotStatePath string
 	Workspace         string
+	Format            string
+}
+
+// Validate checks the output options
+func (oo *outputOptions) Validate() error {
+	for _, f := range attestation.OutputFormats() {
+		if oo.Format == f {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown output format %q", oo.Format)
 }
 
 // FinalSnapshotStatePath returns the final path to store/read the storage
@@ -36,7 +51,8 @@ type outputOptions struct {
 func (oo *outputOptions) FinalSnapshotStatePath(defaultSeed string) string {
 	snapshotState := oo.SnapshotStatePath
 	if oo.SnapshotStatePath == "default" {
-		if defaultSeed == "" {
+		// Snapshots of remote attestations have to be set explicitly
+		if defaultSeed == "" || watcher.IsRemoteLocation(defaultSeed) {
 			return ""
 		}
 		snapshotState = strings.TrimSuffix(defaultSeed, ".json") + ".storage-snap.json"
@@ -44,6 +60,18 @@ func (oo *outputOptions) FinalSnapshotStatePath(defaultSeed string) string {
 	return snapshotState
 }
 
+// parseAnnotations returns the key=value annotations as a map,
+// malformed entries are rejected when validating the options
+func parseAnnotations(list []string) map[string]string {
+	annotations := map[string]string{}
+	for _, a := range list {
+		if k, v, err := attestation.ParseAnnotation(a); err == nil {
+			annotations[k] = v
+		}
+	}
+	return annotations
+}
+
 func addOutputFlags(command *cobra.Command) *outputOptions {
 	opts := &outputOptions{}
 	command.PersistentFlags().StringVar(
@@ -56,7 +84,16 @@ func addOutputFlags(command *cobra.Command) *outputOptions {
 		&opts.SnapshotStatePath,
 		"snapshots",
 		"default",
-		"path to store the storage snapshots state",
+		"path to store the storage snapshots state (attest can also read it from a file://, gs://, https:// or oci:// URL)",
+	)
+	command.PersistentFlags().StringVar(
+		&opts.Format,
+		"format",
+		attestation.FormatJSON,
+		fmt.Sprintf(
+			"format of the attestation output (%s)",
+			strings.Join(attestation.OutputFormats(), ", "),
+		),
 	)
 	return opts
 }
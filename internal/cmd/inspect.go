@@ -0,0 +1,147 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+	"sigs.k8s.io/tejolote/pkg/watcher"
+)
+
+type inspectOptions struct {
+	artifacts    []string
+	nativeStores bool
+}
+
+// inspection is the output of the inspect subcommand
+type inspection struct {
+	Run    *run.Run          `json:"run"`
+	Stores []storeInspection `json:"stores,omitempty"`
+}
+
+// storeInspection lists the artifacts a store would snapshot, or the
+// error reading it
+type storeInspection struct {
+	SpecURL   string         `json:"spec"`
+	Artifacts []run.Artifact `json:"artifacts"`
+	Error     string         `json:"error,omitempty"`
+}
+
+// inspectStores reads the artifacts of each store. Errors don't stop
+// the rest of the stores from being read, they are reported in the
+// store output.
+func inspectStores(stores []store.Store) []storeInspection {
+	res := []storeInspection{}
+	for i := range stores {
+		si := storeInspection{SpecURL: stores[i].SpecURL, Artifacts: []run.Artifact{}}
+		artifacts, err := stores[i].ReadArtifacts()
+		if err != nil {
+			si.Error = err.Error()
+		} else {
+			si.Artifacts = artifacts
+		}
+		res = append(res, si)
+	}
+	return res
+}
+
+// writeInspection prints the inspection as indented JSON
+func writeInspection(w io.Writer, i *inspection) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(i); err != nil {
+		return fmt.Errorf("encoding run data: %w", err)
+	}
+	return nil
+}
+
+func addInspect(parentCmd *cobra.Command) {
+	opts := inspectOptions{}
+	inspectCmd := &cobra.Command{
+		Short: "Print the data of a build run as tejolote sees it",
+		Long: `tejolote inspect buildsys://build-run/identifier
+
+The inspect subcommand fetches a run from the build system and prints
+it as JSON: its status, steps, timings and the raw data returned by
+the build system. No artifacts are collected and no predicate is built.
+
+Stores passed in --artifacts, and the native stores of the build system
+with --native-stores, are read and the artifacts they would snapshot
+are listed too. This helps debugging new build system drivers.
+
+	`,
+		Use:               "inspect",
+		SilenceUsage:      false,
+		PersistentPreRunE: initLogging,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return errors.New("build run spec URL not specified")
+			}
+
+			w, err := watcher.New(args[0])
+			if err != nil {
+				return fmt.Errorf("building watcher: %w", err)
+			}
+			for _, uri := range opts.artifacts {
+				if err := w.AddArtifactSource(uri); err != nil {
+					return fmt.Errorf("adding artifacts source: %w", err)
+				}
+			}
+
+			// GetRun does the first refresh of the run data
+			r, err := w.GetRun(args[0])
+			if err != nil {
+				return fmt.Errorf("fetching run: %w", err)
+			}
+
+			stores := append([]store.Store{}, w.ArtifactStores...)
+			if opts.nativeStores {
+				stores = append(stores, w.Builder.ArtifactStores()...)
+			}
+			i := &inspection{Run: r}
+			if len(stores) > 0 {
+				i.Stores = inspectStores(stores)
+			}
+			return writeInspection(os.Stdout, i)
+		},
+	}
+
+	inspectCmd.PersistentFlags().StringSliceVar(
+		&opts.artifacts,
+		"artifacts",
+		[]string{},
+		"a storage URL to list the artifacts of",
+	)
+
+	inspectCmd.PersistentFlags().BoolVar(
+		&opts.nativeStores,
+		"native-stores",
+		false,
+		"also list the artifacts in the native stores of the build system",
+	)
+
+	parentCmd.AddCommand(inspectCmd)
+}
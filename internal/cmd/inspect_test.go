@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+)
+
+func TestInspect(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app"), []byte("binary"), os.FileMode(0o755)))
+
+	stores := []store.Store{}
+	for _, uri := range []string{"file://" + dir, "file://" + filepath.Join(dir, "missing")} {
+		s, err := store.New(uri)
+		require.NoError(t, err)
+		stores = append(stores, s)
+	}
+
+	// Stores that fail are reported without stopping the rest
+	res := inspectStores(stores)
+	require.Len(t, res, 2)
+	require.Empty(t, res[0].Error)
+	require.Len(t, res[0].Artifacts, 1)
+	require.Equal(t, "app", res[0].Artifacts[0].Path)
+	require.NotEmpty(t, res[1].Error)
+	require.Empty(t, res[1].Artifacts)
+
+	var b bytes.Buffer
+	r := &run.Run{SpecURL: "github://org/repo/1", IsSuccess: true, SystemData: map[string]string{"status": "completed"}}
+	require.NoError(t, writeInspection(&b, &inspection{Run: r, Stores: res}))
+	out := struct {
+		Run struct {
+			SpecURL    string
+			IsSuccess  bool
+			SystemData map[string]string
+		} `json:"run"`
+		Stores []storeInspection `json:"stores"`
+	}{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &out))
+	require.Equal(t, r.SpecURL, out.Run.SpecURL)
+	require.True(t, out.Run.IsSuccess)
+	require.Equal(t, "completed", out.Run.SystemData["status"])
+	require.Len(t, out.Stores, 2)
+}
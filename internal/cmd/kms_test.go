@@ -0,0 +1,41 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto"
+	"testing"
+
+	"github.com/sigstore/sigstore/pkg/signature/kms"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKMSProviders(t *testing.T) {
+	require.Subset(t, kms.SupportedProviders(), []string{"gcpkms://", "awskms://", "azurekms://", "hashivault://"})
+
+	// Key references resolve to their provider, the Vault client is
+	// created without contacting the server
+	t.Setenv("VAULT_ADDR", "http://127.0.0.1:8200")
+	t.Setenv("VAULT_TOKEN", "token")
+	_, err := kms.Get(context.Background(), "hashivault://tejolote", crypto.SHA256)
+	require.NoError(t, err)
+
+	var notFound *kms.ProviderNotFoundError
+	_, err = kms.Get(context.Background(), "unknownkms://tejolote", crypto.SHA256)
+	require.ErrorAs(t, err, &notFound)
+}
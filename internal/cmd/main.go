@@ -17,7 +17,9 @@ limitations under the License.
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"os"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -62,9 +64,19 @@ allows for full control of the process you run.
 		fmt.Sprintf("the logging verbosity, either %s", log.LevelNames()),
 	)
 
+	rootCmd.PersistentFlags().StringVar(
+		&commandLineOpts.logFormat,
+		"log-format",
+		logFormatText,
+		fmt.Sprintf("format of the log output, either %s or %s", logFormatText, logFormatJSON),
+	)
+
 	addRun(rootCmd)
 	addAttest(rootCmd)
 	addStart(rootCmd)
+	addServe(rootCmd)
+	addVSA(rootCmd)
+	addInspect(rootCmd)
 	rootCmd.AddCommand(version.WithFont("larry3d"))
 
 	if err := rootCmd.Execute(); err != nil {
@@ -74,12 +86,34 @@ allows for full control of the process you run.
 	return nil
 }
 
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
 type commandLineOptions struct {
-	logLevel string
+	logLevel  string
+	logFormat string
 }
 
-var commandLineOpts = &commandLineOptions{}
+var commandLineOpts = &commandLineOptions{
+	logFormat: logFormatText,
+}
 
+// initLogging sets up the global logger. Logs always go to STDERR
+// so they never end up mixed with an attestation written to STDOUT.
 func initLogging(*cobra.Command, []string) error {
-	return log.SetupGlobalLogger(commandLineOpts.logLevel)
+	switch commandLineOpts.logFormat {
+	case logFormatText, logFormatJSON:
+	default:
+		return errors.New("log format must be either text or json")
+	}
+	if err := log.SetupGlobalLogger(commandLineOpts.logLevel); err != nil {
+		return err
+	}
+	logrus.SetOutput(os.Stderr)
+	if commandLineOpts.logFormat == logFormatJSON {
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	}
+	return nil
 }
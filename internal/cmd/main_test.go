@@ -0,0 +1,39 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInitLogging(t *testing.T) {
+	defer func(opts commandLineOptions, formatter logrus.Formatter) {
+		*commandLineOpts = opts
+		logrus.SetFormatter(formatter)
+	}(*commandLineOpts, logrus.StandardLogger().Formatter)
+
+	commandLineOpts.logLevel = "info"
+	commandLineOpts.logFormat = logFormatJSON
+	require.NoError(t, initLogging(nil, nil))
+	require.IsType(t, &logrus.JSONFormatter{}, logrus.StandardLogger().Formatter)
+
+	commandLineOpts.logFormat = "xml"
+	require.Error(t, initLogging(nil, nil))
+}
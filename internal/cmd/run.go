@@ -30,9 +30,11 @@ import (
 )
 
 type runOptions struct {
-	Verbose    bool
-	CWD        string
-	OutputDirs []string
+	Verbose     bool
+	CWD         string
+	ConfigPath  string
+	OutputDirs  []string
+	TrustedKeys string
 }
 
 func addRun(parentCmd *cobra.Command) {
@@ -54,7 +56,26 @@ where they came from.
 		SilenceUsage:      false,
 		PersistentPreRunE: initLogging,
 		RunE: func(_ *cobra.Command, args []string) (err error) {
-			runner := buildRunner(runOpts)
+			runner, err := buildRunner(runOpts)
+			if err != nil {
+				return fmt.Errorf("configuring runner: %w", err)
+			}
+
+			if runOpts.ConfigPath != "" {
+				if len(args) > 0 {
+					return errors.New("a command cannot be specified when running a pipeline configuration")
+				}
+				pipeline, err := exec.LoadPipeline(runOpts.ConfigPath)
+				if err != nil {
+					return fmt.Errorf("loading pipeline: %w", err)
+				}
+				run, err := runner.RunPipeline(pipeline)
+				if err != nil {
+					return fmt.Errorf("executing pipeline: %w", err)
+				}
+				logrus.Infof("Pipeline produced %d artifacts", len(run.Artifacts))
+				return nil
+			}
 
 			step := &run.Step{}
 			if len(args) > 0 {
@@ -90,6 +111,13 @@ where they came from.
 		"list of directories that tejolote will monitor for output",
 	)
 
+	runCmd.PersistentFlags().StringVar(
+		&runOpts.ConfigPath,
+		"config",
+		"",
+		"path to a YAML file defining a pipeline of steps to run in order",
+	)
+
 	runCmd.PersistentFlags().StringVarP(
 		&runOpts.CWD,
 		"cwd",
@@ -98,6 +126,13 @@ where they came from.
 		"directory to change when running the build",
 	)
 
+	runCmd.PersistentFlags().StringVar(
+		&runOpts.TrustedKeys,
+		"trusted-keys",
+		"",
+		"path to an armored PGP keyring to verify the signature of the build point commit",
+	)
+
 	runCmd.PersistentFlags().BoolVar(
 		&runOpts.Verbose,
 		"verbose",
@@ -109,21 +144,19 @@ where they came from.
 }
 
 // buildRunner returns a configured runner
-func buildRunner(opts runOptions) *exec.Runner {
+func buildRunner(opts runOptions) (*exec.Runner, error) {
 	runner := exec.NewRunner()
 	runner.Options.CWD = opts.CWD
+	runner.Options.TrustedKeys = opts.TrustedKeys
 
-	// TODO: review this
-	//nolint: gocritic
-	/*
-		for _, dir := range opts.OutputDirs {
-			store, err := store.New(dir)
-			logrus.Infof("Watching directory: %s", dir)
-			runner.Watchers = append(runner.Watchers, store)
+	for _, dir := range opts.OutputDirs {
+		logrus.Infof("Watching directory: %s", dir)
+		if err := runner.AddOutputDirectory(dir); err != nil {
+			return nil, fmt.Errorf("watching output directory: %w", err)
 		}
-	*/
+	}
 
-	return runner
+	return runner, nil
 }
 
 // syntheticStepFromArgs evaluates the arguments passed to see if
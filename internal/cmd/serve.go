@@ -0,0 +1,199 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/watcher"
+)
+
+type serveOptions struct {
+	subscription string
+	outputDir    string
+	format       string
+	sign         bool
+	signingKey   string
+	watchTimeout time.Duration
+}
+
+func (opts *serveOptions) Validate() error {
+	if len(strings.Split(opts.subscription, "/")) != 4 {
+		return errors.New("invalid subscription, format: projects/PROJECTID/subscriptions/NAME")
+	}
+	if opts.watchTimeout <= 0 {
+		return errors.New("--watch-timeout must be positive")
+	}
+	oo := outputOptions{Format: opts.format}
+	return oo.Validate()
+}
+
+func addServe(parentCmd *cobra.Command) {
+	opts := serveOptions{}
+	serveCmd := &cobra.Command{
+		Short: "Finish the attestations of runs published to a pubsub topic",
+		Long: `tejolote serve --subscription projects/PROJECT/subscriptions/NAME
+
+The serve subcommand consumes the messages published by
+tejolote start --pubsub. For each one, it restores the partial
+attestation and storage snapshots, watches the run until it
+finishes and writes the completed attestation.
+
+	`,
+		Use:               "serve",
+		SilenceUsage:      false,
+		PersistentPreRunE: initLogging,
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if err := opts.Validate(); err != nil {
+				return fmt.Errorf("verifying options: %w", err)
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+
+			parts := strings.Split(opts.subscription, "/")
+			client, err := pubsub.NewClient(ctx, parts[1])
+			if err != nil {
+				return fmt.Errorf("creating pubsub client: %w", err)
+			}
+			defer client.Close()
+
+			server := watcher.NewServer(opts.writeAttestation)
+			server.WatchTimeout = opts.watchTimeout
+			if opts.outputDir != "" {
+				server.Done = opts.attestationExists
+			}
+			logrus.Infof("Waiting for start messages in %s", opts.subscription)
+			return server.Serve(ctx, client.Subscription(parts[3]))
+		},
+	}
+
+	serveCmd.PersistentFlags().StringVar(
+		&opts.subscription,
+		"subscription",
+		"",
+		"pubsub subscription to read start messages from (projects/PROJECTID/subscriptions/NAME)",
+	)
+
+	serveCmd.PersistentFlags().DurationVar(
+		&opts.watchTimeout,
+		"watch-timeout",
+		watcher.DefaultServeWatchTimeout,
+		fmt.Sprintf(
+			"maximum time to wait for a run to finish, messages stay leased %s longer",
+			watcher.ServeLeaseMargin,
+		),
+	)
+
+	serveCmd.PersistentFlags().StringVar(
+		&opts.outputDir,
+		"output-dir",
+		"",
+		"directory to write the finished attestations (instead of STDOUT)",
+	)
+
+	serveCmd.PersistentFlags().StringVar(
+		&opts.format,
+		"format",
+		attestation.FormatJSON,
+		fmt.Sprintf(
+			"format of the attestation output (%s)",
+			strings.Join(attestation.OutputFormats(), ", "),
+		),
+	)
+
+	serveCmd.PersistentFlags().BoolVar(
+		&opts.sign,
+		"sign",
+		false,
+		"sign the attestations",
+	)
+
+	serveCmd.PersistentFlags().StringVar(
+		&opts.signingKey,
+		"signing-key",
+		"",
+		"path to a cosign private key or KMS key reference to sign with instead of keyless signing",
+	)
+
+	parentCmd.AddCommand(serveCmd)
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// writeAttestation outputs a finished attestation, files in the
+// output directory are named after the run spec URL
+func (opts *serveOptions) writeAttestation(message *watcher.StartMessage, att *attestation.Attestation) error {
+	var data []byte
+	var err error
+	if opts.sign {
+		data, err = att.Sign(attestation.NewSigner(opts.signingKey))
+		if err == nil {
+			data, err = attestation.ConvertFormat(data, opts.format)
+		}
+	} else {
+		data, err = att.Encode(opts.format)
+	}
+	if err != nil {
+		return fmt.Errorf("serializing attestation: %w", err)
+	}
+
+	if opts.outputDir == "" {
+		fmt.Println(string(data))
+		return nil
+	}
+
+	path := opts.attestationPath(message)
+	if err := os.WriteFile(path, data, os.FileMode(0o644)); err != nil {
+		return fmt.Errorf("writing attestation file: %w", err)
+	}
+	logrus.Infof("Wrote attestation of %s to %s", message.SpecURL, path)
+	return nil
+}
+
+// attestationPath returns the path of the attestation file of
+// a run in the output directory, named after its spec URL
+func (opts *serveOptions) attestationPath(message *watcher.StartMessage) string {
+	name := strings.Trim(unsafeFileChars.ReplaceAllString(message.SpecURL, "-"), "-") + ".intoto.json"
+	return filepath.Join(opts.outputDir, name)
+}
+
+// attestationExists returns true if the attestation of
+// the run was already written to the output directory
+func (opts *serveOptions) attestationExists(message *watcher.StartMessage) (bool, error) {
+	_, err := os.Stat(opts.attestationPath(message))
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return false, err
+}
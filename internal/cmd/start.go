@@ -20,6 +20,7 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -32,6 +33,7 @@ import (
 	"sigs.k8s.io/release-utils/util"
 
 	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/git"
 	"sigs.k8s.io/tejolote/pkg/watcher"
 )
 
@@ -39,6 +41,8 @@ type startAttestationOptions struct {
 	clone           bool
 	repo            string
 	repoPath        string
+	ref             string
+	depth           int
 	pubsub          string
 	vcsURL          string
 	builder         string
@@ -46,6 +50,7 @@ type startAttestationOptions struct {
 	configSrcURI    string
 	configSrcDigest string
 	artifacts       []string
+	annotations     []string
 }
 
 func (opts *startAttestationOptions) Validate() error {
@@ -56,6 +61,16 @@ func (opts *startAttestationOptions) Validate() error {
 	if opts.clone && opts.repoPath == "" {
 		return errors.New("repository clone requested but no repository path was specified")
 	}
+
+	if opts.depth < 0 {
+		return errors.New("clone depth cannot be negative")
+	}
+
+	for _, a := range opts.annotations {
+		if _, _, err := attestation.ParseAnnotation(a); err != nil {
+			return fmt.Errorf("checking annotations: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -86,7 +101,9 @@ storage locations and retake them when finishing building the
 provenance metadata. This allows it to "remember" the storage
 states to notice new artifacts. By default tejolote will store the
 storage state in a file with the same name as the partial
-attestation but with ".storage-snap.json" appended.
+attestation but with ".storage-snap.json" appended. When the
+partial attestation is written to STDOUT (no --output), pass a
+path in --snapshots to save the storage state.
 
 	`,
 		Use:               "attestation",
@@ -97,6 +114,10 @@ attestation but with ".storage-snap.json" appended.
 				return fmt.Errorf("validating options: %w", err)
 			}
 
+			if err := outputOps.Validate(); err != nil {
+				return fmt.Errorf("validating output options: %w", err)
+			}
+
 			if len(args) == 0 {
 				return errors.New("build run spec URL not specified")
 			}
@@ -106,6 +127,14 @@ attestation but with ".storage-snap.json" appended.
 				return fmt.Errorf("building watcher")
 			}
 
+			// Clone before snapshotting so the source checkout is
+			// not picked up as new artifacts
+			if startAttestationOpts.clone {
+				if err := cloneRepository(outputOps, startAttestationOpts); err != nil {
+					return fmt.Errorf("cloning repository: %w", err)
+				}
+			}
+
 			// Add artifact monitors to the watcher
 			for _, uri := range startAttestationOpts.artifacts {
 				if err := w.AddArtifactSource(uri); err != nil {
@@ -117,24 +146,9 @@ attestation but with ".storage-snap.json" appended.
 				return fmt.Errorf("snapshotting the artifact repositories: %w", err)
 			}
 
-			if outputOps.FinalSnapshotStatePath(outputOps.OutputPath) == "" {
-				if len(w.Snapshots) > 0 {
-					logrus.Warning("Not saving storage state but artifact sources defined")
-				}
-			} else {
-				if err := w.SaveSnapshots(outputOps.FinalSnapshotStatePath(outputOps.OutputPath)); err != nil {
-					return fmt.Errorf("saving storage snapshots: %w", err)
-				}
-			}
-
 			att := attestation.New()
 			predicate := attestation.NewSLSAPredicate()
 
-			if startAttestationOpts.clone {
-				// TODO: Implement
-				return fmt.Errorf("repository cloning not yet implemented")
-			}
-
 			vcsURL := startAttestationOpts.vcsURL
 			if vcsURL == "" {
 				vcsURL, err = readVCSURL(outputOps, startAttestationOpts)
@@ -144,22 +158,11 @@ attestation but with ".storage-snap.json" appended.
 			}
 
 			if vcsURL != "" {
-				material := common.ProvenanceMaterial{
-					URI:    vcsURL,
-					Digest: map[string]string{},
-				}
-				commithash := map[string]string{}
-				if repoURL, repoDigest, ok := strings.Cut(vcsURL, "@"); ok {
-					// The thing after the @ may not be a commit
-					if len(repoDigest) == 40 {
-						commithash["sha1"] = repoDigest
-					} else {
-						repoURL = vcsURL
-					}
-					material.URI = repoURL
-					material.Digest = commithash
+				repo, err := openRepository(outputOps, startAttestationOpts)
+				if err != nil {
+					return fmt.Errorf("opening repository: %w", err)
 				}
-				predicate.Materials = append(predicate.Materials, material)
+				predicate.Materials = append(predicate.Materials, vcsMaterial(vcsURL, repo))
 			}
 
 			att.Predicate = predicate
@@ -174,24 +177,26 @@ attestation but with ".storage-snap.json" appended.
 				}
 			}
 
-			json, err := att.ToJSON()
+			for k, v := range parseAnnotations(startAttestationOpts.annotations) {
+				if err := att.Predicate.SetAnnotation(k, v); err != nil {
+					return fmt.Errorf("setting annotation %s: %w", k, err)
+				}
+			}
+
+			json, err := att.Encode(outputOps.Format)
 			if err != nil {
-				return fmt.Errorf("serializing attestation json: %w", err)
+				return fmt.Errorf("serializing attestation: %w", err)
 			}
 
-			if outputOps.OutputPath == "" {
-				fmt.Println(string(json))
-			} else {
-				err = os.WriteFile(outputOps.OutputPath, json, os.FileMode(0o644))
-				if err != nil {
-					return fmt.Errorf("writing output data: %w", err)
-				}
+			if err := writeStartOutput(w, outputOps, json, os.Stdout); err != nil {
+				return err
 			}
 
 			if startAttestationOpts.pubsub != "" {
 				var sdata []byte
-				if util.Exists(outputOps.FinalSnapshotStatePath(outputOps.OutputPath)) {
-					sdata, err = os.ReadFile(outputOps.FinalSnapshotStatePath(outputOps.OutputPath))
+				snapshotStatePath := outputOps.FinalSnapshotStatePath(outputOps.OutputPath)
+				if util.Exists(snapshotStatePath) {
+					sdata, err = os.ReadFile(snapshotStatePath)
 					if err != nil {
 						return fmt.Errorf("reading snapshot data: %w", err)
 					}
@@ -238,6 +243,20 @@ attestation but with ".storage-snap.json" appended.
 		"clone the repository",
 	)
 
+	startAttestationCmd.PersistentFlags().StringVar(
+		&startAttestationOpts.ref,
+		"ref",
+		"",
+		"branch, tag or commit to check out when cloning (defaults to the remote HEAD)",
+	)
+
+	startAttestationCmd.PersistentFlags().IntVar(
+		&startAttestationOpts.depth,
+		"depth",
+		1,
+		"number of commits to fetch when cloning (0 fetches the whole history)",
+	)
+
 	startAttestationCmd.PersistentFlags().StringSliceVar(
 		&startAttestationOpts.artifacts,
 		"artifacts",
@@ -252,6 +271,13 @@ attestation but with ".storage-snap.json" appended.
 		"publish event to a pubsub topic",
 	)
 
+	startAttestationCmd.PersistentFlags().StringArrayVar(
+		&startAttestationOpts.annotations,
+		"annotation",
+		[]string{},
+		"key=value annotation recorded in the predicate invocation environment (can be repeated)",
+	)
+
 	startAttestationCmd.PersistentFlags().StringVar(
 		&startAttestationOpts.vcsURL,
 		"vcs-url",
@@ -291,6 +317,36 @@ attestation but with ".storage-snap.json" appended.
 	parentCmd.AddCommand(startCmd)
 }
 
+// writeStartOutput writes the partial attestation and the storage
+// snapshots. Without an output path the attestation is written to
+// stdout and the snapshots are only saved if --snapshots has a path.
+func writeStartOutput(w *watcher.Watcher, outputOps *outputOptions, data []byte, stdout io.Writer) error {
+	snapshotStatePath := outputOps.FinalSnapshotStatePath(outputOps.OutputPath)
+	if snapshotStatePath == "" && len(w.Snapshots) > 0 {
+		logrus.Warning("Not saving storage state but artifact sources defined, set a --snapshots path to save it")
+	}
+
+	if outputOps.OutputPath != "" {
+		// Write the attestation and the storage state together
+		if err := w.WriteAttestation(outputOps.OutputPath, data, snapshotStatePath); err != nil {
+			return fmt.Errorf("writing output data: %w", err)
+		}
+		return nil
+	}
+
+	// Save the snapshots first so the attestation is only
+	// printed when the state to complete it is on disk
+	if snapshotStatePath != "" {
+		if err := w.SaveSnapshots(snapshotStatePath); err != nil {
+			return fmt.Errorf("saving storage snapshots: %w", err)
+		}
+	}
+	if _, err := fmt.Fprintln(stdout, string(data)); err != nil {
+		return fmt.Errorf("writing attestation: %w", err)
+	}
+	return nil
+}
+
 // readVCSURL checks the repository path to get the VCS url for the
 // materials
 func readVCSURL(outputOpts *outputOptions, opts *startAttestationOptions) (string, error) {
@@ -298,6 +354,76 @@ func readVCSURL(outputOpts *outputOptions, opts *startAttestationOptions) (strin
 		return "", nil
 	}
 
+	repoPath, err := resolveRepoPath(outputOpts, opts)
+	if err != nil {
+		return "", err
+	}
+
+	urlString, err := vcs.ProbeDirForVCSUrl(repoPath, repoPath)
+	if err != nil {
+		return "", fmt.Errorf("probing VCS URL: %w", err)
+	}
+	return urlString, nil
+}
+
+// openRepository opens the repository in the repository path. It
+// returns nil if there is no path or it is not a git repository.
+func openRepository(outputOpts *outputOptions, opts *startAttestationOptions) (*git.Repository, error) {
+	if opts.repoPath == "" {
+		return nil, nil
+	}
+	repoPath, err := resolveRepoPath(outputOpts, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !git.IsRepo(repoPath) {
+		return nil, nil
+	}
+	return git.NewRepository(repoPath)
+}
+
+// vcsMaterial returns the material of a VCS locator in the form
+// url@ref. The ref is resolved to its commit in the local repository
+// when there is one, so branches and tags (including annotated ones)
+// are recorded with the precise commit built. Without a repository,
+// only full commit SHAs are recognized.
+func vcsMaterial(vcsURL string, repo *git.Repository) common.ProvenanceMaterial {
+	repoURL, ref, ok := strings.Cut(vcsURL, "@")
+	if !ok {
+		return common.ProvenanceMaterial{URI: vcsURL, Digest: common.DigestSet{}}
+	}
+
+	commit := ""
+	switch {
+	case git.IsCommitHash(ref):
+		commit = strings.ToLower(ref)
+	case repo != nil:
+		var err error
+		commit, err = repo.CommitForRef(ref)
+		if err != nil {
+			logrus.Warnf("unable to resolve %s in the repository: %v", ref, err)
+		}
+	}
+
+	// The thing after the @ may not be a commit
+	if commit == "" {
+		logrus.Warnf("unable to read commit from vcs url %s", vcsURL)
+		return common.ProvenanceMaterial{URI: vcsURL, Digest: common.DigestSet{}}
+	}
+	if repo != nil {
+		if tags, err := repo.TagsPointingAt(commit); err == nil && len(tags) > 0 {
+			logrus.Infof("Commit %s is tagged %s", commit, strings.Join(tags, ", "))
+		}
+	}
+	return common.ProvenanceMaterial{
+		URI:    repoURL,
+		Digest: common.DigestSet{git.CommitHashAlgorithm(commit): commit},
+	}
+}
+
+// resolveRepoPath returns the absolute path to the repository,
+// relative paths are considered to be under the workspace
+func resolveRepoPath(outputOpts *outputOptions, opts *startAttestationOptions) (string, error) {
 	repoPath := opts.repoPath
 
 	// If its a relative URL, append the workspace
@@ -309,10 +435,22 @@ func readVCSURL(outputOpts *outputOptions, opts *startAttestationOptions) (strin
 	if err != nil {
 		return "", fmt.Errorf("resolving absolute path to repo: %w", err)
 	}
+	return repoPath, nil
+}
 
-	urlString, err := vcs.ProbeDirForVCSUrl(repoPath, repoPath)
+// cloneRepository clones the repository into the repository path
+func cloneRepository(outputOpts *outputOptions, opts *startAttestationOptions) error {
+	repoPath, err := resolveRepoPath(outputOpts, opts)
 	if err != nil {
-		return "", fmt.Errorf("probing VCS URL: %w", err)
+		return err
 	}
-	return urlString, nil
+
+	logrus.Infof("Cloning %s into %s", opts.repo, repoPath)
+	if _, err := git.Clone(opts.repo, repoPath, git.CloneOptions{
+		Ref:   opts.ref,
+		Depth: opts.depth,
+	}); err != nil {
+		return err
+	}
+	return nil
 }
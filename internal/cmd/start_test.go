@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/watcher"
+)
+
+func TestWriteStartOutputStdout(t *testing.T) {
+	artifacts := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(artifacts, "file.txt"), []byte("data"), os.FileMode(0o644)))
+
+	w, err := watcher.New("github://org/repo/1")
+	require.NoError(t, err)
+	require.NoError(t, w.AddArtifactSource("file://"+artifacts))
+	require.NoError(t, w.Snap())
+
+	// The attestation goes to stdout and the snapshots to the path
+	snapPath := filepath.Join(t.TempDir(), "state.json")
+	var stdout bytes.Buffer
+	opts := &outputOptions{SnapshotStatePath: snapPath}
+	require.NoError(t, writeStartOutput(w, opts, []byte(`{"attestation":true}`), &stdout))
+	require.Equal(t, "{\"attestation\":true}\n", stdout.String())
+	require.FileExists(t, snapPath)
+
+	// The saved state can be loaded to complete the attestation
+	w2, err := watcher.New("github://org/repo/1")
+	require.NoError(t, err)
+	require.NoError(t, w2.AddArtifactSource("file://"+artifacts))
+	require.NoError(t, w2.LoadSnapshots(snapPath))
+	require.Len(t, w2.Snapshots, 1)
+
+	// With the default snapshots path there is nothing to seed
+	// the file name from, the attestation is still printed
+	stdout.Reset()
+	opts = &outputOptions{SnapshotStatePath: "default"}
+	require.NoError(t, writeStartOutput(w, opts, []byte("{}"), &stdout))
+	require.Equal(t, "{}\n", stdout.String())
+
+	// Failing to save the state does not print the attestation
+	stdout.Reset()
+	opts = &outputOptions{SnapshotStatePath: filepath.Join(snapPath, "not-a-dir", "state.json")}
+	require.Error(t, writeStartOutput(w, opts, []byte("{}"), &stdout))
+	require.Empty(t, stdout.String())
+}
+
+func TestVCSMaterialWithoutRepo(t *testing.T) {
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	m := vcsMaterial("git+https://github.com/org/repo@"+sha, nil)
+	require.Equal(t, "git+https://github.com/org/repo", m.URI)
+	require.Equal(t, sha, m.Digest["sha1"])
+
+	sha256 := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	m = vcsMaterial("git+https://github.com/org/repo@"+sha256, nil)
+	require.Equal(t, "git+https://github.com/org/repo", m.URI)
+	require.Equal(t, common.DigestSet{"sha256": sha256}, m.Digest)
+
+	// Refs other than full commit SHAs cannot be resolved without a repo
+	m = vcsMaterial("git+https://github.com/org/repo@v1.0.0", nil)
+	require.Equal(t, "git+https://github.com/org/repo@v1.0.0", m.URI)
+	require.Empty(t, m.Digest)
+}
@@ -0,0 +1,216 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+)
+
+type vsaOptions struct {
+	verifier     string
+	resourceURI  string
+	policyURI    string
+	policyDigest string
+	result       string
+	levels       []string
+	sign         bool
+	signingKey   string
+	outputPath   string
+	format       string
+}
+
+func (opts *vsaOptions) Validate() error {
+	if opts.verifier == "" {
+		return errors.New("the verifier ID is required")
+	}
+	switch strings.ToUpper(opts.result) {
+	case attestation.VSAResultPassed, attestation.VSAResultFailed:
+	default:
+		return fmt.Errorf("verification result must be %s or %s", attestation.VSAResultPassed, attestation.VSAResultFailed)
+	}
+	if opts.policyDigest != "" {
+		if _, _, ok := strings.Cut(opts.policyDigest, ":"); !ok {
+			return errors.New("policy digest must be in the form algorithm:digest")
+		}
+	}
+	if opts.signingKey != "" && !opts.sign {
+		return errors.New("--signing-key requires --sign")
+	}
+	oo := outputOptions{Format: opts.format}
+	return oo.Validate()
+}
+
+// buildVSA returns the verification summary of the subjects
+func (opts *vsaOptions) buildVSA(subjects []string, verified time.Time) (*attestation.VSA, error) {
+	vsa := attestation.NewVSA()
+	for _, spec := range subjects {
+		s, err := attestation.ParseSubject(spec)
+		if err != nil {
+			return nil, err
+		}
+		vsa.Subject = append(vsa.Subject, s)
+	}
+
+	vsa.Predicate.SetVerifier(opts.verifier)
+	vsa.Predicate.SetTimeVerified(verified)
+	vsa.Predicate.SetResourceURI(opts.resourceURI)
+	var policyDigest map[string]string
+	if algo, value, ok := strings.Cut(opts.policyDigest, ":"); ok {
+		policyDigest = map[string]string{strings.ToLower(algo): value}
+	}
+	vsa.Predicate.SetPolicy(opts.policyURI, policyDigest)
+	vsa.Predicate.SetResult(strings.EqualFold(opts.result, attestation.VSAResultPassed))
+	vsa.Predicate.SetVerifiedLevels(opts.levels...)
+	return vsa, nil
+}
+
+func addVSA(parentCmd *cobra.Command) {
+	opts := vsaOptions{}
+	vsaCmd := &cobra.Command{
+		Short: "Emit a SLSA verification summary attestation",
+		Long: `tejolote vsa --verifier ID --result passed name@sha256:digest...
+
+The vsa subcommand records the result of verifying the provenance
+of one or more artifacts against a policy in a SLSA verification
+summary attestation (VSA). Artifacts are specified with their name
+and digest in the form name@algorithm:digest.
+
+	`,
+		Use:               "vsa",
+		SilenceUsage:      false,
+		PersistentPreRunE: initLogging,
+		RunE: func(_ *cobra.Command, args []string) error {
+			if err := opts.Validate(); err != nil {
+				return fmt.Errorf("validating options: %w", err)
+			}
+
+			if len(args) == 0 {
+				return errors.New("no verified artifacts specified")
+			}
+
+			vsa, err := opts.buildVSA(args, time.Now())
+			if err != nil {
+				return fmt.Errorf("building verification summary: %w", err)
+			}
+
+			var data []byte
+			if opts.sign {
+				data, err = vsa.Sign(attestation.NewSigner(opts.signingKey))
+				if err == nil {
+					data, err = attestation.ConvertFormat(data, opts.format)
+				}
+			} else {
+				data, err = vsa.Encode(opts.format)
+			}
+			if err != nil {
+				return fmt.Errorf("serializing verification summary: %w", err)
+			}
+
+			if opts.outputPath != "" {
+				if err := os.WriteFile(opts.outputPath, data, os.FileMode(0o644)); err != nil {
+					return fmt.Errorf("writing verification summary: %w", err)
+				}
+				return nil
+			}
+			fmt.Println(string(data))
+			return nil
+		},
+	}
+
+	vsaCmd.PersistentFlags().StringVar(
+		&opts.verifier,
+		"verifier",
+		"",
+		"URI identifying the verifier that checked the artifacts",
+	)
+
+	vsaCmd.PersistentFlags().StringVar(
+		&opts.resourceURI,
+		"resource-uri",
+		"",
+		"URI of the verified resource (eg where the artifacts will be deployed)",
+	)
+
+	vsaCmd.PersistentFlags().StringVar(
+		&opts.policyURI,
+		"policy",
+		"",
+		"URI of the policy the artifacts were verified against",
+	)
+
+	vsaCmd.PersistentFlags().StringVar(
+		&opts.policyDigest,
+		"policy-digest",
+		"",
+		"digest of the policy in the form algorithm:digest",
+	)
+
+	vsaCmd.PersistentFlags().StringVar(
+		&opts.result,
+		"result",
+		"",
+		"result of the verification, either passed or failed",
+	)
+
+	vsaCmd.PersistentFlags().StringSliceVar(
+		&opts.levels,
+		"level",
+		[]string{},
+		"levels the artifacts were verified at (eg SLSA_BUILD_LEVEL_3)",
+	)
+
+	vsaCmd.PersistentFlags().BoolVar(
+		&opts.sign,
+		"sign",
+		false,
+		"sign the verification summary",
+	)
+
+	vsaCmd.PersistentFlags().StringVar(
+		&opts.signingKey,
+		"signing-key",
+		"",
+		"path to a cosign private key or KMS key reference to sign with instead of keyless signing",
+	)
+
+	vsaCmd.PersistentFlags().StringVar(
+		&opts.outputPath,
+		"output",
+		"",
+		"file to write the verification summary to (instead of STDOUT)",
+	)
+
+	vsaCmd.PersistentFlags().StringVar(
+		&opts.format,
+		"format",
+		attestation.FormatJSON,
+		fmt.Sprintf(
+			"format of the verification summary output (%s)",
+			strings.Join(attestation.OutputFormats(), ", "),
+		),
+	)
+
+	parentCmd.AddCommand(vsaCmd)
+}
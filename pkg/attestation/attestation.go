@@ -20,6 +20,9 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"sort"
+	"strings"
 
 	intoto "github.com/in-toto/in-toto-golang/in_toto"
 	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
@@ -30,9 +33,20 @@ import (
 type (
 	Attestation struct {
 		intoto.StatementHeader
+		// Subject shadows the header subjects to support annotations
+		Subject   []Subject     `json:"subject"`
 		Predicate SLSAPredicate `json:"predicate"`
 	}
 	SLSAPredicate slsa.ProvenancePredicate
+
+	// Subject is an in-toto subject with optional annotations. in-toto
+	// subjects only have a name and digests, the annotations are
+	// serialized in the predicate (see SubjectAnnotationsKey).
+	Subject struct {
+		Name        string            `json:"name"`
+		Digest      common.DigestSet  `json:"digest"`
+		Annotations map[string]string `json:"-"`
+	}
 )
 
 func New() *Attestation {
@@ -40,8 +54,8 @@ func New() *Attestation {
 		StatementHeader: intoto.StatementHeader{
 			Type:          intoto.StatementInTotoV01,
 			PredicateType: slsa.PredicateSLSAProvenance,
-			Subject:       []intoto.Subject{},
 		},
+		Subject: []Subject{},
 	}
 	return attestation
 }
@@ -115,3 +129,160 @@ func (pred *SLSAPredicate) AddMaterial(uri string, hashes map[string]string) {
 		Digest: hashes,
 	})
 }
+
+// SortMaterials sorts the materials by URI, then by digest. Materials
+// are added as drivers, SBOMs and lockfiles are read, sorting them
+// makes the predicates of the same build byte for byte equal.
+func (pred *SLSAPredicate) SortMaterials() {
+	sort.SliceStable(pred.Materials, func(i, j int) bool {
+		if pred.Materials[i].URI != pred.Materials[j].URI {
+			return pred.Materials[i].URI < pred.Materials[j].URI
+		}
+		return digestKey(pred.Materials[i].Digest) < digestKey(pred.Materials[j].Digest)
+	})
+}
+
+// AnnotationsKey is the invocation environment key holding
+// the annotations set with SetAnnotation
+const AnnotationsKey = "annotations"
+
+// EnvironmentMap returns the invocation environment as a generic map.
+// Drivers set the environment to their own types, so it is converted
+// and stored back in the predicate to keep any changes made to it.
+func (pred *SLSAPredicate) EnvironmentMap() (map[string]interface{}, error) {
+	if env, ok := pred.Invocation.Environment.(map[string]interface{}); ok {
+		return env, nil
+	}
+	env := map[string]interface{}{}
+	if pred.Invocation.Environment != nil {
+		data, err := json.Marshal(pred.Invocation.Environment)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling invocation environment: %w", err)
+		}
+		if err := json.Unmarshal(data, &env); err != nil {
+			return nil, fmt.Errorf("invocation environment is not an object: %w", err)
+		}
+	}
+	pred.Invocation.Environment = env
+	return env, nil
+}
+
+// SetAnnotation records an arbitrary key/value pair in the predicate.
+// SLSA v0.2 has no field for them, so they are kept in the
+// invocation environment under the annotations key.
+func (pred *SLSAPredicate) SetAnnotation(key, value string) error {
+	annotations := pred.Annotations()
+	env, err := pred.EnvironmentMap()
+	if err != nil {
+		return err
+	}
+	annotations[key] = value
+	env[AnnotationsKey] = annotations
+	return nil
+}
+
+// Annotations returns the annotations set in the predicate
+func (pred *SLSAPredicate) Annotations() map[string]string {
+	annotations := map[string]string{}
+	env, ok := pred.Invocation.Environment.(map[string]interface{})
+	if !ok {
+		return annotations
+	}
+	switch set := env[AnnotationsKey].(type) {
+	case map[string]string:
+		for k, v := range set {
+			annotations[k] = v
+		}
+	// Annotations read back from JSON
+	case map[string]interface{}:
+		for k, v := range set {
+			if s, ok := v.(string); ok {
+				annotations[k] = s
+			}
+		}
+	}
+	return annotations
+}
+
+// SubjectAnnotationsKey is the invocation environment key holding
+// the annotations of the subjects, by subject name
+const SubjectAnnotationsKey = "subjectAnnotations"
+
+// statement is the attestation without its JSON methods
+type statement Attestation
+
+// MarshalJSON serializes the attestation as a standard in-toto statement.
+// The subject annotations are written to the invocation environment under
+// SubjectAnnotationsKey, the attestation itself is not modified.
+func (att Attestation) MarshalJSON() ([]byte, error) {
+	annotations := map[string]map[string]string{}
+	for _, s := range att.Subject {
+		if len(s.Annotations) == 0 {
+			continue
+		}
+		if annotations[s.Name] == nil {
+			annotations[s.Name] = map[string]string{}
+		}
+		maps.Copy(annotations[s.Name], s.Annotations)
+	}
+	if len(annotations) > 0 {
+		env, err := att.Predicate.EnvironmentMap()
+		if err != nil {
+			return nil, err
+		}
+		env = maps.Clone(env)
+		env[SubjectAnnotationsKey] = annotations
+		att.Predicate.Invocation.Environment = env
+	}
+
+	// Keep URLs readable, like ToJSON does
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(statement(att)); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(b.Bytes(), []byte("\n")), nil
+}
+
+// UnmarshalJSON parses an in-toto statement, the annotations of the
+// subjects are read back from the invocation environment
+func (att *Attestation) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*statement)(att)); err != nil {
+		return err
+	}
+	env, ok := att.Predicate.Invocation.Environment.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	set, ok := env[SubjectAnnotationsKey].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	for i, s := range att.Subject {
+		subjectSet, ok := set[s.Name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		att.Subject[i].Annotations = map[string]string{}
+		for k, v := range subjectSet {
+			if value, ok := v.(string); ok {
+				att.Subject[i].Annotations[k] = value
+			}
+		}
+	}
+	delete(env, SubjectAnnotationsKey)
+	if len(env) == 0 {
+		att.Predicate.Invocation.Environment = nil
+	}
+	return nil
+}
+
+// ParseAnnotation parses an annotation in key=value form
+func ParseAnnotation(s string) (key, value string, err error) {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return "", "", fmt.Errorf("annotation %q is not in key=value form", s)
+	}
+	return key, value, nil
+}
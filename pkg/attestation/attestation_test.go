@@ -0,0 +1,95 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetAnnotation(t *testing.T) {
+	att := New().SLSA()
+	// Drivers set the environment to their own types
+	att.Predicate.Invocation.Environment = struct {
+		Project string `json:"project"`
+	}{Project: "test"}
+
+	require.NoError(t, att.Predicate.SetAnnotation("ticket", "REL-1"))
+	require.NoError(t, att.Predicate.SetAnnotation("env", "prod"))
+	require.Equal(t, map[string]string{"ticket": "REL-1", "env": "prod"}, att.Predicate.Annotations())
+
+	data, err := att.ToJSON()
+	require.NoError(t, err)
+	decoded := &Attestation{}
+	require.NoError(t, json.Unmarshal(data, decoded))
+
+	// The annotations survive the serialization next to the driver data
+	require.Equal(t, map[string]string{"ticket": "REL-1", "env": "prod"}, decoded.Predicate.Annotations())
+	env, ok := decoded.Predicate.Invocation.Environment.(map[string]interface{})
+	require.True(t, ok)
+	require.Equal(t, "test", env["project"])
+}
+
+func TestSubjectAnnotations(t *testing.T) {
+	att := New().SLSA()
+	att.Subject = []Subject{
+		{Name: "app.tar.gz", Digest: map[string]string{"sha256": "abc"}, Annotations: map[string]string{"group": "app"}},
+		{Name: "app.sig", Digest: map[string]string{"sha256": "def"}},
+	}
+	require.NoError(t, att.Predicate.SetAnnotation("ticket", "REL-1"))
+
+	data, err := att.ToJSON()
+	require.NoError(t, err)
+
+	// The subjects are plain in-toto subjects
+	raw := struct {
+		Subject []map[string]interface{} `json:"subject"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &raw))
+	require.Len(t, raw.Subject, 2)
+	for _, s := range raw.Subject {
+		require.NotContains(t, s, "annotations")
+	}
+	require.NotContains(t, att.Predicate.Invocation.Environment, SubjectAnnotationsKey)
+
+	// and their annotations are read back from the predicate
+	decoded := &Attestation{}
+	require.NoError(t, json.Unmarshal(data, decoded))
+	require.Equal(t, map[string]string{"group": "app"}, decoded.Subject[0].Annotations)
+	require.Nil(t, decoded.Subject[1].Annotations)
+	require.Equal(t, map[string]string{"ticket": "REL-1"}, decoded.Predicate.Annotations())
+	require.NotContains(t, decoded.Predicate.Invocation.Environment, SubjectAnnotationsKey)
+}
+
+func TestParseAnnotation(t *testing.T) {
+	k, v, err := ParseAnnotation("cost-center=1234")
+	require.NoError(t, err)
+	require.Equal(t, "cost-center", k)
+	require.Equal(t, "1234", v)
+
+	k, v, err = ParseAnnotation("note=a=b")
+	require.NoError(t, err)
+	require.Equal(t, "note", k)
+	require.Equal(t, "a=b", v)
+
+	for _, s := range []string{"novalue", "=value", ""} {
+		_, _, err := ParseAnnotation(s)
+		require.Error(t, err, s)
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatDSSE = "dsse"
+
+	// PayloadType is the DSSE payload type of in-toto statements
+	PayloadType = "application/vnd.in-toto+json"
+)
+
+// OutputFormats returns the formats attestations can be encoded to
+func OutputFormats() []string {
+	return []string{FormatJSON, FormatYAML, FormatDSSE}
+}
+
+// Encode serializes the attestation in one of the output formats. The
+// dsse format wraps the JSON statement in an unsigned DSSE envelope.
+func (att *Attestation) Encode(format string) ([]byte, error) {
+	data, err := att.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("serializing attestation to json: %w", err)
+	}
+	return encodeStatement(data, format)
+}
+
+// encodeStatement converts a statement serialized as JSON
+// to one of the output formats
+func encodeStatement(data []byte, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON, "":
+		return data, nil
+	case FormatYAML:
+		return ConvertFormat(data, FormatYAML)
+	case FormatDSSE:
+		envelope := dsse.Envelope{
+			PayloadType: PayloadType,
+			Payload:     base64.StdEncoding.EncodeToString(data),
+			Signatures:  []dsse.Signature{},
+		}
+		envelopeData, err := json.MarshalIndent(envelope, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("serializing dsse envelope: %w", err)
+		}
+		return append(envelopeData, '\n'), nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// ConvertFormat converts an already serialized JSON document to the
+// output format. It is used to render signed envelopes as YAML.
+func ConvertFormat(data []byte, format string) ([]byte, error) {
+	switch format {
+	case FormatJSON, FormatDSSE, "":
+		return data, nil
+	case FormatYAML:
+		yamlData, err := yaml.JSONToYAML(data)
+		if err != nil {
+			return nil, fmt.Errorf("converting json to yaml: %w", err)
+		}
+		return yamlData, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// Decode reads an attestation in any of the output formats
+func (att *Attestation) Decode(data []byte) error {
+	// YAML is a superset of JSON, converting normalizes both
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return fmt.Errorf("parsing attestation data: %w", err)
+	}
+
+	envelope := dsse.Envelope{}
+	if err := json.Unmarshal(jsonData, &envelope); err == nil && envelope.PayloadType != "" {
+		if envelope.PayloadType != PayloadType {
+			return fmt.Errorf("unsupported dsse payload type %s", envelope.PayloadType)
+		}
+		jsonData, err = envelope.DecodeB64Payload()
+		if err != nil {
+			return fmt.Errorf("decoding dsse payload: %w", err)
+		}
+	}
+
+	if err := json.Unmarshal(jsonData, att); err != nil {
+		return fmt.Errorf("unmarshaling attestation json: %w", err)
+	}
+	return nil
+}
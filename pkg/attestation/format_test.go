@@ -0,0 +1,92 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeFormats(t *testing.T) {
+	att := New().SLSA()
+	att.Subject = append(att.Subject, Subject{
+		Name: "test.txt", Digest: common.DigestSet{"sha256": "abc"},
+	})
+	att.Predicate.Builder.ID = "https://example.com/builder"
+
+	jsonData, err := att.Encode(FormatJSON)
+	require.NoError(t, err)
+	require.True(t, json.Valid(jsonData))
+
+	yamlData, err := att.Encode(FormatYAML)
+	require.NoError(t, err)
+	require.Contains(t, string(yamlData), "name: test.txt")
+
+	dsseData, err := att.Encode(FormatDSSE)
+	require.NoError(t, err)
+	envelope := struct {
+		PayloadType string        `json:"payloadType"`
+		Payload     string        `json:"payload"`
+		Signatures  []interface{} `json:"signatures"`
+	}{}
+	require.NoError(t, json.Unmarshal(dsseData, &envelope))
+	require.Equal(t, PayloadType, envelope.PayloadType)
+	require.NotNil(t, envelope.Signatures)
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	require.NoError(t, err)
+	require.JSONEq(t, string(jsonData), string(payload))
+
+	// All formats decode back to the same attestation
+	for _, data := range [][]byte{jsonData, yamlData, dsseData} {
+		decoded := New().SLSA()
+		require.NoError(t, decoded.Decode(data))
+		require.Equal(t, att.Subject, decoded.Subject)
+		require.Equal(t, att.Predicate.Builder.ID, decoded.Predicate.Builder.ID)
+	}
+
+	_, err = att.Encode("xml")
+	require.Error(t, err)
+	require.Error(t, New().Decode([]byte(strings.Replace(string(dsseData), PayloadType, "text/plain", 1))))
+}
+
+func TestLinkEncode(t *testing.T) {
+	link := NewLink("build")
+	link.AddMaterial("main.go", map[string]string{"SHA256": "abc"})
+	link.AddProduct("app", map[string]string{"SHA256": "def", "SHA512": "123"})
+
+	data, err := link.Encode(FormatJSON)
+	require.NoError(t, err)
+	mb := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &mb))
+	require.Equal(t, []interface{}{}, mb["signatures"])
+	signed, ok := mb["signed"].(map[string]interface{})
+	require.True(t, ok)
+	require.Equal(t, LinkType, signed["_type"])
+	require.Equal(t, "build", signed["name"])
+	require.Equal(t, map[string]interface{}{"main.go": map[string]interface{}{"sha256": "abc"}}, signed["materials"])
+	require.Equal(t, map[string]interface{}{"app": map[string]interface{}{"sha256": "def", "sha512": "123"}}, signed["products"])
+
+	_, err = link.Encode(FormatYAML)
+	require.NoError(t, err)
+	_, err = link.Encode(FormatDSSE)
+	require.Error(t, err)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+)
+
+// LinkType is the type of in-toto link metadata
+const LinkType = "link"
+
+// Link is the metadata of a step in a classic in-toto supply chain
+// layout. It records the artifacts a step used (materials) and the
+// ones it created (products).
+type Link intoto.Link
+
+// NewLink returns an empty link for the named step
+func NewLink(name string) *Link {
+	return &Link{
+		Type:        LinkType,
+		Name:        name,
+		Materials:   map[string]interface{}{},
+		Products:    map[string]interface{}{},
+		ByProducts:  map[string]interface{}{},
+		Command:     []string{},
+		Environment: map[string]interface{}{},
+	}
+}
+
+// AddMaterial records an artifact the step used
+func (l *Link) AddMaterial(name string, digest map[string]string) {
+	l.Materials[name] = linkHashObj(digest)
+}
+
+// AddProduct records an artifact the step created
+func (l *Link) AddProduct(name string, digest map[string]string) {
+	l.Products[name] = linkHashObj(digest)
+}
+
+// linkHashObj converts a digest set to an in-toto hash object,
+// in-toto expects the algorithm names in lowercase
+func linkHashObj(digest map[string]string) map[string]interface{} {
+	obj := map[string]interface{}{}
+	for algo, value := range digest {
+		obj[strings.ToLower(algo)] = value
+	}
+	return obj
+}
+
+// Encode serializes the link in an unsigned metablock, the envelope
+// in-toto tools sign and verify. Links cannot be wrapped in DSSE.
+func (l *Link) Encode(format string) ([]byte, error) {
+	if format == FormatDSSE {
+		return nil, errors.New("in-toto links cannot be encoded as dsse")
+	}
+	mb := intoto.Metablock{
+		Signed:     intoto.Link(*l),
+		Signatures: []intoto.Signature{},
+	}
+	data, err := json.MarshalIndent(mb, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("serializing link: %w", err)
+	}
+	return ConvertFormat(append(data, '\n'), format)
+}
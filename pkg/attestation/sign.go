@@ -17,91 +17,69 @@ limitations under the License.
 package attestation
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"time"
 
-	"github.com/sigstore/cosign/v2/cmd/cosign/cli/options"
-	"github.com/sigstore/cosign/v2/cmd/cosign/cli/sign"
-	"github.com/sigstore/sigstore/pkg/signature/dsse"
-	signatureoptions "github.com/sigstore/sigstore/pkg/signature/options"
-	"github.com/sigstore/sigstore/pkg/tuf"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
 )
 
-func (att *Attestation) Sign() ([]byte, error) {
-	var certPath, certChainPath string
-
-	ctx := context.Background()
-	var timeout time.Duration // TODO: move to options
-	if timeout != 0 {
-		var cancelFn context.CancelFunc
-		ctx, cancelFn = context.WithTimeout(ctx, timeout)
-		defer cancelFn()
-	}
+// Signer produces the signature over a DSSE pre-authentication encoded
+// payload. Implementations only deal with the raw signature, wrapping it
+// in the envelope is done by the attestation.
+type Signer interface {
+	SignPayload(ctx context.Context, payload []byte) ([]byte, error)
+}
 
-	// Initialize the TUF cache to ensure we have the
-	// latests root, otherwise proof of inclusion may fail.
-	if err := tuf.Initialize(ctx, tuf.DefaultRemoteRoot, nil); err != nil {
-		return nil, fmt.Errorf("initializing TUF client: %w", err)
+// Sign wraps the attestation in a DSSE envelope signed with signer. When
+// signer is nil, the attestation is signed with cosign keyless signing.
+func (att *Attestation) Sign(signer Signer) ([]byte, error) {
+	if signer == nil {
+		signer = NewCosignKeylessSigner()
 	}
 
-	ko := options.KeyOpts{
-		// KeyRef:     s.options.PrivateKeyPath,
-		// IDToken:    identityToken,
-		FulcioURL:    options.DefaultFulcioURL,
-		RekorURL:     options.DefaultRekorURL,
-		OIDCIssuer:   options.DefaultOIDCIssuerURL,
-		OIDCClientID: "sigstore",
-
-		InsecureSkipFulcioVerify: false,
-		SkipConfirmation:         true,
-		// FulcioAuthFlow:           "", //nolint: gocritic
-	}
+	ctx := context.Background()
 
-	sv, err := sign.SignerFromKeyOpts(ctx, certPath, certChainPath, ko)
+	json, err := att.ToJSON()
 	if err != nil {
-		return nil, fmt.Errorf("getting signer: %w", err)
+		return nil, fmt.Errorf("serializing attestation to json: %w", err)
 	}
-	defer sv.Close()
 
-	// Wrap the attestation in the DSSE envelope
-	wrapped := dsse.WrapSigner(sv, "application/vnd.in-toto+json")
+	return signEnvelope(ctx, signer, json)
+}
 
-	json, err := att.ToJSON()
+// signEnvelope wraps payload in a DSSE envelope signed by signer
+func signEnvelope(ctx context.Context, signer Signer, payload []byte) ([]byte, error) {
+	envSigner, err := dsse.NewEnvelopeSigner(&envelopeSigner{signer: signer})
 	if err != nil {
-		return nil, fmt.Errorf("serializing attestation to json: %w", err)
+		return nil, fmt.Errorf("creating envelope signer: %w", err)
 	}
 
-	signedPayload, err := wrapped.SignMessage(
-		bytes.NewReader(json), signatureoptions.WithContext(ctx),
-	)
+	envelope, err := envSigner.SignPayload(ctx, PayloadType, payload)
 	if err != nil {
 		return nil, fmt.Errorf("signing attestation: %w", err)
 	}
 
-	fmt.Println(string(signedPayload))
-	return signedPayload, nil
-
-	// TODO: review this
-	//nolint: gocritic
-	/*
-		opts := []static.Option{static.WithLayerMediaType(types.DssePayloadType)}
-		if sv.Cert != nil {
-			opts = append(opts, static.WithCertChain(sv.Cert, sv.Chain))
-		}
-	*/
-	// Should we upload?
-	/*
-		// Check whether we should be uploading to the transparency log
-		if sign.ShouldUploadToTlog(ctx, digest, force, noTlogUpload, ko.RekorURL) {
-			bundle, err := uploadToTlog(ctx, sv, ko.RekorURL, func(r *client.Rekor, b []byte) (*models.LogEntryAnon, error) {
-				return cosign.TLogUploadInTotoAttestation(ctx, r, signedPayload, b)
-			})
-			if err != nil {
-				return err
-			}
-			opts = append(opts, static.WithBundle(bundle))
-		}
-	*/
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("marshaling signed envelope: %w", err)
+	}
+	return data, nil
+}
+
+// envelopeSigner adapts a Signer to the signer interface of the DSSE library
+type envelopeSigner struct {
+	signer Signer
+}
+
+func (es *envelopeSigner) Sign(ctx context.Context, data []byte) ([]byte, error) {
+	if es.signer == nil {
+		return nil, errors.New("no signer defined")
+	}
+	return es.signer.SignPayload(ctx, data)
+}
+
+func (es *envelopeSigner) KeyID() (string, error) {
+	return "", nil
 }
@@ -0,0 +1,94 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSigner struct {
+	signed []byte
+}
+
+func (fs *fakeSigner) SignPayload(_ context.Context, payload []byte) ([]byte, error) {
+	fs.signed = payload
+	return []byte("fake-signature"), nil
+}
+
+func TestSignEnvelope(t *testing.T) {
+	att := New().SLSA()
+	att.Subject = append(att.Subject, Subject{
+		Name: "test.txt", Digest: common.DigestSet{"sha256": "abc"},
+	})
+
+	signer := &fakeSigner{}
+	data, err := att.Sign(signer)
+	require.NoError(t, err)
+
+	envelope := dsse.Envelope{}
+	require.NoError(t, json.Unmarshal(data, &envelope))
+	require.Equal(t, PayloadType, envelope.PayloadType)
+
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	require.NoError(t, err)
+	attJSON, err := att.ToJSON()
+	require.NoError(t, err)
+	require.Equal(t, attJSON, payload)
+
+	// The signer gets the pre-authentication encoding, not the raw payload
+	require.Equal(t, dsse.PAE(PayloadType, payload), signer.signed)
+	require.Len(t, envelope.Signatures, 1)
+	require.Equal(t, base64.StdEncoding.EncodeToString([]byte("fake-signature")), envelope.Signatures[0].Sig)
+}
+
+func TestKeySigner(t *testing.T) {
+	t.Setenv(keyPasswordEnvVar, "secret")
+	keys, err := cosign.GenerateKeyPair(func(bool) ([]byte, error) { return []byte("secret"), nil })
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "cosign.key")
+	require.NoError(t, os.WriteFile(keyPath, keys.PrivateBytes, os.FileMode(0o600)))
+
+	signer := NewSigner(keyPath)
+	require.IsType(t, &KeySigner{}, signer)
+	sig, err := signer.SignPayload(context.Background(), []byte("payload"))
+	require.NoError(t, err)
+
+	pub, err := cosign.PemToECDSAKey(keys.PublicBytes)
+	require.NoError(t, err)
+	verifier, err := signature.LoadECDSAVerifier(pub, crypto.SHA256)
+	require.NoError(t, err)
+	require.NoError(t, verifier.VerifySignature(bytes.NewReader(sig), bytes.NewReader([]byte("payload"))))
+}
+
+func TestNewSigner(t *testing.T) {
+	require.IsType(t, &CosignKeylessSigner{}, NewSigner(""))
+	require.IsType(t, &KMSSigner{}, NewSigner("gcpkms://projects/p/locations/l/keyRings/r/cryptoKeys/k"))
+	require.IsType(t, &KeySigner{}, NewSigner("cosign.key"))
+}
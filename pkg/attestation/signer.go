@@ -0,0 +1,145 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/options"
+	"github.com/sigstore/cosign/v2/cmd/cosign/cli/sign"
+	"github.com/sigstore/cosign/v2/pkg/cosign"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/kms"
+	signatureoptions "github.com/sigstore/sigstore/pkg/signature/options"
+	"github.com/sigstore/sigstore/pkg/tuf"
+)
+
+// keyPasswordEnvVar holds the password of encrypted private keys
+const keyPasswordEnvVar = "COSIGN_PASSWORD"
+
+// NewSigner returns the signer for a key reference. An empty reference
+// selects cosign keyless signing, references with a scheme (eg
+// gcpkms://...) are KMS keys and anything else is a path to a local key.
+func NewSigner(keyRef string) Signer {
+	switch {
+	case keyRef == "":
+		return NewCosignKeylessSigner()
+	case strings.Contains(keyRef, "://"):
+		return NewKMSSigner(keyRef)
+	default:
+		return NewKeySigner(keyRef)
+	}
+}
+
+// CosignKeylessSigner signs with a short lived certificate issued by
+// Fulcio to the identity obtained from the OIDC issuer
+type CosignKeylessSigner struct {
+	FulcioURL  string
+	RekorURL   string
+	OIDCIssuer string
+}
+
+func NewCosignKeylessSigner() *CosignKeylessSigner {
+	return &CosignKeylessSigner{
+		FulcioURL:  options.DefaultFulcioURL,
+		RekorURL:   options.DefaultRekorURL,
+		OIDCIssuer: options.DefaultOIDCIssuerURL,
+	}
+}
+
+func (s *CosignKeylessSigner) SignPayload(ctx context.Context, payload []byte) ([]byte, error) {
+	var certPath, certChainPath string
+
+	// Initialize the TUF cache to ensure we have the
+	// latests root, otherwise proof of inclusion may fail.
+	if err := tuf.Initialize(ctx, tuf.DefaultRemoteRoot, nil); err != nil {
+		return nil, fmt.Errorf("initializing TUF client: %w", err)
+	}
+
+	ko := options.KeyOpts{
+		FulcioURL:    s.FulcioURL,
+		RekorURL:     s.RekorURL,
+		OIDCIssuer:   s.OIDCIssuer,
+		OIDCClientID: "sigstore",
+
+		InsecureSkipFulcioVerify: false,
+		SkipConfirmation:         true,
+	}
+
+	sv, err := sign.SignerFromKeyOpts(ctx, certPath, certChainPath, ko)
+	if err != nil {
+		return nil, fmt.Errorf("getting signer: %w", err)
+	}
+	defer sv.Close()
+
+	return signMessage(ctx, sv, payload)
+}
+
+// KeySigner signs with a cosign private key stored in a local file. The
+// key password is read from the COSIGN_PASSWORD environment variable.
+type KeySigner struct {
+	KeyPath string
+}
+
+func NewKeySigner(keyPath string) *KeySigner {
+	return &KeySigner{KeyPath: keyPath}
+}
+
+func (s *KeySigner) SignPayload(ctx context.Context, payload []byte) ([]byte, error) {
+	keyData, err := os.ReadFile(s.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading private key: %w", err)
+	}
+	sv, err := cosign.LoadPrivateKey(keyData, []byte(os.Getenv(keyPasswordEnvVar)))
+	if err != nil {
+		return nil, fmt.Errorf("loading private key: %w", err)
+	}
+	return signMessage(ctx, sv, payload)
+}
+
+// KMSSigner signs with a key held in a key management service. The
+// provider of the key reference has to be registered by importing its
+// sigstore package, eg sigstore/pkg/signature/kms/gcp.
+type KMSSigner struct {
+	KeyRef string
+}
+
+func NewKMSSigner(keyRef string) *KMSSigner {
+	return &KMSSigner{KeyRef: keyRef}
+}
+
+func (s *KMSSigner) SignPayload(ctx context.Context, payload []byte) ([]byte, error) {
+	sv, err := kms.Get(ctx, s.KeyRef, crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("getting kms key %s: %w", s.KeyRef, err)
+	}
+	return signMessage(ctx, sv, payload)
+}
+
+// signMessage signs payload with a sigstore signer
+func signMessage(ctx context.Context, signer signature.Signer, payload []byte) ([]byte, error) {
+	sig, err := signer.SignMessage(bytes.NewReader(payload), signatureoptions.WithContext(ctx))
+	if err != nil {
+		return nil, fmt.Errorf("signing payload: %w", err)
+	}
+	return sig, nil
+}
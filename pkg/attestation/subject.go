@@ -0,0 +1,205 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	purl "github.com/package-url/packageurl-go"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// SubjectTransformer computes the name of the subject that
+// represents an artifact in the attestation
+type SubjectTransformer func(run.Artifact) string
+
+// DefaultSubjectTransformer is the name of the transformer used
+// when none is specified
+const DefaultSubjectTransformer = "identity"
+
+// subjectTransformersMtx guards subjectTransformers against
+// transformers registered while others are looked up
+var subjectTransformersMtx sync.RWMutex
+
+var subjectTransformers = map[string]SubjectTransformer{
+	// identity uses the artifact path as returned by the store
+	"identity": func(a run.Artifact) string {
+		return a.Path
+	},
+
+	// basename drops everything but the last element of the path
+	"basename": func(a run.Artifact) string {
+		return path.Base(a.Path)
+	},
+
+	// purl names the subject with a generic package URL
+	"purl": func(a run.Artifact) string {
+		qualifiers := purl.Qualifiers{}
+		if sha, ok := a.Checksum["SHA256"]; ok {
+			qualifiers = append(qualifiers, purl.Qualifier{Key: "checksum", Value: "sha256:" + sha})
+		}
+		if u, err := url.Parse(a.Path); err == nil && u.Scheme != "" {
+			qualifiers = append(qualifiers, purl.Qualifier{Key: "download_url", Value: a.Path})
+		}
+		return purl.NewPackageURL(
+			purl.TypeGeneric, "", path.Base(a.Path), "", qualifiers, "",
+		).ToString()
+	},
+
+	// uri returns the artifact location as a URI, local paths
+	// are converted to file: URIs
+	"uri": func(a run.Artifact) string {
+		if u, err := url.Parse(a.Path); err == nil && u.Scheme != "" {
+			return a.Path
+		}
+		return (&url.URL{Scheme: "file", Path: a.Path}).String()
+	},
+}
+
+// RegisterSubjectTransformer makes t available under name, eg to
+// name subjects after an internal artifact registry. Registering
+// a name again replaces its transformer.
+func RegisterSubjectTransformer(name string, t SubjectTransformer) {
+	subjectTransformersMtx.Lock()
+	defer subjectTransformersMtx.Unlock()
+	subjectTransformers[name] = t
+}
+
+// GetSubjectTransformer returns the subject transformer registered
+// under name. An empty name returns the default transformer.
+func GetSubjectTransformer(name string) (SubjectTransformer, error) {
+	if name == "" {
+		name = DefaultSubjectTransformer
+	}
+	subjectTransformersMtx.RLock()
+	t, ok := subjectTransformers[name]
+	subjectTransformersMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf(
+			"unknown subject transformer %q (available: %s)",
+			name, strings.Join(SubjectTransformerNames(), ", "),
+		)
+	}
+	return t, nil
+}
+
+// SubjectTransformerNames returns the names of the registered transformers
+func SubjectTransformerNames() []string {
+	subjectTransformersMtx.RLock()
+	defer subjectTransformersMtx.RUnlock()
+	names := []string{}
+	for n := range subjectTransformers {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// subjectDigestLengths are the hex lengths of the digest
+// algorithms accepted in subjects added by hand
+var subjectDigestLengths = map[string]int{
+	"SHA1":   40,
+	"SHA256": 64,
+	"SHA512": 128,
+}
+
+// ParseSubject parses a subject from a string in the form
+// name@algorithm:hexdigest (eg tejolote@sha256:abc...). The
+// algorithm is recorded in uppercase like the store digests.
+func ParseSubject(spec string) (Subject, error) {
+	name, digest, ok := cutLast(spec, "@")
+	if !ok || name == "" {
+		return Subject{}, fmt.Errorf("subject %q is not in the form name@algorithm:digest", spec)
+	}
+	algo, value, ok := strings.Cut(digest, ":")
+	if !ok {
+		return Subject{}, fmt.Errorf("digest of subject %q has no algorithm", spec)
+	}
+	algo = strings.ToUpper(algo)
+	length, ok := subjectDigestLengths[algo]
+	if !ok {
+		return Subject{}, fmt.Errorf("unsupported digest algorithm %q in subject %q", algo, spec)
+	}
+	if _, err := hex.DecodeString(value); err != nil || len(value) != length {
+		return Subject{}, fmt.Errorf("invalid %s digest in subject %q", algo, spec)
+	}
+	return Subject{
+		Name:   name,
+		Digest: common.DigestSet{algo: strings.ToLower(value)},
+	}, nil
+}
+
+// SubjectsEqual returns true if both lists have the same subjects with
+// the same digests, in any order. Annotations are not compared and
+// digest algorithm names are matched regardless of their case.
+func SubjectsEqual(a, b []Subject) bool {
+	set := func(subjects []Subject) map[string]int {
+		keys := map[string]int{}
+		for _, s := range subjects {
+			keys[s.Name+"@"+digestKey(s.Digest)]++
+		}
+		return keys
+	}
+	setA, setB := set(a), set(b)
+	if len(setA) != len(setB) {
+		return false
+	}
+	for key, n := range setA {
+		if setB[key] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// SortSubjects sorts the subjects by name, then by digest, so
+// attestations of the same artifacts are byte for byte equal
+func SortSubjects(subjects []Subject) {
+	sort.SliceStable(subjects, func(i, j int) bool {
+		if subjects[i].Name != subjects[j].Name {
+			return subjects[i].Name < subjects[j].Name
+		}
+		return digestKey(subjects[i].Digest) < digestKey(subjects[j].Digest)
+	})
+}
+
+// digestKey returns a digest set as a string that does not depend
+// on the map order or the case of the algorithms and values
+func digestKey(digest common.DigestSet) string {
+	digests := []string{}
+	for algo, value := range digest {
+		digests = append(digests, strings.ToUpper(algo)+":"+strings.ToLower(value))
+	}
+	sort.Strings(digests)
+	return strings.Join(digests, ",")
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
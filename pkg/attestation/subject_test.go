@@ -0,0 +1,124 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"path"
+	"testing"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+func TestSubjectTransformers(t *testing.T) {
+	for _, tc := range []struct {
+		transformer string
+		path        string
+		expected    string
+	}{
+		{"identity", "bin/linux/tejolote", "bin/linux/tejolote"},
+		{"identity", "gs://bucket/release/tejolote", "gs://bucket/release/tejolote"},
+		{"", "bin/linux/tejolote", "bin/linux/tejolote"},
+		{"basename", "bin/linux/tejolote", "tejolote"},
+		{"basename", "gs://bucket/release/tejolote", "tejolote"},
+		{"basename", "tejolote", "tejolote"},
+	} {
+		transform, err := GetSubjectTransformer(tc.transformer)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, transform(run.Artifact{Path: tc.path}))
+	}
+
+	_, err := GetSubjectTransformer("unknown")
+	require.Error(t, err)
+}
+
+func TestRegisterSubjectTransformer(t *testing.T) {
+	RegisterSubjectTransformer("registry", func(a run.Artifact) string {
+		return "registry.example.com/" + path.Base(a.Path)
+	})
+	defer func() {
+		subjectTransformersMtx.Lock()
+		delete(subjectTransformers, "registry")
+		subjectTransformersMtx.Unlock()
+	}()
+
+	require.Contains(t, SubjectTransformerNames(), "registry")
+	transform, err := GetSubjectTransformer("registry")
+	require.NoError(t, err)
+	require.Equal(t, "registry.example.com/tejolote", transform(run.Artifact{Path: "bin/linux/tejolote"}))
+}
+
+func TestParseSubject(t *testing.T) {
+	sha256 := "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+	s, err := ParseSubject("pkg:oci/tejolote@v1?repository_url=ghcr.io@sha256:" + sha256)
+	require.NoError(t, err)
+	require.Equal(t, "pkg:oci/tejolote@v1?repository_url=ghcr.io", s.Name)
+	require.Equal(t, sha256, s.Digest["SHA256"])
+
+	s, err = ParseSubject("tejolote@SHA1:0BEEC7B5EA3F0FDBC95D0DD47F3C5BC275DA8A33")
+	require.NoError(t, err)
+	require.Equal(t, "0beec7b5ea3f0fdbc95d0dd47f3c5bc275da8a33", s.Digest["SHA1"])
+
+	for _, spec := range []string{
+		"tejolote",
+		"@sha256:" + sha256,
+		"tejolote@" + sha256,
+		"tejolote@md5:d3b07384d113edec49eaa6238ad5ff00",
+		"tejolote@sha256:" + sha256[1:],
+		"tejolote@sha256:" + sha256[1:] + "z",
+	} {
+		_, err := ParseSubject(spec)
+		require.Error(t, err, spec)
+	}
+}
+
+func TestSubjectsEqual(t *testing.T) {
+	subjects := []Subject{
+		{Name: "tejolote-linux", Digest: common.DigestSet{"SHA256": "aaa", "SHA512": "bbb"}},
+		{Name: "tejolote-darwin", Digest: common.DigestSet{"SHA256": "ccc"}},
+	}
+	for _, tc := range []struct {
+		name     string
+		other    []Subject
+		expected bool
+	}{
+		{"identical", []Subject{
+			{Name: "tejolote-linux", Digest: common.DigestSet{"SHA256": "aaa", "SHA512": "bbb"}},
+			{Name: "tejolote-darwin", Digest: common.DigestSet{"SHA256": "ccc"}},
+		}, true},
+		{"reordered with annotations", []Subject{
+			{Name: "tejolote-darwin", Digest: common.DigestSet{"sha256": "CCC"}, Annotations: map[string]string{"a": "b"}},
+			{Name: "tejolote-linux", Digest: common.DigestSet{"SHA512": "bbb", "SHA256": "aaa"}},
+		}, true},
+		{"different digest", []Subject{
+			{Name: "tejolote-linux", Digest: common.DigestSet{"SHA256": "aaa", "SHA512": "bbb"}},
+			{Name: "tejolote-darwin", Digest: common.DigestSet{"SHA256": "ddd"}},
+		}, false},
+		{"renamed", []Subject{
+			{Name: "tejolote-linux", Digest: common.DigestSet{"SHA256": "aaa", "SHA512": "bbb"}},
+			{Name: "tejolote-macos", Digest: common.DigestSet{"SHA256": "ccc"}},
+		}, false},
+		{"missing subject", subjects[:1], false},
+		{"extra subject", append([]Subject{{Name: "new", Digest: common.DigestSet{"SHA256": "eee"}}}, subjects...), false},
+		{"empty", []Subject{}, false},
+	} {
+		require.Equal(t, tc.expected, SubjectsEqual(subjects, tc.other), tc.name)
+	}
+	require.True(t, SubjectsEqual(nil, []Subject{}))
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// DefaultTransformTimeout is the time a predicate transform
+	// program is allowed to run before it is killed
+	DefaultTransformTimeout = 30 * time.Second
+
+	// maxTransformOutput caps the size of the transformed predicate
+	maxTransformOutput = 10 * 1024 * 1024
+)
+
+// PredicateTransformer post-processes predicates by piping them through
+// an external program. The program receives the predicate JSON in its
+// standard input and writes the transformed predicate to its standard
+// output. It runs without a shell and with only PATH in its environment.
+type PredicateTransformer struct {
+	// Command is the program and its arguments
+	Command []string
+
+	// Timeout is the maximum time the program can run
+	Timeout time.Duration
+}
+
+// Transform runs the program on the predicate and returns the
+// transformed predicate after checking it is still valid
+func (pt *PredicateTransformer) Transform(pred *SLSAPredicate) (*SLSAPredicate, error) {
+	if len(pt.Command) == 0 {
+		return nil, errors.New("predicate transform command not set")
+	}
+	input, err := json.Marshal(pred)
+	if err != nil {
+		return nil, fmt.Errorf("serializing predicate: %w", err)
+	}
+
+	timeout := pt.Timeout
+	if timeout == 0 {
+		timeout = DefaultTransformTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, pt.Command[0], pt.Command[1:]...) //nolint: gosec // Command set by the user
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &limitedWriter{w: &stdout, n: maxTransformOutput}
+	cmd.Stderr = &limitedWriter{w: &stderr, n: maxTransformOutput}
+
+	logrus.Infof("Transforming predicate with %s", pt.Command[0])
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("predicate transform timed out after %s", timeout)
+		}
+		return nil, fmt.Errorf("running predicate transform: %w: %s", err, stderr.String())
+	}
+
+	// Decode strictly, fields not in the predicate spec would be lost
+	transformed := &SLSAPredicate{}
+	dec := json.NewDecoder(&stdout)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(transformed); err != nil {
+		return nil, fmt.Errorf("transformed predicate is not valid: %w", err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return nil, errors.New("transformed predicate has trailing data")
+	}
+	return transformed, nil
+}
+
+// limitedWriter fails writes after n bytes have been written
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.n {
+		return 0, errors.New("predicate transform output too large")
+	}
+	lw.n -= int64(len(p))
+	return lw.w.Write(p)
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPredicateTransform(t *testing.T) {
+	pred := NewSLSAPredicate()
+	pred.Builder.ID = "https://example.com/builder"
+
+	// The transform injects a field in the build config
+	pt := PredicateTransformer{Command: []string{
+		"sed", "-e", `s|"buildType":""|"buildType":"","buildConfig":{"team":"release"}|`,
+	}}
+	transformed, err := pt.Transform(&pred)
+	require.NoError(t, err)
+	require.Equal(t, "https://example.com/builder", transformed.Builder.ID)
+	require.Equal(t, map[string]interface{}{"team": "release"}, transformed.BuildConfig)
+
+	// Output that is not a predicate is rejected
+	for _, command := range [][]string{
+		{"echo", "not json"},
+		{"echo", `{"unknown": true}`},
+		{"false"},
+	} {
+		pt := PredicateTransformer{Command: command}
+		_, err := pt.Transform(&pred)
+		require.Error(t, err, command)
+	}
+
+	pt = PredicateTransformer{Command: []string{"sleep", "5"}, Timeout: 100 * time.Millisecond}
+	_, err = pt.Transform(&pred)
+	require.Error(t, err)
+}
@@ -0,0 +1,174 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+)
+
+const (
+	// VSAPredicateType is the predicate type of SLSA verification summaries
+	VSAPredicateType = "https://slsa.dev/verification_summary/v1"
+
+	// Results of the verification
+	VSAResultPassed = "PASSED"
+	VSAResultFailed = "FAILED"
+)
+
+type (
+	// VSA is an in-toto statement with a SLSA verification summary
+	// predicate. It records that a verifier checked the provenance
+	// of the subjects against a policy and the outcome.
+	VSA struct {
+		intoto.StatementHeader
+		Subject   []Subject    `json:"subject"`
+		Predicate VSAPredicate `json:"predicate"`
+	}
+
+	VSAPredicate struct {
+		Verifier           VSAVerifier           `json:"verifier"`
+		TimeVerified       *time.Time            `json:"timeVerified,omitempty"`
+		ResourceURI        string                `json:"resourceUri"`
+		Policy             VSAPolicy             `json:"policy"`
+		InputAttestations  []VSAInputAttestation `json:"inputAttestations,omitempty"`
+		VerificationResult string                `json:"verificationResult"`
+		VerifiedLevels     []string              `json:"verifiedLevels"`
+		DependencyLevels   map[string]int        `json:"dependencyLevels,omitempty"`
+		SLSAVersion        string                `json:"slsaVersion,omitempty"`
+	}
+
+	VSAVerifier struct {
+		ID      string            `json:"id"`
+		Version map[string]string `json:"version,omitempty"`
+	}
+
+	VSAPolicy struct {
+		URI    string           `json:"uri,omitempty"`
+		Digest common.DigestSet `json:"digest,omitempty"`
+	}
+
+	// VSAInputAttestation is an attestation read to reach the result
+	VSAInputAttestation struct {
+		URI    string           `json:"uri"`
+		Digest common.DigestSet `json:"digest"`
+	}
+)
+
+// NewVSA returns a verification summary statement without subjects
+func NewVSA() *VSA {
+	return &VSA{
+		StatementHeader: intoto.StatementHeader{
+			Type:          intoto.StatementInTotoV01,
+			PredicateType: VSAPredicateType,
+		},
+		Subject:   []Subject{},
+		Predicate: NewVSAPredicate(),
+	}
+}
+
+// NewVSAPredicate returns a verification summary predicate
+// recording a failed verification until a result is set
+func NewVSAPredicate() VSAPredicate {
+	return VSAPredicate{
+		VerificationResult: VSAResultFailed,
+		VerifiedLevels:     []string{},
+		SLSAVersion:        "1.0",
+	}
+}
+
+// SetVerifier records the ID of the verifier
+func (pred *VSAPredicate) SetVerifier(id string) {
+	pred.Verifier.ID = id
+}
+
+// SetTimeVerified records when the verification was performed
+func (pred *VSAPredicate) SetTimeVerified(t time.Time) {
+	t = t.UTC()
+	pred.TimeVerified = &t
+}
+
+// SetResourceURI records the URI of the verified resource
+func (pred *VSAPredicate) SetResourceURI(uri string) {
+	pred.ResourceURI = uri
+}
+
+// SetPolicy records the policy the subjects were verified against
+func (pred *VSAPredicate) SetPolicy(uri string, digest map[string]string) {
+	pred.Policy = VSAPolicy{URI: uri, Digest: digest}
+}
+
+// SetResult records whether the verification passed
+func (pred *VSAPredicate) SetResult(passed bool) {
+	pred.VerificationResult = VSAResultFailed
+	if passed {
+		pred.VerificationResult = VSAResultPassed
+	}
+}
+
+// SetVerifiedLevels records the levels the subjects were
+// verified at, eg SLSA_BUILD_LEVEL_3
+func (pred *VSAPredicate) SetVerifiedLevels(levels ...string) {
+	pred.VerifiedLevels = append([]string{}, levels...)
+}
+
+// AddInputAttestation records an attestation read during the verification
+func (pred *VSAPredicate) AddInputAttestation(uri string, digest map[string]string) {
+	pred.InputAttestations = append(pred.InputAttestations, VSAInputAttestation{
+		URI: uri, Digest: digest,
+	})
+}
+
+func (vsa *VSA) ToJSON() ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	if err := enc.Encode(vsa); err != nil {
+		return nil, fmt.Errorf("encoding verification summary: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// Encode serializes the verification summary in one of the output formats
+func (vsa *VSA) Encode(format string) ([]byte, error) {
+	data, err := vsa.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("serializing verification summary to json: %w", err)
+	}
+	return encodeStatement(data, format)
+}
+
+// Sign wraps the verification summary in a DSSE envelope signed
+// with signer. When signer is nil, cosign keyless signing is used.
+func (vsa *VSA) Sign(signer Signer) ([]byte, error) {
+	if signer == nil {
+		signer = NewCosignKeylessSigner()
+	}
+	data, err := vsa.ToJSON()
+	if err != nil {
+		return nil, fmt.Errorf("serializing verification summary to json: %w", err)
+	}
+	return signEnvelope(context.Background(), signer, data)
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attestation
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	"github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVSA(t *testing.T) {
+	vsa := NewVSA()
+	vsa.Subject = append(vsa.Subject, Subject{
+		Name: "app.tar.gz", Digest: common.DigestSet{"sha256": "abc"},
+	})
+	require.Equal(t, VSAResultFailed, vsa.Predicate.VerificationResult)
+
+	verified := time.Date(2022, 10, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	vsa.Predicate.SetVerifier("https://example.com/verifier")
+	vsa.Predicate.SetTimeVerified(verified)
+	vsa.Predicate.SetPolicy("https://example.com/policy.rego", map[string]string{"sha256": "def"})
+	vsa.Predicate.SetResult(true)
+	vsa.Predicate.SetVerifiedLevels("SLSA_BUILD_LEVEL_3")
+
+	data, err := vsa.Encode(FormatJSON)
+	require.NoError(t, err)
+	decoded := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, VSAPredicateType, decoded["predicateType"])
+
+	predicate, ok := decoded["predicate"].(map[string]interface{})
+	require.True(t, ok)
+	require.Equal(t, map[string]interface{}{"id": "https://example.com/verifier"}, predicate["verifier"])
+	require.Equal(t, "2022-10-01T10:00:00Z", predicate["timeVerified"])
+	require.Equal(t, "PASSED", predicate["verificationResult"])
+	require.Equal(t, []interface{}{"SLSA_BUILD_LEVEL_3"}, predicate["verifiedLevels"])
+	require.Equal(t, "https://example.com/policy.rego", predicate["policy"].(map[string]interface{})["uri"])
+
+	// Signed summaries are wrapped in a DSSE envelope like the provenance
+	signed, err := vsa.Sign(&fakeSigner{})
+	require.NoError(t, err)
+	envelope := dsse.Envelope{}
+	require.NoError(t, json.Unmarshal(signed, &envelope))
+	require.Equal(t, PayloadType, envelope.PayloadType)
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	require.NoError(t, err)
+	vsaJSON, err := vsa.ToJSON()
+	require.NoError(t, err)
+	require.Equal(t, vsaJSON, payload)
+}
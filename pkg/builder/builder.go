@@ -18,12 +18,16 @@ package builder
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/release-utils/version"
 
 	"sigs.k8s.io/tejolote/pkg/attestation"
 	"sigs.k8s.io/tejolote/pkg/builder/driver"
+	"sigs.k8s.io/tejolote/pkg/git"
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store"
 )
@@ -31,7 +35,12 @@ import (
 type Builder struct {
 	SpecURL string
 	VCSURL  string
-	driver  driver.BuildSystem
+
+	// ValidUntil is recorded in the predicate to signal consumers
+	// when the provenance should be considered stale
+	ValidUntil time.Time
+
+	driver driver.BuildSystem
 }
 
 // New returns a new builder loaded with the driver derived from
@@ -50,6 +59,33 @@ func New(spec string) (bldr Builder, err error) {
 	return bldr, nil
 }
 
+// NewWithDriver returns a builder that uses a build system driver
+// created elsewhere, eg one implemented outside of tejolote
+func NewWithDriver(spec string, d driver.BuildSystem) Builder {
+	return Builder{
+		SpecURL: spec,
+		driver:  d,
+	}
+}
+
+// Log returns a logger with the fields identifying the
+// run and the driver of the build system
+func (b *Builder) Log() *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"spec_url": b.SpecURL,
+		"driver":   b.DriverName(),
+	})
+}
+
+// DriverName returns the name of the build system driver,
+// the scheme of the spec URL
+func (b *Builder) DriverName() string {
+	if u, err := url.Parse(b.SpecURL); err == nil {
+		return u.Scheme
+	}
+	return ""
+}
+
 func (b *Builder) Snap() error {
 	return nil
 }
@@ -69,26 +105,62 @@ func (b *Builder) BuildPredicate(r *run.Run, draft *attestation.SLSAPredicate) (
 	if err != nil {
 		return nil, err
 	}
+	if err := b.addGeneratorInfo(pred); err != nil {
+		return nil, fmt.Errorf("recording tejolote version in predicate: %w", err)
+	}
 	// If there is a VCS URL set, add it to the predicate
 	if b.VCSURL != "" {
 		commithash := map[string]string{}
 		u, commit, ok := strings.Cut(b.VCSURL, "@")
 		if ok {
 			// The thing after the @ may not be a commit
-			if len(commit) == 40 {
-				commithash["sha1"] = commit
+			if algo := git.CommitHashAlgorithm(commit); algo != "" {
+				commithash[algo] = strings.ToLower(commit)
 			} else {
 				u = b.VCSURL
 			}
 			pred.AddMaterial(u, commithash)
 		} else {
-			logrus.Warn("unable to read commit from vcs url")
+			b.Log().Warn("unable to read commit from vcs url")
 			pred.AddMaterial(u, commithash)
 		}
 	}
 	return pred, nil
 }
 
+// generatorInfo records the tejolote code that generated the
+// predicate and until when the predicate should be trusted
+type generatorInfo struct {
+	Version          string     `json:"version"`
+	Driver           string     `json:"driver"`
+	DriverAPIVersion string     `json:"driverApiVersion"`
+	ValidUntil       *time.Time `json:"validUntil,omitempty"`
+}
+
+// addGeneratorInfo adds a block to the invocation environment noting
+// the tejolote version and the driver that built the predicate
+func (b *Builder) addGeneratorInfo(pred *attestation.SLSAPredicate) error {
+	env, err := pred.EnvironmentMap()
+	if err != nil {
+		return err
+	}
+
+	info := generatorInfo{
+		Version:          version.GetVersionInfo().GitVersion,
+		Driver:           b.DriverName(),
+		DriverAPIVersion: driver.APIVersion,
+	}
+	if !b.ValidUntil.IsZero() {
+		validUntil := b.ValidUntil.UTC()
+		info.ValidUntil = &validUntil
+	}
+	env["tejolote"] = info
+	return nil
+}
+
 func (b *Builder) ArtifactStores() []store.Store {
+	if b.driver == nil {
+		return []store.Store{}
+	}
 	return b.driver.ArtifactStores()
 }
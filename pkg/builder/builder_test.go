@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package builder
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+)
+
+type fakeDriver struct{}
+
+func (fakeDriver) GetRun(string) (*run.Run, error) { return &run.Run{}, nil }
+func (fakeDriver) RefreshRun(*run.Run) error       { return nil }
+func (fakeDriver) ArtifactStores() []store.Store   { return []store.Store{} }
+func (fakeDriver) BuildPredicate(_ *run.Run, draft *attestation.SLSAPredicate) (*attestation.SLSAPredicate, error) {
+	draft.Invocation.Environment = struct {
+		Runner string `json:"runner"`
+	}{"test"}
+	return draft, nil
+}
+
+func TestBuildPredicateGeneratorInfo(t *testing.T) {
+	b := Builder{SpecURL: "gcb://project/build-id", driver: fakeDriver{}}
+	pred := attestation.NewSLSAPredicate()
+	predicate, err := b.BuildPredicate(&run.Run{}, &pred)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(predicate.Invocation.Environment)
+	require.NoError(t, err)
+	env := struct {
+		Runner   string `json:"runner"`
+		Tejolote struct {
+			Version          string `json:"version"`
+			Driver           string `json:"driver"`
+			DriverAPIVersion string `json:"driverApiVersion"`
+		} `json:"tejolote"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &env))
+
+	// The driver data is preserved
+	require.Equal(t, "test", env.Runner)
+	require.Equal(t, "gcb", env.Tejolote.Driver)
+	require.Equal(t, "v1", env.Tejolote.DriverAPIVersion)
+	require.NotEmpty(t, env.Tejolote.Version)
+}
+
+func TestBuildPredicateValidUntil(t *testing.T) {
+	validUntil := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.FixedZone("CET", 3600))
+	b := Builder{SpecURL: "gcb://project/build-id", driver: fakeDriver{}, ValidUntil: validUntil}
+	pred := attestation.NewSLSAPredicate()
+	predicate, err := b.BuildPredicate(&run.Run{}, &pred)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(predicate.Invocation.Environment)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"validUntil":"2030-01-02T02:04:05Z"`)
+
+	// Without an expiry, the field is not recorded
+	b.ValidUntil = time.Time{}
+	pred = attestation.NewSLSAPredicate()
+	predicate, err = b.BuildPredicate(&run.Run{}, &pred)
+	require.NoError(t, err)
+	data, err = json.Marshal(predicate.Invocation.Environment)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "validUntil")
+}
+
+func TestBuildPredicateVCSURL(t *testing.T) {
+	sha1 := "0123456789abcdef0123456789abcdef01234567"
+	sha256 := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for vcsURL, expected := range map[string]struct {
+		uri    string
+		digest map[string]string
+	}{
+		"git+https://github.com/org/repo@" + sha1:   {"git+https://github.com/org/repo", map[string]string{"sha1": sha1}},
+		"git+https://github.com/org/repo@" + sha256: {"git+https://github.com/org/repo", map[string]string{"sha256": sha256}},
+		"git+https://github.com/org/repo@main":      {"git+https://github.com/org/repo@main", map[string]string{}},
+	} {
+		b := Builder{SpecURL: "gcb://project/build-id", VCSURL: vcsURL, driver: fakeDriver{}}
+		pred := attestation.NewSLSAPredicate()
+		predicate, err := b.BuildPredicate(&run.Run{}, &pred)
+		require.NoError(t, err)
+		require.Len(t, predicate.Materials, 1, vcsURL)
+		require.Equal(t, expected.uri, predicate.Materials[0].URI, vcsURL)
+		require.Equal(t, expected.digest, map[string]string(predicate.Materials[0].Digest), vcsURL)
+	}
+}
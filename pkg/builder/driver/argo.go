@@ -0,0 +1,457 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+)
+
+const (
+	ARGO          = "argo"
+	argoBuildType = "https://argoproj.github.io/argo-workflows/Workflow@v1"
+)
+
+// argoWorkflowResource is the API resource of argo workflows
+var argoWorkflowResource = schema.GroupVersionResource{
+	Group: "argoproj.io", Version: "v1alpha1", Resource: "workflows",
+}
+
+// Well known names of the workflow parameters and artifacts
+// that hold the git source of the build
+var (
+	argoRepoParameters     = []string{"repo", "repo-url", "git-repo", "git-url", "repository"}
+	argoRevisionParameters = []string{"revision", "git-revision", "commit", "sha", "git-sha"}
+)
+
+var commitRegex = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ArgoWorkflow is a driver that reads the status of an Argo
+// Workflow from the Kubernetes API
+type ArgoWorkflow struct {
+	Namespace string
+	Name      string
+	client    dynamic.Interface
+}
+
+// argoWorkflow are the fields of the Workflow custom resource we read
+type argoWorkflow struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+		UID       string `json:"uid"`
+	} `json:"metadata"`
+	Spec   argoWorkflowSpec `json:"spec"`
+	Status struct {
+		Phase      string              `json:"phase"`
+		StartedAt  string              `json:"startedAt"`
+		FinishedAt string              `json:"finishedAt"`
+		Nodes      map[string]argoNode `json:"nodes"`
+		// Workflows submitted from a template keep its spec here
+		StoredWorkflowTemplateSpec *argoWorkflowSpec `json:"storedWorkflowTemplateSpec"`
+	} `json:"status"`
+}
+
+type argoWorkflowSpec struct {
+	Entrypoint          string         `json:"entrypoint"`
+	ServiceAccountName  string         `json:"serviceAccountName"`
+	Arguments           argoInputs     `json:"arguments"`
+	Templates           []argoTemplate `json:"templates"`
+	WorkflowTemplateRef *struct {
+		Name string `json:"name"`
+	} `json:"workflowTemplateRef"`
+}
+
+type argoTemplate struct {
+	Name      string         `json:"name"`
+	Container *argoContainer `json:"container"`
+	Script    *argoContainer `json:"script"`
+}
+
+type argoContainer struct {
+	Image      string   `json:"image"`
+	Command    []string `json:"command"`
+	Args       []string `json:"args"`
+	WorkingDir string   `json:"workingDir"`
+	Env        []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"env"`
+}
+
+type argoInputs struct {
+	Parameters []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	} `json:"parameters"`
+	Artifacts []struct {
+		Name string `json:"name"`
+		Git  *struct {
+			Repo     string `json:"repo"`
+			Revision string `json:"revision"`
+		} `json:"git"`
+	} `json:"artifacts"`
+}
+
+type argoNode struct {
+	ID           string      `json:"id"`
+	Name         string      `json:"name"`
+	DisplayName  string      `json:"displayName"`
+	Type         string      `json:"type"`
+	TemplateName string      `json:"templateName"`
+	Phase        string      `json:"phase"`
+	StartedAt    string      `json:"startedAt"`
+	FinishedAt   string      `json:"finishedAt"`
+	Inputs       *argoInputs `json:"inputs"`
+}
+
+// NewArgoWorkflow returns a driver for a workflow specified
+// as argo://namespace/workflow-name
+func NewArgoWorkflow(specURL string) (*ArgoWorkflow, error) {
+	namespace, name, err := parseArgoURL(specURL)
+	if err != nil {
+		return nil, err
+	}
+	return &ArgoWorkflow{Namespace: namespace, Name: name}, nil
+}
+
+func parseArgoURL(specURL string) (namespace, name string, err error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return "", "", fmt.Errorf("parsing argo spec url: %w", err)
+	}
+	if u.Scheme != ARGO {
+		return "", "", errors.New("URL is not an argo URL")
+	}
+	name = strings.Trim(u.Path, "/")
+	if u.Hostname() == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", fmt.Errorf("argo URL %s is not in the form argo://namespace/workflow", specURL)
+	}
+	return u.Hostname(), name, nil
+}
+
+// getClient returns the kubernetes client, it uses the in-cluster
+// configuration when running in a pod and KUBECONFIG otherwise
+func (aw *ArgoWorkflow) getClient() (dynamic.Interface, error) {
+	if aw.client != nil {
+		return aw.client, nil
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		if !errors.Is(err, rest.ErrNotInCluster) {
+			return nil, fmt.Errorf("reading in-cluster config: %w", err)
+		}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{},
+		).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("reading kubeconfig: %w", err)
+		}
+	}
+	aw.client, err = dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating kubernetes client: %w", err)
+	}
+	return aw.client, nil
+}
+
+func (aw *ArgoWorkflow) GetRun(specURL string) (*run.Run, error) {
+	r := &run.Run{
+		SpecURL:   specURL,
+		IsSuccess: false,
+		Steps:     []run.Step{},
+		Artifacts: []run.Artifact{},
+		StartTime: time.Time{},
+		EndTime:   time.Time{},
+	}
+	if err := aw.RefreshRun(r); err != nil {
+		return nil, fmt.Errorf("doing initial refresh of run data: %w", err)
+	}
+	return r, nil
+}
+
+// RefreshRun reads the workflow resource and updates the run
+func (aw *ArgoWorkflow) RefreshRun(r *run.Run) error {
+	namespace, name, err := parseArgoURL(r.SpecURL)
+	if err != nil {
+		return err
+	}
+	client, err := aw.getClient()
+	if err != nil {
+		return err
+	}
+	obj, err := client.Resource(argoWorkflowResource).Namespace(namespace).Get(
+		context.Background(), name, metav1.GetOptions{},
+	)
+	if err != nil {
+		return fmt.Errorf("getting workflow %s/%s: %w", namespace, name, err)
+	}
+	data, err := json.Marshal(obj.Object)
+	if err != nil {
+		return fmt.Errorf("marshaling workflow: %w", err)
+	}
+	wf := &argoWorkflow{}
+	if err := json.Unmarshal(data, wf); err != nil {
+		return fmt.Errorf("unmarshaling workflow: %w", err)
+	}
+
+	switch wf.Status.Phase {
+	case "Succeeded":
+		r.IsRunning, r.IsSuccess = false, true
+	case "Failed", "Error":
+		r.IsRunning, r.IsSuccess = false, false
+	default:
+		// Workflows without a phase have not been picked up yet
+		r.IsRunning, r.IsSuccess = true, false
+	}
+
+	if r.StartTime, err = parseArgoTime(wf.Status.StartedAt); err != nil {
+		return fmt.Errorf("parsing workflow start time: %w", err)
+	}
+	if r.EndTime, err = parseArgoTime(wf.Status.FinishedAt); err != nil {
+		return fmt.Errorf("parsing workflow finish time: %w", err)
+	}
+
+	r.Params = []string{}
+	for _, p := range wf.spec().Arguments.Parameters {
+		r.Params = append(r.Params, fmt.Sprintf("%s=%s", p.Name, p.Value))
+	}
+
+	r.Steps, err = wf.steps()
+	if err != nil {
+		return fmt.Errorf("reading workflow steps: %w", err)
+	}
+	r.SystemData = wf
+	return nil
+}
+
+// spec returns the workflow spec, merging the templates of the
+// workflow template it was submitted from
+func (wf *argoWorkflow) spec() argoWorkflowSpec {
+	spec := wf.Spec
+	if stored := wf.Status.StoredWorkflowTemplateSpec; stored != nil {
+		if spec.Entrypoint == "" {
+			spec.Entrypoint = stored.Entrypoint
+		}
+		spec.Templates = append(spec.Templates, stored.Templates...)
+		if len(spec.Arguments.Parameters) == 0 {
+			spec.Arguments.Parameters = stored.Arguments.Parameters
+		}
+		if len(spec.Arguments.Artifacts) == 0 {
+			spec.Arguments.Artifacts = stored.Arguments.Artifacts
+		}
+	}
+	return spec
+}
+
+// steps converts the pods executed by the workflow into steps, sorted
+// by the time they started. Steps skipped by a when condition are
+// listed too, with the skipped status.
+func (wf *argoWorkflow) steps() ([]run.Step, error) {
+	templates := map[string]argoTemplate{}
+	for _, t := range wf.spec().Templates {
+		templates[t.Name] = t
+	}
+
+	nodes := []argoNode{}
+	for _, n := range wf.Status.Nodes {
+		if n.Type == "Pod" || n.Type == "Skipped" {
+			nodes = append(nodes, n)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].StartedAt != nodes[j].StartedAt {
+			return nodes[i].StartedAt < nodes[j].StartedAt
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	steps := []run.Step{}
+	for _, n := range nodes {
+		step := run.Step{
+			IsSuccess:   n.Phase == "Succeeded",
+			Status:      argoStepStatus(n.Phase),
+			Params:      []string{},
+			Environment: map[string]string{},
+		}
+		var err error
+		if step.StartTime, err = parseArgoTime(n.StartedAt); err != nil {
+			return nil, fmt.Errorf("parsing start time of %s: %w", n.Name, err)
+		}
+		if step.EndTime, err = parseArgoTime(n.FinishedAt); err != nil {
+			return nil, fmt.Errorf("parsing finish time of %s: %w", n.Name, err)
+		}
+		if n.Inputs != nil {
+			for _, p := range n.Inputs.Parameters {
+				step.Params = append(step.Params, fmt.Sprintf("%s=%s", p.Name, p.Value))
+			}
+		}
+
+		t := templates[n.TemplateName]
+		c := t.Container
+		if c == nil {
+			c = t.Script
+		}
+		if c != nil {
+			step.Image = c.Image
+			step.Command = strings.Join(append(append([]string{}, c.Command...), c.Args...), " ")
+			step.Directory = c.WorkingDir
+			for _, e := range c.Env {
+				step.Environment[e.Name] = e.Value
+			}
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// argoStepStatus returns the execution status of a node phase
+func argoStepStatus(phase string) string {
+	switch phase {
+	case "Succeeded":
+		return run.StepStatusRan
+	case "Failed", "Error":
+		return run.StepStatusFailed
+	case "Skipped", "Omitted":
+		return run.StepStatusSkipped
+	}
+	return ""
+}
+
+// gitSource returns the repository and revision built by the
+// workflow, read from well known parameters or a git artifact
+func (wf *argoWorkflow) gitSource() (repo, revision string) {
+	spec := wf.spec()
+	for _, a := range spec.Arguments.Artifacts {
+		if a.Git != nil && a.Git.Repo != "" {
+			return a.Git.Repo, a.Git.Revision
+		}
+	}
+	params := map[string]string{}
+	for _, p := range spec.Arguments.Parameters {
+		params[p.Name] = p.Value
+	}
+	for _, name := range argoRepoParameters {
+		if params[name] != "" {
+			repo = params[name]
+			break
+		}
+	}
+	for _, name := range argoRevisionParameters {
+		if params[name] != "" {
+			revision = params[name]
+			break
+		}
+	}
+	return repo, revision
+}
+
+func parseArgoTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// BuildPredicate returns the predicate of a workflow run
+func (aw *ArgoWorkflow) BuildPredicate(
+	r *run.Run, draft *attestation.SLSAPredicate,
+) (predicate *attestation.SLSAPredicate, err error) {
+	wf, ok := r.SystemData.(*argoWorkflow)
+	if !ok {
+		return nil, errors.New("run has no argo workflow data")
+	}
+	if draft == nil {
+		pred := attestation.NewSLSAPredicate()
+		predicate = &pred
+	} else {
+		predicate = draft
+	}
+
+	spec := wf.spec()
+	predicate.BuildType = argoBuildType
+	if predicate.Builder.ID == "" && spec.ServiceAccountName != "" {
+		predicate.Builder.ID = fmt.Sprintf(
+			"system:serviceaccount:%s:%s", wf.Metadata.Namespace, spec.ServiceAccountName,
+		)
+	}
+
+	repo, revision := wf.gitSource()
+	if repo != "" {
+		if !strings.HasPrefix(repo, "git+") {
+			repo = "git+" + repo
+		}
+		predicate.Invocation.ConfigSource.URI = repo
+		if commitRegex.MatchString(revision) {
+			predicate.Invocation.ConfigSource.Digest = common.DigestSet{"sha1": revision}
+		}
+		predicate.AddMaterial(repo, predicate.Invocation.ConfigSource.Digest)
+	}
+	predicate.Invocation.ConfigSource.EntryPoint = spec.Entrypoint
+	if spec.WorkflowTemplateRef != nil {
+		predicate.Invocation.ConfigSource.EntryPoint = spec.WorkflowTemplateRef.Name + "#" + spec.Entrypoint
+	}
+
+	params := map[string]string{}
+	for _, p := range spec.Arguments.Parameters {
+		params[p.Name] = p.Value
+	}
+	predicate.Invocation.Parameters = params
+	predicate.Invocation.Environment = map[string]string{
+		"namespace": wf.Metadata.Namespace,
+		"name":      wf.Metadata.Name,
+		"uid":       wf.Metadata.UID,
+	}
+	if predicate.Metadata == nil {
+		predicate.Metadata = &slsa.ProvenanceMetadata{}
+	}
+	predicate.Metadata.BuildInvocationID = wf.Metadata.UID
+	if !r.StartTime.IsZero() {
+		predicate.Metadata.BuildStartedOn = &r.StartTime
+	}
+	if !r.EndTime.IsZero() {
+		predicate.Metadata.BuildFinishedOn = &r.EndTime
+	}
+	predicate.Metadata.Completeness.Parameters = true
+	return predicate, nil
+}
+
+// ArtifactStores returns the native artifact stores of the workflow.
+// Argo artifact repositories are configured per cluster, so none is
+// returned and artifacts must be collected with --artifacts.
+func (aw *ArgoWorkflow) ArtifactStores() []store.Store {
+	return []store.Store{}
+}
@@ -0,0 +1,177 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+const testArgoWorkflow = `
+apiVersion: argoproj.io/v1alpha1
+kind: Workflow
+metadata:
+  name: build-x7k2p
+  namespace: ci
+  uid: 0b6c1b1e-7a55-4c4e-9a3e-4f1f1c0c9d11
+spec:
+  entrypoint: main
+  serviceAccountName: builder
+  arguments:
+    parameters:
+    - name: repo
+      value: https://github.com/kubernetes-sigs/tejolote
+    - name: revision
+      value: 3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d
+  templates:
+  - name: main
+    steps:
+    - - name: test
+        template: test
+    - - name: build
+        template: build
+  - name: test
+    container:
+      image: golang:1.23
+      command: [go, test, ./...]
+      workingDir: /src
+  - name: build
+    script:
+      image: golang:1.23
+      command: [sh]
+      env:
+      - name: CGO_ENABLED
+        value: "0"
+status:
+  phase: %s
+  startedAt: "2024-05-01T10:00:00Z"
+  finishedAt: "2024-05-01T10:05:00Z"
+  nodes:
+    build-x7k2p:
+      id: build-x7k2p
+      name: build-x7k2p
+      type: Steps
+      templateName: main
+      phase: Succeeded
+    build-x7k2p-2:
+      id: build-x7k2p-2
+      name: build-x7k2p[1].build
+      type: Pod
+      templateName: build
+      phase: Succeeded
+      startedAt: "2024-05-01T10:02:00Z"
+      finishedAt: "2024-05-01T10:05:00Z"
+    build-x7k2p-1:
+      id: build-x7k2p-1
+      name: build-x7k2p[0].test
+      type: Pod
+      templateName: test
+      phase: Succeeded
+      startedAt: "2024-05-01T10:00:00Z"
+      finishedAt: "2024-05-01T10:02:00Z"
+      inputs:
+        parameters:
+        - name: pkg
+          value: ./...
+    build-x7k2p-3:
+      id: build-x7k2p-3
+      name: build-x7k2p[2].publish
+      type: Skipped
+      templateName: publish
+      phase: Skipped
+      message: when 'false == true' evaluated false
+      startedAt: "2024-05-01T10:05:00Z"
+      finishedAt: "2024-05-01T10:05:00Z"
+`
+
+func newTestArgoWorkflow(t *testing.T, phase string) *ArgoWorkflow {
+	obj := &unstructured.Unstructured{}
+	data, err := yaml.YAMLToJSON([]byte(fmt.Sprintf(testArgoWorkflow, phase)))
+	require.NoError(t, err)
+	require.NoError(t, obj.UnmarshalJSON(data))
+
+	aw, err := NewArgoWorkflow("argo://ci/build-x7k2p")
+	require.NoError(t, err)
+	aw.client = dynamicfake.NewSimpleDynamicClientWithCustomListKinds(
+		runtime.NewScheme(), map[schema.GroupVersionResource]string{argoWorkflowResource: "WorkflowList"}, obj,
+	)
+	return aw
+}
+
+func TestParseArgoURL(t *testing.T) {
+	ns, name, err := parseArgoURL("argo://ci/build-x7k2p")
+	require.NoError(t, err)
+	require.Equal(t, "ci", ns)
+	require.Equal(t, "build-x7k2p", name)
+
+	for _, u := range []string{"argo://ci", "argo://ci/a/b", "gcb://ci/build"} {
+		_, _, err := parseArgoURL(u)
+		require.Error(t, err, u)
+	}
+}
+
+func TestArgoWorkflow(t *testing.T) {
+	for phase, expected := range map[string][2]bool{
+		"Running":   {true, false},
+		"Succeeded": {false, true},
+		"Failed":    {false, false},
+		"Error":     {false, false},
+	} {
+		r, err := newTestArgoWorkflow(t, phase).GetRun("argo://ci/build-x7k2p")
+		require.NoError(t, err, phase)
+		require.Equal(t, expected[0], r.IsRunning, phase)
+		require.Equal(t, expected[1], r.IsSuccess, phase)
+	}
+
+	aw := newTestArgoWorkflow(t, "Succeeded")
+	r, err := aw.GetRun("argo://ci/build-x7k2p")
+	require.NoError(t, err)
+	require.Equal(t, 5*time.Minute, r.EndTime.Sub(r.StartTime))
+
+	// Pods become steps in the order they ran, skipped steps are kept
+	require.Len(t, r.Steps, 3)
+	require.Equal(t, "golang:1.23", r.Steps[0].Image)
+	require.Equal(t, "go test ./...", r.Steps[0].Command)
+	require.Equal(t, "/src", r.Steps[0].Directory)
+	require.Equal(t, []string{"pkg=./..."}, r.Steps[0].Params)
+	require.Equal(t, "sh", r.Steps[1].Command)
+	require.Equal(t, "0", r.Steps[1].Environment["CGO_ENABLED"])
+	require.True(t, r.Steps[1].IsSuccess)
+	require.Equal(t, run.StepStatusRan, r.Steps[1].Status)
+	require.Equal(t, run.StepStatusSkipped, r.Steps[2].Status)
+	require.False(t, r.Steps[2].IsSuccess)
+
+	pred, err := aw.BuildPredicate(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, argoBuildType, pred.BuildType)
+	require.Equal(t, "system:serviceaccount:ci:builder", pred.Builder.ID)
+	require.Equal(t, "git+https://github.com/kubernetes-sigs/tejolote", pred.Invocation.ConfigSource.URI)
+	require.Equal(t, "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d", pred.Invocation.ConfigSource.Digest["sha1"])
+	require.Equal(t, "main", pred.Invocation.ConfigSource.EntryPoint)
+	require.Len(t, pred.Materials, 1)
+	require.Equal(t, "0b6c1b1e-7a55-4c4e-9a3e-4f1f1c0c9d11", pred.Metadata.BuildInvocationID)
+}
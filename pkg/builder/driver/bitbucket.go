@@ -0,0 +1,391 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+)
+
+const (
+	BITBUCKET          = "bitbucket"
+	bitbucketBuildType = "https://bitbucket.org/Pipelines@v1"
+	bitbucketAPIURL    = "https://api.bitbucket.org/2.0"
+	bitbucketURL       = "https://bitbucket.org"
+	bitbucketConfig    = "bitbucket-pipelines.yml"
+
+	// BitbucketTokenEnvVar holds an access token used to query the
+	// API. When not set, BitbucketUserEnvVar and BitbucketAppPasswordEnvVar
+	// are used for basic auth with an app password.
+	BitbucketTokenEnvVar       = "BITBUCKET_TOKEN"
+	BitbucketUserEnvVar        = "BITBUCKET_USERNAME"
+	BitbucketAppPasswordEnvVar = "BITBUCKET_APP_PASSWORD"
+)
+
+// Bitbucket is a driver that reads pipelines from Bitbucket Cloud
+type Bitbucket struct {
+	Workspace  string
+	Repository string
+	// Pipeline is the UUID (with braces) or build number of the pipeline
+	Pipeline string
+	// apiURL is the base URL of the API, it defaults to bitbucketAPIURL
+	apiURL string
+}
+
+// bitbucketPipeline is the pipeline data returned by the API
+type bitbucketPipeline struct {
+	UUID        string          `json:"uuid"`
+	BuildNumber int64           `json:"build_number"`
+	State       bitbucketState  `json:"state"`
+	Target      bitbucketTarget `json:"target"`
+	Trigger     struct {
+		Name string `json:"name"`
+	} `json:"trigger"`
+	Creator struct {
+		UUID string `json:"uuid"`
+	} `json:"creator"`
+	Variables   []bitbucketVariable `json:"variables"`
+	CreatedOn   time.Time           `json:"created_on"`
+	CompletedOn time.Time           `json:"completed_on"`
+	Steps       []bitbucketStep     `json:"-"`
+	Workspace   string              `json:"-"`
+	Repository  string              `json:"-"`
+}
+
+type bitbucketState struct {
+	Name   string `json:"name"`
+	Result *struct {
+		Name string `json:"name"`
+	} `json:"result"`
+}
+
+// result returns the name of the result of a completed state
+func (s *bitbucketState) result() string {
+	if s.Result == nil {
+		return ""
+	}
+	return s.Result.Name
+}
+
+type bitbucketTarget struct {
+	RefType  string `json:"ref_type"`
+	RefName  string `json:"ref_name"`
+	Selector struct {
+		Type    string `json:"type"`
+		Pattern string `json:"pattern"`
+	} `json:"selector"`
+	Commit struct {
+		Hash string `json:"hash"`
+	} `json:"commit"`
+}
+
+type bitbucketVariable struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Secured bool   `json:"secured"`
+}
+
+type bitbucketStep struct {
+	UUID  string         `json:"uuid"`
+	Name  string         `json:"name"`
+	State bitbucketState `json:"state"`
+	Image struct {
+		Name string `json:"name"`
+	} `json:"image"`
+	StartedOn   time.Time `json:"started_on"`
+	CompletedOn time.Time `json:"completed_on"`
+}
+
+// bitbucketStepsPage is a page of the pipeline steps listing
+type bitbucketStepsPage struct {
+	Values []bitbucketStep `json:"values"`
+	Next   string          `json:"next"`
+}
+
+// NewBitbucket returns a driver for a pipeline specified as
+// bitbucket://workspace/repo/pipelines/<uuid or build number>
+func NewBitbucket(specURL string) (*Bitbucket, error) {
+	b := &Bitbucket{}
+	if err := b.parseURL(specURL); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *Bitbucket) parseURL(specURL string) error {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return fmt.Errorf("parsing bitbucket spec url: %w", err)
+	}
+	if u.Scheme != BITBUCKET {
+		return errors.New("URL is not a bitbucket URL")
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 3 || parts[0] == "" || parts[1] != "pipelines" || parts[2] == "" {
+		return fmt.Errorf("bitbucket URL %s is not in the form bitbucket://workspace/repo/pipelines/id", specURL)
+	}
+	pipeline := parts[2]
+	// Build numbers are used as is, UUIDs need braces
+	if _, err := strconv.ParseInt(pipeline, 10, 64); err != nil && !strings.HasPrefix(pipeline, "{") {
+		pipeline = "{" + pipeline + "}"
+	}
+	b.Workspace = u.Host
+	b.Repository = parts[0]
+	b.Pipeline = pipeline
+	return nil
+}
+
+// baseURL returns the URL of the API
+func (b *Bitbucket) baseURL() string {
+	if b.apiURL != "" {
+		return strings.TrimSuffix(b.apiURL, "/")
+	}
+	return bitbucketAPIURL
+}
+
+// apiGet queries an API endpoint and decodes the response into v.
+// Paths starting with https:// are queried as is, the API returns
+// the full URL of the next page of listings.
+func (b *Bitbucket) apiGet(path string, v interface{}) error {
+	u := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		u = b.baseURL() + path
+	}
+	req, err := http.NewRequest(http.MethodGet, u, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("creating http request: %w", err)
+	}
+	switch {
+	case os.Getenv(BitbucketTokenEnvVar) != "":
+		req.Header.Set("Authorization", "Bearer "+os.Getenv(BitbucketTokenEnvVar))
+	case os.Getenv(BitbucketUserEnvVar) != "":
+		req.SetBasicAuth(os.Getenv(BitbucketUserEnvVar), os.Getenv(BitbucketAppPasswordEnvVar))
+	default:
+		logrus.WithField("driver", "bitbucket").Warn("making unauthenticated request to bitbucket")
+	}
+	client, err := httpclient.New()
+	if err != nil {
+		return fmt.Errorf("creating http client: %w", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("querying bitbucket api: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("got http error %d from bitbucket API", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding bitbucket api response: %w", err)
+	}
+	return nil
+}
+
+func (b *Bitbucket) GetRun(specURL string) (*run.Run, error) {
+	r := &run.Run{
+		SpecURL:   specURL,
+		IsSuccess: false,
+		Steps:     []run.Step{},
+		Artifacts: []run.Artifact{},
+		StartTime: time.Time{},
+		EndTime:   time.Time{},
+	}
+	if err := b.RefreshRun(r); err != nil {
+		return nil, fmt.Errorf("doing initial refresh of run data: %w", err)
+	}
+	return r, nil
+}
+
+// RefreshRun queries the pipeline and its steps from the API
+func (b *Bitbucket) RefreshRun(r *run.Run) error {
+	if err := b.parseURL(r.SpecURL); err != nil {
+		return fmt.Errorf("parsing spec url: %w", err)
+	}
+
+	pipelinePath := fmt.Sprintf(
+		"/repositories/%s/%s/pipelines/%s", b.Workspace, b.Repository, url.PathEscape(b.Pipeline),
+	)
+	pipeline := &bitbucketPipeline{}
+	if err := b.apiGet(pipelinePath, pipeline); err != nil {
+		return fmt.Errorf("getting pipeline: %w", err)
+	}
+	steps := []bitbucketStep{}
+	next := pipelinePath + "/steps/"
+	for next != "" {
+		page := &bitbucketStepsPage{}
+		if err := b.apiGet(next, page); err != nil {
+			return fmt.Errorf("getting pipeline steps: %w", err)
+		}
+		steps = append(steps, page.Values...)
+		next = page.Next
+	}
+	pipeline.Steps = steps
+	pipeline.Workspace = b.Workspace
+	pipeline.Repository = b.Repository
+
+	switch pipeline.State.Name {
+	case "COMPLETED":
+		r.IsRunning, r.IsSuccess = false, pipeline.State.result() == "SUCCESSFUL"
+	case "PENDING", "IN_PROGRESS", "PAUSED", "HALTED":
+		r.IsRunning, r.IsSuccess = true, false
+	default:
+		return fmt.Errorf("unknown bitbucket pipeline state %q", pipeline.State.Name)
+	}
+
+	r.StartTime = pipeline.CreatedOn
+	r.EndTime = pipeline.CompletedOn
+	r.Params = []string{}
+	for _, v := range pipeline.Variables {
+		// Secured variables are returned without their value
+		if v.Secured {
+			continue
+		}
+		r.Params = append(r.Params, fmt.Sprintf("%s=%s", v.Key, v.Value))
+	}
+
+	r.Steps = []run.Step{}
+	for _, s := range steps {
+		r.Steps = append(r.Steps, run.Step{
+			Name:        s.Name,
+			Image:       s.Image.Name,
+			IsSuccess:   s.State.result() == "SUCCESSFUL",
+			Status:      bitbucketStepStatus(s.State),
+			Params:      []string{},
+			StartTime:   s.StartedOn,
+			EndTime:     s.CompletedOn,
+			Environment: map[string]string{},
+		})
+	}
+	r.SystemData = pipeline
+	return nil
+}
+
+// bitbucketStepStatus returns the execution status of a step
+func bitbucketStepStatus(state bitbucketState) string {
+	if state.Name == "NOT_RUN" {
+		return run.StepStatusSkipped
+	}
+	switch state.result() {
+	case "SUCCESSFUL":
+		return run.StepStatusRan
+	case "FAILED", "ERROR", "STOPPED":
+		return run.StepStatusFailed
+	case "NOT_RUN", "SKIPPED":
+		return run.StepStatusSkipped
+	}
+	return ""
+}
+
+// pipelineURL returns the address of the pipeline in the web UI
+func (p *bitbucketPipeline) pipelineURL() string {
+	return fmt.Sprintf("%s/%s/%s/pipelines/results/%d", bitbucketURL, p.Workspace, p.Repository, p.BuildNumber)
+}
+
+// BuildPredicate returns the predicate of a bitbucket pipeline
+func (b *Bitbucket) BuildPredicate(
+	r *run.Run, draft *attestation.SLSAPredicate,
+) (predicate *attestation.SLSAPredicate, err error) {
+	pipeline, ok := r.SystemData.(*bitbucketPipeline)
+	if !ok {
+		return nil, errors.New("run has no bitbucket pipeline data")
+	}
+	if draft == nil {
+		pred := attestation.NewSLSAPredicate()
+		predicate = &pred
+	} else {
+		predicate = draft
+	}
+
+	predicate.BuildType = bitbucketBuildType
+	if predicate.Builder.ID == "" {
+		predicate.Builder.ID = bitbucketURL + "/" + pipeline.Workspace
+	}
+
+	repo := fmt.Sprintf("git+%s/%s/%s", bitbucketURL, pipeline.Workspace, pipeline.Repository)
+	predicate.Invocation.ConfigSource.URI = repo
+	predicate.Invocation.ConfigSource.EntryPoint = bitbucketConfig
+	if commitRegex.MatchString(pipeline.Target.Commit.Hash) {
+		predicate.Invocation.ConfigSource.Digest = common.DigestSet{"sha1": pipeline.Target.Commit.Hash}
+	}
+	predicate.AddMaterial(repo, predicate.Invocation.ConfigSource.Digest)
+
+	if len(r.Params) > 0 {
+		predicate.Invocation.Parameters = r.Params
+	}
+	env := map[string]string{
+		"ref_type": pipeline.Target.RefType,
+		"ref_name": pipeline.Target.RefName,
+		"trigger":  pipeline.Trigger.Name,
+		"creator":  pipeline.Creator.UUID,
+	}
+	// The selector is the pipeline definition that ran (eg branches:main)
+	if pipeline.Target.Selector.Pattern != "" {
+		env["selector"] = pipeline.Target.Selector.Type + ":" + pipeline.Target.Selector.Pattern
+	}
+	for k, v := range env {
+		if v == "" {
+			delete(env, k)
+		}
+	}
+	predicate.Invocation.Environment = env
+
+	type stepData struct {
+		Name   string `json:"name"`
+		Image  string `json:"image,omitempty"`
+		Status string `json:"status,omitempty"`
+	}
+	steps := []stepData{}
+	for _, s := range r.Steps {
+		steps = append(steps, stepData{Name: s.Name, Image: s.Image, Status: s.Status})
+	}
+	predicate.BuildConfig = map[string][]stepData{"steps": steps}
+
+	if predicate.Metadata == nil {
+		predicate.Metadata = &slsa.ProvenanceMetadata{}
+	}
+	predicate.Metadata.BuildInvocationID = pipeline.pipelineURL()
+	if !r.StartTime.IsZero() {
+		predicate.Metadata.BuildStartedOn = &r.StartTime
+	}
+	if !r.EndTime.IsZero() {
+		predicate.Metadata.BuildFinishedOn = &r.EndTime
+	}
+	return predicate, nil
+}
+
+// ArtifactStores returns the native artifact stores of the pipeline.
+// Bitbucket pipeline artifacts expire and are not exposed by the API,
+// they must be collected with --artifacts.
+func (b *Bitbucket) ArtifactStores() []store.Store {
+	return []store.Store{}
+}
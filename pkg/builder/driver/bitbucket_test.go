@@ -0,0 +1,170 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// Trimmed response from /repositories/octo-ws/app/pipelines/42
+const testBitbucketPipeline = `{
+  "uuid": "{9b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0}",
+  "build_number": 42,
+  "state": %s,
+  "target": {
+    "type": "pipeline_ref_target",
+    "ref_type": "branch",
+    "ref_name": "main",
+    "selector": {"type": "branches", "pattern": "main"},
+    "commit": {"hash": "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d"}
+  },
+  "trigger": {"name": "PUSH"},
+  "creator": {"uuid": "{0d1e2f3a-4b5c-6d7e-8f90-a1b2c3d4e5f6}"},
+  "variables": [
+    {"key": "RELEASE", "value": "true", "secured": false},
+    {"key": "API_KEY", "secured": true}
+  ],
+  "created_on": "2024-05-01T10:00:00Z",
+  "completed_on": "2024-05-01T10:05:00Z"
+}`
+
+const testBitbucketStepsPage1 = `{
+  "values": [
+    {"uuid": "{s1}", "name": "Build", "image": {"name": "golang:1.23"},
+     "state": {"name": "COMPLETED", "result": {"name": "SUCCESSFUL"}},
+     "started_on": "2024-05-01T10:00:10Z", "completed_on": "2024-05-01T10:04:00Z"}
+  ],
+  "next": "%s/repositories/octo-ws/app/pipelines/42/steps/?page=2"
+}`
+
+const testBitbucketStepsPage2 = `{
+  "values": [
+    {"uuid": "{s2}", "name": "Deploy", "image": {"name": "atlassian/default-image:4"},
+     "state": {"name": "NOT_RUN"}}
+  ]
+}`
+
+func newTestBitbucket(t *testing.T, state string) (*Bitbucket, *http.Header) {
+	headers := &http.Header{}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*headers = r.Header.Clone()
+		switch r.URL.Path {
+		case "/repositories/octo-ws/app/pipelines/42":
+			fmt.Fprintf(w, testBitbucketPipeline, state)
+		case "/repositories/octo-ws/app/pipelines/42/steps/":
+			if r.URL.Query().Get("page") == "2" {
+				fmt.Fprint(w, testBitbucketStepsPage2)
+				return
+			}
+			fmt.Fprintf(w, testBitbucketStepsPage1, server.URL)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	b, err := NewBitbucket("bitbucket://octo-ws/app/pipelines/42")
+	require.NoError(t, err)
+	b.apiURL = server.URL
+	return b, headers
+}
+
+func TestParseBitbucketURL(t *testing.T) {
+	b, err := NewBitbucket("bitbucket://octo-ws/app/pipelines/9b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0")
+	require.NoError(t, err)
+	require.Equal(t, "octo-ws", b.Workspace)
+	require.Equal(t, "app", b.Repository)
+	require.Equal(t, "{9b1c2d3e-4f50-6172-8394-a5b6c7d8e9f0}", b.Pipeline)
+
+	b, err = NewBitbucket("bitbucket://octo-ws/app/pipelines/42")
+	require.NoError(t, err)
+	require.Equal(t, "42", b.Pipeline)
+
+	for _, u := range []string{
+		"bitbucket://octo-ws/app/42",
+		"bitbucket://octo-ws/app/builds/42",
+		"bitbucket:///app/pipelines/42",
+		"drone://octo-ws/app/pipelines/42",
+	} {
+		_, err := NewBitbucket(u)
+		require.Error(t, err, u)
+	}
+}
+
+func TestBitbucket(t *testing.T) {
+	t.Setenv(BitbucketTokenEnvVar, "")
+	t.Setenv(BitbucketUserEnvVar, "")
+	for state, expected := range map[string][2]bool{
+		`{"name": "PENDING"}`:                                     {true, false},
+		`{"name": "IN_PROGRESS"}`:                                 {true, false},
+		`{"name": "COMPLETED", "result": {"name": "SUCCESSFUL"}}`: {false, true},
+		`{"name": "COMPLETED", "result": {"name": "FAILED"}}`:     {false, false},
+		`{"name": "COMPLETED", "result": {"name": "STOPPED"}}`:    {false, false},
+	} {
+		b, _ := newTestBitbucket(t, state)
+		r, err := b.GetRun("bitbucket://octo-ws/app/pipelines/42")
+		require.NoError(t, err, state)
+		require.Equal(t, expected[0], r.IsRunning, state)
+		require.Equal(t, expected[1], r.IsSuccess, state)
+	}
+
+	t.Setenv(BitbucketUserEnvVar, "octocat")
+	t.Setenv(BitbucketAppPasswordEnvVar, "app-password")
+	b, headers := newTestBitbucket(t, `{"name": "COMPLETED", "result": {"name": "SUCCESSFUL"}}`)
+	r, err := b.GetRun("bitbucket://octo-ws/app/pipelines/42")
+	require.NoError(t, err)
+	user, pass, ok := (&http.Request{Header: *headers}).BasicAuth()
+	require.True(t, ok)
+	require.Equal(t, "octocat", user)
+	require.Equal(t, "app-password", pass)
+	require.Equal(t, 5*time.Minute, r.EndTime.Sub(r.StartTime))
+	require.Equal(t, []string{"RELEASE=true"}, r.Params)
+
+	// Steps are read from both pages of the listing
+	require.Len(t, r.Steps, 2)
+	require.Equal(t, "Build", r.Steps[0].Name)
+	require.Equal(t, "golang:1.23", r.Steps[0].Image)
+	require.Equal(t, run.StepStatusRan, r.Steps[0].Status)
+	require.True(t, r.Steps[0].IsSuccess)
+	require.Equal(t, run.StepStatusSkipped, r.Steps[1].Status)
+
+	pred, err := b.BuildPredicate(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, bitbucketBuildType, pred.BuildType)
+	require.Equal(t, "https://bitbucket.org/octo-ws", pred.Builder.ID)
+	require.Equal(t, "https://bitbucket.org/octo-ws/app/pipelines/results/42", pred.Metadata.BuildInvocationID)
+	require.Equal(t, "git+https://bitbucket.org/octo-ws/app", pred.Invocation.ConfigSource.URI)
+	require.Equal(t, "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d", pred.Invocation.ConfigSource.Digest["sha1"])
+	require.Equal(t, bitbucketConfig, pred.Invocation.ConfigSource.EntryPoint)
+	require.Len(t, pred.Materials, 1)
+	env := pred.Invocation.Environment.(map[string]string)
+	require.Equal(t, "branches:main", env["selector"])
+	require.Equal(t, "PUSH", env["trigger"])
+
+	// Pipelines that do not exist
+	_, err = b.GetRun("bitbucket://octo-ws/app/pipelines/43")
+	require.Error(t, err)
+}
@@ -26,7 +26,12 @@ import (
 )
 
 const (
-	GITHUB = "github"
+	GITHUB           = "github"
+	GITHUBDEPLOYMENT = "github-deployment"
+
+	// APIVersion is the version of the BuildSystem interface
+	// implemented by the drivers
+	APIVersion = "v1"
 )
 
 // BuildSystemDriver is an interface to a type that can query a buildsystem
@@ -53,6 +58,28 @@ func NewFromSpecURL(specURL string) (BuildSystem, error) {
 		}
 	case GITHUB:
 		driver = &GitHubWorkflow{}
+	case GITHUBDEPLOYMENT:
+		driver = NewGitHubDeployment()
+	case ARGO:
+		driver, err = NewArgoWorkflow(specURL)
+		if err != nil {
+			return nil, fmt.Errorf("creating argo driver: %w", err)
+		}
+	case DRONE, WOODPECKER:
+		driver, err = NewDrone(specURL)
+		if err != nil {
+			return nil, fmt.Errorf("creating drone driver: %w", err)
+		}
+	case BITBUCKET:
+		driver, err = NewBitbucket(specURL)
+		if err != nil {
+			return nil, fmt.Errorf("creating bitbucket driver: %w", err)
+		}
+	case GITEA:
+		driver, err = NewGitea(specURL)
+		if err != nil {
+			return nil, fmt.Errorf("creating gitea driver: %w", err)
+		}
 	default:
 		return nil, fmt.Errorf("unable to get driver from url %s", specURL)
 	}
@@ -66,6 +93,18 @@ func NewFromMoniker(moniker string) (BuildSystem, error) {
 		driver = &GCB{}
 	case GITHUB:
 		driver = &GitHubWorkflow{}
+	case GITHUBDEPLOYMENT:
+		driver = NewGitHubDeployment()
+	case ARGO:
+		driver = &ArgoWorkflow{}
+	case DRONE:
+		driver = &Drone{}
+	case WOODPECKER:
+		driver = &Drone{Woodpecker: true}
+	case BITBUCKET:
+		driver = &Bitbucket{}
+	case GITEA:
+		driver = &Gitea{}
 	default:
 		return nil, fmt.Errorf("unable to get driver from moniker %s", moniker)
 	}
@@ -0,0 +1,367 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+)
+
+const (
+	DRONE          = "drone"
+	WOODPECKER     = "woodpecker"
+	droneBuildType = "https://drone.io/Pipeline@v1"
+
+	// DroneTokenEnvVar and WoodpeckerTokenEnvVar hold the API tokens
+	// used to query the servers. Woodpecker runs fall back to the
+	// Drone token.
+	DroneTokenEnvVar      = "DRONE_TOKEN"
+	WoodpeckerTokenEnvVar = "WOODPECKER_TOKEN"
+)
+
+// Drone is a driver that reads builds from a Drone CI server. Woodpecker
+// servers expose the same API and are read with it too.
+type Drone struct {
+	Host         string
+	Organization string
+	Repository   string
+	BuildNumber  int64
+	// Woodpecker is true when the run is on a Woodpecker server
+	Woodpecker bool
+	// serverURL is the base URL of the server, it
+	// defaults to https:// and the host of the spec URL
+	serverURL string
+}
+
+// droneBuild is the build data returned by the drone API
+type droneBuild struct {
+	ID           int64             `json:"id"`
+	Number       int64             `json:"number"`
+	Status       string            `json:"status"`
+	Event        string            `json:"event"`
+	Link         string            `json:"link"`
+	Message      string            `json:"message"`
+	After        string            `json:"after"`
+	Ref          string            `json:"ref"`
+	Source       string            `json:"source"`
+	Target       string            `json:"target"`
+	AuthorLogin  string            `json:"author_login"`
+	Sender       string            `json:"sender"`
+	Params       map[string]string `json:"params"`
+	Started      int64             `json:"started"`
+	Finished     int64             `json:"finished"`
+	Stages       []droneStage      `json:"stages"`
+	Repo         *droneRepo        `json:"-"`
+	ServerURL    string            `json:"-"`
+	Organization string            `json:"-"`
+	Repository   string            `json:"-"`
+}
+
+type droneStage struct {
+	Name    string      `json:"name"`
+	Status  string      `json:"status"`
+	Started int64       `json:"started"`
+	Stopped int64       `json:"stopped"`
+	Steps   []droneStep `json:"steps"`
+}
+
+type droneStep struct {
+	Name     string `json:"name"`
+	Number   int    `json:"number"`
+	Status   string `json:"status"`
+	Image    string `json:"image"`
+	ExitCode int    `json:"exit_code"`
+	Started  int64  `json:"started"`
+	Stopped  int64  `json:"stopped"`
+}
+
+// droneRepo are the fields of the repository we read
+type droneRepo struct {
+	HTTPURL    string `json:"git_http_url"`
+	Link       string `json:"link"`
+	ConfigPath string `json:"config_path"`
+}
+
+// NewDrone returns a driver for a build specified as
+// drone://host/org/repo/number or woodpecker://host/org/repo/number
+func NewDrone(specURL string) (*Drone, error) {
+	d := &Drone{}
+	if err := d.parseURL(specURL); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Drone) parseURL(specURL string) error {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return fmt.Errorf("parsing drone spec url: %w", err)
+	}
+	if u.Scheme != DRONE && u.Scheme != WOODPECKER {
+		return errors.New("URL is not a drone or woodpecker URL")
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("drone URL %s is not in the form %s://host/org/repo/build", specURL, u.Scheme)
+	}
+	number, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing build number from URL: %w", err)
+	}
+	d.Host = u.Host
+	d.Organization = parts[0]
+	d.Repository = parts[1]
+	d.BuildNumber = number
+	d.Woodpecker = u.Scheme == WOODPECKER
+	return nil
+}
+
+// baseURL returns the URL of the server
+func (d *Drone) baseURL() string {
+	if d.serverURL != "" {
+		return strings.TrimSuffix(d.serverURL, "/")
+	}
+	return "https://" + d.Host
+}
+
+// token returns the API token from the environment
+func (d *Drone) token() string {
+	if d.Woodpecker {
+		if token := os.Getenv(WoodpeckerTokenEnvVar); token != "" {
+			return token
+		}
+	}
+	return os.Getenv(DroneTokenEnvVar)
+}
+
+// apiGet queries an API endpoint and decodes the response into v
+func (d *Drone) apiGet(path string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, d.baseURL()+"/api"+path, http.NoBody)
+	if err != nil {
+		return fmt.Errorf("creating http request: %w", err)
+	}
+	if token := d.token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else {
+		logrus.WithField("driver", "drone").Warn("making unauthenticated request to drone")
+	}
+	client, err := httpclient.New()
+	if err != nil {
+		return fmt.Errorf("creating http client: %w", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("querying drone api: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("got http error %d from drone API", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding drone api response: %w", err)
+	}
+	return nil
+}
+
+func (d *Drone) GetRun(specURL string) (*run.Run, error) {
+	r := &run.Run{
+		SpecURL:   specURL,
+		IsSuccess: false,
+		Steps:     []run.Step{},
+		Artifacts: []run.Artifact{},
+		StartTime: time.Time{},
+		EndTime:   time.Time{},
+	}
+	if err := d.RefreshRun(r); err != nil {
+		return nil, fmt.Errorf("doing initial refresh of run data: %w", err)
+	}
+	return r, nil
+}
+
+// RefreshRun queries the build and its repository from the API
+func (d *Drone) RefreshRun(r *run.Run) error {
+	if err := d.parseURL(r.SpecURL); err != nil {
+		return fmt.Errorf("parsing spec url: %w", err)
+	}
+
+	repoPath := fmt.Sprintf("/repos/%s/%s", d.Organization, d.Repository)
+	build := &droneBuild{}
+	if err := d.apiGet(fmt.Sprintf("%s/builds/%d", repoPath, d.BuildNumber), build); err != nil {
+		return fmt.Errorf("getting build: %w", err)
+	}
+	repo := &droneRepo{}
+	if err := d.apiGet(repoPath, repo); err != nil {
+		return fmt.Errorf("getting repository: %w", err)
+	}
+	build.Repo = repo
+	build.ServerURL = d.baseURL()
+	build.Organization = d.Organization
+	build.Repository = d.Repository
+
+	switch build.Status {
+	case "pending", "running", "blocked", "waiting_on_dependencies":
+		r.IsRunning, r.IsSuccess = true, false
+	case "success":
+		r.IsRunning, r.IsSuccess = false, true
+	default:
+		// failure, killed, error, declined and skipped
+		r.IsRunning, r.IsSuccess = false, false
+	}
+
+	r.StartTime = droneTime(build.Started)
+	r.EndTime = droneTime(build.Finished)
+	r.Params = []string{}
+	for k, v := range build.Params {
+		r.Params = append(r.Params, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	r.Steps = []run.Step{}
+	for _, stage := range build.Stages {
+		for _, s := range stage.Steps {
+			r.Steps = append(r.Steps, run.Step{
+				Name:        stage.Name + "/" + s.Name,
+				Image:       s.Image,
+				IsSuccess:   s.Status == "success",
+				Status:      droneStepStatus(s.Status),
+				Params:      []string{},
+				StartTime:   droneTime(s.Started),
+				EndTime:     droneTime(s.Stopped),
+				Environment: map[string]string{},
+			})
+		}
+	}
+	r.SystemData = build
+	return nil
+}
+
+// droneTime converts the unix timestamps of the API, zero means unset
+func droneTime(ts int64) time.Time {
+	if ts == 0 {
+		return time.Time{}
+	}
+	return time.Unix(ts, 0).UTC()
+}
+
+// droneStepStatus returns the execution status of a step
+func droneStepStatus(status string) string {
+	switch status {
+	case "success":
+		return run.StepStatusRan
+	case "failure", "error", "killed":
+		return run.StepStatusFailed
+	case "skipped":
+		return run.StepStatusSkipped
+	}
+	return ""
+}
+
+// BuildPredicate returns the predicate of a drone build
+func (d *Drone) BuildPredicate(
+	r *run.Run, draft *attestation.SLSAPredicate,
+) (predicate *attestation.SLSAPredicate, err error) {
+	build, ok := r.SystemData.(*droneBuild)
+	if !ok {
+		return nil, errors.New("run has no drone build data")
+	}
+	if draft == nil {
+		pred := attestation.NewSLSAPredicate()
+		predicate = &pred
+	} else {
+		predicate = draft
+	}
+
+	predicate.BuildType = droneBuildType
+	if predicate.Builder.ID == "" {
+		predicate.Builder.ID = build.ServerURL
+	}
+
+	if build.Repo != nil && build.Repo.HTTPURL != "" {
+		repo := "git+" + build.Repo.HTTPURL
+		predicate.Invocation.ConfigSource.URI = repo
+		predicate.Invocation.ConfigSource.EntryPoint = build.Repo.ConfigPath
+		if commitRegex.MatchString(build.After) {
+			predicate.Invocation.ConfigSource.Digest = common.DigestSet{"sha1": build.After}
+		}
+		predicate.AddMaterial(repo, predicate.Invocation.ConfigSource.Digest)
+	}
+
+	if len(build.Params) > 0 {
+		predicate.Invocation.Parameters = build.Params
+	}
+	env := map[string]string{
+		"event":  build.Event,
+		"ref":    build.Ref,
+		"source": build.Source,
+		"target": build.Target,
+		"author": build.AuthorLogin,
+		"sender": build.Sender,
+	}
+	for k, v := range env {
+		if v == "" {
+			delete(env, k)
+		}
+	}
+	predicate.Invocation.Environment = env
+
+	type stepData struct {
+		Name   string `json:"name"`
+		Image  string `json:"image,omitempty"`
+		Status string `json:"status,omitempty"`
+	}
+	steps := []stepData{}
+	for _, s := range r.Steps {
+		steps = append(steps, stepData{Name: s.Name, Image: s.Image, Status: s.Status})
+	}
+	predicate.BuildConfig = map[string][]stepData{"steps": steps}
+
+	if predicate.Metadata == nil {
+		predicate.Metadata = &slsa.ProvenanceMetadata{}
+	}
+	predicate.Metadata.BuildInvocationID = fmt.Sprintf(
+		"%s/%s/%s/%d", build.ServerURL, build.Organization, build.Repository, build.Number,
+	)
+	if !r.StartTime.IsZero() {
+		predicate.Metadata.BuildStartedOn = &r.StartTime
+	}
+	if !r.EndTime.IsZero() {
+		predicate.Metadata.BuildFinishedOn = &r.EndTime
+	}
+	return predicate, nil
+}
+
+// ArtifactStores returns the native artifact stores of the build.
+// Drone has no artifact storage, artifacts must be collected
+// with --artifacts.
+func (d *Drone) ArtifactStores() []store.Store {
+	return []store.Store{}
+}
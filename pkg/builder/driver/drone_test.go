@@ -0,0 +1,162 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// Trimmed response from /api/repos/octo-org/app/builds/42
+const testDroneBuild = `{
+  "id": 1042,
+  "number": 42,
+  "status": "%s",
+  "event": "push",
+  "link": "https://github.com/octo-org/app/compare/009b8a3a9ccb...3f1b2c4d5e6f",
+  "after": "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d",
+  "ref": "refs/heads/main",
+  "source": "main",
+  "target": "main",
+  "author_login": "octocat",
+  "sender": "octocat",
+  "params": {"RELEASE": "true"},
+  "started": 1714557600,
+  "finished": 1714557900,
+  "stages": [
+    {
+      "name": "default",
+      "status": "success",
+      "steps": [
+        {"name": "clone", "number": 1, "status": "success", "image": "drone/git", "started": 1714557600, "stopped": 1714557610},
+        {"name": "build", "number": 2, "status": "success", "image": "golang:1.23", "started": 1714557610, "stopped": 1714557890},
+        {"name": "publish", "number": 3, "status": "skipped", "image": "plugins/docker"}
+      ]
+    }
+  ]
+}`
+
+const testDroneRepo = `{
+  "git_http_url": "https://github.com/octo-org/app.git",
+  "link": "https://github.com/octo-org/app",
+  "config_path": ".drone.yml"
+}`
+
+func newTestDrone(t *testing.T, status string) (*Drone, *http.Header) {
+	headers := &http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*headers = r.Header.Clone()
+		switch r.URL.Path {
+		case "/api/repos/octo-org/app/builds/42":
+			fmt.Fprintf(w, testDroneBuild, status)
+		case "/api/repos/octo-org/app":
+			fmt.Fprint(w, testDroneRepo)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	d, err := NewDrone("drone://drone.example.com/octo-org/app/42")
+	require.NoError(t, err)
+	d.serverURL = server.URL
+	return d, headers
+}
+
+func TestParseDroneURL(t *testing.T) {
+	d, err := NewDrone("woodpecker://ci.example.com/octo-org/app/42")
+	require.NoError(t, err)
+	require.True(t, d.Woodpecker)
+	require.Equal(t, "ci.example.com", d.Host)
+	require.Equal(t, "octo-org", d.Organization)
+	require.Equal(t, "app", d.Repository)
+	require.Equal(t, int64(42), d.BuildNumber)
+	require.Equal(t, "https://ci.example.com", d.baseURL())
+
+	for _, u := range []string{
+		"drone://ci.example.com/octo-org/42",
+		"drone://ci.example.com/octo-org/app/latest",
+		"drone:///octo-org/app/42",
+		"gcb://ci.example.com/octo-org/app/42",
+	} {
+		_, err := NewDrone(u)
+		require.Error(t, err, u)
+	}
+}
+
+func TestDroneToken(t *testing.T) {
+	t.Setenv(DroneTokenEnvVar, "drone-token")
+	t.Setenv(WoodpeckerTokenEnvVar, "woodpecker-token")
+	require.Equal(t, "drone-token", (&Drone{}).token())
+	require.Equal(t, "woodpecker-token", (&Drone{Woodpecker: true}).token())
+	t.Setenv(WoodpeckerTokenEnvVar, "")
+	require.Equal(t, "drone-token", (&Drone{Woodpecker: true}).token())
+}
+
+func TestDrone(t *testing.T) {
+	t.Setenv(DroneTokenEnvVar, "secret")
+	for status, expected := range map[string][2]bool{
+		"pending": {true, false},
+		"running": {true, false},
+		"success": {false, true},
+		"failure": {false, false},
+		"killed":  {false, false},
+	} {
+		d, _ := newTestDrone(t, status)
+		r, err := d.GetRun("drone://drone.example.com/octo-org/app/42")
+		require.NoError(t, err, status)
+		require.Equal(t, expected[0], r.IsRunning, status)
+		require.Equal(t, expected[1], r.IsSuccess, status)
+	}
+
+	d, headers := newTestDrone(t, "success")
+	r, err := d.GetRun("drone://drone.example.com/octo-org/app/42")
+	require.NoError(t, err)
+	require.Equal(t, "Bearer secret", headers.Get("Authorization"))
+	require.Equal(t, 5*time.Minute, r.EndTime.Sub(r.StartTime))
+	require.Equal(t, []string{"RELEASE=true"}, r.Params)
+
+	require.Len(t, r.Steps, 3)
+	require.Equal(t, "default/build", r.Steps[1].Name)
+	require.Equal(t, "golang:1.23", r.Steps[1].Image)
+	require.Equal(t, 280*time.Second, r.Steps[1].EndTime.Sub(r.Steps[1].StartTime))
+	require.Equal(t, run.StepStatusRan, r.Steps[1].Status)
+	require.Equal(t, run.StepStatusSkipped, r.Steps[2].Status)
+	require.True(t, r.Steps[2].StartTime.IsZero())
+
+	pred, err := d.BuildPredicate(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, droneBuildType, pred.BuildType)
+	require.Equal(t, d.serverURL, pred.Builder.ID)
+	require.Equal(t, d.serverURL+"/octo-org/app/42", pred.Metadata.BuildInvocationID)
+	require.Equal(t, "git+https://github.com/octo-org/app.git", pred.Invocation.ConfigSource.URI)
+	require.Equal(t, "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d", pred.Invocation.ConfigSource.Digest["sha1"])
+	require.Equal(t, ".drone.yml", pred.Invocation.ConfigSource.EntryPoint)
+	require.Len(t, pred.Materials, 1)
+	require.Equal(t, "octocat", pred.Invocation.Environment.(map[string]string)["author"])
+
+	// Builds that do not exist
+	_, err = d.GetRun("drone://drone.example.com/octo-org/app/43")
+	require.Error(t, err)
+}
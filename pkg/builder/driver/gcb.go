@@ -16,46 +16,106 @@ limitations under the License.
 
 package driver
 
-/*
-  This is not yet implemented, but probably we should use the full
-  URL as handled internally in the GCP API, eg:
-  projects/648026197307/locations/global/builds/ba067a55-6090-4080-bc1a-6d1ff944fd60
-
-*/
-
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
-	"strings"
+	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/option"
 
 	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/httpclient"
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store"
+	storedriver "sigs.k8s.io/tejolote/pkg/store/driver"
 )
 
 type GCB struct {
 	ProjectID string
 	BuildID   string
+
+	// Region is the location of regional builds, read from spec URLs
+	// like gcb://project/locations/region/builds/id or ?region=.
+	// Builds in the global region leave it empty.
+	Region string
+
+	// Filter selects the build to attest when the spec URL does not
+	// include a build ID, eg gcb://project?filter=tags="release"
+	Filter string
+
+	// Latest picks the most recent build when the filter matches
+	// more than one. Without it, multiple matches are an error.
+	Latest bool
+
+	clientOptions []option.ClientOption
 }
 
 func NewGCB(specURL string) (*GCB, error) {
-	project, build, err := parseGCBURL(specURL)
+	project, region, build, err := parseGCBURL(specURL)
 	if err != nil {
 		return nil, fmt.Errorf("parsing gcb url: %w", err)
 	}
 
-	return &GCB{
+	gcb := &GCB{
 		ProjectID: project,
 		BuildID:   build,
-	}, nil
+		Region:    region,
+	}
+
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing gcb url: %w", err)
+	}
+	gcb.Filter = u.Query().Get("filter")
+	if v := u.Query().Get("latest"); v != "" {
+		gcb.Latest, err = strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("parsing latest value: %w", err)
+		}
+	}
+	return gcb, nil
+}
+
+// service returns a new client for the cloud build API, using the
+// regional endpoint when the builds are in a region
+func (gcb *GCB) service(ctx context.Context) (*cloudbuild.Service, error) {
+	return storedriver.NewGCBService(ctx, gcb.Region, gcb.clientOptions...)
 }
 
 func (gcb *GCB) GetRun(specURL string) (*run.Run, error) {
+	project, region, buildID, err := parseGCBURL(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing GCB spec URL: %w", err)
+	}
+
+	// Without a build ID, we look for the build using the filter
+	if buildID == "" {
+		if gcb.Filter == "" {
+			return nil, errors.New("gcb spec URL has no build ID or filter")
+		}
+		build, err := gcb.selectBuild()
+		if err != nil {
+			return nil, fmt.Errorf("selecting build: %w", err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"driver": "gcb", "run_id": build.Id,
+		}).Infof("Filter %q matched build", gcb.Filter)
+		gcb.BuildID = build.Id
+		specURL = storedriver.GCBSpecURL(project, region, build.Id)
+	}
+
+	// The latest flag only selects the build, it is not part of
+	// the build location recorded in the run
+	specURL, err = withoutLatestQuery(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing GCB spec URL: %w", err)
+	}
+
 	r := &run.Run{
 		SpecURL:   specURL,
 		IsSuccess: false,
@@ -68,40 +128,90 @@ func (gcb *GCB) GetRun(specURL string) (*run.Run, error) {
 		return nil, fmt.Errorf("doing initial refresh of run data: %w", err)
 	}
 	return r, nil
+}
 
-	// TODO: review this
-	//nolint: gocritic
-	/*
-		req, err := cloudbuildService.Projects.Builds.List(project).Filter(tagsFilter).PageSize(50).Do()
-		if err != nil {
-			return nil, fmt.Errorf("failed to listing the builds: %w", err)
-		}
-	*/
+// withoutLatestQuery removes the latest parameter from the query
+// of a spec URL
+func withoutLatestQuery(specURL string) (string, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if !q.Has("latest") {
+		return specURL, nil
+	}
+	q.Del("latest")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
 }
 
-func parseGCBURL(gcbURL string) (host, path string, err error) {
-	// Fetch the required data to get the build from the URL
-	u, err := url.Parse(gcbURL)
+// ListBuilds returns up to limit of the most recent builds in the
+// project matching filter, most recent first. The filter uses the
+// cloud build list syntax, eg tags="release". The API returns the
+// builds newest first, so only the first page is read.
+func (gcb *GCB) ListBuilds(filter string, limit int64) ([]*cloudbuild.Build, error) {
+	ctx, cancel := httpclient.WithTimeout(context.Background())
+	defer cancel()
+	cloudbuildService, err := gcb.service(ctx)
 	if err != nil {
-		return "", "", fmt.Errorf("parsing GCB spec URL: %w", err)
+		return nil, fmt.Errorf("creating cloudbuild client: %w", err)
 	}
-	return u.Hostname(), strings.TrimPrefix(u.Path, "/"), nil
+
+	var resp *cloudbuild.ListBuildsResponse
+	if gcb.Region == "" {
+		resp, err = cloudbuildService.Projects.Builds.List(gcb.ProjectID).
+			Filter(filter).PageSize(limit).Context(ctx).Do()
+	} else {
+		resp, err = cloudbuildService.Projects.Locations.Builds.List(
+			fmt.Sprintf("projects/%s/locations/%s", gcb.ProjectID, gcb.Region),
+		).Filter(filter).PageSize(limit).Context(ctx).Do()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing builds: %w", err)
+	}
+
+	builds := resp.Builds
+	if int64(len(builds)) > limit {
+		builds = builds[:limit]
+	}
+	return builds, nil
+}
+
+// selectBuild returns the build matching the configured filter. Two
+// builds are enough to tell if the filter matches more than one.
+func (gcb *GCB) selectBuild() (*cloudbuild.Build, error) {
+	builds, err := gcb.ListBuilds(gcb.Filter, 2)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(builds) == 0:
+		return nil, fmt.Errorf("no builds match filter %q", gcb.Filter)
+	case len(builds) > 1 && !gcb.Latest:
+		return nil, fmt.Errorf(
+			"more than one build matches filter %q, set --latest to attest the most recent one",
+			gcb.Filter,
+		)
+	}
+	return builds[0], nil
+}
+
+func parseGCBURL(gcbURL string) (project, region, buildID string, err error) {
+	return storedriver.ParseGCBURL(gcbURL)
 }
 
 // RefreshRun queries the API from the build system and
 // updates the run metadata.
 func (gcb *GCB) RefreshRun(r *run.Run) error {
-	project, buildID, err := parseGCBURL(r.SpecURL)
+	project, region, buildID, err := parseGCBURL(r.SpecURL)
 	if err != nil {
 		return fmt.Errorf("parsing GCB spec URL: %w", err)
 	}
 
-	ctx := context.Background()
-	cloudbuildService, err := cloudbuild.NewService(ctx)
-	if err != nil {
-		return fmt.Errorf("creating cloudbuild client: %w", err)
-	}
-	build, err := cloudbuildService.Projects.Builds.Get(project, buildID).Do()
+	ctx, cancel := httpclient.WithTimeout(context.Background())
+	defer cancel()
+	build, err := storedriver.GetGCBBuild(ctx, project, region, buildID, gcb.clientOptions...)
 	if err != nil {
 		return fmt.Errorf("getting build %s from GCB: %w", buildID, err)
 	}
@@ -120,8 +230,11 @@ func (gcb *GCB) RefreshRun(r *run.Run) error {
 			})
 		}
 		//
+		r.Steps[i].Name = s.Id
 		r.Steps[i].Image = s.Name
 		r.Steps[i].Params = s.Args
+		r.Steps[i].IsSuccess = s.Status == "SUCCESS"
+		r.Steps[i].Status = gcbStepStatus(s, gcbBuildDone(build.Status))
 		if s.Timing != nil {
 			if s.Timing.StartTime == "" {
 				stime, err := time.Parse(time.RFC3339Nano, s.Timing.StartTime)
@@ -172,17 +285,118 @@ func (gcb *GCB) RefreshRun(r *run.Run) error {
 		r.IsRunning = false
 	}
 
+	if build.StartTime != "" {
+		r.StartTime, err = time.Parse(time.RFC3339Nano, build.StartTime)
+		if err != nil {
+			return fmt.Errorf("parsing build start time: %w", err)
+		}
+	}
+
+	if build.FinishTime != "" {
+		r.EndTime, err = time.Parse(time.RFC3339Nano, build.FinishTime)
+		if err != nil {
+			return fmt.Errorf("parsing build finish time: %w", err)
+		}
+	}
+
+	r.Resources, err = gcbResourceUsage(build)
+	if err != nil {
+		return fmt.Errorf("reading build resource usage: %w", err)
+	}
+
 	r.SystemData = build
 
 	return nil
 }
 
+// gcbResourceUsage extracts the machine type and timing data
+// cloud build reports about a build
+func gcbResourceUsage(build *cloudbuild.Build) (*run.ResourceUsage, error) {
+	usage := &run.ResourceUsage{
+		Timing: map[string]run.TimeSpan{},
+	}
+
+	if build.Options != nil {
+		usage.MachineType = build.Options.MachineType
+	}
+
+	for phase, span := range build.Timing {
+		ts := run.TimeSpan{}
+		if span.StartTime != "" {
+			stime, err := time.Parse(time.RFC3339Nano, span.StartTime)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s start time: %w", phase, err)
+			}
+			ts.StartTime = stime
+		}
+		if span.EndTime != "" {
+			etime, err := time.Parse(time.RFC3339Nano, span.EndTime)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %s end time: %w", phase, err)
+			}
+			ts.EndTime = etime
+		}
+		usage.Timing[phase] = ts
+	}
+
+	if build.StartTime != "" && build.FinishTime != "" {
+		stime, err := time.Parse(time.RFC3339Nano, build.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("parsing build start time: %w", err)
+		}
+		etime, err := time.Parse(time.RFC3339Nano, build.FinishTime)
+		if err != nil {
+			return nil, fmt.Errorf("parsing build finish time: %w", err)
+		}
+		usage.DurationMS = etime.Sub(stime).Milliseconds()
+	}
+
+	return usage, nil
+}
+
+// gcbBuildDone returns true if a build status is final
+func gcbBuildDone(status string) bool {
+	switch status {
+	case "STATUS_UNKNOWN", "PENDING", "QUEUED", "WORKING", "":
+		return false
+	}
+	return true
+}
+
+// gcbStepStatus returns the execution status of a build step. Steps
+// still queued when the build is done never ran, cancelled steps
+// failed if they had started.
+func gcbStepStatus(s *cloudbuild.BuildStep, buildDone bool) string {
+	switch s.Status {
+	case "SUCCESS":
+		return run.StepStatusRan
+	case "FAILURE", "INTERNAL_ERROR", "TIMEOUT":
+		return run.StepStatusFailed
+	case "CANCELLED", "EXPIRED":
+		if s.Timing != nil && s.Timing.StartTime != "" {
+			return run.StepStatusFailed
+		}
+		return run.StepStatusSkipped
+	case "PENDING", "QUEUED", "STATUS_UNKNOWN", "":
+		if buildDone {
+			return run.StepStatusSkipped
+		}
+	}
+	return ""
+}
+
 // BuildPredicate returns a SLSA predicate populated with the GCB
 // run data as recommended by the SLSA 0.2 spec
 func (gcb *GCB) BuildPredicate(r *run.Run, draft *attestation.SLSAPredicate) (predicate *attestation.SLSAPredicate, err error) {
 	type stepData struct {
+		ID        string   `json:"id,omitempty"`
 		Image     string   `json:"image"`
 		Arguments []string `json:"arguments"`
+		Status    string   `json:"status,omitempty"`
+	}
+
+	type gcbEnvironment struct {
+		Resources *run.ResourceUsage `json:"resources,omitempty"`
 	}
 
 	if draft == nil {
@@ -199,13 +413,31 @@ func (gcb *GCB) BuildPredicate(r *run.Run, draft *attestation.SLSAPredicate) (pr
 
 	for _, s := range r.Steps {
 		buildconfig["steps"] = append(buildconfig["steps"], stepData{
+			ID:        s.Name,
 			Image:     s.Image,
 			Arguments: s.Params,
+			Status:    s.Status,
 		})
 	}
 
 	predicate.BuildConfig = buildconfig
 
+	// Record the resources used by the build
+	if r.Resources != nil {
+		predicate.Invocation.Environment = gcbEnvironment{
+			Resources: r.Resources,
+		}
+	}
+
+	if predicate.Metadata != nil {
+		if !r.StartTime.IsZero() {
+			predicate.Metadata.BuildStartedOn = &r.StartTime
+		}
+		if !r.EndTime.IsZero() {
+			predicate.Metadata.BuildFinishedOn = &r.EndTime
+		}
+	}
+
 	// Get the platform specific data
 	build, ok := r.SystemData.(*cloudbuild.Build)
 	if ok {
@@ -246,11 +478,13 @@ func (gcb *GCB) BuildPredicate(r *run.Run, draft *attestation.SLSAPredicate) (pr
 
 // TriggerDetails
 func (gcb *GCB) TriggerDetails(triggerID string) (repoURL string, err error) {
-	cloudbuildService, err := cloudbuild.NewService(context.Background())
+	ctx, cancel := httpclient.WithTimeout(context.Background())
+	defer cancel()
+	cloudbuildService, err := gcb.service(ctx)
 	if err != nil {
 		return repoURL, fmt.Errorf("creating cloudbuild client: %w", err)
 	}
-	trigger, err := cloudbuildService.Projects.Triggers.Get(gcb.ProjectID, triggerID).Do()
+	trigger, err := cloudbuildService.Projects.Triggers.Get(gcb.ProjectID, triggerID).Context(ctx).Do()
 	if err != nil {
 		return repoURL, fmt.Errorf("getting trigger %s from GCB: %w", triggerID, err)
 	}
@@ -269,7 +503,7 @@ func (gcb *GCB) ArtifactStores() []store.Store {
 		logrus.Error("incomplete build data to create artifact store")
 		return []store.Store{}
 	}
-	d, err := store.New(fmt.Sprintf("gcb://%s/%s", gcb.ProjectID, gcb.BuildID))
+	d, err := store.New(storedriver.GCBSpecURL(gcb.ProjectID, gcb.Region, gcb.BuildID))
 	if err != nil {
 		logrus.Error(err)
 	}
@@ -17,9 +17,17 @@ limitations under the License.
 package driver
 
 import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/option"
+
+	"sigs.k8s.io/tejolote/pkg/run"
 )
 
 func TestReadStep(t *testing.T) {
@@ -29,3 +37,206 @@ func TestReadStep(t *testing.T) {
 	require.Error(t, err)
 	require.Nil(t, r)
 }
+
+func TestGCBResourceUsage(t *testing.T) {
+	build := &cloudbuild.Build{
+		Options: &cloudbuild.BuildOptions{
+			MachineType: "E2_HIGHCPU_8",
+		},
+		StartTime:  "2022-08-19T01:05:00.000000Z",
+		FinishTime: "2022-08-19T01:07:30.500000Z",
+		Timing: map[string]cloudbuild.TimeSpan{
+			"BUILD": {
+				StartTime: "2022-08-19T01:05:10.000000Z",
+				EndTime:   "2022-08-19T01:07:20.000000Z",
+			},
+		},
+	}
+
+	usage, err := gcbResourceUsage(build)
+	require.NoError(t, err)
+	require.Equal(t, "E2_HIGHCPU_8", usage.MachineType)
+	require.Equal(t, int64(150500), usage.DurationMS)
+	require.Contains(t, usage.Timing, "BUILD")
+
+	gcb := GCB{ProjectID: "test-project", BuildID: "test-build"}
+	pred, err := gcb.BuildPredicate(&run.Run{
+		Resources:  usage,
+		SystemData: build,
+	}, nil)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(pred.Invocation.Environment)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"machineType":"E2_HIGHCPU_8"`)
+	require.Contains(t, string(data), `"durationMs":150500`)
+}
+
+// newFakeCloudBuild starts a server that serves the first page of the
+// builds list, in the order they are passed, and the builds by ID
+func newFakeCloudBuild(t *testing.T, builds []*cloudbuild.Build, filters *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/v1/projects/test-project/builds" {
+			*filters = append(*filters, req.URL.Query().Get("filter"))
+			require.Empty(t, req.URL.Query().Get("pageToken"))
+			pageSize, err := strconv.Atoi(req.URL.Query().Get("pageSize"))
+			require.NoError(t, err)
+			resp := &cloudbuild.ListBuildsResponse{Builds: builds}
+			if pageSize < len(builds) {
+				resp.Builds = builds[:pageSize]
+				resp.NextPageToken = "next"
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(resp))
+			return
+		}
+		for _, b := range builds {
+			if req.URL.Path == "/v1/projects/test-project/builds/"+b.Id {
+				require.NoError(t, json.NewEncoder(w).Encode(b))
+				return
+			}
+		}
+		http.NotFound(w, req)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGCBListBuilds(t *testing.T) {
+	// The API lists the builds newest first
+	builds := []*cloudbuild.Build{
+		{Id: "newer", Status: "SUCCESS", CreateTime: "2022-08-19T01:00:00Z"},
+		{Id: "older", Status: "SUCCESS", CreateTime: "2022-08-18T01:00:00Z"},
+		{Id: "oldest", Status: "SUCCESS", CreateTime: "2022-08-17T01:00:00Z"},
+	}
+	filters := []string{}
+	server := newFakeCloudBuild(t, builds, &filters)
+
+	newDriver := func(spec string) *GCB {
+		gcb, err := NewGCB(spec)
+		require.NoError(t, err)
+		gcb.clientOptions = []option.ClientOption{
+			option.WithEndpoint(server.URL + "/"),
+			option.WithoutAuthentication(),
+		}
+		return gcb
+	}
+
+	// Only the first page is read
+	gcb := newDriver("gcb://test-project")
+	list, err := gcb.ListBuilds(`tags="release"`, 2)
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, "newer", list[0].Id)
+	require.Equal(t, []string{`tags="release"`}, filters)
+
+	// Multiple matches without latest are an error
+	gcb = newDriver(`gcb://test-project?filter=tags="release"`)
+	require.Equal(t, `tags="release"`, gcb.Filter)
+	_, err = gcb.GetRun(`gcb://test-project?filter=tags="release"`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "--latest")
+
+	// With latest, the most recent build is selected
+	gcb = newDriver(`gcb://test-project?filter=tags="release"&latest=true`)
+	require.True(t, gcb.Latest)
+	r, err := gcb.GetRun(`gcb://test-project?filter=tags="release"&latest=true`)
+	require.NoError(t, err)
+	require.Equal(t, "gcb://test-project/newer", r.SpecURL)
+	require.Equal(t, "newer", gcb.BuildID)
+	require.True(t, r.IsSuccess)
+
+	// The latest flag is not recorded in the spec URL of the run
+	gcb = newDriver("gcb://test-project/older?latest=true")
+	r, err = gcb.GetRun("gcb://test-project/older?latest=true")
+	require.NoError(t, err)
+	require.Equal(t, "gcb://test-project/older", r.SpecURL)
+
+	// No build ID and no filter
+	gcb = newDriver("gcb://test-project")
+	_, err = gcb.GetRun("gcb://test-project")
+	require.Error(t, err)
+}
+
+func TestGCBRegionalBuild(t *testing.T) {
+	paths := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		paths = append(paths, req.URL.Path)
+		switch req.URL.Path {
+		case "/v1/projects/test-project/locations/us-central1/builds":
+			require.NoError(t, json.NewEncoder(w).Encode(&cloudbuild.ListBuildsResponse{
+				Builds: []*cloudbuild.Build{{Id: "regional", Status: "SUCCESS"}},
+			}))
+		case "/v1/projects/test-project/locations/us-central1/builds/regional":
+			require.NoError(t, json.NewEncoder(w).Encode(&cloudbuild.Build{Id: "regional", Status: "SUCCESS"}))
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	spec := `gcb://test-project/locations/us-central1?filter=tags="release"`
+	gcb, err := NewGCB(spec)
+	require.NoError(t, err)
+	require.Equal(t, "us-central1", gcb.Region)
+	gcb.clientOptions = []option.ClientOption{
+		option.WithEndpoint(server.URL + "/"),
+		option.WithoutAuthentication(),
+	}
+
+	// The build is listed and read from the regional API
+	r, err := gcb.GetRun(spec)
+	require.NoError(t, err)
+	require.Equal(t, "gcb://test-project/locations/us-central1/builds/regional", r.SpecURL)
+	require.True(t, r.IsSuccess)
+	require.Equal(t, []string{
+		"/v1/projects/test-project/locations/us-central1/builds",
+		"/v1/projects/test-project/locations/us-central1/builds/regional",
+	}, paths)
+}
+
+func TestGCBStepStatus(t *testing.T) {
+	// The build failed in its second step, the third never ran
+	build := &cloudbuild.Build{
+		Id:     "failed",
+		Status: "FAILURE",
+		Steps: []*cloudbuild.BuildStep{
+			{
+				Id: "test", Name: "golang", Args: []string{"go", "test"}, Status: "SUCCESS",
+				Timing: &cloudbuild.TimeSpan{StartTime: "2022-08-19T01:05:00Z", EndTime: "2022-08-19T01:06:00Z"},
+			},
+			{
+				Id: "build", Name: "golang", Args: []string{"go", "build"}, Status: "FAILURE",
+				Timing: &cloudbuild.TimeSpan{StartTime: "2022-08-19T01:06:00Z", EndTime: "2022-08-19T01:07:00Z"},
+			},
+			{Id: "push", Name: "docker", Args: []string{"push"}, Status: "QUEUED"},
+		},
+	}
+	filters := []string{}
+	server := newFakeCloudBuild(t, []*cloudbuild.Build{build}, &filters)
+
+	gcb, err := NewGCB("gcb://test-project/failed")
+	require.NoError(t, err)
+	gcb.clientOptions = []option.ClientOption{
+		option.WithEndpoint(server.URL + "/"),
+		option.WithoutAuthentication(),
+	}
+	r, err := gcb.GetRun("gcb://test-project/failed")
+	require.NoError(t, err)
+	require.Len(t, r.Steps, 3)
+	require.Equal(t, run.StepStatusRan, r.Steps[0].Status)
+	require.True(t, r.Steps[0].IsSuccess)
+	require.Equal(t, run.StepStatusFailed, r.Steps[1].Status)
+	require.Equal(t, run.StepStatusSkipped, r.Steps[2].Status)
+	require.Equal(t, "push", r.Steps[2].Name)
+
+	pred, err := gcb.BuildPredicate(r, nil)
+	require.NoError(t, err)
+	data, err := json.Marshal(pred.BuildConfig)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `{"id":"push","image":"docker","arguments":["push"],"status":"skipped"}`)
+
+	// Queued steps of running builds have not been skipped yet
+	require.Empty(t, gcbStepStatus(&cloudbuild.BuildStep{Status: "QUEUED"}, false))
+	require.Equal(t, run.StepStatusSkipped, gcbStepStatus(&cloudbuild.BuildStep{Status: "CANCELLED"}, true))
+}
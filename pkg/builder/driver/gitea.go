@@ -0,0 +1,158 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/gitea"
+	"sigs.k8s.io/tejolote/pkg/github"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+)
+
+const GITEA = "gitea"
+
+// Gitea is a driver that reads workflow runs of Gitea and Forgejo
+// Actions. Their API mimics the GitHub Actions one, so the run data
+// is read into the GitHub types.
+type Gitea struct {
+	Host         string
+	Organization string
+	Repository   string
+	RunID        int64
+	// apiURL is the base URL of the API, it defaults
+	// to the API of the host in the spec URL
+	apiURL string
+}
+
+// NewGitea returns a driver for a run specified as gitea://host/org/repo/runID
+func NewGitea(specURL string) (*Gitea, error) {
+	g := &Gitea{}
+	if err := g.parseURL(specURL); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *Gitea) parseURL(specURL string) error {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return fmt.Errorf("parsing gitea spec url: %w", err)
+	}
+	if u.Scheme != GITEA {
+		return errors.New("URL is not a gitea URL")
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("gitea URL %s is not in the form gitea://host/org/repo/run", specURL)
+	}
+	runID, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return fmt.Errorf("parsing run ID from URL: %w", err)
+	}
+	g.Host = u.Host
+	g.Organization = parts[0]
+	g.Repository = parts[1]
+	g.RunID = runID
+	return nil
+}
+
+// runURL returns the API URL of the workflow run
+func (g *Gitea) runURL() string {
+	apiURL := g.apiURL
+	if apiURL == "" {
+		apiURL = gitea.APIURL(g.Host)
+	}
+	return fmt.Sprintf(ghRunURL, apiURL, g.Organization, g.Repository, g.RunID)
+}
+
+func (g *Gitea) GetRun(specURL string) (*run.Run, error) {
+	r := &run.Run{
+		SpecURL:   specURL,
+		IsSuccess: false,
+		Steps:     []run.Step{},
+		Artifacts: []run.Artifact{},
+		StartTime: time.Time{},
+		EndTime:   time.Time{},
+	}
+	if err := g.RefreshRun(r); err != nil {
+		return nil, fmt.Errorf("doing initial refresh of run data: %w", err)
+	}
+	return r, nil
+}
+
+// RefreshRun queries the run and its jobs from the API
+func (g *Gitea) RefreshRun(r *run.Run) error {
+	if err := g.parseURL(r.SpecURL); err != nil {
+		return fmt.Errorf("parsing spec url: %w", err)
+	}
+
+	runData := &github.Run{}
+	if err := gitea.APIGet(g.runURL(), runData); err != nil {
+		return fmt.Errorf("getting run: %w", err)
+	}
+
+	r.IsRunning = runData.Status != "completed"
+	r.IsSuccess = runData.Conclusion == "success"
+	r.SystemData = runData
+
+	jobs := &github.Jobs{}
+	if err := gitea.APIGet(g.runURL()+"/jobs", jobs); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"driver": GITEA, "run_id": g.RunID,
+		}).Warnf("unable to read run steps: %v", err)
+	} else {
+		r.Steps = githubSteps(jobs.Jobs)
+	}
+	return nil
+}
+
+// BuildPredicate builds a predicate from the run data
+func (g *Gitea) BuildPredicate(
+	r *run.Run, draft *attestation.SLSAPredicate,
+) (*attestation.SLSAPredicate, error) {
+	if err := g.parseURL(r.SpecURL); err != nil {
+		return nil, fmt.Errorf("parsing run spec URL: %w", err)
+	}
+	if _, ok := r.SystemData.(*github.Run); !ok {
+		return nil, errors.New("run has no gitea run data")
+	}
+	predicate := actionsPredicate(r, draft, g.Host, g.Organization, g.Repository, g.RunID)
+	predicate.Builder.ID = fmt.Sprintf("https://%s/actions", g.Host)
+	return predicate, nil
+}
+
+// ArtifactStores returns the artifacts store of the run
+func (g *Gitea) ArtifactStores() []store.Store {
+	d, err := store.New(fmt.Sprintf(
+		"gitea-actions://%s/%s/%s/%d", g.Host, g.Organization, g.Repository, g.RunID,
+	))
+	if err != nil {
+		logrus.Error(err)
+		return []store.Store{}
+	}
+	return []store.Store{d}
+}
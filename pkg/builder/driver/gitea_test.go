@@ -0,0 +1,148 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/gitea"
+	"sigs.k8s.io/tejolote/pkg/github"
+)
+
+// Trimmed response from a Gitea 1.24 server to
+// /api/v1/repos/octo-org/app/actions/runs/42
+const testGiteaRun = `{
+  "id": 42,
+  "url": "https://gitea.example.com/api/v1/repos/octo-org/app/actions/runs/42",
+  "html_url": "https://gitea.example.com/octo-org/app/actions/runs/7",
+  "display_title": "Release v1.0.0",
+  "path": "release.yml@refs/tags/v1.0.0",
+  "event": "push",
+  "run_attempt": 1,
+  "run_number": 7,
+  "head_sha": "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d",
+  "head_branch": "v1.0.0",
+  "status": "%s",
+  "conclusion": "%s",
+  "actor": {"id": 1, "login": "octocat"},
+  "trigger_actor": {"id": 1, "login": "octocat"},
+  "started_at": "2024-05-01T10:00:00Z",
+  "completed_at": "2024-05-01T10:05:00Z"
+}`
+
+const testGiteaJobs = `{
+  "jobs": [
+    {
+      "id": 100,
+      "run_id": 42,
+      "name": "build",
+      "status": "completed",
+      "conclusion": "success",
+      "steps": [
+        {"name": "Set up job", "number": 1, "status": "completed", "conclusion": "success", "started_at": "2024-05-01T10:00:00Z", "completed_at": "2024-05-01T10:00:05Z"},
+        {"name": "Build", "number": 2, "status": "completed", "conclusion": "success", "started_at": "2024-05-01T10:00:05Z", "completed_at": "2024-05-01T10:04:00Z"}
+      ]
+    }
+  ],
+  "total_count": 1
+}`
+
+func newTestGitea(t *testing.T, status, conclusion string) (*Gitea, *http.Header) {
+	headers := &http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*headers = r.Header.Clone()
+		switch r.URL.Path {
+		case "/repos/octo-org/app/actions/runs/42":
+			fmt.Fprintf(w, testGiteaRun, status, conclusion)
+		case "/repos/octo-org/app/actions/runs/42/jobs":
+			fmt.Fprint(w, testGiteaJobs)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	g, err := NewGitea("gitea://gitea.example.com/octo-org/app/42")
+	require.NoError(t, err)
+	g.apiURL = server.URL
+	return g, headers
+}
+
+func TestParseGiteaURL(t *testing.T) {
+	g, err := NewGitea("gitea://gitea.example.com/octo-org/app/42")
+	require.NoError(t, err)
+	require.Equal(t, "gitea.example.com", g.Host)
+	require.Equal(t, "octo-org", g.Organization)
+	require.Equal(t, "app", g.Repository)
+	require.Equal(t, int64(42), g.RunID)
+	require.Equal(t, "https://gitea.example.com/api/v1/repos/octo-org/app/actions/runs/42", g.runURL())
+
+	for _, u := range []string{
+		"gitea://gitea.example.com/octo-org/42",
+		"gitea://gitea.example.com/octo-org/app/latest",
+		"gitea:///octo-org/app/42",
+		"github://octo-org/app/42",
+	} {
+		_, err := NewGitea(u)
+		require.Error(t, err, u)
+	}
+}
+
+func TestGitea(t *testing.T) {
+	t.Setenv(gitea.TokenEnvVar, "secret")
+	for _, tc := range []struct {
+		status, conclusion string
+		running, success   bool
+	}{
+		{"in_progress", "", true, false},
+		{"completed", "success", false, true},
+		{"completed", "failure", false, false},
+	} {
+		g, _ := newTestGitea(t, tc.status, tc.conclusion)
+		r, err := g.GetRun("gitea://gitea.example.com/octo-org/app/42")
+		require.NoError(t, err, tc.status)
+		require.Equal(t, tc.running, r.IsRunning, tc.status)
+		require.Equal(t, tc.success, r.IsSuccess, tc.status)
+	}
+
+	g, headers := newTestGitea(t, "completed", "success")
+	r, err := g.GetRun("gitea://gitea.example.com/octo-org/app/42")
+	require.NoError(t, err)
+	require.Equal(t, "token secret", headers.Get("Authorization"))
+	require.Len(t, r.Steps, 2)
+	require.Equal(t, "build/Build", r.Steps[1].Name)
+
+	predicate, err := g.BuildPredicate(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://gitea.example.com/actions", predicate.Builder.ID)
+	require.Equal(t, "git+https://gitea.example.com/octo-org/app.git", predicate.Invocation.ConfigSource.URI)
+	require.Equal(t, "release.yml@refs/tags/v1.0.0", predicate.Invocation.ConfigSource.EntryPoint)
+	require.Equal(t,
+		"3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d",
+		predicate.Invocation.ConfigSource.Digest["sha1"],
+	)
+	require.Equal(t, "octocat", r.SystemData.(*github.Run).Actor.Login)
+
+	stores := g.ArtifactStores()
+	require.Len(t, stores, 1)
+	require.Equal(t, "gitea-actions://gitea.example.com/octo-org/app/42", stores[0].SpecURL)
+}
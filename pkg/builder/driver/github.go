@@ -35,29 +35,54 @@ import (
 	"sigs.k8s.io/tejolote/pkg/store"
 )
 
-const ghRunURL string = "https://api.github.com/repos/%s/%s/actions/runs/%d"
+const ghRunURL string = "%s/repos/%s/%s/actions/runs/%d"
+
+// fetchOIDCClaims reads the claims of the OIDC token of the job
+// running tejolote, tests replace it to skip the token verification
+var fetchOIDCClaims = github.FetchOIDCClaims
 
 type GitHubWorkflow struct {
+	// Host is the GitHub server running the workflow, when
+	// empty it defaults to github.com
+	Host         string
 	Organization string
 	Repository   string
 	RunID        int
 }
 
-func parseGitHubURL(specURL string) (org, repo string, runID int64, err error) {
+// parseGitHubURL parses a run spec URL. Runs in github.com are specified
+// as github://org/repo/runID while runs in GitHub Enterprise Server
+// include the hostname: github://github.example.com/org/repo/runID
+func parseGitHubURL(specURL string) (host, org, repo string, runID int64, err error) {
 	u, err := url.Parse(specURL)
+	if err != nil {
+		return host, org, repo, runID, fmt.Errorf("parsing spec url: %w", err)
+	}
 	if u.Scheme != GITHUB {
-		return org, repo, runID, errors.New("URL is not a github URL")
+		return host, org, repo, runID, errors.New("URL is not a github URL")
 	}
-	if err != nil {
-		return org, repo, runID, fmt.Errorf("parsing spec url: %w", err)
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	switch len(parts) {
+	case 2:
+		host, org = github.DefaultHost, u.Hostname()
+	case 3:
+		host, org, parts = u.Hostname(), parts[0], parts[1:]
+	default:
+		return host, org, repo, runID, fmt.Errorf("unable to parse repository and run from %s", u.Path)
 	}
-	parts := strings.SplitN(u.Path, "/", 3)
-	rID, err := strconv.Atoi(strings.TrimSuffix(parts[2], "/"))
+	rID, err := strconv.Atoi(parts[1])
 	if err != nil {
-		return org, repo, runID, fmt.Errorf("parsing run ID from URL: %w", err)
+		return host, org, repo, runID, fmt.Errorf("parsing run ID from URL: %w", err)
 	}
 
-	return u.Hostname(), parts[1], int64(rID), nil
+	return host, org, parts[0], int64(rID), nil
+}
+
+// runURL returns the API URL of the workflow run
+func (ghw *GitHubWorkflow) runURL() string {
+	return fmt.Sprintf(
+		ghRunURL, github.APIURLForHost(ghw.Host), ghw.Organization, ghw.Repository, ghw.RunID,
+	)
 }
 
 func (ghw *GitHubWorkflow) GetRun(specURL string) (*run.Run, error) {
@@ -79,15 +104,16 @@ func (ghw *GitHubWorkflow) GetRun(specURL string) (*run.Run, error) {
 func (ghw *GitHubWorkflow) RefreshRun(r *run.Run) error {
 	// https://api.github.com/repos/distroless/static/actions/runs/2858064062
 	// https://api.github.com/repos/distroless/static/actions/runs/7492361110 (failure)
-	org, repo, id, err := parseGitHubURL(r.SpecURL)
+	host, org, repo, id, err := parseGitHubURL(r.SpecURL)
 	if err != nil {
 		return fmt.Errorf("parsing spec url: %w", err)
 	}
+	ghw.Host = host
 	ghw.Organization = org
 	ghw.Repository = repo
 	ghw.RunID = int(id)
 
-	res, err := github.APIGetRequest(fmt.Sprintf(ghRunURL, ghw.Organization, ghw.Repository, ghw.RunID))
+	res, err := github.APIGetRequest(ghw.runURL())
 	if err != nil {
 		return fmt.Errorf("querying github api: %w", err)
 	}
@@ -122,6 +148,21 @@ func (ghw *GitHubWorkflow) RefreshRun(r *run.Run) error {
 
 	r.SystemData = runData
 
+	log := logrus.WithFields(logrus.Fields{"driver": "github", "run_id": ghw.RunID})
+	resources, err := ghw.readResourceUsage()
+	if err != nil {
+		log.Warnf("unable to read run resource usage: %v", err)
+	} else {
+		r.Resources = resources
+	}
+
+	steps, err := ghw.readSteps()
+	if err != nil {
+		log.Warnf("unable to read run steps: %v", err)
+	} else {
+		r.Steps = steps
+	}
+
 	// TODO: Consider pulling the job data if specified and the workflow yaml.
 	// Using those we can populate the entry point better to the job, the label of
 	// the runner
@@ -129,10 +170,183 @@ func (ghw *GitHubWorkflow) RefreshRun(r *run.Run) error {
 	return nil
 }
 
+// readResourceUsage queries the timing endpoint of the API to
+// get the billable time of the run
+func (ghw *GitHubWorkflow) readResourceUsage() (*run.ResourceUsage, error) {
+	res, err := github.APIGetRequest(ghw.runURL() + "/timing")
+	if err != nil {
+		return nil, fmt.Errorf("querying github api: %w", err)
+	}
+	defer res.Body.Close()
+
+	timing := &github.RunTiming{}
+	if err := json.NewDecoder(res.Body).Decode(timing); err != nil {
+		return nil, fmt.Errorf("decoding run timing data: %w", err)
+	}
+
+	usage := &run.ResourceUsage{
+		DurationMS: timing.RunDurationMS,
+		BillableMS: map[string]int64{},
+	}
+	for platform, b := range timing.Billable {
+		usage.BillableMS[platform] = b.TotalMS
+	}
+	return usage, nil
+}
+
+// readSteps queries the jobs of the run and returns their steps
+func (ghw *GitHubWorkflow) readSteps() ([]run.Step, error) {
+	res, err := github.APIGetRequest(ghw.runURL() + "/jobs?per_page=100")
+	if err != nil {
+		return nil, fmt.Errorf("querying github api: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("got https error %d from github API", res.StatusCode)
+	}
+
+	jobs := &github.Jobs{}
+	if err := json.NewDecoder(res.Body).Decode(jobs); err != nil {
+		return nil, fmt.Errorf("decoding run jobs: %w", err)
+	}
+	return githubSteps(jobs.Jobs), nil
+}
+
+// githubSteps converts the steps of the jobs of a run to run steps,
+// named after the job and the step
+func githubSteps(jobs []github.Job) []run.Step {
+	steps := []run.Step{}
+	for _, job := range jobs {
+		for _, s := range job.Steps {
+			steps = append(steps, run.Step{
+				Name:        job.Name + "/" + s.Name,
+				IsSuccess:   s.Conclusion == "success",
+				Status:      githubStepStatus(s),
+				Params:      []string{},
+				StartTime:   s.StartedAt,
+				EndTime:     s.CompletedAt,
+				Environment: map[string]string{},
+			})
+		}
+	}
+	return steps
+}
+
+// githubStepStatus returns the execution status of a job step.
+// Steps skipped by an if: condition are concluded as skipped,
+// cancelled steps failed if they had started.
+func githubStepStatus(s github.JobStep) string {
+	switch s.Conclusion {
+	case "success", "neutral":
+		return run.StepStatusRan
+	case "failure", "timed_out":
+		return run.StepStatusFailed
+	case "skipped":
+		return run.StepStatusSkipped
+	case "cancelled":
+		if s.StartedAt.IsZero() {
+			return run.StepStatusSkipped
+		}
+		return run.StepStatusFailed
+	}
+	return ""
+}
+
 // BuildPredicate builds a predicate from the run data
 func (ghw *GitHubWorkflow) BuildPredicate(
 	r *run.Run, draft *attestation.SLSAPredicate,
 ) (predicate *attestation.SLSAPredicate, err error) {
+	host, org, repo, runID, err := parseGitHubURL(r.SpecURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing run spec URL: %w", err)
+	}
+	predicate = actionsPredicate(r, draft, host, org, repo, runID)
+	predicate.Builder.ID = "https://github.com/Attestations/GitHubHostedActions@v1"
+
+	// When running in the attested run, the claims of its OIDC
+	// token are preferred over the data read from the API
+	claims, err := fetchOIDCClaims()
+	if err != nil {
+		logrus.Warnf("unable to read the OIDC token of the job: %v", err)
+	} else if claims != nil {
+		if err := applyOIDCClaims(predicate, r, claims, host, org, repo, runID); err != nil {
+			return nil, err
+		}
+	}
+	return predicate, nil
+}
+
+// applyOIDCClaims records the builder, config source and runner
+// environment from the OIDC token of the run being attested. The
+// claims are checked against the run data read from the API, tokens
+// of other runs (eg when attesting from another workflow) are ignored.
+func applyOIDCClaims(
+	predicate *attestation.SLSAPredicate, r *run.Run, claims *github.OIDCClaims,
+	host, org, repo string, runID int64,
+) error {
+	if !strings.EqualFold(claims.Repository, org+"/"+repo) || claims.RunID != strconv.FormatInt(runID, 10) {
+		logrus.Debugf("OIDC token is from run %s of %s, not using its claims", claims.RunID, claims.Repository)
+		return nil
+	}
+
+	ghRun := r.SystemData.(*github.Run)
+	if ghRun.HeadSHA != "" && !strings.EqualFold(claims.SHA, ghRun.HeadSHA) {
+		return fmt.Errorf(
+			"commit in the OIDC token (%s) does not match the run data (%s)", claims.SHA, ghRun.HeadSHA,
+		)
+	}
+	if ghRun.Path != "" && claims.WorkflowRef != "" && claims.WorkflowPath() != ghRun.Path {
+		return fmt.Errorf(
+			"workflow in the OIDC token (%s) does not match the run data (%s)", claims.WorkflowPath(), ghRun.Path,
+		)
+	}
+
+	if claims.JobWorkflowRef != "" {
+		predicate.Builder.ID = fmt.Sprintf("https://%s/%s", host, claims.JobWorkflowRef)
+	}
+	predicate.Invocation.ConfigSource.URI = fmt.Sprintf("git+https://%s/%s.git", host, claims.Repository)
+	if claims.Ref != "" {
+		predicate.Invocation.ConfigSource.URI += "@" + claims.Ref
+	}
+	predicate.Invocation.ConfigSource.Digest = common.DigestSet{"sha1": claims.SHA}
+	if claims.WorkflowRef != "" {
+		predicate.Invocation.ConfigSource.EntryPoint = claims.WorkflowPath()
+	}
+
+	env, err := predicate.EnvironmentMap()
+	if err != nil {
+		return err
+	}
+	contexts, ok := env["context"].(map[string]interface{})
+	if !ok {
+		contexts = map[string]interface{}{}
+		env["context"] = contexts
+	}
+	githubContext, ok := contexts["github"].(map[string]interface{})
+	if !ok {
+		githubContext = map[string]interface{}{}
+		contexts["github"] = githubContext
+	}
+	for k, v := range map[string]string{
+		"ref":                claims.Ref,
+		"workflow_ref":       claims.WorkflowRef,
+		"job_workflow_ref":   claims.JobWorkflowRef,
+		"run_attempt":        claims.RunAttempt,
+		"runner_environment": claims.RunnerEnvironment,
+	} {
+		if v != "" {
+			githubContext[k] = v
+		}
+	}
+	return nil
+}
+
+// actionsPredicate builds the predicate of a run of a GitHub Actions
+// workflow. Other forges running the same workflows (eg Gitea) return
+// compatible run data, so they share it.
+func actionsPredicate(
+	r *run.Run, draft *attestation.SLSAPredicate, host, org, repo string, runID int64,
+) (predicate *attestation.SLSAPredicate) {
 	type githubEnvironment struct {
 		// The architecture of the runner.
 		Arch string            `json:"arch"`
@@ -143,10 +357,8 @@ func (ghw *GitHubWorkflow) BuildPredicate(
 			GitHub map[string]string `json:"github"`
 			Runner map[string]string `json:"runner"`
 		} `json:"context"`
-	}
-	org, repo, runID, err := parseGitHubURL(r.SpecURL)
-	if err != nil {
-		return nil, fmt.Errorf("parsing run spec URL: %w", nil)
+		// Resources used by the run as reported by the API
+		Resources *run.ResourceUsage `json:"resources,omitempty"`
 	}
 	if draft == nil {
 		pred := attestation.NewSLSAPredicate()
@@ -154,15 +366,29 @@ func (ghw *GitHubWorkflow) BuildPredicate(
 	} else {
 		predicate = draft
 	}
-	predicate.Builder.ID = "https://github.com/Attestations/GitHubHostedActions@v1"
 	predicate.BuildType = "https://github.com/Attestations/GitHubActionsWorkflow@v1"
 	predicate.Invocation.ConfigSource.Digest = common.DigestSet{
 		"sha1": r.SystemData.(*github.Run).HeadSHA,
 	}
 	predicate.Invocation.ConfigSource.EntryPoint = r.SystemData.(*github.Run).Path
 	predicate.Invocation.ConfigSource.URI = fmt.Sprintf(
-		"git+https://github.com/%s/%s.git", org, repo,
+		"git+https://%s/%s/%s.git", host, org, repo,
 	)
+	// Record the event and who initiated the run in the github context
+	ghRun := r.SystemData.(*github.Run)
+	githubContext := map[string]string{
+		"run_id": fmt.Sprintf("%d", runID),
+	}
+	if ghRun.Event != "" {
+		githubContext["event_name"] = ghRun.Event
+	}
+	if ghRun.Actor.Login != "" {
+		githubContext["actor"] = ghRun.Actor.Login
+	}
+	if ghRun.TriggeringActor.Login != "" {
+		githubContext["triggering_actor"] = ghRun.TriggeringActor.Login
+	}
+
 	// TODO: I think we need to checkout the file from git to fill
 	predicate.Invocation.Environment = githubEnvironment{
 		Arch: "",
@@ -171,22 +397,64 @@ func (ghw *GitHubWorkflow) BuildPredicate(
 			GitHub map[string]string `json:"github"`
 			Runner map[string]string `json:"runner"`
 		}{
-			GitHub: map[string]string{
-				"run_id": fmt.Sprintf("%d", runID),
-			},
+			GitHub: githubContext,
 		},
+		Resources: r.Resources,
 	}
-	return predicate, nil
+
+	// Record the steps that ran, the workflow definition
+	// lists them all even when their conditions skip them
+	if len(r.Steps) > 0 {
+		type stepData struct {
+			Name   string `json:"name"`
+			Status string `json:"status,omitempty"`
+		}
+		steps := []stepData{}
+		for _, s := range r.Steps {
+			steps = append(steps, stepData{Name: s.Name, Status: s.Status})
+		}
+		predicate.BuildConfig = map[string][]stepData{"steps": steps}
+	}
+
+	// Record the reusable workflows called by the run as materials
+	for _, wf := range ghRun.ReferencedWorkflows {
+		uri, err := referencedWorkflowURI(host, wf)
+		if err != nil {
+			logrus.Warnf("skipping referenced workflow: %v", err)
+			continue
+		}
+		predicate.AddMaterial(uri, common.DigestSet{"sha1": wf.SHA})
+	}
+	return predicate
+}
+
+// referencedWorkflowURI returns the VCS locator of a reusable workflow
+// in the form git+https://github.com/owner/repo@ref#path/to/workflow.yml
+func referencedWorkflowURI(host string, wf github.ReferencedWorkflow) (string, error) {
+	workflowPath, ref, _ := strings.Cut(wf.Path, "@")
+	if wf.Ref != "" {
+		ref = wf.Ref
+	}
+	parts := strings.SplitN(workflowPath, "/", 3)
+	if len(parts) != 3 {
+		return "", fmt.Errorf("unable to parse workflow path %s", wf.Path)
+	}
+	uri := fmt.Sprintf("git+https://%s/%s/%s", host, parts[0], parts[1])
+	if ref != "" {
+		uri += "@" + ref
+	}
+	return uri + "#" + parts[2], nil
 }
 
 // ArtifactStores returns the native artifact store of github actions
 func (ghw *GitHubWorkflow) ArtifactStores() []store.Store {
-	d, err := store.New(
-		fmt.Sprintf(
-			"actions://%s/%s/%d",
-			ghw.Organization, ghw.Repository, ghw.RunID,
-		),
-	)
+	specURL := fmt.Sprintf("actions://%s/%s/%d", ghw.Organization, ghw.Repository, ghw.RunID)
+	if ghw.Host != "" && ghw.Host != github.DefaultHost {
+		specURL = fmt.Sprintf(
+			"actions://%s/%s/%s/%d", ghw.Host, ghw.Organization, ghw.Repository, ghw.RunID,
+		)
+	}
+	d, err := store.New(specURL)
 	if err != nil {
 		logrus.Error(err)
 		return []store.Store{}
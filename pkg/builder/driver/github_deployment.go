@@ -0,0 +1,260 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/github"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+)
+
+const (
+	ghDeploymentURL  = "%s/repos/%s/%s/deployments/%d"
+	ghDeploymentType = "https://github.com/Attestations/GitHubDeployment@v1"
+)
+
+// actionsRunRegex extracts the run ID from the log or target URL
+// of a deployment status
+var actionsRunRegex = regexp.MustCompile(`/actions/runs/(\d+)`)
+
+// GitHubDeployment is a driver that attests to a GitHub deployment
+// and the actions run that performed it
+type GitHubDeployment struct {
+	Organization string
+	Repository   string
+	DeploymentID int64
+	RunID        int64
+	apiURL       string
+}
+
+// deploymentData is the system data of a deployment run
+type deploymentData struct {
+	Deployment *github.Deployment
+	Status     *github.DeploymentStatus
+	Run        *github.Run
+}
+
+func NewGitHubDeployment() *GitHubDeployment {
+	return &GitHubDeployment{
+		apiURL: github.APIURL(),
+	}
+}
+
+func parseGitHubDeploymentURL(specURL string) (org, repo string, deploymentID int64, err error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return org, repo, deploymentID, fmt.Errorf("parsing spec url: %w", err)
+	}
+	if u.Scheme != GITHUBDEPLOYMENT {
+		return org, repo, deploymentID, errors.New("URL is not a github deployment URL")
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) != 2 {
+		return org, repo, deploymentID, fmt.Errorf("unable to parse repository and deployment from %s", u.Path)
+	}
+	id, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return org, repo, deploymentID, fmt.Errorf("parsing deployment ID from URL: %w", err)
+	}
+	return u.Hostname(), parts[0], id, nil
+}
+
+func (ghd *GitHubDeployment) GetRun(specURL string) (*run.Run, error) {
+	r := &run.Run{
+		SpecURL:   specURL,
+		IsSuccess: false,
+		Steps:     []run.Step{},
+		Artifacts: []run.Artifact{},
+		StartTime: time.Time{},
+		EndTime:   time.Time{},
+	}
+	if err := ghd.RefreshRun(r); err != nil {
+		return nil, fmt.Errorf("doing initial refresh of run data: %w", err)
+	}
+	return r, nil
+}
+
+// getJSON queries the GitHub API and decodes the response into obj
+func (ghd *GitHubDeployment) getJSON(apiURL string, obj interface{}) error {
+	res, err := github.APIGetRequest(apiURL)
+	if err != nil {
+		return fmt.Errorf("querying github api: %w", err)
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(obj); err != nil {
+		return fmt.Errorf("unmarshalling GitHub response: %w", err)
+	}
+	return nil
+}
+
+// RefreshRun fetches the deployment, its latest status and the
+// actions run associated with it
+func (ghd *GitHubDeployment) RefreshRun(r *run.Run) error {
+	org, repo, id, err := parseGitHubDeploymentURL(r.SpecURL)
+	if err != nil {
+		return fmt.Errorf("parsing spec url: %w", err)
+	}
+	ghd.Organization = org
+	ghd.Repository = repo
+	ghd.DeploymentID = id
+	if ghd.apiURL == "" {
+		ghd.apiURL = github.APIURL()
+	}
+
+	deploymentURL := fmt.Sprintf(ghDeploymentURL, ghd.apiURL, org, repo, id)
+	data := &deploymentData{
+		Deployment: &github.Deployment{},
+	}
+	if err := ghd.getJSON(deploymentURL, data.Deployment); err != nil {
+		return fmt.Errorf("fetching deployment: %w", err)
+	}
+
+	// Statuses are returned newest first
+	statuses := []github.DeploymentStatus{}
+	if err := ghd.getJSON(deploymentURL+"/statuses", &statuses); err != nil {
+		return fmt.Errorf("fetching deployment statuses: %w", err)
+	}
+
+	// A deployment without statuses has not started yet
+	r.IsRunning = true
+	r.IsSuccess = false
+	if len(statuses) > 0 {
+		data.Status = &statuses[0]
+		switch data.Status.State {
+		case "success":
+			r.IsRunning = false
+			r.IsSuccess = true
+		case "error", "failure", "inactive":
+			r.IsRunning = false
+			r.IsSuccess = false
+		case "queued", "pending", "in_progress":
+			r.IsRunning = true
+			r.IsSuccess = false
+		}
+
+		// Find the actions run that reported the status
+		for _, u := range []string{data.Status.LogURL, data.Status.TargetURL} {
+			if m := actionsRunRegex.FindStringSubmatch(u); m != nil {
+				ghd.RunID, err = strconv.ParseInt(m[1], 10, 64)
+				if err != nil {
+					return fmt.Errorf("parsing run ID from status: %w", err)
+				}
+				break
+			}
+		}
+	}
+
+	if ghd.RunID != 0 {
+		data.Run = &github.Run{}
+		if err := ghd.getJSON(
+			fmt.Sprintf("%s/repos/%s/%s/actions/runs/%d", ghd.apiURL, org, repo, ghd.RunID), data.Run,
+		); err != nil {
+			return fmt.Errorf("fetching deployment run: %w", err)
+		}
+	} else {
+		logrus.WithField("driver", "github-deployment").Warnf("unable to find the actions run of deployment %d", id)
+	}
+
+	r.SystemData = data
+	return nil
+}
+
+// BuildPredicate builds a predicate from the deployment data
+func (ghd *GitHubDeployment) BuildPredicate(
+	r *run.Run, draft *attestation.SLSAPredicate,
+) (predicate *attestation.SLSAPredicate, err error) {
+	type deploymentParameters struct {
+		Environment string `json:"environment"`
+		Task        string `json:"task,omitempty"`
+		Ref         string `json:"ref,omitempty"`
+	}
+
+	data, ok := r.SystemData.(*deploymentData)
+	if !ok {
+		return nil, errors.New("run does not contain deployment data")
+	}
+
+	if draft == nil {
+		pred := attestation.NewSLSAPredicate()
+		predicate = &pred
+	} else {
+		predicate = draft
+	}
+
+	predicate.Builder.ID = "https://github.com/Attestations/GitHubHostedActions@v1"
+	predicate.BuildType = ghDeploymentType
+	predicate.Invocation.ConfigSource.URI = fmt.Sprintf(
+		"git+https://%s/%s/%s.git", github.HostForAPIURL(ghd.apiURL), ghd.Organization, ghd.Repository,
+	)
+	predicate.Invocation.ConfigSource.Digest = common.DigestSet{
+		"sha1": data.Deployment.SHA,
+	}
+	if data.Run != nil {
+		predicate.Invocation.ConfigSource.EntryPoint = data.Run.Path
+	}
+
+	predicate.Invocation.Parameters = deploymentParameters{
+		Environment: data.Deployment.Environment,
+		Task:        data.Deployment.Task,
+		Ref:         data.Deployment.Ref,
+	}
+
+	githubContext := map[string]string{
+		"deployment_id": fmt.Sprintf("%d", ghd.DeploymentID),
+	}
+	if ghd.RunID != 0 {
+		githubContext["run_id"] = fmt.Sprintf("%d", ghd.RunID)
+	}
+	predicate.Invocation.Environment = map[string]interface{}{
+		"context": map[string]interface{}{
+			"github": githubContext,
+		},
+	}
+	return predicate, nil
+}
+
+// ArtifactStores returns the artifact store of the actions
+// run that performed the deployment
+func (ghd *GitHubDeployment) ArtifactStores() []store.Store {
+	if ghd.RunID == 0 {
+		return []store.Store{}
+	}
+	d, err := store.New(
+		fmt.Sprintf(
+			"actions://%s/%s/%d",
+			ghd.Organization, ghd.Repository, ghd.RunID,
+		),
+	)
+	if err != nil {
+		logrus.Error(err)
+		return []store.Store{}
+	}
+	return []store.Store{d}
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubDeployment(t *testing.T) {
+	responses := map[string]string{
+		"/repos/example/app/deployments/42": `{
+			"id": 42, "sha": "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+			"ref": "v1.0.0", "task": "deploy", "environment": "production"
+		}`,
+		"/repos/example/app/deployments/42/statuses": `[
+			{"id": 2, "state": "success", "log_url": "https://github.com/example/app/actions/runs/1234/job/5678"},
+			{"id": 1, "state": "in_progress"}
+		]`,
+		"/repos/example/app/actions/runs/1234": `{
+			"id": 1234, "status": "completed", "conclusion": "success",
+			"path": ".github/workflows/deploy.yaml"
+		}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, ok := responses[req.URL.Path]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = w.Write([]byte(data)) //nolint: errcheck
+	}))
+	defer server.Close()
+
+	ghd := NewGitHubDeployment()
+	ghd.apiURL = server.URL
+
+	r, err := ghd.GetRun("github-deployment://example/app/42")
+	require.NoError(t, err)
+	require.False(t, r.IsRunning)
+	require.True(t, r.IsSuccess)
+	require.Equal(t, int64(1234), ghd.RunID)
+
+	pred, err := ghd.BuildPredicate(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3", pred.Invocation.ConfigSource.Digest["sha1"])
+	require.Equal(t, ".github/workflows/deploy.yaml", pred.Invocation.ConfigSource.EntryPoint)
+
+	// The repository is on the server of the API
+	u, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	require.Equal(t, "git+https://"+u.Host+"/example/app.git", pred.Invocation.ConfigSource.URI)
+
+	params, err := json.Marshal(pred.Invocation.Parameters)
+	require.NoError(t, err)
+	require.Contains(t, string(params), `"environment":"production"`)
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/github"
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// Trimmed response from /repos/octo-org/app/actions/runs/42
+const runWithReferencedWorkflows = `{
+  "id": 42,
+  "status": "completed",
+  "conclusion": "success",
+  "head_branch": "main",
+  "head_sha": "009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d",
+  "path": ".github/workflows/release.yml",
+  "run_number": 7,
+  "workflow_id": 159038,
+  "event": "workflow_dispatch",
+  "actor": {"login": "octocat", "id": 1, "type": "User"},
+  "triggering_actor": {"login": "hubot", "id": 2, "type": "Bot"},
+  "referenced_workflows": [
+    {
+      "path": "octo-org/shared/.github/workflows/build.yml@v1.2.0",
+      "sha": "a8cdcd6c1b1e0e5c8a2b83c1a2a1ee1c8a9b9d7e",
+      "ref": "refs/tags/v1.2.0"
+    },
+    {
+      "path": "octo-org/app/.github/workflows/test.yml@009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d",
+      "sha": "009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d"
+    }
+  ]
+}`
+
+func TestGitHubReferencedWorkflows(t *testing.T) {
+	runData := &github.Run{}
+	require.NoError(t, json.Unmarshal([]byte(runWithReferencedWorkflows), runData))
+
+	ghw := &GitHubWorkflow{}
+	predicate, err := ghw.BuildPredicate(&run.Run{
+		SpecURL:    "github://octo-org/app/42",
+		SystemData: runData,
+	}, nil)
+	require.NoError(t, err)
+
+	require.Len(t, predicate.Materials, 2)
+	require.Equal(t,
+		"git+https://github.com/octo-org/shared@refs/tags/v1.2.0#.github/workflows/build.yml",
+		predicate.Materials[0].URI,
+	)
+	require.Equal(t, "a8cdcd6c1b1e0e5c8a2b83c1a2a1ee1c8a9b9d7e", predicate.Materials[0].Digest["sha1"])
+	require.Equal(t,
+		"git+https://github.com/octo-org/app@009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d#.github/workflows/test.yml",
+		predicate.Materials[1].URI,
+	)
+}
+
+func TestGitHubActors(t *testing.T) {
+	runData := &github.Run{}
+	require.NoError(t, json.Unmarshal([]byte(runWithReferencedWorkflows), runData))
+
+	ghw := &GitHubWorkflow{}
+	predicate, err := ghw.BuildPredicate(&run.Run{
+		SpecURL:    "github://octo-org/app/42",
+		SystemData: runData,
+	}, nil)
+	require.NoError(t, err)
+
+	data, err := json.Marshal(predicate.Invocation.Environment)
+	require.NoError(t, err)
+	env := struct {
+		Context struct {
+			GitHub map[string]string `json:"github"`
+		} `json:"context"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &env))
+	require.Equal(t, map[string]string{
+		"run_id":           "42",
+		"event_name":       "workflow_dispatch",
+		"actor":            "octocat",
+		"triggering_actor": "hubot",
+	}, env.Context.GitHub)
+}
+
+func TestGitHubEnterpriseURLs(t *testing.T) {
+	t.Setenv("GITHUB_API_URL", "")
+	for _, tc := range []struct {
+		specURL string
+		runURL  string
+		source  string
+		store   string
+	}{
+		{
+			"github://octo-org/app/42",
+			"https://api.github.com/repos/octo-org/app/actions/runs/42",
+			"git+https://github.com/octo-org/app.git",
+			"actions://octo-org/app/42",
+		},
+		{
+			"github://github.corp/octo-org/app/42",
+			"https://github.corp/api/v3/repos/octo-org/app/actions/runs/42",
+			"git+https://github.corp/octo-org/app.git",
+			"actions://github.corp/octo-org/app/42",
+		},
+	} {
+		host, org, repo, runID, err := parseGitHubURL(tc.specURL)
+		require.NoError(t, err)
+		ghw := &GitHubWorkflow{Host: host, Organization: org, Repository: repo, RunID: int(runID)}
+		require.Equal(t, tc.runURL, ghw.runURL())
+
+		predicate, err := ghw.BuildPredicate(&run.Run{
+			SpecURL:    tc.specURL,
+			SystemData: &github.Run{},
+		}, nil)
+		require.NoError(t, err)
+		require.Equal(t, tc.source, predicate.Invocation.ConfigSource.URI)
+
+		stores := ghw.ArtifactStores()
+		require.Len(t, stores, 1)
+		require.Equal(t, tc.store, stores[0].SpecURL)
+	}
+
+	_, _, _, _, err := parseGitHubURL("github://octo-org/42")
+	require.Error(t, err)
+}
+
+// Trimmed response from /repos/octo-org/app/actions/runs/42/jobs
+const runJobs = `{
+  "total_count": 1,
+  "jobs": [
+    {
+      "id": 399444496,
+      "name": "release",
+      "status": "completed",
+      "conclusion": "success",
+      "steps": [
+        {
+          "name": "Checkout",
+          "status": "completed",
+          "conclusion": "success",
+          "number": 1,
+          "started_at": "2024-05-01T10:00:00Z",
+          "completed_at": "2024-05-01T10:00:05Z"
+        },
+        {
+          "name": "Publish",
+          "status": "completed",
+          "conclusion": "skipped",
+          "number": 2,
+          "started_at": null,
+          "completed_at": null
+        }
+      ]
+    }
+  ]
+}`
+
+func TestGitHubSteps(t *testing.T) {
+	jobs := &github.Jobs{}
+	require.NoError(t, json.Unmarshal([]byte(runJobs), jobs))
+
+	steps := githubSteps(jobs.Jobs)
+	require.Len(t, steps, 2)
+	require.Equal(t, "release/Checkout", steps[0].Name)
+	require.Equal(t, run.StepStatusRan, steps[0].Status)
+	require.True(t, steps[0].IsSuccess)
+	require.Equal(t, "release/Publish", steps[1].Name)
+	require.Equal(t, run.StepStatusSkipped, steps[1].Status)
+	require.False(t, steps[1].IsSuccess)
+
+	// The steps and their status are recorded in the build config
+	ghw := &GitHubWorkflow{}
+	predicate, err := ghw.BuildPredicate(&run.Run{
+		SpecURL:    "github://octo-org/app/42",
+		SystemData: &github.Run{},
+		Steps:      steps,
+	}, nil)
+	require.NoError(t, err)
+	data, err := json.Marshal(predicate.BuildConfig)
+	require.NoError(t, err)
+	require.JSONEq(t,
+		`{"steps":[{"name":"release/Checkout","status":"ran"},{"name":"release/Publish","status":"skipped"}]}`,
+		string(data),
+	)
+}
+
+// setOIDCClaims makes the claims the ones of the OIDC token of the
+// job, as returned by the GitHub Actions runtime once verified
+func setOIDCClaims(t *testing.T, claims string) {
+	t.Helper()
+	fetch := fetchOIDCClaims
+	t.Cleanup(func() { fetchOIDCClaims = fetch })
+	fetchOIDCClaims = func() (*github.OIDCClaims, error) {
+		c := &github.OIDCClaims{}
+		return c, json.Unmarshal([]byte(claims), c)
+	}
+}
+
+func TestGitHubOIDCClaims(t *testing.T) {
+	runData := &github.Run{}
+	require.NoError(t, json.Unmarshal([]byte(runWithReferencedWorkflows), runData))
+	r := &run.Run{SpecURL: "github://octo-org/app/42", SystemData: runData}
+	ghw := &GitHubWorkflow{}
+
+	setOIDCClaims(t, `{
+		"repository": "octo-org/app",
+		"ref": "refs/heads/main",
+		"sha": "009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d",
+		"workflow_ref": "octo-org/app/.github/workflows/release.yml@refs/heads/main",
+		"job_workflow_ref": "octo-org/shared/.github/workflows/build.yml@refs/tags/v1.2.0",
+		"run_id": "42",
+		"run_attempt": "1",
+		"runner_environment": "self-hosted"
+	}`)
+	predicate, err := ghw.BuildPredicate(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://github.com/octo-org/shared/.github/workflows/build.yml@refs/tags/v1.2.0", predicate.Builder.ID)
+	require.Equal(t, "git+https://github.com/octo-org/app.git@refs/heads/main", predicate.Invocation.ConfigSource.URI)
+	require.Equal(t, ".github/workflows/release.yml", predicate.Invocation.ConfigSource.EntryPoint)
+	data, err := json.Marshal(predicate.Invocation.Environment)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"runner_environment":"self-hosted"`)
+
+	// Tokens of other runs are ignored
+	setOIDCClaims(t, `{"repository": "octo-org/other", "run_id": "42", "sha": "0000"}`)
+	predicate, err = ghw.BuildPredicate(r, nil)
+	require.NoError(t, err)
+	require.Equal(t, "https://github.com/Attestations/GitHubHostedActions@v1", predicate.Builder.ID)
+	require.Equal(t, "git+https://github.com/octo-org/app.git", predicate.Invocation.ConfigSource.URI)
+
+	// Claims of the run that don't match the API data are an error
+	setOIDCClaims(t, `{"repository": "octo-org/app", "run_id": "42", "sha": "0000"}`)
+	_, err = ghw.BuildPredicate(r, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not match")
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exec
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	purl "github.com/package-url/packageurl-go"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/release-utils/util"
+
+	"sigs.k8s.io/tejolote/pkg/lockfile"
+)
+
+// packageManagerDependencies checks if the run executed a package manager
+// tejolote recognizes and, if so, reads the dependencies it installed from
+// the resulting lockfile. Unrecognized commands return no dependencies.
+func (r *Run) packageManagerDependencies() ([]common.ProvenanceMaterial, error) {
+	command := filepath.Base(r.Command)
+	params := r.Params
+
+	// Handle python -m pip install
+	if strings.HasPrefix(command, "python") && len(params) > 1 && params[0] == "-m" {
+		command = params[1]
+		params = params[2:]
+	}
+
+	if len(params) == 0 {
+		return nil, nil
+	}
+
+	switch command {
+	case "npm":
+		switch params[0] {
+		case "ci", "install", "i":
+			return readNPMLockfile(filepath.Join(r.Environment.Directory, "package-lock.json"))
+		}
+	case "pip", "pip3":
+		if params[0] == "install" {
+			return r.readPipRequirements(params[1:])
+		}
+	}
+	return nil, nil
+}
+
+// readNPMLockfile parses an npm lockfile and returns the locked
+// packages as materials
+func readNPMLockfile(path string) ([]common.ProvenanceMaterial, error) {
+	if !util.Exists(path) {
+		logrus.Warnf("npm run detected but no lockfile found at %s", path)
+		return nil, nil
+	}
+	materials, err := lockfile.Read(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading npm lockfile: %w", err)
+	}
+	return materials, nil
+}
+
+// readPipRequirements reads the requirements files passed to pip
+// and returns the pinned packages as materials
+func (r *Run) readPipRequirements(params []string) ([]common.ProvenanceMaterial, error) {
+	files := []string{}
+	for i, p := range params {
+		switch {
+		case (p == "-r" || p == "--requirement") && i+1 < len(params):
+			files = append(files, params[i+1])
+		case strings.HasPrefix(p, "--requirement="):
+			files = append(files, strings.TrimPrefix(p, "--requirement="))
+		case strings.HasPrefix(p, "-r") && len(p) > 2:
+			files = append(files, strings.TrimPrefix(p, "-r"))
+		}
+	}
+
+	if len(files) == 0 {
+		logrus.Warn("pip run detected but no requirements file was passed, not recording dependencies")
+		return nil, nil
+	}
+
+	materials := []common.ProvenanceMaterial{}
+	for _, f := range files {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(r.Environment.Directory, f)
+		}
+		fileMaterials, err := readPipRequirementsFile(f)
+		if err != nil {
+			return nil, fmt.Errorf("reading requirements from %s: %w", f, err)
+		}
+		materials = append(materials, fileMaterials...)
+	}
+	return materials, nil
+}
+
+// readPipRequirementsFile parses a requirements file returning the
+// packages pinned to a version. Hashes are read from --hash options.
+func readPipRequirementsFile(path string) ([]common.ProvenanceMaterial, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening requirements file: %w", err)
+	}
+	defer f.Close()
+
+	// Join the continued lines before parsing
+	lines := []string{}
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasSuffix(line, "\\") {
+			current += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		lines = append(lines, current+line)
+		current = ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading requirements file: %w", err)
+	}
+
+	materials := []common.ProvenanceMaterial{}
+	for _, line := range lines {
+		line, _, _ = strings.Cut(line, "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+
+		name, version, ok := strings.Cut(fields[0], "==")
+		if !ok {
+			logrus.Warnf("requirement %s is not pinned, skipping", fields[0])
+			continue
+		}
+		// Drop any environment markers
+		version, _, _ = strings.Cut(version, ";")
+
+		digest := common.DigestSet{}
+		for _, field := range fields[1:] {
+			if !strings.HasPrefix(field, "--hash=") {
+				continue
+			}
+			algo, value, ok := strings.Cut(strings.TrimPrefix(field, "--hash="), ":")
+			if ok {
+				digest[algo] = value
+			}
+		}
+
+		materials = append(materials, common.ProvenanceMaterial{
+			URI: purl.NewPackageURL(
+				purl.TypePyPi, "", strings.ToLower(name), version, nil, "",
+			).ToString(),
+			Digest: digest,
+		})
+	}
+	return materials, nil
+}
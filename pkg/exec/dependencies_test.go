@@ -0,0 +1,112 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+const fakeNPM = `#!/bin/sh
+cat > package-lock.json <<EOF
+{
+  "name": "test",
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "test"},
+    "node_modules/left-pad": {
+      "version": "1.3.0",
+      "resolved": "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz",
+      "integrity": "sha512-XRcglhh3p2lHAu4gFg75i5owZ3/uttIZh11iKj+W2fqc4Iu8OvwIHkDSftaw4gURo0WA2fT2oguwTa4HChLwJw=="
+    },
+    "node_modules/@scope/util": {
+      "version": "2.0.0"
+    }
+  }
+}
+EOF
+`
+
+func TestPackageManagerDependencies(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// Write a fake npm that produces a lockfile when run
+	binDir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(binDir)
+	npmPath := filepath.Join(binDir, "npm")
+	require.NoError(t, os.WriteFile(npmPath, []byte(fakeNPM), os.FileMode(0o755)))
+
+	attPath := filepath.Join(dir, "provenance.json")
+	runner := NewRunner()
+	runner.Options.CWD = dir
+	runner.Options.AttestationPath = attPath
+
+	_, err = runner.RunStep(&run.Step{Command: npmPath, Params: []string{"ci"}})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(attPath)
+	require.NoError(t, err)
+	att := struct {
+		intoto.StatementHeader
+		Predicate slsa.ProvenancePredicate `json:"predicate"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &att))
+
+	require.Len(t, att.Predicate.Materials, 2)
+	require.Equal(t, "pkg:npm/%40scope/util@2.0.0", att.Predicate.Materials[0].URI)
+	require.Equal(t, "pkg:npm/left-pad@1.3.0", att.Predicate.Materials[1].URI)
+	require.Equal(t,
+		"5d1720961877a7694702ee20160ef98b9a30677feeb6d219875d622a3f96d9fa9ce08bbc3afc081e40d27ed6b0e20511a34580d9f4f6a20bb04dae070a12f027",
+		att.Predicate.Materials[1].Digest["sha512"],
+	)
+}
+
+func TestPipRequirements(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "requirements.txt"), []byte(`# Pinned requirements
+requests==2.31.0 \
+    --hash=sha256:58cd2187c01e70e6e26505bca751777aa9f2ee0b7f4300988b709f44e013003f
+Flask==3.0.0
+urllib3>=2.0
+`), os.FileMode(0o644)))
+
+	r := &Run{
+		Command:     "pip",
+		Params:      []string{"install", "-r", "requirements.txt"},
+		Environment: RunEnvironment{Directory: dir},
+	}
+	materials, err := r.packageManagerDependencies()
+	require.NoError(t, err)
+	require.Len(t, materials, 2)
+	require.Equal(t, "pkg:pypi/requests@2.31.0", materials[0].URI)
+	require.Equal(t, "58cd2187c01e70e6e26505bca751777aa9f2ee0b7f4300988b709f44e013003f", materials[0].Digest["sha256"])
+	require.Equal(t, "pkg:pypi/flask@3.0.0", materials[1].URI)
+}
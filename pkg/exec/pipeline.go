@@ -0,0 +1,141 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// Pipeline is an ordered list of steps executed as a single build
+type Pipeline struct {
+	Steps []PipelineStep `json:"steps"`
+}
+
+// PipelineStep describes a command in a pipeline configuration file
+type PipelineStep struct {
+	Command         string            `json:"command"`
+	Args            []string          `json:"args,omitempty"`
+	Env             map[string]string `json:"env,omitempty"`
+	WorkDir         string            `json:"workdir,omitempty"`
+	ContinueOnError bool              `json:"continue-on-error,omitempty"`
+}
+
+// LoadPipeline reads a pipeline definition from a YAML file
+func LoadPipeline(path string) (*Pipeline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading pipeline configuration: %w", err)
+	}
+	p := &Pipeline{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("parsing pipeline configuration: %w", err)
+	}
+	if len(p.Steps) == 0 {
+		return nil, errors.New("pipeline configuration does not define any steps")
+	}
+	for i, s := range p.Steps {
+		if s.Command == "" {
+			return nil, fmt.Errorf("step #%d does not define a command", i+1)
+		}
+	}
+	return p, nil
+}
+
+// Step returns the run step of the pipeline step
+func (ps *PipelineStep) Step() *run.Step {
+	env := map[string]string{}
+	for k, v := range ps.Env {
+		env[k] = v
+	}
+	return &run.Step{
+		Command:     ps.Command,
+		Params:      ps.Args,
+		Directory:   ps.WorkDir,
+		Environment: env,
+	}
+}
+
+// RunPipeline executes the steps of a pipeline in order. The output
+// directories are snapshotted once before the first step and once after
+// the last, so the attestation covers everything the pipeline produced.
+// A failing step stops the pipeline unless it is set to continue on error.
+func (r *Runner) RunPipeline(p *Pipeline) (pipelineRun *Run, err error) {
+	pipelineRun = &Run{
+		Artifacts: []run.Artifact{},
+		Steps:     []*Run{},
+		Environment: RunEnvironment{
+			Directory: r.Options.CWD,
+			Variables: map[string]string{},
+		},
+		TrustedKeys: r.Options.TrustedKeys,
+	}
+
+	if err := r.implementation.Snapshot(&r.Options, &r.Watchers); err != nil {
+		return nil, fmt.Errorf("running initial snapshots: %w", err)
+	}
+
+	if err := pipelineRun.RecordSource(); err != nil {
+		return nil, err
+	}
+
+	for i := range p.Steps {
+		stepRun, err := r.implementation.CreateRun(&r.Options, p.Steps[i].Step())
+		if err != nil {
+			return nil, fmt.Errorf("creating run for step #%d: %w", i+1, err)
+		}
+		pipelineRun.Steps = append(pipelineRun.Steps, stepRun)
+
+		if err := r.implementation.Execute(&r.Options, stepRun); err != nil {
+			if !p.Steps[i].ContinueOnError {
+				return pipelineRun, fmt.Errorf("executing step #%d: %w", i+1, err)
+			}
+			r.Options.Logger.Warnf("step #%d failed, continuing: %v", i+1, err)
+			continue
+		}
+
+		if err := r.implementation.ReadDependencies(&r.Options, stepRun); err != nil {
+			return pipelineRun, fmt.Errorf("reading step #%d dependencies: %w", i+1, err)
+		}
+		pipelineRun.Materials = append(pipelineRun.Materials, stepRun.Materials...)
+	}
+
+	pipelineRun.StartTime = pipelineRun.Steps[0].StartTime
+	pipelineRun.EndTime = time.Now()
+	if e := pipelineRun.Steps[len(pipelineRun.Steps)-1].EndTime; !e.IsZero() {
+		pipelineRun.EndTime = e
+	}
+
+	if err := r.implementation.Snapshot(&r.Options, &r.Watchers); err != nil {
+		return pipelineRun, fmt.Errorf("running final snapshots: %w", err)
+	}
+
+	for i := range r.Watchers {
+		pipelineRun.Artifacts = append(pipelineRun.Artifacts, r.Watchers[i].SnapshotDelta()...)
+	}
+
+	if err := r.implementation.WriteAttestation(&r.Options, pipelineRun); err != nil {
+		return pipelineRun, fmt.Errorf("writing provenance attestation: %w", err)
+	}
+	return pipelineRun, nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/stretchr/testify/require"
+)
+
+const testPipeline = `steps:
+  - command: sh
+    args: ["-c", "echo $GREETING > first.txt"]
+    env:
+      GREETING: hello
+  - command: sh
+    args: ["-c", "exit 1"]
+    continue-on-error: true
+  - command: sh
+    args: ["-c", "cp ../first.txt second.txt"]
+    workdir: sub
+`
+
+func TestRunPipeline(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), os.FileMode(0o755)))
+
+	confDir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(confDir)
+	confPath := filepath.Join(confDir, "pipeline.yaml")
+	require.NoError(t, os.WriteFile(confPath, []byte(testPipeline), os.FileMode(0o644)))
+
+	pipeline, err := LoadPipeline(confPath)
+	require.NoError(t, err)
+	require.Len(t, pipeline.Steps, 3)
+
+	attPath := filepath.Join(confDir, "provenance.json")
+	runner := NewRunner()
+	runner.Options.CWD = dir
+	runner.Options.AttestationPath = attPath
+	require.NoError(t, runner.AddOutputDirectory("."))
+
+	r, err := runner.RunPipeline(pipeline)
+	require.NoError(t, err)
+	require.Len(t, r.Steps, 3)
+	require.Len(t, r.Artifacts, 2)
+
+	data, err := os.ReadFile(attPath)
+	require.NoError(t, err)
+	att := struct {
+		intoto.StatementHeader
+		Predicate struct {
+			BuildConfig buildConfig `json:"buildConfig"`
+		} `json:"predicate"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &att))
+	require.Len(t, att.Subject, 2)
+	require.Len(t, att.Predicate.BuildConfig.Steps, 3)
+	require.Equal(t, 1, att.Predicate.BuildConfig.Steps[1].ExitCode)
+	for _, s := range att.Predicate.BuildConfig.Steps {
+		require.False(t, s.StartedOn.IsZero())
+		require.False(t, s.FinishedOn.IsZero())
+	}
+
+	// Without continue-on-error, a failing step stops the pipeline
+	pipeline.Steps[1].ContinueOnError = false
+	r, err = runner.RunPipeline(pipeline)
+	require.Error(t, err)
+	require.Len(t, r.Steps, 2)
+}
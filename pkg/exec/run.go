@@ -44,6 +44,42 @@ type Run struct {
 	StartTime   time.Time
 	EndTime     time.Time
 	Environment RunEnvironment
+	Materials   []common.ProvenanceMaterial
+	Steps       []*Run // Runs of each step when executing a pipeline
+
+	// TrustedKeys is the path to an armored PGP keyring to verify
+	// the signature of the build point commit
+	TrustedKeys string
+
+	// source is the state of the source tree before the build,
+	// recorded with RecordSource
+	source         *sourceConfig
+	sourceRecorded bool
+}
+
+// buildConfig records the state of the source tree and the
+// steps of a pipeline in the build config
+type buildConfig struct {
+	Source *sourceConfig        `json:"source,omitempty"`
+	Steps  []pipelineStepConfig `json:"steps,omitempty"`
+}
+
+// sourceConfig describes the working tree the build ran in
+type sourceConfig struct {
+	// Dirty is true when the tree had uncommitted changes
+	Dirty bool `json:"dirty"`
+
+	// Signature is the signature of the build point commit
+	Signature *git.CommitSignature `json:"signature,omitempty"`
+}
+
+type pipelineStepConfig struct {
+	Command    []string          `json:"command"`
+	WorkDir    string            `json:"workdir"`
+	Env        map[string]string `json:"env,omitempty"`
+	ExitCode   int               `json:"exitCode"`
+	StartedOn  time.Time         `json:"startedOn"`
+	FinishedOn time.Time         `json:"finishedOn"`
 }
 
 const TejoloteURI = "http://github.com/kubernetes-sigs/tejolote"
@@ -59,8 +95,17 @@ func (r *Run) InvocationData() (slsa.ProvenanceInvocation, error) {
 	invocation := slsa.ProvenanceInvocation{
 		ConfigSource: slsa.ConfigSource{},
 	}
-	invocation.Parameters = []string{r.Command}
-	invocation.Parameters = append(invocation.Parameters.([]string), r.Params...)
+	if len(r.Steps) > 0 {
+		// Pipelines record the command line of each step
+		commands := [][]string{}
+		for _, step := range r.Steps {
+			commands = append(commands, append([]string{step.Command}, step.Params...))
+		}
+		invocation.Parameters = commands
+	} else {
+		invocation.Parameters = []string{r.Command}
+		invocation.Parameters = append(invocation.Parameters.([]string), r.Params...)
+	}
 	invocation.Environment = map[string]string{}
 
 	for _, e := range os.Environ() {
@@ -159,5 +204,73 @@ func (r *Run) Predicate() (*slsa.ProvenancePredicate, error) {
 		Materials: []common.ProvenanceMaterial{},
 	}
 
+	predicate.Materials = append(predicate.Materials, r.Materials...)
+
+	config := buildConfig{}
+	config.Source = r.source
+	if !r.sourceRecorded {
+		source, err := r.sourceConfig()
+		if err != nil {
+			return nil, fmt.Errorf("reading source tree state: %w", err)
+		}
+		config.Source = source
+	}
+
+	for _, step := range r.Steps {
+		config.Steps = append(config.Steps, pipelineStepConfig{
+			Command:    append([]string{step.Command}, step.Params...),
+			WorkDir:    step.Environment.Directory,
+			Env:        step.Environment.Variables,
+			ExitCode:   step.ExitCode,
+			StartedOn:  step.StartTime,
+			FinishedOn: step.EndTime,
+		})
+	}
+
+	if config.Source != nil || len(config.Steps) > 0 {
+		predicate.BuildConfig = config
+	}
+
 	return &predicate, nil
 }
+
+// RecordSource reads the state of the source tree. It is called before
+// executing the build, files written by the build would mark the tree
+// as dirty otherwise. Runs without a recorded state read it when
+// generating their predicate.
+func (r *Run) RecordSource() error {
+	source, err := r.sourceConfig()
+	if err != nil {
+		return fmt.Errorf("reading source tree state: %w", err)
+	}
+	r.source = source
+	r.sourceRecorded = true
+	return nil
+}
+
+// sourceConfig returns the state of the git working tree where
+// the build ran. If the build did not run in a repository it
+// returns nil.
+func (r *Run) sourceConfig() (*sourceConfig, error) {
+	if !git.IsRepo(r.Environment.Directory) {
+		return nil, nil
+	}
+	repo, err := git.NewRepository(r.Environment.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("opening build repo: %w", err)
+	}
+	repo.Options.TrustedKeys = r.TrustedKeys
+	clean, err := repo.IsClean()
+	if err != nil {
+		return nil, fmt.Errorf("checking worktree status: %w", err)
+	}
+	commit, err := repo.HeadCommitSHA()
+	if err != nil {
+		return nil, fmt.Errorf("fetching build point commit: %w", err)
+	}
+	signature, err := repo.CommitSignature(commit)
+	if err != nil {
+		return nil, fmt.Errorf("reading build point commit signature: %w", err)
+	}
+	return &sourceConfig{Dirty: !clean, Signature: signature}, nil
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+)
+
+// initSourceRepo creates a repository in dir with a committed README.md
+func initSourceRepo(t *testing.T, dir string) {
+	t.Helper()
+	repo, err := gogit.PlainInit(dir, false)
+	require.NoError(t, err)
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: "origin", URLs: []string{"git@github.com:kubernetes-sigs/tejolote.git"},
+	})
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("test"), os.FileMode(0o644)))
+	_, err = wt.Add("README.md")
+	require.NoError(t, err)
+	_, err = wt.Commit("initial", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Tejolote", Email: "tejolote@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+}
+
+func TestPredicateSourceState(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	initSourceRepo(t, dir)
+
+	r := &Run{Environment: RunEnvironment{Directory: dir}}
+	predicate, err := r.Predicate()
+	require.NoError(t, err)
+	require.False(t, predicate.BuildConfig.(buildConfig).Source.Dirty)
+
+	// An uncommitted change marks the source as dirty
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("modified"), os.FileMode(0o644)))
+	predicate, err = r.Predicate()
+	require.NoError(t, err)
+	require.True(t, predicate.BuildConfig.(buildConfig).Source.Dirty)
+}
@@ -18,6 +18,7 @@ package exec
 
 import (
 	"fmt"
+	"path/filepath"
 
 	"github.com/sirupsen/logrus"
 	"sigs.k8s.io/tejolote/pkg/run"
@@ -45,6 +46,29 @@ type Options struct {
 	CWD             string
 	AttestationPath string
 	Logger          *logrus.Logger
+
+	// TrustedKeys is the path to an armored PGP keyring used to
+	// verify the signature of the build point commit
+	TrustedKeys string
+}
+
+// AddOutputDirectory adds a directory to watch for artifacts produced
+// by the run. Relative paths are resolved from the runner's CWD.
+func (r *Runner) AddOutputDirectory(dir string) error {
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(r.Options.CWD, dir)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return fmt.Errorf("resolving output directory path: %w", err)
+	}
+
+	w := watcher.Watcher{}
+	if err := w.AddArtifactSource("file://" + dir); err != nil {
+		return fmt.Errorf("adding output directory: %w", err)
+	}
+	r.Watchers = append(r.Watchers, w)
+	return nil
 }
 
 // RunStep executes a step
@@ -60,22 +84,28 @@ func (r *Runner) RunStep(step *run.Step) (runner *Run, err error) {
 		return runner, fmt.Errorf("running initial snapshots: %w", err)
 	}
 
+	if err := runner.RecordSource(); err != nil {
+		return runner, err
+	}
+
 	if err := r.implementation.Execute(&r.Options, runner); err != nil {
 		return nil, fmt.Errorf("executing run: %w", err)
 	}
 
+	// Record any dependencies installed by the step
+	if err := r.implementation.ReadDependencies(&r.Options, runner); err != nil {
+		return runner, fmt.Errorf("reading run dependencies: %w", err)
+	}
+
 	// Call the watcher to snapshot the results
 	if err := r.implementation.Snapshot(&r.Options, &r.Watchers); err != nil {
 		return runner, fmt.Errorf("running final snapshots: %w", err)
 	}
 
-	// TODO: review this
-	//nolint: gocritic
-	/*
-		for _, w := range r.Watchers {
-			run.Artifacts = append(run.Artifacts, w.(*watcher.Directory).Snapshots[0].Delta(&w.(*watcher.Directory).Snapshots[1])...)
-		}
-	*/
+	// The artifacts are the files that changed during the run
+	for i := range r.Watchers {
+		runner.Artifacts = append(runner.Artifacts, r.Watchers[i].SnapshotDelta()...)
+	}
 	if err := r.implementation.WriteAttestation(&r.Options, runner); err != nil {
 		return runner, fmt.Errorf("writing provenance attestation: %w", err)
 	}
@@ -19,6 +19,7 @@ package exec
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -32,6 +33,7 @@ type RunnerImplementation interface {
 	CreateRun(*Options, *run.Step) (*Run, error)
 	Snapshot(*Options, *[]watcher.Watcher) error
 	Execute(*Options, *Run) error
+	ReadDependencies(*Options, *Run) error
 	WriteAttestation(*Options, *Run) error
 }
 
@@ -47,12 +49,26 @@ func (ri *defaultRunnerImplementation) CreateRun(opts *Options, step *run.Step)
 			return nil, fmt.Errorf("getting current directory: %w", err)
 		}
 	}
+	// Steps may define their own directory, relative to the CWD
+	if step.Directory != "" {
+		if filepath.IsAbs(step.Directory) {
+			cwd = step.Directory
+		} else {
+			cwd = filepath.Join(cwd, step.Directory)
+		}
+	}
 	cmd = command.NewWithWorkDir(
 		cwd,
 		step.Command,
 		step.Params...,
 	)
 
+	variables := map[string]string{}
+	for k, v := range step.Environment {
+		cmd.Env(k + "=" + v)
+		variables[k] = v
+	}
+
 	r = &Run{
 		Executable: cmd,
 		ExitCode:   0,
@@ -63,8 +79,9 @@ func (ri *defaultRunnerImplementation) CreateRun(opts *Options, step *run.Step)
 		Params:     step.Params,
 		Environment: RunEnvironment{
 			Directory: cwd,
-			Variables: map[string]string{},
+			Variables: variables,
 		},
+		TrustedKeys: opts.TrustedKeys,
 	} // command.Command
 
 	opts.Logger.Infof(
@@ -74,38 +91,56 @@ func (ri *defaultRunnerImplementation) CreateRun(opts *Options, step *run.Step)
 }
 
 func (ri *defaultRunnerImplementation) Execute(opts *Options, runner *Run) (err error) {
-	var output *command.Stream
+	var status *command.Status
 
 	runner.StartTime = time.Now()
 	// Execute the run's command
 	if opts.Verbose {
-		output, err = runner.Executable.RunSuccessOutput()
+		status, err = runner.Executable.Run()
 	} else {
-		output, err = runner.Executable.RunSilentSuccessOutput()
+		status, err = runner.Executable.RunSilent()
 	}
 	runner.EndTime = time.Now()
 	if err != nil {
 		return fmt.Errorf("executing run: %w", err)
 	}
 
-	runner.Output = output
+	runner.Status = *status
+	runner.ExitCode = status.ExitCode()
+	runner.Output = status.Stream
+	if !status.Success() {
+		return fmt.Errorf(
+			"command %s did not succeed (exit code %d): %s",
+			runner.Executable.String(), runner.ExitCode, status.Error(),
+		)
+	}
 	if opts.Verbose {
 		logrus.Info(runner.Output)
 	}
 	return nil
 }
 
-func (ri *defaultRunnerImplementation) Snapshot(_ *Options, _ *[]watcher.Watcher) error {
-	// TODO: review this
-	// Take the initial snapshots
-	//nolint: gocritic
-	/*
-		for i := range *watchers {
-			if err := (*watchers)[i].Snap(); err != nil {
-				return fmt.Errorf("snapshotting watcher: %w", err)
-			}
+// ReadDependencies checks if the run invoked a known package manager
+// and records the dependencies it installed as materials
+func (ri *defaultRunnerImplementation) ReadDependencies(opts *Options, runner *Run) error {
+	materials, err := runner.packageManagerDependencies()
+	if err != nil {
+		return fmt.Errorf("reading package manager dependencies: %w", err)
+	}
+	if len(materials) > 0 {
+		opts.Logger.Infof("Recorded %d dependencies installed by %s", len(materials), runner.Command)
+	}
+	runner.Materials = append(runner.Materials, materials...)
+	return nil
+}
+
+// Snapshot captures the state of the output directories
+func (ri *defaultRunnerImplementation) Snapshot(_ *Options, watchers *[]watcher.Watcher) error {
+	for i := range *watchers {
+		if err := (*watchers)[i].Snap(); err != nil {
+			return fmt.Errorf("snapshotting watcher: %w", err)
 		}
-	*/
+	}
 	return nil
 }
 
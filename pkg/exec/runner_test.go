@@ -0,0 +1,104 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package exec
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	intoto "github.com/in-toto/in-toto-golang/in_toto"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+func TestRunStepArtifacts(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	attDir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(attDir)
+
+	// A file that exists before the run is not an artifact
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "existing.txt"), []byte("test"), os.FileMode(0o644)))
+
+	attPath := filepath.Join(attDir, "provenance.json")
+	runner := NewRunner()
+	runner.Options.CWD = dir
+	runner.Options.AttestationPath = attPath
+	require.NoError(t, runner.AddOutputDirectory("."))
+
+	r, err := runner.RunStep(&run.Step{
+		Command: "sh", Params: []string{"-c", "echo test > output.txt"},
+	})
+	require.NoError(t, err)
+	require.Len(t, r.Artifacts, 1)
+	require.Equal(t, "output.txt", r.Artifacts[0].Path)
+
+	data, err := os.ReadFile(attPath)
+	require.NoError(t, err)
+	att := intoto.StatementHeader{}
+	require.NoError(t, json.Unmarshal(data, &att))
+	require.Len(t, att.Subject, 1)
+	require.Equal(t, "output.txt", att.Subject[0].Name)
+	require.Equal(t,
+		"f2ca1bb6c7e907d06dafe4687e579fce76b37e4e93b7605022da52e6ccc26fd2",
+		att.Subject[0].Digest["SHA256"],
+	)
+}
+
+func TestRunStepSourceState(t *testing.T) {
+	dir := t.TempDir()
+	initSourceRepo(t, dir)
+	attPath := filepath.Join(t.TempDir(), "provenance.json")
+
+	sourceDirty := func() bool {
+		data, err := os.ReadFile(attPath)
+		require.NoError(t, err)
+		att := struct {
+			Predicate struct {
+				BuildConfig buildConfig `json:"buildConfig"`
+			} `json:"predicate"`
+		}{}
+		require.NoError(t, json.Unmarshal(data, &att))
+		require.NotNil(t, att.Predicate.BuildConfig.Source)
+		return att.Predicate.BuildConfig.Source.Dirty
+	}
+
+	// Outputs written to the repository don't make the source dirty
+	runner := NewRunner()
+	runner.Options.CWD = dir
+	runner.Options.AttestationPath = attPath
+	require.NoError(t, runner.AddOutputDirectory("."))
+	_, err := runner.RunStep(&run.Step{
+		Command: "sh", Params: []string{"-c", "echo test > output.txt"},
+	})
+	require.NoError(t, err)
+	require.False(t, sourceDirty())
+
+	// changes made before the build do
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("modified"), os.FileMode(0o644)))
+	_, err = runner.RunStep(&run.Step{
+		Command: "sh", Params: []string{"-c", "echo test > output2.txt"},
+	})
+	require.NoError(t, err)
+	require.True(t, sourceDirty())
+}
@@ -17,12 +17,18 @@ limitations under the License.
 package git
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
 
 	"sigs.k8s.io/release-utils/util"
 )
@@ -57,6 +63,110 @@ func NewRepository(dir string) (*Repository, error) {
 
 type Options struct {
 	CWD string
+
+	// TrustedKeys is the path to an armored PGP keyring used
+	// to verify commit signatures
+	TrustedKeys string
+}
+
+// CloneOptions control how a repository is cloned
+type CloneOptions struct {
+	// Ref is the branch, tag or commit to check out. When empty,
+	// the remote's default branch is cloned.
+	Ref string
+
+	// Depth limits the history fetched. Zero fetches the whole history.
+	Depth int
+}
+
+// Clone clones the repository at url into dir and checks out
+// the reference in the options. The directory has to be empty
+// or not exist, existing files are never overwritten.
+func Clone(url, dir string, opts CloneOptions) (*Repository, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading clone directory: %w", err)
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("cloning %s: directory %s is not empty", url, dir)
+	}
+
+	cloneOpts := &gogit.CloneOptions{
+		URL:          url,
+		Depth:        opts.Depth,
+		SingleBranch: opts.Ref != "",
+	}
+
+	// Commits cannot be fetched directly, so clone the full
+	// history and check out the commit after
+	isCommit := plumbing.IsHash(opts.Ref)
+	if isCommit {
+		cloneOpts.Depth = 0
+		cloneOpts.SingleBranch = false
+	}
+
+	var gorepo *gogit.Repository
+	switch {
+	case opts.Ref == "" || isCommit:
+		gorepo, err = gogit.PlainClone(dir, false, cloneOpts)
+	default:
+		// Look up the ref in the remote to know if it is a branch or a
+		// tag before cloning, a failed clone may leave files behind
+		cloneOpts.ReferenceName, err = remoteRefName(url, opts.Ref)
+		if err != nil {
+			return nil, err
+		}
+		gorepo, err = gogit.PlainClone(dir, false, cloneOpts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cloning %s: %w", url, err)
+	}
+
+	if isCommit {
+		wt, err := gorepo.Worktree()
+		if err != nil {
+			return nil, fmt.Errorf("getting repository worktree: %w", err)
+		}
+		if err := wt.Checkout(&gogit.CheckoutOptions{
+			Hash: plumbing.NewHash(opts.Ref),
+		}); err != nil {
+			return nil, fmt.Errorf("checking out commit %s: %w", opts.Ref, err)
+		}
+	}
+
+	return &Repository{
+		repo: gorepo,
+		Options: Options{
+			CWD: dir,
+		},
+	}, nil
+}
+
+// remoteRefName returns the full name of a ref in the remote
+// repository at url, branches take precedence over tags
+func remoteRefName(url, ref string) (plumbing.ReferenceName, error) {
+	remote := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: defaultRemote,
+		URLs: []string{url},
+	})
+	refs, err := remote.List(&gogit.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("listing refs of %s: %w", url, err)
+	}
+	branch, tag := plumbing.NewBranchReferenceName(ref), plumbing.NewTagReferenceName(ref)
+	foundTag := false
+	for _, r := range refs {
+		switch r.Name() {
+		case branch:
+			return branch, nil
+		case tag:
+			foundTag = true
+		}
+	}
+	if foundTag {
+		return tag, nil
+	}
+	return "", fmt.Errorf("ref %s not found in %s", ref, url)
 }
 
 // SourceURL returns the repository URL
@@ -88,3 +198,103 @@ func (r *Repository) HeadCommitSHA() (string, error) {
 	}
 	return hash.String(), err
 }
+
+// CommitHashAlgorithm returns the digest algorithm of a full commit
+// hash: sha1 for 40 hex characters or sha256 for 64, as used by
+// repositories in the SHA-256 object format. It returns an empty
+// string if s is not a full commit hash.
+func CommitHashAlgorithm(s string) string {
+	if _, err := hex.DecodeString(s); err != nil {
+		return ""
+	}
+	switch len(s) {
+	case 40:
+		return "sha1"
+	case 64:
+		return "sha256"
+	default:
+		return ""
+	}
+}
+
+// IsCommitHash returns true if a string is a full sha1 or sha256
+// commit hash
+func IsCommitHash(s string) bool {
+	return CommitHashAlgorithm(s) != ""
+}
+
+// CommitForRef returns the SHA of the commit a branch, tag or any
+// other revision points to. Annotated tags resolve to the commit
+// they tag, not to the tag object.
+func (r *Repository) CommitForRef(ref string) (string, error) {
+	// Tags are looked up first, so a tag and a branch
+	// with the same name resolve to the tag
+	if tagRef, err := r.repo.Tag(ref); err == nil {
+		commit, err := r.tagCommit(tagRef)
+		if err != nil {
+			return "", err
+		}
+		return commit.String(), nil
+	}
+	hash, err := r.repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %w", ref, err)
+	}
+	return hash.String(), nil
+}
+
+// TagsPointingAt returns the names of the tags, lightweight or
+// annotated, that point to a commit, sorted alphabetically
+func (r *Repository) TagsPointingAt(sha string) ([]string, error) {
+	iter, err := r.repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("listing tags: %w", err)
+	}
+	tags := []string{}
+	if err := iter.ForEach(func(ref *plumbing.Reference) error {
+		commit, err := r.tagCommit(ref)
+		if err != nil {
+			return err
+		}
+		if commit.String() == strings.ToLower(sha) {
+			tags = append(tags, ref.Name().Short())
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("reading tags: %w", err)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// tagCommit returns the commit a tag reference points to,
+// peeling annotated tag objects
+func (r *Repository) tagCommit(ref *plumbing.Reference) (plumbing.Hash, error) {
+	tag, err := r.repo.TagObject(ref.Hash())
+	switch {
+	case err == nil:
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("reading commit of tag %s: %w", ref.Name().Short(), err)
+		}
+		return commit.Hash, nil
+	case errors.Is(err, plumbing.ErrObjectNotFound):
+		// Lightweight tags point to the commit directly
+		return ref.Hash(), nil
+	default:
+		return plumbing.ZeroHash, fmt.Errorf("reading tag %s: %w", ref.Name().Short(), err)
+	}
+}
+
+// IsClean returns true if the worktree has no uncommitted changes
+func (r *Repository) IsClean() (bool, error) {
+	wt, err := r.repo.Worktree()
+	if err != nil {
+		return false, fmt.Errorf("getting repository worktree: %w", err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return false, fmt.Errorf("reading worktree status: %w", err)
+	}
+	return status.IsClean(), nil
+}
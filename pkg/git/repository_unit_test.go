@@ -17,11 +17,25 @@ limitations under the License.
 package git
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/go-git/go-billy/v5/memfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
 )
 
 func TestSourceURL(t *testing.T) {
@@ -54,3 +68,250 @@ func TestSourceURL(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, url, "git+ssh://git@github.com/kubernetes-sigs/tejolote")
 }
+
+// createBareRepo creates a bare repository with two commits on main
+// and a tag pointing to the first one. It returns the path to the
+// repository and the hashes of the commits.
+func createBareRepo(t *testing.T) (path string, commits []string) {
+	src, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(src)
+
+	repo, err := gogit.PlainInitWithOptions(src, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	for _, content := range []string{"first", "second"} {
+		require.NoError(t, os.WriteFile(filepath.Join(src, "README.md"), []byte(content), os.FileMode(0o644)))
+		_, err := wt.Add("README.md")
+		require.NoError(t, err)
+		hash, err := wt.Commit(content, &gogit.CommitOptions{
+			Author: &object.Signature{Name: "Tejolote", Email: "tejolote@example.com", When: time.Now()},
+		})
+		require.NoError(t, err)
+		commits = append(commits, hash.String())
+	}
+	_, err = repo.CreateTag("v0.1.0", plumbing.NewHash(commits[0]), nil)
+	require.NoError(t, err)
+
+	path, err = os.MkdirTemp("", "")
+	require.NoError(t, err)
+	_, err = gogit.PlainClone(path, true, &gogit.CloneOptions{URL: src})
+	require.NoError(t, err)
+	return path, commits
+}
+
+func TestClone(t *testing.T) {
+	bare, commits := createBareRepo(t)
+	defer os.RemoveAll(bare)
+
+	for _, tc := range []struct {
+		name     string
+		opts     CloneOptions
+		expected string
+	}{
+		{"default branch", CloneOptions{Depth: 1}, commits[1]},
+		{"branch", CloneOptions{Ref: "main", Depth: 1}, commits[1]},
+		{"tag", CloneOptions{Ref: "v0.1.0", Depth: 1}, commits[0]},
+		{"commit", CloneOptions{Ref: commits[0], Depth: 1}, commits[0]},
+	} {
+		dir, err := os.MkdirTemp("", "")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		repo, err := Clone(bare, dir, tc.opts)
+		require.NoError(t, err, tc.name)
+		sha, err := repo.HeadCommitSHA()
+		require.NoError(t, err, tc.name)
+		require.Equal(t, tc.expected, sha, tc.name)
+	}
+}
+
+func TestCloneKeepsExistingFiles(t *testing.T) {
+	bare, _ := createBareRepo(t)
+	defer os.RemoveAll(bare)
+
+	// Directories with files are never cloned into
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("keep"), os.FileMode(0o644)))
+	for _, ref := range []string{"", "main", "v0.1.0", "missing"} {
+		_, err := Clone(bare, dir, CloneOptions{Ref: ref, Depth: 1})
+		require.Error(t, err, ref)
+		require.Contains(t, err.Error(), "not empty", ref)
+		require.FileExists(t, filepath.Join(dir, "notes.txt"), ref)
+	}
+
+	// Missing refs fail before anything is written
+	dir = t.TempDir()
+	_, err := Clone(bare, dir, CloneOptions{Ref: "missing", Depth: 1})
+	require.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestIsClean(t *testing.T) {
+	bare, _ := createBareRepo(t)
+	defer os.RemoveAll(bare)
+
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	repo, err := Clone(bare, dir, CloneOptions{})
+	require.NoError(t, err)
+	clean, err := repo.IsClean()
+	require.NoError(t, err)
+	require.True(t, clean)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte("modified"), os.FileMode(0o644)))
+	clean, err = repo.IsClean()
+	require.NoError(t, err)
+	require.False(t, clean)
+}
+
+func TestCommitSignature(t *testing.T) {
+	dir := t.TempDir()
+	gorepo, err := gogit.PlainInit(dir, false)
+	require.NoError(t, err)
+	wt, err := gorepo.Worktree()
+	require.NoError(t, err)
+
+	entity, err := openpgp.NewEntity("Tejolote", "", "tejolote@example.com", nil)
+	require.NoError(t, err)
+
+	commits := []string{}
+	for _, key := range []*openpgp.Entity{nil, entity} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "README.md"), []byte(time.Now().String()), os.FileMode(0o644)))
+		_, err := wt.Add("README.md")
+		require.NoError(t, err)
+		hash, err := wt.Commit("commit", &gogit.CommitOptions{
+			Author:  &object.Signature{Name: "Tejolote", Email: "tejolote@example.com", When: time.Now()},
+			SignKey: key,
+		})
+		require.NoError(t, err)
+		commits = append(commits, hash.String())
+	}
+
+	repo, err := NewRepository(dir)
+	require.NoError(t, err)
+
+	// Unsigned commits have no signature
+	sig, err := repo.CommitSignature(commits[0])
+	require.NoError(t, err)
+	require.Nil(t, sig)
+
+	// Without trusted keys the signature is not verified
+	sig, err = repo.CommitSignature(commits[1])
+	require.NoError(t, err)
+	require.Equal(t, &CommitSignature{
+		Format: SignatureFormatGPG,
+		Signer: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint),
+	}, sig)
+
+	// Trusting the key verifies the signature
+	var keyring bytes.Buffer
+	w, err := armor.Encode(&keyring, openpgp.PublicKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.Serialize(w))
+	require.NoError(t, w.Close())
+	repo.Options.TrustedKeys = filepath.Join(t.TempDir(), "keyring.asc")
+	require.NoError(t, os.WriteFile(repo.Options.TrustedKeys, keyring.Bytes(), os.FileMode(0o644)))
+	sig, err = repo.CommitSignature(commits[1])
+	require.NoError(t, err)
+	require.True(t, sig.Verified)
+	require.Equal(t, "Tejolote <tejolote@example.com>", sig.Signer)
+}
+
+func TestSSHSignatureKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+
+	// Build a minimal sshsig blob: preamble, version and public key
+	blob := []byte(sshSignatureMagic)
+	blob = binary.BigEndian.AppendUint32(blob, 1)
+	blob = binary.BigEndian.AppendUint32(blob, uint32(len(sshPub.Marshal())))
+	blob = append(blob, sshPub.Marshal()...)
+	armored := sshSignatureHeader + "\n" + base64.StdEncoding.EncodeToString(blob) + "\n-----END SSH SIGNATURE-----\n"
+
+	fingerprint, err := sshSignatureKey(armored)
+	require.NoError(t, err)
+	require.Equal(t, ssh.FingerprintSHA256(sshPub), fingerprint)
+
+	_, err = sshSignatureKey(sshSignatureHeader + "\nbm90IGEgc2lnbmF0dXJl\n")
+	require.Error(t, err)
+}
+
+func TestCommitForRefAndTags(t *testing.T) {
+	gorepo, err := gogit.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+	wt, err := gorepo.Worktree()
+	require.NoError(t, err)
+
+	signature := &object.Signature{Name: "Tejolote", Email: "tejolote@example.com", When: time.Now()}
+	commits := []plumbing.Hash{}
+	for _, content := range []string{"first", "second"} {
+		f, err := wt.Filesystem.Create("README.md")
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		_, err = wt.Add("README.md")
+		require.NoError(t, err)
+		hash, err := wt.Commit(content, &gogit.CommitOptions{Author: signature})
+		require.NoError(t, err)
+		commits = append(commits, hash)
+	}
+
+	// A lightweight and an annotated tag on the first commit
+	_, err = gorepo.CreateTag("v0.1.0", commits[0], nil)
+	require.NoError(t, err)
+	_, err = gorepo.CreateTag("v0.1.0-annotated", commits[0], &gogit.CreateTagOptions{
+		Tagger: signature, Message: "release",
+	})
+	require.NoError(t, err)
+
+	repo := &Repository{repo: gorepo}
+	for ref, expected := range map[string]plumbing.Hash{
+		"HEAD":             commits[1],
+		"master":           commits[1],
+		"v0.1.0":           commits[0],
+		"v0.1.0-annotated": commits[0],
+		"HEAD~1":           commits[0],
+	} {
+		sha, err := repo.CommitForRef(ref)
+		require.NoError(t, err, ref)
+		require.Equal(t, expected.String(), sha, ref)
+	}
+	_, err = repo.CommitForRef("v9.9.9")
+	require.Error(t, err)
+
+	tags, err := repo.TagsPointingAt(commits[0].String())
+	require.NoError(t, err)
+	require.Equal(t, []string{"v0.1.0", "v0.1.0-annotated"}, tags)
+	tags, err = repo.TagsPointingAt(commits[1].String())
+	require.NoError(t, err)
+	require.Empty(t, tags)
+}
+
+func TestCommitHashAlgorithm(t *testing.T) {
+	for s, expected := range map[string]string{
+		"0123456789abcdef0123456789abcdef01234567":                         "sha1",
+		"0123456789ABCDEF0123456789ABCDEF01234567":                         "sha1",
+		"0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef": "sha256",
+		"0123456": "",
+		"v1.0.0":  "",
+		"main":    "",
+		"":        "",
+		"0123456789abcdef0123456789abcdef0123456z":  "",
+		"0123456789abcdef0123456789abcdef012345678": "",
+	} {
+		require.Equal(t, expected, CommitHashAlgorithm(s), s)
+		require.Equal(t, expected != "", IsCommitHash(s), s)
+	}
+}
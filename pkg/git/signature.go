@@ -0,0 +1,163 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package git
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	SignatureFormatGPG = "gpg"
+	SignatureFormatSSH = "ssh"
+
+	sshSignatureHeader = "-----BEGIN SSH SIGNATURE-----"
+	sshSignatureMagic  = "SSHSIG"
+)
+
+// CommitSignature describes the signature of a commit
+type CommitSignature struct {
+	// Format is the kind of signature, gpg or ssh
+	Format string `json:"format"`
+
+	// Signer identifies the signing key. It is the key fingerprint
+	// (or ID) of gpg signatures and the SHA256 fingerprint of ssh keys.
+	// When the signature is verified, it is the identity of the key.
+	Signer string `json:"signer"`
+
+	// Verified is true when the signature was checked against
+	// the trusted keys in the repository options
+	Verified bool `json:"verified"`
+}
+
+// CommitSignature returns the signature of a commit or nil if the
+// commit is not signed. GPG signatures are verified when the
+// repository options define a trusted keyring, ssh signatures are
+// recorded but never verified.
+func (r *Repository) CommitSignature(sha string) (*CommitSignature, error) {
+	commit, err := r.repo.CommitObject(plumbing.NewHash(sha))
+	if err != nil {
+		return nil, fmt.Errorf("reading commit %s: %w", sha, err)
+	}
+	if commit.PGPSignature == "" {
+		return nil, nil
+	}
+
+	if strings.HasPrefix(strings.TrimSpace(commit.PGPSignature), sshSignatureHeader) {
+		fingerprint, err := sshSignatureKey(commit.PGPSignature)
+		if err != nil {
+			return nil, fmt.Errorf("parsing ssh signature: %w", err)
+		}
+		return &CommitSignature{Format: SignatureFormatSSH, Signer: fingerprint}, nil
+	}
+
+	sig := &CommitSignature{Format: SignatureFormatGPG}
+	sig.Signer, err = gpgSignatureKey(commit.PGPSignature)
+	if err != nil {
+		return nil, fmt.Errorf("parsing gpg signature: %w", err)
+	}
+
+	if r.Options.TrustedKeys == "" {
+		return sig, nil
+	}
+	keyring, err := os.ReadFile(r.Options.TrustedKeys)
+	if err != nil {
+		return nil, fmt.Errorf("reading trusted keys: %w", err)
+	}
+	entity, err := commit.Verify(string(keyring))
+	if err != nil {
+		// A signature from an unknown key is not an error, it
+		// just does not get verified
+		return sig, nil
+	}
+	sig.Verified = true
+	if identity := entity.PrimaryIdentity(); identity != nil {
+		sig.Signer = identity.Name
+	}
+	return sig, nil
+}
+
+// gpgSignatureKey returns the fingerprint of the key that made an
+// armored gpg signature, or its key ID if the fingerprint is missing
+func gpgSignatureKey(armored string) (string, error) {
+	block, err := armor.Decode(strings.NewReader(armored))
+	if err != nil {
+		return "", fmt.Errorf("decoding armored signature: %w", err)
+	}
+	p, err := packet.Read(block.Body)
+	if err != nil {
+		return "", fmt.Errorf("reading signature packet: %w", err)
+	}
+	sig, ok := p.(*packet.Signature)
+	if !ok {
+		return "", errors.New("armored data is not a signature")
+	}
+	switch {
+	case len(sig.IssuerFingerprint) > 0:
+		return fmt.Sprintf("%X", sig.IssuerFingerprint), nil
+	case sig.IssuerKeyId != nil:
+		return fmt.Sprintf("%016X", *sig.IssuerKeyId), nil
+	default:
+		return "", errors.New("signature does not identify its key")
+	}
+}
+
+// sshSignatureKey returns the fingerprint of the public key embedded
+// in an armored ssh signature (see PROTOCOL.sshsig in openssh)
+func sshSignatureKey(armored string) (string, error) {
+	var encoded strings.Builder
+	for _, line := range strings.Split(armored, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "-----") {
+			continue
+		}
+		encoded.WriteString(line)
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil {
+		return "", fmt.Errorf("decoding signature: %w", err)
+	}
+	if !bytes.HasPrefix(data, []byte(sshSignatureMagic)) {
+		return "", errors.New("signature does not have the sshsig preamble")
+	}
+	// Skip the preamble and the version to read the public key
+	data = data[len(sshSignatureMagic):]
+	if len(data) < 8 {
+		return "", errors.New("signature is truncated")
+	}
+	data = data[4:]
+	keyLen := binary.BigEndian.Uint32(data[:4])
+	data = data[4:]
+	if uint64(len(data)) < uint64(keyLen) {
+		return "", errors.New("signature public key is truncated")
+	}
+	pubKey, err := ssh.ParsePublicKey(data[:keyLen])
+	if err != nil {
+		return "", fmt.Errorf("parsing signature public key: %w", err)
+	}
+	return ssh.FingerprintSHA256(pubKey), nil
+}
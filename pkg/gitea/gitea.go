@@ -0,0 +1,122 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitea
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+)
+
+// TokenEnvVar holds the token to authenticate to the Gitea API
+const TokenEnvVar = "GITEA_TOKEN"
+
+// Release is a release of a repository as returned by the API
+type Release struct {
+	ID          int64        `json:"id"`
+	TagName     string       `json:"tag_name"`
+	ZipballURL  string       `json:"zipball_url"`
+	TarballURL  string       `json:"tarball_url"`
+	Attachments []Attachment `json:"assets"`
+}
+
+// Attachment is a file uploaded to a release
+type Attachment struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	DownloadURL string    `json:"browser_download_url"`
+}
+
+// Commit is the commit a reference points to
+type Commit struct {
+	SHA string `json:"sha"`
+}
+
+// APIURL returns the base URL of the API of a Gitea or Forgejo server
+func APIURL(host string) string {
+	return fmt.Sprintf("https://%s/api/v1", host)
+}
+
+// get performs a request to the Gitea server, authenticated
+// with the token in the environment when it is set
+func get(url string) (*http.Response, error) {
+	client, err := httpclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating http client: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("creating http request: %w", err)
+	}
+	if token := os.Getenv(TokenEnvVar); token != "" {
+		req.Header.Set("Authorization", "token "+token)
+	} else {
+		logrus.Warn("making unauthenticated request to gitea")
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing http request to gitea: %w", err)
+	}
+	return res, nil
+}
+
+// APIGet queries an API endpoint and decodes its JSON response into v
+func APIGet(url string, v interface{}) error {
+	logrus.Debugf("GiteaAPI[GET]: %s", url)
+	res, err := get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http error %d making request to gitea API", res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding gitea API response: %w", err)
+	}
+	return nil
+}
+
+// Download writes the file at url to f
+func Download(url string, f io.Writer) error {
+	return DownloadWithOptions(url, f, httpclient.DefaultCopyOptions)
+}
+
+// DownloadWithOptions writes the file at url to f, copying the
+// response as set in opts
+func DownloadWithOptions(url string, f io.Writer, opts httpclient.CopyOptions) error {
+	res, err := get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	numBytes, err := httpclient.CopyBodyWithOptions(f, res, opts)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", url, err)
+	}
+	logrus.Debugf("%d MB downloaded from %s", (numBytes / 1024 / 1024), url)
+	return nil
+}
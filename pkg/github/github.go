@@ -20,15 +20,60 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 
 	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+)
+
+const (
+	// DefaultHost is the hostname of the public GitHub
+	DefaultHost = "github.com"
+
+	// DefaultAPIURL is the base URL of the public GitHub API
+	DefaultAPIURL = "https://api.github.com"
 )
 
+// APIURL returns the base URL of the GitHub API. It can be overridden
+// with the GITHUB_API_URL environment variable which GitHub Actions
+// sets when running in GitHub Enterprise Server.
+func APIURL() string {
+	if apiURL := os.Getenv("GITHUB_API_URL"); apiURL != "" {
+		return strings.TrimSuffix(apiURL, "/")
+	}
+	return DefaultAPIURL
+}
+
+// APIURLForHost returns the base URL of the API of a GitHub host. Any
+// host other than github.com is treated as a GitHub Enterprise Server.
+func APIURLForHost(host string) string {
+	if host == "" || host == DefaultHost {
+		return APIURL()
+	}
+	return fmt.Sprintf("https://%s/api/v3", host)
+}
+
+// HostForAPIURL returns the host of the GitHub server whose API is
+// at apiURL, the inverse of APIURLForHost. GitHub Enterprise Server
+// APIs live under /api/v3 of the server, the rest under an api.
+// subdomain of the server like api.github.com.
+func HostForAPIURL(apiURL string) string {
+	u, err := url.Parse(apiURL)
+	if err != nil || u.Host == "" {
+		return DefaultHost
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		return strings.TrimPrefix(u.Host, "api.")
+	}
+	return u.Host
+}
+
 // TokenScopes returns the scopes of token in the eviroment
 func TokenScopes() ([]string, error) {
-	res, err := APIGetRequest("https://api.github.com/repos/github/docs")
+	res, err := APIGetRequest("/repos/github/docs")
 	if err != nil {
 		return nil, fmt.Errorf("making request to API: %w", err)
 	}
@@ -54,16 +99,29 @@ func TokenHas(scope string) (bool, error) {
 	return false, nil
 }
 
+// resolveURL returns the full URL of an API path. Paths starting
+// with a slash are relative to the API base URL (see APIURL).
+func resolveURL(url string) string {
+	if strings.HasPrefix(url, "/") {
+		return APIURL() + url
+	}
+	return url
+}
+
 func APIGetRequest(url string) (*http.Response, error) {
+	url = resolveURL(url)
 	logrus.Infof("GitHubAPI[GET]: %s", url)
-	client := &http.Client{}
+	client, err := httpclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating http client: %w", err)
+	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, fmt.Errorf("creating http request: %w", err)
 	}
 	req.Header.Set("Accept", "application/vnd.github+json")
-	if os.Getenv("GITHUB_TOKEN") != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("token %s", os.Getenv("GITHUB_TOKEN")))
+	if token := tokenForURL(url); token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
 	} else {
 		logrus.Warn("making unauthenticated request to github")
 	}
@@ -79,15 +137,26 @@ func APIGetRequest(url string) (*http.Response, error) {
 	return res, nil
 }
 
+// Download writes the file at url to f
 func Download(url string, f io.Writer) error {
-	client := &http.Client{}
+	return DownloadWithOptions(url, f, httpclient.DefaultCopyOptions)
+}
+
+// DownloadWithOptions writes the file at url to f, copying the
+// response as set in opts
+func DownloadWithOptions(url string, f io.Writer, opts httpclient.CopyOptions) error {
+	url = resolveURL(url)
+	client, err := httpclient.New()
+	if err != nil {
+		return fmt.Errorf("creating http client: %w", err)
+	}
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("creating http request: %w", err)
 	}
 
-	if os.Getenv("GITHUB_TOKEN") != "" {
-		req.Header.Set("Authorization", fmt.Sprintf("token %s", os.Getenv("GITHUB_TOKEN")))
+	if token := tokenForURL(url); token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("token %s", token))
 	} else {
 		logrus.Warn("making unauthenticated request to github")
 	}
@@ -96,18 +165,12 @@ func Download(url string, f io.Writer) error {
 	if err != nil {
 		return fmt.Errorf("executing http request to GitHub API: %w", err)
 	}
-
-	// Check server response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http error when downloading: %s", resp.Status)
-	}
-
 	defer resp.Body.Close()
 
-	// Writer the body to file
-	numBytes, err := io.Copy(f, resp.Body)
+	// Write the body to file
+	numBytes, err := httpclient.CopyBodyWithOptions(f, resp, opts)
 	if err != nil {
-		return fmt.Errorf("writing http response to disk: %w", err)
+		return fmt.Errorf("downloading %s: %w", url, err)
 	}
 	logrus.Infof("%d MB downloaded from %s", (numBytes / 1024 / 1024), url)
 	return nil
@@ -0,0 +1,274 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+)
+
+func TestAPIURL(t *testing.T) {
+	t.Setenv("GITHUB_API_URL", "")
+	require.Equal(t, DefaultAPIURL, APIURL())
+	require.Equal(t, DefaultAPIURL, APIURLForHost("github.com"))
+	require.Equal(t, "https://github.corp/api/v3", APIURLForHost("github.corp"))
+	require.Equal(t, DefaultAPIURL+"/repos/github/docs", resolveURL("/repos/github/docs"))
+
+	t.Setenv("GITHUB_API_URL", "https://github.corp/api/v3/")
+	require.Equal(t, "https://github.corp/api/v3", APIURL())
+	require.Equal(t, "https://github.corp/api/v3", APIURLForHost(""))
+	require.Equal(t, "https://github.corp/api/v3/repos/github/docs", resolveURL("/repos/github/docs"))
+	require.Equal(t, "https://example.com/file", resolveURL("https://example.com/file"))
+
+	require.Equal(t, DefaultHost, HostForAPIURL(DefaultAPIURL))
+	require.Equal(t, "github.corp", HostForAPIURL("https://github.corp/api/v3/"))
+	require.Equal(t, "acme.ghe.com", HostForAPIURL("https://api.acme.ghe.com"))
+	require.Equal(t, DefaultHost, HostForAPIURL(""))
+}
+
+func TestDownload(t *testing.T) {
+	t.Setenv("GITHUB_TOKEN", "token")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/partial":
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("data")) //nolint: errcheck
+		case "/empty":
+			// Flushing the headers leaves the length of the body unknown
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+		case "/zero":
+			w.Header().Set("Content-Length", "0")
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// Any successful status is accepted
+	var buf bytes.Buffer
+	require.NoError(t, Download(server.URL+"/partial", &buf))
+	require.Equal(t, "data", buf.String())
+
+	// An empty body is not a valid download
+	buf.Reset()
+	err := Download(server.URL+"/empty", &buf)
+	require.ErrorIs(t, err, httpclient.ErrEmptyBody)
+
+	// unless the file is expected to be empty
+	require.NoError(t, DownloadWithOptions(
+		server.URL+"/empty", &buf, httpclient.CopyOptions{AllowEmpty: true},
+	))
+	require.Empty(t, buf.String())
+
+	// or the server says it is with an explicit length
+	require.NoError(t, Download(server.URL+"/zero", &buf))
+	require.Empty(t, buf.String())
+
+	// Error pages are not written as the file contents
+	buf.Reset()
+	require.Error(t, Download(server.URL+"/missing", &buf))
+	require.Empty(t, buf.String())
+}
+
+func TestToken(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hosts.yml"), []byte(`github.com:
+    oauth_token: gho_config
+    user: octocat
+    git_protocol: https
+github.corp:
+    oauth_token: gho_corp
+`), os.FileMode(0o600)))
+	t.Setenv("GH_CONFIG_DIR", dir)
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	defer SetTokenProvider(nil)
+
+	// Each source overrides the ones after it
+	require.Equal(t, "gho_config", Token(""))
+	require.Equal(t, "gho_corp", Token("github.corp"))
+	require.Equal(t, "gho_config", tokenForURL("https://api.github.com/repos/org/repo"))
+	require.Equal(t, "gho_corp", tokenForURL("https://github.corp/api/v3/repos/org/repo"))
+
+	t.Setenv("GH_TOKEN", "gh_env")
+	require.Equal(t, "gh_env", Token(DefaultHost))
+
+	t.Setenv("GITHUB_TOKEN", "github_env")
+	require.Equal(t, "github_env", Token(DefaultHost))
+
+	SetTokenProvider(TokenProviderFunc(func(host string) (string, error) {
+		if host == "github.corp" {
+			return "", errors.New("no installation")
+		}
+		return "explicit", nil
+	}))
+	require.Equal(t, "explicit", Token(DefaultHost))
+
+	// Provider errors fall back to the environment
+	require.Equal(t, "github_env", Token("github.corp"))
+
+	// Without any source there is no token
+	SetTokenProvider(nil)
+	t.Setenv("GITHUB_TOKEN", "")
+	t.Setenv("GH_TOKEN", "")
+	t.Setenv("GH_CONFIG_DIR", t.TempDir())
+	require.Empty(t, Token(DefaultHost))
+}
+
+// fakeOIDCServer is a GitHub Enterprise Server issuing OIDC tokens
+// to the jobs it runs, the claims of the tokens can be changed
+type fakeOIDCServer struct {
+	*httptest.Server
+	key    *rsa.PrivateKey
+	claims map[string]interface{}
+}
+
+func newFakeOIDCServer(t *testing.T) *fakeOIDCServer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	fake := &fakeOIDCServer{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_services/token/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := fake.URL + "/_services/token"
+		json.NewEncoder(w).Encode(map[string]string{ //nolint: errcheck
+			"issuer": issuer, "jwks_uri": issuer + "/.well-known/jwks",
+		})
+	})
+	mux.HandleFunc("/_services/token/.well-known/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(jose.JSONWebKeySet{Keys: []jose.JSONWebKey{ //nolint: errcheck
+			{Key: &key.PublicKey, KeyID: "1", Algorithm: string(jose.RS256), Use: "sig"},
+		}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer request-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		claims := map[string]interface{}{
+			"iss": fake.URL + "/_services/token",
+			"aud": r.URL.Query().Get("audience"),
+			"exp": time.Now().Add(time.Hour).Unix(),
+			"iat": time.Now().Unix(),
+		}
+		maps.Copy(claims, fake.claims)
+		w.Write([]byte(`{"value":"` + fake.sign(t, claims) + `"}`)) //nolint: errcheck
+	})
+	fake.Server = httptest.NewTLSServer(mux)
+	t.Cleanup(fake.Close)
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: fake.Certificate().Raw,
+	}), os.FileMode(0o644)))
+	t.Setenv(httpclient.CABundleEnvVar, bundle)
+	t.Setenv("GITHUB_API_URL", fake.URL+"/api/v3")
+	t.Setenv(OIDCRequestURLEnvVar, fake.URL+"/token?api-version=2.0")
+	t.Setenv(OIDCRequestTokenEnvVar, "request-token")
+	return fake
+}
+
+// sign returns a JWT with the claims signed by the server key
+func (fake *fakeOIDCServer) sign(t *testing.T, claims map[string]interface{}) string {
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: fake.key},
+		(&jose.SignerOptions{}).WithType("JWT").WithHeader("kid", "1"),
+	)
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	object, err := signer.Sign(payload)
+	require.NoError(t, err)
+	token, err := object.CompactSerialize()
+	require.NoError(t, err)
+	return token
+}
+
+func TestOIDCClaims(t *testing.T) {
+	// Outside of a job allowed to request tokens there are no claims
+	t.Setenv(OIDCRequestURLEnvVar, "")
+	claims, err := FetchOIDCClaims()
+	require.NoError(t, err)
+	require.Nil(t, claims)
+
+	fake := newFakeOIDCServer(t)
+	fake.claims = map[string]interface{}{
+		"repository":         "octo-org/app",
+		"ref":                "refs/heads/main",
+		"sha":                "009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d",
+		"workflow_ref":       "octo-org/app/.github/workflows/release.yml@refs/heads/main",
+		"run_id":             "42",
+		"runner_environment": "github-hosted",
+	}
+	claims, err = FetchOIDCClaims()
+	require.NoError(t, err)
+	require.Equal(t, "octo-org/app", claims.Repository)
+	require.Equal(t, "42", claims.RunID)
+	require.Equal(t, "github-hosted", claims.RunnerEnvironment)
+	require.Equal(t, ".github/workflows/release.yml", claims.WorkflowPath())
+
+	t.Setenv(OIDCRequestTokenEnvVar, "wrong")
+	_, err = FetchOIDCClaims()
+	require.Error(t, err)
+	t.Setenv(OIDCRequestTokenEnvVar, "request-token")
+
+	// Tokens from other issuers or for other audiences are rejected
+	for claim, value := range map[string]string{"iss": DefaultOIDCIssuer, "aud": "https://github.com/octo-org"} {
+		fake.claims[claim] = value
+		_, err = FetchOIDCClaims()
+		require.Error(t, err, claim)
+		delete(fake.claims, claim)
+	}
+
+	// and so are tokens not signed by the issuer keys
+	key := fake.key
+	fake.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = FetchOIDCClaims()
+	require.Error(t, err)
+	fake.key = key
+
+	for _, invalid := range []string{"not-a-jwt", "a.!!!.c", "a." + base64.RawURLEncoding.EncodeToString([]byte(`{}`)) + ".c"} {
+		_, err := ParseOIDCToken(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestOIDCIssuer(t *testing.T) {
+	require.Equal(t, DefaultOIDCIssuer, OIDCIssuer(DefaultHost))
+	require.Equal(t, DefaultOIDCIssuer, OIDCIssuer(""))
+	require.Equal(t, "https://github.corp/_services/token", OIDCIssuer("github.corp"))
+}
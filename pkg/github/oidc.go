@@ -0,0 +1,173 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+)
+
+// Environment variables set by GitHub Actions in jobs
+// with the id-token: write permission
+const (
+	OIDCRequestURLEnvVar   = "ACTIONS_ID_TOKEN_REQUEST_URL"
+	OIDCRequestTokenEnvVar = "ACTIONS_ID_TOKEN_REQUEST_TOKEN"
+)
+
+const (
+	// OIDCAudience is the audience of the tokens requested by tejolote,
+	// tokens meant for other services are not accepted
+	OIDCAudience = "sigs.k8s.io/tejolote"
+
+	// DefaultOIDCIssuer is the issuer of the OIDC tokens of github.com
+	DefaultOIDCIssuer = "https://token.actions.githubusercontent.com"
+)
+
+// OIDCClaims are the claims of the OIDC token issued to a GitHub
+// Actions job that describe the run
+type OIDCClaims struct {
+	Issuer            string `json:"iss"`
+	Repository        string `json:"repository"`
+	Ref               string `json:"ref"`
+	SHA               string `json:"sha"`
+	Workflow          string `json:"workflow"`
+	WorkflowRef       string `json:"workflow_ref"`
+	JobWorkflowRef    string `json:"job_workflow_ref"`
+	EventName         string `json:"event_name"`
+	RunID             string `json:"run_id"`
+	RunAttempt        string `json:"run_attempt"`
+	RunnerEnvironment string `json:"runner_environment"`
+}
+
+// OIDCIssuer returns the issuer of the OIDC tokens of the GitHub
+// server at host, GitHub Enterprise Server issues them under
+// /_services/token
+func OIDCIssuer(host string) string {
+	if host == "" || host == DefaultHost {
+		return DefaultOIDCIssuer
+	}
+	return fmt.Sprintf("https://%s/_services/token", host)
+}
+
+// FetchOIDCClaims requests an OIDC token for the running GitHub Actions
+// job and returns its claims. It returns nil when not running in a job
+// allowed to request tokens. The token is requested for OIDCAudience
+// and verified against the keys of the issuer of the GitHub server
+// the API URL points to.
+func FetchOIDCClaims() (*OIDCClaims, error) {
+	requestURL := os.Getenv(OIDCRequestURLEnvVar)
+	if requestURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing OIDC token request URL: %w", err)
+	}
+	query := u.Query()
+	query.Set("audience", OIDCAudience)
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("creating http request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+os.Getenv(OIDCRequestTokenEnvVar))
+	req.Header.Set("Accept", "application/json")
+
+	client, err := httpclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating http client: %w", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("requesting OIDC token: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http error %d requesting OIDC token", res.StatusCode)
+	}
+
+	resp := struct {
+		Value string `json:"value"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("decoding OIDC token response: %w", err)
+	}
+	ctx := oidc.ClientContext(context.Background(), client)
+	return VerifyOIDCToken(ctx, resp.Value, OIDCIssuer(HostForAPIURL(APIURL())))
+}
+
+// VerifyOIDCToken checks the signature, issuer, audience and expiration
+// of a GitHub Actions OIDC token and returns its claims. The keys of the
+// issuer are fetched using its discovery document.
+func VerifyOIDCToken(ctx context.Context, token, issuer string) (*OIDCClaims, error) {
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("fetching OIDC configuration of %s: %w", issuer, err)
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: OIDCAudience}).Verify(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("verifying OIDC token: %w", err)
+	}
+	claims := &OIDCClaims{}
+	if err := idToken.Claims(claims); err != nil {
+		return nil, fmt.Errorf("parsing OIDC token claims: %w", err)
+	}
+	if claims.Repository == "" || claims.RunID == "" {
+		return nil, errors.New("OIDC token has no repository or run claims")
+	}
+	return claims, nil
+}
+
+// ParseOIDCToken decodes the claims of a GitHub Actions OIDC token
+// without verifying its signature
+func ParseOIDCToken(token string) (*OIDCClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("OIDC token is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, fmt.Errorf("decoding OIDC token payload: %w", err)
+	}
+	claims := &OIDCClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, fmt.Errorf("parsing OIDC token claims: %w", err)
+	}
+	if claims.Repository == "" || claims.RunID == "" {
+		return nil, errors.New("OIDC token has no repository or run claims")
+	}
+	return claims, nil
+}
+
+// WorkflowPath returns the path of the workflow file in the
+// workflow_ref claim, eg .github/workflows/build.yml
+func (c *OIDCClaims) WorkflowPath() string {
+	workflowPath, _, _ := strings.Cut(c.WorkflowRef, "@")
+	return strings.TrimPrefix(workflowPath, c.Repository+"/")
+}
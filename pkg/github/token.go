@@ -0,0 +1,140 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package github
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"sigs.k8s.io/yaml"
+)
+
+// TokenProvider returns the token used to authenticate to the API
+// of a GitHub host. Programs embedding tejolote can set one with
+// SetTokenProvider, eg to use GitHub App installation tokens.
+type TokenProvider interface {
+	Token(host string) (string, error)
+}
+
+// TokenProviderFunc adapts a function to the TokenProvider interface
+type TokenProviderFunc func(host string) (string, error)
+
+func (f TokenProviderFunc) Token(host string) (string, error) {
+	return f(host)
+}
+
+var (
+	tokenProviderMtx sync.RWMutex
+	tokenProvider    TokenProvider
+)
+
+// SetTokenProvider sets the provider of the GitHub tokens, it takes
+// precedence over the tokens in the environment. Passing nil goes
+// back to reading them from the environment.
+func SetTokenProvider(p TokenProvider) {
+	tokenProviderMtx.Lock()
+	defer tokenProviderMtx.Unlock()
+	tokenProvider = p
+}
+
+// Token returns the token to authenticate to a GitHub host. It is
+// looked up in order in the token provider, the GITHUB_TOKEN and
+// GH_TOKEN environment variables and the configuration of the gh
+// CLI. An empty string is returned when none has a token.
+func Token(host string) string {
+	if host == "" {
+		host = DefaultHost
+	}
+	tokenProviderMtx.RLock()
+	p := tokenProvider
+	tokenProviderMtx.RUnlock()
+	if p != nil {
+		token, err := p.Token(host)
+		if err != nil {
+			logrus.Warnf("getting token for %s from provider: %v", host, err)
+		} else if token != "" {
+			return token
+		}
+	}
+	for _, v := range []string{"GITHUB_TOKEN", "GH_TOKEN"} {
+		if token := os.Getenv(v); token != "" {
+			return token
+		}
+	}
+	token, err := ghConfigToken(host)
+	if err != nil {
+		logrus.Debugf("reading gh CLI configuration: %v", err)
+	}
+	return token
+}
+
+// tokenForURL returns the token for the host serving an API URL,
+// requests to api.github.com use the token of github.com
+func tokenForURL(apiURL string) string {
+	host := DefaultHost
+	if u, err := url.Parse(apiURL); err == nil && u.Hostname() != "" && u.Hostname() != "api.github.com" {
+		host = u.Hostname()
+	}
+	return Token(host)
+}
+
+// ghConfigDir returns the directory of the gh CLI configuration
+func ghConfigDir() (string, error) {
+	if dir := os.Getenv("GH_CONFIG_DIR"); dir != "" {
+		return dir, nil
+	}
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "gh"), nil
+	}
+	if dir := os.Getenv("AppData"); runtime.GOOS == "windows" && dir != "" {
+		return filepath.Join(dir, "GitHub CLI"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("getting home directory: %w", err)
+	}
+	return filepath.Join(home, ".config", "gh"), nil
+}
+
+// ghConfigToken reads the token of a host from the hosts.yml file
+// of the gh CLI. Recent gh versions keep it in the system keyring
+// instead, those are not read.
+func ghConfigToken(host string) (string, error) {
+	dir, err := ghConfigDir()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "hosts.yml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("reading gh hosts file: %w", err)
+	}
+	hosts := map[string]struct {
+		OAuthToken string `json:"oauth_token"`
+	}{}
+	if err := yaml.Unmarshal(data, &hosts); err != nil {
+		return "", fmt.Errorf("parsing gh hosts file: %w", err)
+	}
+	return hosts[host].OAuthToken, nil
+}
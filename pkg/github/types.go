@@ -34,6 +34,7 @@ type Run struct {
 	Conclusion      string `json:"conclusion"`
 	HeadBranch      string `json:"head_branch"`
 	HeadSHA         string `json:"head_sha"`
+	Event           string `json:"event"`
 	Path            string `json:"path"`
 	RunNumber       int64  `json:"run_number"`
 	WorkFlowID      int64  `json:"workflow_id"`
@@ -42,6 +43,54 @@ type Run struct {
 	LogsURL         string `json:"logs_url"`
 	Actor           Actor  `json:"actor"`
 	TriggeringActor Actor  `json:"triggering_actor"`
+
+	// ReferencedWorkflows lists the reusable workflows called by the run
+	ReferencedWorkflows []ReferencedWorkflow `json:"referenced_workflows"`
+}
+
+// ReferencedWorkflow is a reusable workflow called from a run. Path
+// has the form owner/repo/path/to/workflow.yml@ref
+type ReferencedWorkflow struct {
+	Path string `json:"path"`
+	SHA  string `json:"sha"`
+	Ref  string `json:"ref"`
+}
+
+// RunTiming is the usage data of a workflow run as returned by the
+// timing endpoint of the API
+type RunTiming struct {
+	Billable map[string]struct {
+		TotalMS int64 `json:"total_ms"`
+		Jobs    int   `json:"jobs"`
+	} `json:"billable"`
+	RunDurationMS int64 `json:"run_duration_ms"`
+}
+
+// Jobs is the list of jobs of a workflow run as returned by the API
+type Jobs struct {
+	TotalCount int   `json:"total_count"`
+	Jobs       []Job `json:"jobs"`
+}
+
+// Job is a job of a workflow run
+type Job struct {
+	ID          int64     `json:"id"`
+	Name        string    `json:"name"`
+	Status      string    `json:"status"`
+	Conclusion  string    `json:"conclusion"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
+	Steps       []JobStep `json:"steps"`
+}
+
+// JobStep is a step executed in a job
+type JobStep struct {
+	Name        string    `json:"name"`
+	Number      int       `json:"number"`
+	Status      string    `json:"status"`
+	Conclusion  string    `json:"conclusion"`
+	StartedAt   time.Time `json:"started_at"`
+	CompletedAt time.Time `json:"completed_at"`
 }
 
 type Actor struct {
@@ -50,3 +99,27 @@ type Actor struct {
 	Type  string `json:"type"`
 	URL   string `json:"url"`
 }
+
+// Deployment is a deployment as returned by the GitHub API
+type Deployment struct {
+	ID          int64  `json:"id"`
+	SHA         string `json:"sha"`
+	Ref         string `json:"ref"`
+	Task        string `json:"task"`
+	Environment string `json:"environment"`
+	Description string `json:"description"`
+	Creator     Actor  `json:"creator"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// DeploymentStatus is an entry in the status list of a deployment
+type DeploymentStatus struct {
+	ID             int64  `json:"id"`
+	State          string `json:"state"`
+	Environment    string `json:"environment"`
+	LogURL         string `json:"log_url"`
+	TargetURL      string `json:"target_url"`
+	EnvironmentURL string `json:"environment_url"`
+	CreatedAt      string `json:"created_at"`
+}
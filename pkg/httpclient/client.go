@@ -0,0 +1,84 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// CABundleEnvVar is the environment variable pointing to a PEM file
+// with extra root certificates to trust, eg those of a TLS inspecting
+// proxy. They are added to the system roots, not replacing them.
+const CABundleEnvVar = "TEJOLOTE_CA_BUNDLE"
+
+// Transport returns an HTTP transport that uses the proxies configured
+// in the environment (HTTPS_PROXY, NO_PROXY, etc) and trusts the
+// certificates in the TEJOLOTE_CA_BUNDLE file.
+func Transport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone() //nolint: errcheck
+	transport.Proxy = http.ProxyFromEnvironment
+
+	bundlePath := os.Getenv(CABundleEnvVar)
+	if bundlePath == "" {
+		return transport, nil
+	}
+	pool, err := rootCAs(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+	return transport, nil
+}
+
+// New returns an HTTP client using the transport returned by Transport.
+// Its requests time out and are retried as set in DefaultOptions.
+func New() (*http.Client, error) {
+	transport, err := Transport()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &retryTransport{base: transport, opts: DefaultOptions},
+	}, nil
+}
+
+// rootCAs returns the system root certificates plus
+// those in the PEM file at bundlePath
+func rootCAs(bundlePath string) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		logrus.Warnf("unable to load system root certificates, only trusting %s: %v", bundlePath, err)
+		pool = x509.NewCertPool()
+	}
+	data, err := os.ReadFile(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("reading CA bundle from %s: %w", CABundleEnvVar, err)
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", bundlePath)
+	}
+	return pool, nil
+}
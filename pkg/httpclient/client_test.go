@@ -0,0 +1,71 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer server.Close()
+
+	// Without the bundle, the test server certificate is not trusted
+	t.Setenv(CABundleEnvVar, "")
+	client, err := New()
+	require.NoError(t, err)
+	_, err = client.Get(server.URL)
+	require.Error(t, err)
+
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: server.Certificate().Raw,
+	}), os.FileMode(0o644)))
+	t.Setenv(CABundleEnvVar, bundle)
+
+	transport, err := Transport()
+	require.NoError(t, err)
+	require.NotNil(t, transport.TLSClientConfig)
+	require.NotNil(t, transport.Proxy)
+
+	// The custom CA is added to the root pool
+	pool, err := rootCAs(bundle)
+	require.NoError(t, err)
+	require.True(t, transport.TLSClientConfig.RootCAs.Equal(pool))
+
+	client, err = New()
+	require.NoError(t, err)
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// Bundles without certificates are an error
+	require.NoError(t, os.WriteFile(bundle, []byte("not a certificate"), os.FileMode(0o644)))
+	_, err = New()
+	require.Error(t, err)
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrEmptyBody is returned by CopyBody when the response has no content
+var ErrEmptyBody = errors.New("empty response body")
+
+// CopyOptions control how download responses are copied
+type CopyOptions struct {
+	// AllowEmpty accepts responses without content, for callers
+	// downloading files known to be zero bytes long
+	AllowEmpty bool
+}
+
+// DefaultCopyOptions reject empty responses
+var DefaultCopyOptions = CopyOptions{}
+
+// CopyBody writes the body of a download response to w. Any 2xx status
+// is a success, some mirrors answer with 206 Partial Content. Empty bodies
+// return ErrEmptyBody as they are usually broken mirrors rather than real
+// files, unless the server sent an explicit Content-Length of zero.
+func CopyBody(w io.Writer, resp *http.Response) (int64, error) {
+	return CopyBodyWithOptions(w, resp, DefaultCopyOptions)
+}
+
+// CopyBodyWithOptions is CopyBody with the behavior set in opts
+func CopyBodyWithOptions(w io.Writer, resp *http.Response, opts CopyOptions) (int64, error) {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, fmt.Errorf("http error when downloading: %s", resp.Status)
+	}
+	numBytes, err := io.Copy(w, resp.Body)
+	if err != nil {
+		return numBytes, fmt.Errorf("writing http response: %w", err)
+	}
+	// ContentLength is -1 when the server did not send its length
+	if numBytes == 0 && !opts.AllowEmpty && resp.ContentLength != 0 {
+		return 0, ErrEmptyBody
+	}
+	return numBytes, nil
+}
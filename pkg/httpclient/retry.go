@@ -0,0 +1,159 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Options control the timeouts and retries of the clients returned by New
+type Options struct {
+	// RequestTimeout is the longest a request waits for the server,
+	// either for the response or for more data of its body, so slow
+	// but progressing downloads are not interrupted. Zero disables it.
+	RequestTimeout time.Duration
+
+	// MaxRetries is the number of times GET and HEAD requests failing
+	// with network or server errors (5xx and 429) are retried
+	MaxRetries int
+
+	// RetryBackoff is the wait before the first
+	// retry, it doubles after each one
+	RetryBackoff time.Duration
+}
+
+// DefaultOptions are the options of the clients returned by New
+var DefaultOptions = Options{
+	RequestTimeout: 30 * time.Second,
+	MaxRetries:     3,
+	RetryBackoff:   time.Second,
+}
+
+// ErrRequestTimeout is returned when the server does not
+// answer a request within Options.RequestTimeout
+var ErrRequestTimeout = errors.New("request timed out")
+
+// WithTimeout returns a context cancelled after the request timeout, it
+// bounds the calls to cloud APIs which do not use the clients of New
+func WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if DefaultOptions.RequestTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, DefaultOptions.RequestTimeout)
+}
+
+// retryTransport is a round tripper that cancels requests
+// taking too long and retries those that failed
+type retryTransport struct {
+	base http.RoundTripper
+	opts Options
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := t.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := t.roundTrip(req)
+		if attempt >= t.opts.MaxRetries || !retryable(req, resp, err) {
+			return resp, err
+		}
+		if resp != nil {
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096)) //nolint: errcheck
+			resp.Body.Close()
+			err = errors.New(resp.Status)
+		}
+		logrus.Debugf("Request to %s failed (attempt %d), retrying in %s: %v", req.URL.Redacted(), attempt+1, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		backoff *= 2
+	}
+}
+
+// retryable returns true if a failed request can be sent again
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Context().Err() != nil {
+		return false
+	}
+	if err != nil {
+		// Untrusted certificates will not change by retrying
+		var certErr *tls.CertificateVerificationError
+		return !errors.As(err, &certErr)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+}
+
+// roundTrip sends the request once, cancelling it when the
+// server is silent for longer than the request timeout
+func (t *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	timeout := t.opts.RequestTimeout
+	if timeout <= 0 {
+		return t.base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancelCause(req.Context())
+	timer := time.AfterFunc(timeout, func() { cancel(ErrRequestTimeout) })
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		timer.Stop()
+		if errors.Is(context.Cause(ctx), ErrRequestTimeout) {
+			err = fmt.Errorf("%w after %s: %s", ErrRequestTimeout, timeout, req.URL.Redacted())
+		}
+		cancel(nil)
+		return nil, err
+	}
+	timer.Reset(timeout)
+	resp.Body = &timeoutBody{body: resp.Body, ctx: ctx, cancel: cancel, timer: timer, timeout: timeout}
+	return resp, nil
+}
+
+// timeoutBody restarts the request timeout each time data is read
+type timeoutBody struct {
+	body    io.ReadCloser
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (b *timeoutBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && !errors.Is(err, io.EOF) && errors.Is(context.Cause(b.ctx), ErrRequestTimeout) {
+		return n, fmt.Errorf("%w after %s reading response", ErrRequestTimeout, b.timeout)
+	}
+	return n, err
+}
+
+func (b *timeoutBody) Close() error {
+	b.timer.Stop()
+	b.cancel(nil)
+	return b.body.Close()
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func withOptions(t *testing.T, opts Options) {
+	saved := DefaultOptions
+	DefaultOptions = opts
+	t.Cleanup(func() { DefaultOptions = saved })
+}
+
+func TestRequestTimeout(t *testing.T) {
+	withOptions(t, Options{RequestTimeout: 100 * time.Millisecond})
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/stalled":
+			w.Write([]byte("data")) //nolint: errcheck
+			w.(http.Flusher).Flush()
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/trickle":
+			// Slower overall than the timeout but never silent for that long
+			for i := 0; i < 5; i++ {
+				fmt.Fprint(w, "data")
+				w.(http.Flusher).Flush()
+				time.Sleep(40 * time.Millisecond)
+			}
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := New()
+	require.NoError(t, err)
+
+	// The request is cancelled when the server does not answer
+	start := time.Now()
+	_, err = client.Get(server.URL + "/slow")
+	require.ErrorIs(t, err, ErrRequestTimeout)
+	require.Less(t, time.Since(start), 2*time.Second)
+
+	// or stops sending the body
+	resp, err := client.Get(server.URL + "/stalled")
+	require.NoError(t, err)
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.ErrorIs(t, err, ErrRequestTimeout)
+
+	resp, err = client.Get(server.URL + "/trickle")
+	require.NoError(t, err)
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	require.NoError(t, err)
+	require.Len(t, data, 20)
+}
+
+func TestRetries(t *testing.T) {
+	withOptions(t, Options{MaxRetries: 2, RetryBackoff: time.Millisecond})
+	attempts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts[r.Method+r.URL.Path]++
+		switch {
+		case r.URL.Path == "/flaky" && attempts[r.Method+r.URL.Path] < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			fmt.Fprint(w, "ok")
+		}
+	}))
+	defer server.Close()
+
+	client, err := New()
+	require.NoError(t, err)
+
+	resp, err := client.Get(server.URL + "/flaky")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, 3, attempts["GET/flaky"])
+
+	// The retries are limited, the last response is returned
+	resp, err = client.Get(server.URL + "/broken")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	require.Equal(t, 3, attempts["GET/broken"])
+
+	// Client errors and non idempotent requests are not retried
+	resp, err = client.Get(server.URL + "/missing")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 1, attempts["GET/missing"])
+
+	resp, err = client.Post(server.URL+"/broken", "text/plain", http.NoBody)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, 1, attempts["POST/broken"])
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lockfile
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	purl "github.com/package-url/packageurl-go"
+)
+
+// DigestDirHash is the digest algorithm of the go module hashes,
+// computed with golang.org/x/mod/sumdb/dirhash. Values keep their
+// version prefix (eg h1:).
+const DigestDirHash = "dirHash"
+
+// ParseGoSum returns the modules in a go.sum file. Modules listed
+// only by the hash of their go.mod were not downloaded to build,
+// only their module graph was read, so they are skipped.
+func ParseGoSum(data []byte) ([]common.ProvenanceMaterial, error) {
+	materials := []common.ProvenanceMaterial{}
+	seen := map[string]struct{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid go.sum line %d", line)
+		}
+		module, version, hash := fields[0], fields[1], fields[2]
+		if strings.HasSuffix(version, "/go.mod") {
+			continue
+		}
+		if _, ok := seen[module+"@"+version]; ok {
+			continue
+		}
+		seen[module+"@"+version] = struct{}{}
+
+		namespace, name := path.Split(module)
+		materials = append(materials, common.ProvenanceMaterial{
+			URI: purl.NewPackageURL(
+				purl.TypeGolang, strings.TrimSuffix(namespace, "/"), name, version, nil, "",
+			).ToString(),
+			Digest: common.DigestSet{DigestDirHash: hash},
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading go.sum: %w", err)
+	}
+	sortMaterials(materials)
+	return materials, nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lockfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testGoSum = `github.com/sirupsen/logrus v1.9.3 h1:dueUQJ1C2q9oE3F7wvmSGAaVtTmUizReu6fjN8uqzbQ=
+github.com/sirupsen/logrus v1.9.3/go.mod h1:naHLuLoDiP4jHNo9R0sCBMtWGeIprob74mVsIT4qYEQ=
+golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8/go.mod h1:oPkhp1MJrh7nUepCBck5+mAzfO9JrbApNNgaTdGDITg=
+sigs.k8s.io/yaml v1.4.0 h1:Mk1wCc2gy/F0THH0TAp1QYyJNzRm2KCLy3o5ASXVI5E=
+sigs.k8s.io/yaml v1.4.0/go.mod h1:Ejl7/uTz7PSA4eKMyQCUTnhZYNmLIl+5c2lQPGR2BPY=
+`
+
+func TestParseGoSum(t *testing.T) {
+	materials, err := ParseGoSum([]byte(testGoSum))
+	require.NoError(t, err)
+
+	// Modules with only their go.mod hash are not recorded
+	require.Len(t, materials, 2)
+	require.Equal(t, "pkg:golang/github.com/sirupsen/logrus@v1.9.3", materials[0].URI)
+	require.Equal(t, "h1:dueUQJ1C2q9oE3F7wvmSGAaVtTmUizReu6fjN8uqzbQ=", materials[0].Digest[DigestDirHash])
+	require.Equal(t, "pkg:golang/sigs.k8s.io/yaml@v1.4.0", materials[1].URI)
+
+	_, err = ParseGoSum([]byte("sigs.k8s.io/yaml v1.4.0\n"))
+	require.Error(t, err)
+}
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, GoSum)
+	require.NoError(t, os.WriteFile(path, []byte(testGoSum), os.FileMode(0o644)))
+	materials, err := Read(path)
+	require.NoError(t, err)
+	require.Len(t, materials, 2)
+
+	path = filepath.Join(dir, "Cargo.lock")
+	require.NoError(t, os.WriteFile(path, []byte(""), os.FileMode(0o644)))
+	_, err = Read(path)
+	require.Error(t, err)
+
+	_, err = Read(filepath.Join(dir, "missing", GoSum))
+	require.Error(t, err)
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lockfile
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+)
+
+// Names of the supported lockfiles
+const (
+	GoSum = "go.sum"
+	NPM   = "package-lock.json"
+)
+
+// Types returns the names of the lockfiles that can be read
+func Types() []string {
+	return []string{GoSum, NPM}
+}
+
+// Read parses a lockfile and returns its locked dependencies as
+// materials named with their package URL. The format is detected
+// from the file name.
+func Read(path string) ([]common.ProvenanceMaterial, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading lockfile: %w", err)
+	}
+	switch filepath.Base(path) {
+	case GoSum:
+		return ParseGoSum(data)
+	case NPM:
+		return ParseNPM(data)
+	default:
+		return nil, fmt.Errorf("unsupported lockfile %s (supported: %v)", filepath.Base(path), Types())
+	}
+}
+
+// sortMaterials orders materials by URI to produce deterministic output
+func sortMaterials(materials []common.ProvenanceMaterial) {
+	sort.Slice(materials, func(i, j int) bool {
+		return materials[i].URI < materials[j].URI
+	})
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lockfile
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	purl "github.com/package-url/packageurl-go"
+	"github.com/sirupsen/logrus"
+)
+
+// npmLockfile captures the parts of package-lock.json we care about. Version
+// 1 lockfiles list the dependencies in a tree, while 2 and 3 use a flat list
+// of packages keyed by their path in node_modules.
+type npmLockfile struct {
+	Packages     map[string]npmLockedPackage `json:"packages"`
+	Dependencies map[string]npmLockedPackage `json:"dependencies"`
+}
+
+type npmLockedPackage struct {
+	Version      string                      `json:"version"`
+	Resolved     string                      `json:"resolved"`
+	Integrity    string                      `json:"integrity"`
+	Link         bool                        `json:"link"`
+	Dependencies map[string]npmLockedPackage `json:"dependencies"`
+}
+
+// ParseNPM returns the packages locked in an npm package-lock.json
+func ParseNPM(data []byte) ([]common.ProvenanceMaterial, error) {
+	lockfile := npmLockfile{}
+	if err := json.Unmarshal(data, &lockfile); err != nil {
+		return nil, fmt.Errorf("parsing npm lockfile: %w", err)
+	}
+
+	packages := map[string]npmLockedPackage{}
+	if len(lockfile.Packages) > 0 {
+		for path, pkg := range lockfile.Packages {
+			// The empty key is the root project itself
+			if path == "" || pkg.Link {
+				continue
+			}
+			// Nested packages are keyed as node_modules/a/node_modules/b
+			i := strings.LastIndex(path, "node_modules/")
+			if i == -1 {
+				continue
+			}
+			packages[path[i+len("node_modules/"):]+"@"+pkg.Version] = pkg
+		}
+	} else {
+		flattenNPMDependencies(lockfile.Dependencies, packages)
+	}
+
+	materials := []common.ProvenanceMaterial{}
+	for key, pkg := range packages {
+		name := key[:strings.LastIndex(key, "@")]
+		namespace := ""
+		if strings.HasPrefix(name, "@") {
+			namespace, name, _ = strings.Cut(name, "/")
+		}
+		materials = append(materials, common.ProvenanceMaterial{
+			URI:    purl.NewPackageURL(purl.TypeNPM, namespace, name, pkg.Version, nil, "").ToString(),
+			Digest: integrityToDigest(pkg.Integrity),
+		})
+	}
+	sortMaterials(materials)
+	return materials, nil
+}
+
+// flattenNPMDependencies walks the dependency tree of a v1 lockfile
+func flattenNPMDependencies(deps map[string]npmLockedPackage, packages map[string]npmLockedPackage) {
+	for name, pkg := range deps {
+		packages[name+"@"+pkg.Version] = npmLockedPackage{
+			Version: pkg.Version, Resolved: pkg.Resolved, Integrity: pkg.Integrity,
+		}
+		flattenNPMDependencies(pkg.Dependencies, packages)
+	}
+}
+
+// integrityToDigest converts a subresource integrity string
+// (eg sha512-base64data) to a digest set
+func integrityToDigest(integrity string) common.DigestSet {
+	digest := common.DigestSet{}
+	// The integrity field may contain more than one hash
+	for _, sri := range strings.Fields(integrity) {
+		algo, value, ok := strings.Cut(sri, "-")
+		if !ok {
+			continue
+		}
+		rawHash, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			logrus.Warnf("unable to decode integrity value %s", sri)
+			continue
+		}
+		digest[algo] = hex.EncodeToString(rawHash)
+	}
+	return digest
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lockfile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testNPMLockfileV3 = `{
+  "name": "test",
+  "lockfileVersion": 3,
+  "packages": {
+    "": {"name": "test"},
+    "node_modules/left-pad": {
+      "version": "1.3.0",
+      "resolved": "https://registry.npmjs.org/left-pad/-/left-pad-1.3.0.tgz",
+      "integrity": "sha512-XRcglhh3p2lHAu4gFg75i5owZ3/uttIZh11iKj+W2fqc4Iu8OvwIHkDSftaw4gURo0WA2fT2oguwTa4HChLwJw=="
+    },
+    "node_modules/@scope/util": {"version": "2.0.0"},
+    "node_modules/@scope/util/node_modules/left-pad": {"version": "1.2.0"},
+    "node_modules/local": {"resolved": "packages/local", "link": true}
+  }
+}`
+
+const testNPMLockfileV1 = `{
+  "name": "test",
+  "lockfileVersion": 1,
+  "dependencies": {
+    "left-pad": {"version": "1.3.0"},
+    "@scope/util": {
+      "version": "2.0.0",
+      "dependencies": {"left-pad": {"version": "1.2.0"}}
+    }
+  }
+}`
+
+func TestParseNPM(t *testing.T) {
+	for name, data := range map[string]string{"v1": testNPMLockfileV1, "v3": testNPMLockfileV3} {
+		materials, err := ParseNPM([]byte(data))
+		require.NoError(t, err, name)
+		require.Len(t, materials, 3, name)
+		require.Equal(t, "pkg:npm/%40scope/util@2.0.0", materials[0].URI, name)
+		require.Equal(t, "pkg:npm/left-pad@1.2.0", materials[1].URI, name)
+		require.Equal(t, "pkg:npm/left-pad@1.3.0", materials[2].URI, name)
+	}
+
+	materials, err := ParseNPM([]byte(testNPMLockfileV3))
+	require.NoError(t, err)
+	require.Equal(t,
+		"5d1720961877a7694702ee20160ef98b9a30677feeb6d219875d622a3f96d9fa9ce08bbc3afc081e40d27ed6b0e20511a34580d9f4f6a20bb04dae070a12f027",
+		materials[2].Digest["sha512"],
+	)
+
+	_, err = ParseNPM([]byte("not json"))
+	require.Error(t, err)
+}
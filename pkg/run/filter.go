@@ -0,0 +1,81 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package run
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// PathFilter selects artifacts by their path using glob patterns (see
+// path.Match). Patterns without a slash are matched against the base
+// name of the path, the rest against the whole path. Exclude patterns
+// take precedence: a path matching both lists is left out.
+type PathFilter struct {
+	// Include patterns, when set only matching paths are kept
+	Include []string
+
+	// Exclude patterns, matching paths are left out
+	Exclude []string
+}
+
+// Validate checks the syntax of the filter patterns
+func (f *PathFilter) Validate() error {
+	for _, p := range append(append([]string{}, f.Include...), f.Exclude...) {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Matches returns true if the path passes the filter
+func (f *PathFilter) Matches(p string) bool {
+	if matchesAny(f.Exclude, p) {
+		return false
+	}
+	return len(f.Include) == 0 || matchesAny(f.Include, p)
+}
+
+// Filter returns the artifacts whose path passes the filter
+func (f *PathFilter) Filter(artifacts []Artifact) []Artifact {
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return artifacts
+	}
+	filtered := []Artifact{}
+	for _, a := range artifacts {
+		if f.Matches(a.Path) {
+			filtered = append(filtered, a)
+		}
+	}
+	return filtered
+}
+
+// matchesAny returns true if the path matches any of the patterns
+func matchesAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		target := p
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(p)
+		}
+		if ok, err := path.Match(pattern, target); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package run
 
 import (
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -29,24 +31,193 @@ type Run struct {
 	Artifacts  []Artifact
 	StartTime  time.Time
 	EndTime    time.Time
+	Resources  *ResourceUsage
 	SystemData interface{}
+
+	// RemovedArtifacts are the artifacts deleted during the
+	// run, when the watcher is set to record them
+	RemovedArtifacts []Artifact
+}
+
+// ResourceUsage records the compute resources consumed by a run
+// as reported by the build system
+type ResourceUsage struct {
+	MachineType string              `json:"machineType,omitempty"`
+	DurationMS  int64               `json:"durationMs,omitempty"`
+	Timing      map[string]TimeSpan `json:"timing,omitempty"`
+	BillableMS  map[string]int64    `json:"billableMs,omitempty"`
+}
+
+// TimeSpan is a start/end pair of timestamps
+type TimeSpan struct {
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime"`
 }
 
 // Step is the interface that defines the behaviour of a build step
 // the exec runner can execute
 type Step struct {
+	Name        string // Name of the step, when the build system has one
 	Command     string // Command run
 	Image       string // Container image used for the step
+	Directory   string // Working directory of the step
 	IsSuccess   bool
+	Status      string // Execution status (StepStatusRan, StepStatusSkipped...)
 	Params      []string
 	StartTime   time.Time // Start time of the step
 	EndTime     time.Time
 	Environment map[string]string
 }
 
+// Step execution status values. A step definition in the build
+// configuration may not run, these record what actually happened.
+// The status is empty when the build system does not report it or
+// the step has not finished yet.
+const (
+	StepStatusRan     = "ran"
+	StepStatusSkipped = "skipped"
+	StepStatusFailed  = "failed"
+)
+
 // Artifact abstracts a file with the items we're interested in monitoring
 type Artifact struct {
-	Path     string
-	Checksum map[string]string
-	Time     time.Time
+	Path        string
+	Checksum    map[string]string
+	Time        time.Time
+	Annotations map[string]string
+}
+
+const (
+	// AnnotationGroup links the compression variants of an artifact
+	AnnotationGroup = "tejolote.group"
+	// AnnotationCompression records the compression of an artifact
+	AnnotationCompression = "tejolote.compression"
+	// AnnotationArtifactType records the OCI artifact type of an artifact
+	AnnotationArtifactType = "tejolote.artifactType"
+	// AnnotationSourceArchive marks the source archives generated by
+	// GitHub for a release, its value is the archive format
+	AnnotationSourceArchive = "tejolote.sourceArchive"
+	// AnnotationSourceURI is the VCS locator of the source in an archive
+	AnnotationSourceURI = "tejolote.sourceURI"
+	// AnnotationSourceCommit is the commit of the source in an archive
+	AnnotationSourceCommit = "tejolote.sourceCommit"
+	// AnnotationCodeSigner is the certificate subject of the signer of
+	// a Windows (Authenticode) or macOS (codesign) executable
+	AnnotationCodeSigner = "tejolote.codeSigner"
+	// AnnotationPointer is the location of the pointer file (eg
+	// latest.txt) that was resolved to the artifact
+	AnnotationPointer = "tejolote.pointer"
+	// AnnotationLFSSize is the size of a Git LFS object as
+	// declared in its pointer file
+	AnnotationLFSSize = "tejolote.lfsSize"
+	// AnnotationPackageName, AnnotationPackageVersion and
+	// AnnotationPackageArch record the control metadata of
+	// Debian and RPM packages
+	AnnotationPackageName    = "tejolote.package.name"
+	AnnotationPackageVersion = "tejolote.package.version"
+	AnnotationPackageArch    = "tejolote.package.arch"
+	// AnnotationSBOM and AnnotationSBOMDigest point an artifact to
+	// the SBOM that describes it
+	AnnotationSBOM       = "tejolote.sbom"
+	AnnotationSBOMDigest = "tejolote.sbomDigest"
+	// AnnotationSBOMFor marks an artifact as the SBOM of another one
+	AnnotationSBOMFor = "tejolote.sbomFor"
+	// AnnotationExternalRefPrefix prefixes the external references of
+	// a package read from an SBOM. The key ends with the reference
+	// category and type (eg tejolote.externalRef.package-manager.purl)
+	// and the value lists the locators separated by spaces.
+	AnnotationExternalRefPrefix = "tejolote.externalRef."
+)
+
+// compressionExtensions maps file extensions to compression formats
+var compressionExtensions = map[string]string{
+	".gz":  "gzip",
+	".zst": "zstd",
+	".xz":  "xz",
+	".bz2": "bzip2",
+	".br":  "brotli",
+	".lz4": "lz4",
+}
+
+// GroupCompressionVariants finds artifacts that are compressed forms of
+// the same file (eg x, x.gz and x.zst) and annotates each of them with
+// the name of their group and their compression format. Artifacts
+// without variants are left untouched.
+func GroupCompressionVariants(artifacts []Artifact) {
+	groups := map[string][]int{}
+	for i := range artifacts {
+		base := strings.TrimSuffix(artifacts[i].Path, filepath.Ext(artifacts[i].Path))
+		if _, ok := compressionExtensions[filepath.Ext(artifacts[i].Path)]; !ok {
+			base = artifacts[i].Path
+		}
+		groups[base] = append(groups[base], i)
+	}
+
+	for base, members := range groups {
+		if len(members) < 2 {
+			continue
+		}
+		for _, i := range members {
+			compression := "none"
+			if format, ok := compressionExtensions[filepath.Ext(artifacts[i].Path)]; ok {
+				compression = format
+			}
+			if artifacts[i].Annotations == nil {
+				artifacts[i].Annotations = map[string]string{}
+			}
+			artifacts[i].Annotations[AnnotationGroup] = base
+			artifacts[i].Annotations[AnnotationCompression] = compression
+		}
+	}
+}
+
+// sbomExtensions are the suffixes appended to the name of an
+// artifact to name its SBOM (eg app.tar.gz.spdx.json)
+var sbomExtensions = []string{
+	".spdx", ".spdx.json", ".spdx.yaml",
+	".cdx.json", ".cdx.xml", ".bom.json",
+	".sbom", ".sbom.json",
+}
+
+// LinkSBOMs pairs artifacts with the SBOMs that describe them. SBOMs
+// are found by their name (the artifact path plus an SBOM extension)
+// or listed in mapping (artifact path to SBOM path), which takes
+// precedence. The artifact is annotated with the path and digest of
+// its SBOM, and the SBOM with the path of the artifact it describes.
+func LinkSBOMs(artifacts []Artifact, mapping map[string]string) {
+	index := map[string]int{}
+	for i := range artifacts {
+		index[artifacts[i].Path] = i
+	}
+
+	for i := range artifacts {
+		sbomPath, ok := mapping[artifacts[i].Path]
+		if !ok {
+			for _, ext := range sbomExtensions {
+				if _, found := index[artifacts[i].Path+ext]; found {
+					sbomPath, ok = artifacts[i].Path+ext, true
+					break
+				}
+			}
+		}
+		if !ok {
+			continue
+		}
+		j, found := index[sbomPath]
+		if !found || j == i {
+			continue
+		}
+
+		if artifacts[i].Annotations == nil {
+			artifacts[i].Annotations = map[string]string{}
+		}
+		artifacts[i].Annotations[AnnotationSBOM] = sbomPath
+		if sha, ok := artifacts[j].Checksum["SHA256"]; ok {
+			artifacts[i].Annotations[AnnotationSBOMDigest] = "sha256:" + sha
+		}
+		if artifacts[j].Annotations == nil {
+			artifacts[j].Annotations = map[string]string{}
+		}
+		artifacts[j].Annotations[AnnotationSBOMFor] = artifacts[i].Path
+	}
 }
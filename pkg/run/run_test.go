@@ -0,0 +1,105 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package run
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupCompressionVariants(t *testing.T) {
+	artifacts := []Artifact{
+		{Path: "bin/x"},
+		{Path: "bin/x.gz"},
+		{Path: "bin/x.zst"},
+		{Path: "bin/y.gz"},
+		{Path: "README.md"},
+	}
+	GroupCompressionVariants(artifacts)
+
+	for i, compression := range []string{"none", "gzip", "zstd"} {
+		require.Equal(t, map[string]string{
+			AnnotationGroup:       "bin/x",
+			AnnotationCompression: compression,
+		}, artifacts[i].Annotations)
+	}
+
+	// Artifacts without variants are not annotated
+	require.Nil(t, artifacts[3].Annotations)
+	require.Nil(t, artifacts[4].Annotations)
+}
+
+func TestLinkSBOMs(t *testing.T) {
+	artifacts := []Artifact{
+		{Path: "app.tar.gz", Checksum: map[string]string{"SHA256": "aaa"}},
+		{Path: "app.tar.gz.spdx.json", Checksum: map[string]string{"SHA256": "bbb"}},
+		{Path: "tool"},
+		{Path: "sboms/tool.cdx.json", Checksum: map[string]string{"SHA256": "ccc"}},
+		{Path: "README.md"},
+	}
+	LinkSBOMs(artifacts, map[string]string{"tool": "sboms/tool.cdx.json", "README.md": "missing.spdx"})
+
+	require.Equal(t, map[string]string{
+		AnnotationSBOM:       "app.tar.gz.spdx.json",
+		AnnotationSBOMDigest: "sha256:bbb",
+	}, artifacts[0].Annotations)
+	require.Equal(t, map[string]string{AnnotationSBOMFor: "app.tar.gz"}, artifacts[1].Annotations)
+
+	// Explicit mappings link SBOMs with any name
+	require.Equal(t, "sboms/tool.cdx.json", artifacts[2].Annotations[AnnotationSBOM])
+	require.Equal(t, "tool", artifacts[3].Annotations[AnnotationSBOMFor])
+
+	// SBOMs that were not collected are not linked
+	require.Nil(t, artifacts[4].Annotations)
+}
+
+func TestPathFilter(t *testing.T) {
+	artifacts := []Artifact{
+		{Path: "bin/app"},
+		{Path: "bin/app.log"},
+		{Path: "docs/build.log"},
+		{Path: "tmp/cache.tmp"},
+		{Path: "README.md"},
+	}
+	paths := func(artifacts []Artifact) []string {
+		res := []string{}
+		for _, a := range artifacts {
+			res = append(res, a.Path)
+		}
+		return res
+	}
+
+	for _, tc := range []struct {
+		name     string
+		filter   PathFilter
+		expected []string
+	}{
+		{"empty", PathFilter{}, []string{"bin/app", "bin/app.log", "docs/build.log", "tmp/cache.tmp", "README.md"}},
+		{"exclude", PathFilter{Exclude: []string{"*.log", "*.tmp"}}, []string{"bin/app", "README.md"}},
+		{"include", PathFilter{Include: []string{"bin/*"}}, []string{"bin/app", "bin/app.log"}},
+		{"exclude wins", PathFilter{Include: []string{"bin/*"}, Exclude: []string{"*.log"}}, []string{"bin/app"}},
+		{"same pattern", PathFilter{Include: []string{"*.md"}, Exclude: []string{"*.md"}}, []string{}},
+	} {
+		require.Equal(t, tc.expected, paths(tc.filter.Filter(artifacts)), tc.name)
+	}
+
+	f := PathFilter{Include: []string{"["}}
+	require.Error(t, f.Validate())
+	f = PathFilter{Include: []string{"bin/*"}, Exclude: []string{"*.log"}}
+	require.NoError(t, f.Validate())
+}
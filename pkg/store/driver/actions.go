@@ -22,25 +22,25 @@ import (
 	"fmt"
 	"io"
 	"net/url"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
 
-	"sigs.k8s.io/release-utils/hash"
-
 	"sigs.k8s.io/tejolote/pkg/github"
+	"sigs.k8s.io/tejolote/pkg/httpclient"
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
 )
 
-const actionsArtifactsURL = "https://api.github.com/repos/%s/%s/actions/runs/%d/artifacts"
+const actionsArtifactsURL = "%s/repos/%s/%s/actions/runs/%d/artifacts"
 
 // const actionsArtifactsURL =    "https://api.github.com/repos/%s/%s/actions/artifacts/%d"
 
 type Actions struct {
+	// Host is the GitHub server running the workflow, when
+	// empty it defaults to github.com
+	Host         string
 	Organization string
 	Repository   string
 	RunID        int
@@ -56,27 +56,42 @@ func NewActions(specURL string) (*Actions, error) {
 	if u.Scheme != "actions" {
 		return nil, errors.New("spec url is not an actions run")
 	}
-	repo, runids, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "/")
-	runid, err := strconv.Atoi(runids)
+
+	// GitHub Enterprise Server runs are specified with
+	// the hostname: actions://github.example.com/org/repo/runid
+	host, org := github.DefaultHost, u.Hostname()
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) == 3 {
+		host, org, parts = u.Hostname(), parts[0], parts[1:]
+	}
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("unable to parse repository and run from %s", u.Path)
+	}
+	runid, err := strconv.Atoi(parts[1])
 	if err != nil {
 		return nil, fmt.Errorf("unable to read runid from %s", u.Path)
 	}
 
 	a := &Actions{
-		Organization: u.Hostname(),
-		Repository:   repo,
+		Host:         host,
+		Organization: org,
+		Repository:   parts[0],
 		RunID:        runid,
 	}
 	return a, nil
 }
 
-// readArtifacts gets the artiofacts from the run
-func (a *Actions) readArtifacts() ([]run.Artifact, error) {
-	runURL := fmt.Sprintf(
+// artifactsURL returns the API URL to list the run artifacts
+func (a *Actions) artifactsURL() string {
+	return fmt.Sprintf(
 		actionsArtifactsURL,
-		a.Organization, a.Repository, a.RunID,
+		github.APIURLForHost(a.Host), a.Organization, a.Repository, a.RunID,
 	)
+}
 
+// readArtifacts gets the artiofacts from the run
+func (a *Actions) readArtifacts() ([]run.Artifact, error) {
+	runURL := a.artifactsURL()
 	res, err := github.APIGetRequest(runURL)
 	if err != nil {
 		return nil, fmt.Errorf("querying GitHub api for artifacts: %w", err)
@@ -97,35 +112,23 @@ func (a *Actions) readArtifacts() ([]run.Artifact, error) {
 		return nil, fmt.Errorf("unmarshalling GitHub response: %w", err)
 	}
 
-	// Now we need to download the artifacts to hash them
-	tmpdir, err := os.MkdirTemp("", "artifacts-")
-	if err != nil {
-		return nil, fmt.Errorf("creating temp dir: %w", err)
-	}
-
+	// Now we need to download the artifacts to hash them. The
+	// downloads are hashed as they stream, nothing is written to disk.
 	ret := []run.Artifact{}
 
 	for _, a := range artifacts.Artifacts {
-		f, err := os.Create(filepath.Join(tmpdir, a.Name))
+		checksums, err := hashDownload(func(w io.Writer) error {
+			return github.DownloadWithOptions(a.URL, w, httpclient.CopyOptions{AllowEmpty: a.Size == 0})
+		}, []string{"SHA256"})
 		if err != nil {
-			return nil, fmt.Errorf("creating artifact file: %w", err)
-		}
-		defer f.Close()
-		if err := github.Download(a.URL, f); err != nil {
 			return nil, fmt.Errorf(
 				"downloading artifact from %s: %w", a.URL, err,
 			)
 		}
-		shaVal, err := hash.SHA256ForFile(f.Name())
-		if err != nil {
-			return nil, fmt.Errorf("hashing file: %w", err)
-		}
 		ret = append(ret, run.Artifact{
-			Path: runURL + "/" + a.Name,
-			Checksum: map[string]string{
-				"SHA256": shaVal,
-			},
-			Time: a.UpdatedAt,
+			Path:     runURL + "/" + a.Name,
+			Checksum: checksums,
+			Time:     a.UpdatedAt,
 		})
 	}
 	logrus.Infof("%d artifacts collected from run %d", len(ret), a.RunID)
@@ -17,9 +17,17 @@ limitations under the License.
 package driver
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/github"
 )
 
 func TestActions(t *testing.T) {
@@ -31,3 +39,47 @@ func TestActions(t *testing.T) {
 	require.NoError(t, err)
 	require.Nil(t, snap)
 }
+
+func TestActionsEnterprise(t *testing.T) {
+	t.Setenv("GITHUB_API_URL", "")
+	a, err := NewActions("actions://puerco/tejolote-test/2969514606")
+	require.NoError(t, err)
+	require.Equal(t, "https://api.github.com/repos/puerco/tejolote-test/actions/runs/2969514606/artifacts", a.artifactsURL())
+
+	a, err = NewActions("actions://github.corp/puerco/tejolote-test/2969514606")
+	require.NoError(t, err)
+	require.Equal(t, "puerco", a.Organization)
+	require.Equal(t, "https://github.corp/api/v3/repos/puerco/tejolote-test/actions/runs/2969514606/artifacts", a.artifactsURL())
+}
+
+func TestActionsReadArtifacts(t *testing.T) {
+	zipData := []byte("PK fake artifact archive")
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/puerco/tejolote-test/actions/runs/1/artifacts":
+			fmt.Fprintf(w, `{"artifacts":[{"name":"binaries","archive_download_url":"%s/zip/binaries"}]}`, server.URL)
+		case "/zip/binaries":
+			w.Write(zipData) //nolint: errcheck
+		case "/zip/missing":
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	t.Setenv("GITHUB_API_URL", server.URL)
+	t.Setenv("GITHUB_TOKEN", "")
+
+	a, err := NewActions("actions://puerco/tejolote-test/1")
+	require.NoError(t, err)
+	artifacts, err := a.readArtifacts()
+	require.NoError(t, err)
+	require.Len(t, artifacts, 1)
+	sum := sha256.Sum256(zipData)
+	require.Equal(t, hex.EncodeToString(sum[:]), artifacts[0].Checksum["SHA256"])
+
+	// Download errors are returned, not hashed
+	_, err = hashDownload(func(w io.Writer) error {
+		return github.Download(server.URL+"/zip/missing", w)
+	}, []string{"SHA256"})
+	require.Error(t, err)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/sirupsen/logrus"
+	"github.com/ulikunitz/xz"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const (
+	ArchiveTar = "tar"
+	ArchiveZip = "zip"
+)
+
+// Magic numbers of the supported tarball compression formats
+var (
+	gzipMagic  = []byte{0x1f, 0x8b}
+	bzip2Magic = []byte("BZh")
+	xzMagic    = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// Archive is a store that reads the files inside a local tarball
+// or zip archive without extracting it to disk
+type Archive struct {
+	Path   string
+	Format string
+}
+
+func NewArchive(specURL string) (*Archive, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing archive spec url: %w", err)
+	}
+	var format string
+	switch u.Scheme {
+	case "tar+file":
+		format = ArchiveTar
+	case "zip+file":
+		format = ArchiveZip
+	default:
+		return nil, fmt.Errorf("spec URL %s is not an archive url", specURL)
+	}
+	if u.Path == "" {
+		return nil, errors.New("archive spec url has no path")
+	}
+
+	logrus.WithField("store", specURL).Infof("Initialized new %s archive storage backend", format)
+
+	return &Archive{
+		Path:   u.Path,
+		Format: format,
+	}, nil
+}
+
+// Snap hashes each regular file in the archive. Artifacts are named
+// after their path in the archive, prefixed by the archive name.
+// Directories and links are skipped.
+func (a *Archive) Snap() (*snapshot.Snapshot, error) {
+	switch a.Format {
+	case ArchiveTar:
+		return a.snapTar()
+	case ArchiveZip:
+		return a.snapZip()
+	default:
+		return nil, fmt.Errorf("unsupported archive format %s", a.Format)
+	}
+}
+
+// artifactPath returns the path of an entry prefixed by the archive name
+func (a *Archive) artifactPath(name string) string {
+	return path.Join(filepath.Base(a.Path), path.Clean("/"+name))
+}
+
+func (a *Archive) snapTar() (*snapshot.Snapshot, error) {
+	f, err := os.Open(a.Path)
+	if err != nil {
+		return nil, fmt.Errorf("opening tarball: %w", err)
+	}
+	defer f.Close()
+
+	r, err := decompressReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("decompressing tarball: %w", err)
+	}
+
+	snap := snapshot.Snapshot{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tarball: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		checksums, err := hashReader(tr, []string{"SHA256"})
+		if err != nil {
+			return nil, fmt.Errorf("hashing %s: %w", hdr.Name, err)
+		}
+		p := a.artifactPath(hdr.Name)
+		snap[p] = run.Artifact{
+			Path:     p,
+			Checksum: checksums,
+			Time:     hdr.ModTime,
+		}
+	}
+	return &snap, nil
+}
+
+func (a *Archive) snapZip() (*snapshot.Snapshot, error) {
+	zr, err := zip.OpenReader(a.Path)
+	if err != nil {
+		return nil, fmt.Errorf("opening zip archive: %w", err)
+	}
+	defer zr.Close()
+
+	snap := snapshot.Snapshot{}
+	for _, zf := range zr.File {
+		if !zf.FileInfo().Mode().IsRegular() {
+			continue
+		}
+		checksums, err := hashZipFile(zf)
+		if err != nil {
+			return nil, fmt.Errorf("hashing %s: %w", zf.Name, err)
+		}
+		p := a.artifactPath(zf.Name)
+		snap[p] = run.Artifact{
+			Path:     p,
+			Checksum: checksums,
+			Time:     zf.Modified,
+		}
+	}
+	return &snap, nil
+}
+
+func hashZipFile(zf *zip.File) (map[string]string, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer rc.Close()
+	return hashReader(rc, []string{"SHA256"})
+}
+
+// decompressReader detects the compression of a tarball from
+// its magic number and returns a reader of the plain data
+func decompressReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(xzMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading archive header: %w", err)
+	}
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(header, bzip2Magic):
+		return bzip2.NewReader(br), nil
+	case bytes.HasPrefix(header, xzMagic):
+		return xz.NewReader(br)
+	default:
+		return br, nil
+	}
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/ulikunitz/xz"
+)
+
+// writeTestTarball writes a tarball with a file, a directory and a symlink
+func writeTestTarball(t *testing.T, w io.Writer) {
+	tw := tar.NewWriter(w)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0o755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "bin/app", Typeflag: tar.TypeReg, Mode: 0o755, Size: 4}))
+	_, err := tw.Write([]byte("test"))
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "bin/latest", Typeflag: tar.TypeSymlink, Linkname: "app"}))
+	require.NoError(t, tw.Close())
+}
+
+func TestArchiveTar(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for name, compress := range map[string]func(io.Writer) (io.WriteCloser, error){
+		"release.tar": func(w io.Writer) (io.WriteCloser, error) { return nopWriteCloser{w}, nil },
+		"release.tar.gz": func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriter(w), nil
+		},
+		"release.tar.xz": func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+	} {
+		f, err := os.Create(filepath.Join(dir, name))
+		require.NoError(t, err)
+		cw, err := compress(f)
+		require.NoError(t, err)
+		writeTestTarball(t, cw)
+		require.NoError(t, cw.Close())
+		require.NoError(t, f.Close())
+
+		sut, err := NewArchive("tar+file://" + filepath.Join(dir, name))
+		require.NoError(t, err)
+		snap, err := sut.Snap()
+		require.NoError(t, err, name)
+		require.Len(t, *snap, 1, name)
+		require.Contains(t, *snap, name+"/bin/app")
+		require.Equal(t,
+			"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+			(*snap)[name+"/bin/app"].Checksum["SHA256"],
+		)
+	}
+}
+
+func TestArchiveZip(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "release.zip")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	zw := zip.NewWriter(f)
+	_, err = zw.Create("docs/")
+	require.NoError(t, err)
+	w, err := zw.Create("docs/README.md")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("test"))
+	require.NoError(t, err)
+	hdr := &zip.FileHeader{Name: "docs/latest"}
+	hdr.SetMode(os.ModeSymlink | 0o777)
+	w, err = zw.CreateHeader(hdr)
+	require.NoError(t, err)
+	_, err = w.Write([]byte("README.md"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	require.NoError(t, f.Close())
+
+	sut, err := NewArchive("zip+file://" + path)
+	require.NoError(t, err)
+	snap, err := sut.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 1)
+	require.Equal(t,
+		"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		(*snap)["release.zip/docs/README.md"].Checksum["SHA256"],
+	)
+
+	_, err = NewArchive("file://" + path)
+	require.Error(t, err)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
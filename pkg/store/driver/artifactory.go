@@ -0,0 +1,207 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const (
+	// artifactoryStoragePath is the storage API endpoint, listing
+	// a folder returns the checksums of all files under it
+	artifactoryStoragePath = "/api/storage/"
+
+	// Environment variables holding the Artifactory credentials
+	artifactoryTokenEnvVar  = "ARTIFACTORY_ACCESS_TOKEN"
+	artifactoryAPIKeyEnvVar = "ARTIFACTORY_API_KEY"
+)
+
+// Artifactory is a store driver that reads artifacts from a
+// repository in a JFrog Artifactory instance
+type Artifactory struct {
+	Host       string
+	Repository string
+	Path       string
+	Options    ArtifactoryOptions
+	apiURL     string
+}
+
+type ArtifactoryOptions struct {
+	// Digests controls which of the checksums reported by
+	// Artifactory are trusted without downloading the file. It is
+	// set with the trusted-digests and algorithms query parameters.
+	Digests DigestOptions
+}
+
+var DefaultArtifactoryOptions = ArtifactoryOptions{
+	Digests: DigestOptions{
+		TrustedAlgorithms: DefaultDigestOptions.TrustedAlgorithms,
+		Algorithms:        []string{"SHA1", "SHA256"},
+	},
+}
+
+// artifactoryFileList is the response of the storage API when
+// listing a folder with the list and deep parameters
+type artifactoryFileList struct {
+	URI   string            `json:"uri"`
+	Files []artifactoryFile `json:"files"`
+}
+
+type artifactoryFile struct {
+	URI          string    `json:"uri"`
+	LastModified time.Time `json:"lastModified"`
+	Folder       bool      `json:"folder"`
+	SHA1         string    `json:"sha1"`
+	SHA2         string    `json:"sha2"`
+}
+
+func NewArtifactory(specURL string) (*Artifactory, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing artifactory spec url: %w", err)
+	}
+	if u.Scheme != "artifactory" {
+		return nil, errors.New("spec url is not an artifactory url")
+	}
+
+	repo, repoPath, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if repo == "" {
+		return nil, fmt.Errorf("unable to find artifactory repository in %s", specURL)
+	}
+
+	opts := DefaultArtifactoryOptions
+	opts.Digests, err = digestOptionsFromQuery(u.Query(), opts.Digests)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new Artifactory storage backend")
+	return &Artifactory{
+		Host:       u.Host,
+		Repository: repo,
+		Path:       repoPath,
+		Options:    opts,
+		apiURL:     "https://" + u.Host + "/artifactory",
+	}, nil
+}
+
+// Snap lists the files in the repository under the path. Checksums are
+// taken from the file list, files without a checksum in one of the
+// trusted algorithms are downloaded and hashed.
+func (a *Artifactory) Snap() (*snapshot.Snapshot, error) {
+	list, err := a.list()
+	if err != nil {
+		return nil, fmt.Errorf("listing artifactory files: %w", err)
+	}
+
+	snap := snapshot.Snapshot{}
+	for _, file := range list.Files {
+		if file.Folder {
+			continue
+		}
+		filePath := strings.TrimPrefix(path.Join(a.Path, file.URI), "/")
+
+		checksums, trusted := a.Options.Digests.reportedDigests(map[string]string{
+			"SHA1":   file.SHA1,
+			"SHA256": file.SHA2,
+		})
+		if !trusted {
+			logrus.Debugf("artifactory file %s has no trusted checksums, downloading", filePath)
+			checksums, err = a.hashFile(filePath)
+			if err != nil {
+				return nil, fmt.Errorf("hashing %s: %w", filePath, err)
+			}
+		}
+
+		snap[filePath] = run.Artifact{
+			Path:     filePath,
+			Checksum: checksums,
+			Time:     file.LastModified,
+		}
+	}
+	return &snap, nil
+}
+
+// list fetches the recursive list of files under the path
+func (a *Artifactory) list() (*artifactoryFileList, error) {
+	listURL := a.apiURL + artifactoryStoragePath + path.Join(a.Repository, a.Path) +
+		"?list&deep=1&listFolders=0"
+	body, err := a.get(listURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	list := &artifactoryFileList{}
+	if err := json.NewDecoder(body).Decode(list); err != nil {
+		return nil, fmt.Errorf("decoding file list: %w", err)
+	}
+	return list, nil
+}
+
+// hashFile downloads a file from the repository and computes its digests
+func (a *Artifactory) hashFile(filePath string) (map[string]string, error) {
+	body, err := a.get(a.apiURL + "/" + path.Join(a.Repository, filePath))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return hashReader(body, a.Options.Digests.Algorithms)
+}
+
+// get performs an authenticated request to the Artifactory server.
+// Access tokens are preferred over the legacy API keys.
+func (a *Artifactory) get(requestURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, requestURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("creating http request: %w", err)
+	}
+	if token := os.Getenv(artifactoryTokenEnvVar); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if apiKey := os.Getenv(artifactoryAPIKeyEnvVar); apiKey != "" {
+		req.Header.Set("X-JFrog-Art-Api", apiKey)
+	}
+
+	client, err := httpclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating http client: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing http request to artifactory: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http error from artifactory: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Recorded from /artifactory/api/storage/generic-local/app/1.0.0?list&deep=1&listFolders=0
+const artifactoryFileListResponse = `{
+  "uri" : "https://example.jfrog.io/artifactory/api/storage/generic-local/app/1.0.0",
+  "created" : "2023-05-10T12:00:00.000Z",
+  "files" : [ {
+    "uri" : "/app-linux-amd64.tar.gz",
+    "size" : 4,
+    "lastModified" : "2023-05-10T12:00:00.000Z",
+    "folder" : false,
+    "sha1" : "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+    "sha2" : "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+  }, {
+    "uri" : "/checksums/SHA256SUMS",
+    "size" : 4,
+    "lastModified" : "2023-05-10T12:01:00.000Z",
+    "folder" : false,
+    "sha1" : "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"
+  } ]
+}`
+
+func TestArtifactorySnap(t *testing.T) {
+	t.Setenv(artifactoryTokenEnvVar, "token")
+
+	downloads := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/artifactory/api/storage/generic-local/app/1.0.0", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, "1", r.URL.Query().Get("deep"))
+		fmt.Fprint(w, artifactoryFileListResponse)
+	})
+	mux.HandleFunc("/artifactory/generic-local/", func(w http.ResponseWriter, r *http.Request) {
+		downloads[r.URL.Path]++
+		fmt.Fprint(w, "test")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	a, err := NewArtifactory("artifactory://example.jfrog.io/generic-local/app/1.0.0")
+	require.NoError(t, err)
+	require.Equal(t, "generic-local", a.Repository)
+	require.Equal(t, "app/1.0.0", a.Path)
+	a.apiURL = server.URL + "/artifactory"
+
+	snap, err := a.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+
+	// Checksums from the file list are used without downloading
+	require.Equal(t, map[string]string{
+		"SHA1":   "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		"SHA256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}, (*snap)["app/1.0.0/app-linux-amd64.tar.gz"].Checksum)
+	require.Zero(t, downloads["/artifactory/generic-local/app/1.0.0/app-linux-amd64.tar.gz"])
+
+	// Files without a sha256 are downloaded and hashed
+	require.Equal(t,
+		"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		(*snap)["app/1.0.0/checksums/SHA256SUMS"].Checksum["SHA256"],
+	)
+	require.Equal(t, 1, downloads["/artifactory/generic-local/app/1.0.0/checksums/SHA256SUMS"])
+}
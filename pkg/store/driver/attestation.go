@@ -30,6 +30,7 @@ import (
 	intoto "github.com/in-toto/in-toto-golang/in_toto"
 	"github.com/sirupsen/logrus"
 
+	"sigs.k8s.io/tejolote/pkg/httpclient"
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
 )
@@ -46,15 +47,20 @@ func NewAttestation(specURL string) (*Attestation, error) {
 	if !strings.HasPrefix(u.Scheme, "intoto+") {
 		return nil, fmt.Errorf("spec URL %s is not an attestation url", u.Scheme)
 	}
-	logrus.Infof(
-		"Initialized new in-toto attestation storage backend (%s)", specURL,
-	)
+	logrus.WithField("store", specURL).Info("Initialized new in-toto attestation storage backend")
 	// TODO: Check scheme to make sure it is valid
 	return &Attestation{
 		URL: strings.TrimPrefix(specURL, "intoto+"),
 	}, nil
 }
 
+// DownloadURL writes the data at a location to w. It supports file://,
+// gs://, http(s):// and oci:// URLs, which can be prefixed with intoto+
+// as in the attestation store spec URLs.
+func DownloadURL(sourceURL string, w io.Writer) error {
+	return downloadURL(strings.TrimPrefix(sourceURL, "intoto+"), w)
+}
+
 // downloadURL universal download function
 // TODO: Move these to methods in each driver
 func downloadURL(sourceURL string, w io.Writer) error {
@@ -72,6 +78,12 @@ func downloadURL(sourceURL string, w io.Writer) error {
 		return downloadGCSObject(client, sourceURL, w)
 	case "http", "https":
 		return downloadHTTP(sourceURL, w)
+	case "oci":
+		oci, err := NewOCI(sourceURL)
+		if err != nil {
+			return fmt.Errorf("creating oci driver: %w", err)
+		}
+		return oci.download(w)
 	case "file":
 		f, err := os.Open(strings.TrimPrefix(sourceURL, "file://"))
 		if err != nil {
@@ -125,7 +137,10 @@ func (att *Attestation) downloadAttestation() ([]byte, error) {
 }
 
 func downloadHTTP(urlPath string, f io.Writer) error {
-	client := &http.Client{}
+	client, err := httpclient.New()
+	if err != nil {
+		return fmt.Errorf("creating http client: %w", err)
+	}
 	req, err := http.NewRequest("GET", urlPath, nil)
 	if err != nil {
 		return fmt.Errorf("creating http request: %w", err)
@@ -133,20 +148,14 @@ func downloadHTTP(urlPath string, f io.Writer) error {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("executing http request to GitHub API: %w", err)
-	}
-
-	// Check server response
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("http error when downloading: %s", resp.Status)
+		return fmt.Errorf("executing http request: %w", err)
 	}
-
 	defer resp.Body.Close()
 
-	// Writer the body to file
-	numBytes, err := io.Copy(f, resp.Body)
+	// Write the body to file
+	numBytes, err := httpclient.CopyBody(f, resp)
 	if err != nil {
-		return fmt.Errorf("writing http response to disk: %w", err)
+		return fmt.Errorf("downloading %s: %w", urlPath, err)
 	}
 	logrus.Debugf("%d MB downloaded from %s", (numBytes / 1024 / 1024), urlPath)
 	return nil
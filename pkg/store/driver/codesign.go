@@ -0,0 +1,183 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"debug/macho"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/digitorus/pkcs7"
+)
+
+const (
+	// Authenticode certificate type holding a PKCS#7 SignedData
+	winCertTypePKCSSignedData = 0x0002
+
+	// macOS code signature load command and blobs
+	lcCodeSignature        = 0x1d
+	csMagicEmbeddedSig     = 0xfade0cc0
+	csMagicBlobWrapper     = 0xfade0b01
+	csSlotSignature        = 0x10000
+	codeSignatureMaxLength = 64 << 20
+)
+
+// codeSigner returns the subject of the certificate that signed a
+// Windows (Authenticode) or macOS (codesign) executable. Files that
+// are not executables or that are not signed return an empty string.
+// The signature is not verified, this only records who claims to
+// have signed the file.
+func codeSigner(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+
+	var cms []byte
+	if peFile, err := pe.NewFile(f); err == nil {
+		cms, err = authenticodeCMS(f, peFile)
+		if err != nil {
+			return "", fmt.Errorf("reading authenticode signature: %w", err)
+		}
+	} else if machoFile, err := macho.NewFile(f); err == nil {
+		cms, err = machoCMS(io.NewSectionReader(f, 0, 1<<63-1), machoFile)
+		if err != nil {
+			return "", fmt.Errorf("reading code signature: %w", err)
+		}
+	} else if fat, err := macho.NewFatFile(f); err == nil && len(fat.Arches) > 0 {
+		// All the slices of a universal binary are signed with
+		// the same identity, read the first one
+		arch := fat.Arches[0]
+		cms, err = machoCMS(io.NewSectionReader(f, int64(arch.Offset), int64(arch.Size)), arch.File)
+		if err != nil {
+			return "", fmt.Errorf("reading code signature: %w", err)
+		}
+	}
+	if len(cms) == 0 {
+		return "", nil
+	}
+	return cmsSigner(cms)
+}
+
+// authenticodeCMS returns the PKCS#7 data in the certificate table
+// of a PE file. Unlike other data directories, the address of the
+// security directory is a file offset.
+func authenticodeCMS(r io.ReaderAt, f *pe.File) ([]byte, error) {
+	var dirs []pe.DataDirectory
+	switch oh := f.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		dirs = oh.DataDirectory[:min(int(oh.NumberOfRvaAndSizes), len(oh.DataDirectory))]
+	case *pe.OptionalHeader64:
+		dirs = oh.DataDirectory[:min(int(oh.NumberOfRvaAndSizes), len(oh.DataDirectory))]
+	}
+	if len(dirs) <= pe.IMAGE_DIRECTORY_ENTRY_SECURITY {
+		return nil, nil
+	}
+	dir := dirs[pe.IMAGE_DIRECTORY_ENTRY_SECURITY]
+	if dir.VirtualAddress == 0 || dir.Size < 8 {
+		return nil, nil
+	}
+	if dir.Size > codeSignatureMaxLength {
+		return nil, errors.New("certificate table is too large")
+	}
+	data := make([]byte, dir.Size)
+	if _, err := r.ReadAt(data, int64(dir.VirtualAddress)); err != nil {
+		return nil, fmt.Errorf("reading certificate table: %w", err)
+	}
+
+	// WIN_CERTIFICATE: dwLength, wRevision, wCertificateType, bCertificate
+	length := binary.LittleEndian.Uint32(data[0:4])
+	if binary.LittleEndian.Uint16(data[6:8]) != winCertTypePKCSSignedData {
+		return nil, nil
+	}
+	if length < 8 || length > dir.Size {
+		return nil, errors.New("invalid certificate length")
+	}
+	return data[8:length], nil
+}
+
+// machoCMS returns the CMS blob in the code signature of a Mach-O
+// file. Ad-hoc signed binaries have no CMS data and return nil.
+func machoCMS(r io.ReaderAt, f *macho.File) ([]byte, error) {
+	var dataOff, dataSize uint32
+	for _, l := range f.Loads {
+		raw := l.Raw()
+		if len(raw) >= 16 && f.ByteOrder.Uint32(raw[0:4]) == lcCodeSignature {
+			dataOff = f.ByteOrder.Uint32(raw[8:12])
+			dataSize = f.ByteOrder.Uint32(raw[12:16])
+			break
+		}
+	}
+	if dataSize == 0 {
+		return nil, nil
+	}
+	if dataSize > codeSignatureMaxLength {
+		return nil, errors.New("code signature is too large")
+	}
+	data := make([]byte, dataSize)
+	if _, err := r.ReadAt(data, int64(dataOff)); err != nil {
+		return nil, fmt.Errorf("reading code signature: %w", err)
+	}
+	return superBlobCMS(data)
+}
+
+// superBlobCMS extracts the CMS signature from a code signing
+// super blob. Code signing structures are always big endian.
+func superBlobCMS(data []byte) ([]byte, error) {
+	if len(data) < 12 || binary.BigEndian.Uint32(data[0:4]) != csMagicEmbeddedSig {
+		return nil, errors.New("data is not an embedded signature blob")
+	}
+	count := binary.BigEndian.Uint32(data[8:12])
+	for i := uint32(0); i < count; i++ {
+		entry := 12 + int(i)*8
+		if entry+8 > len(data) {
+			return nil, errors.New("signature blob index is truncated")
+		}
+		if binary.BigEndian.Uint32(data[entry:entry+4]) != csSlotSignature {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(data[entry+4 : entry+8]))
+		if offset+8 > len(data) || binary.BigEndian.Uint32(data[offset:offset+4]) != csMagicBlobWrapper {
+			return nil, errors.New("invalid signature blob")
+		}
+		length := int(binary.BigEndian.Uint32(data[offset+4 : offset+8]))
+		if length < 8 || offset+length > len(data) {
+			return nil, errors.New("signature blob is truncated")
+		}
+		return data[offset+8 : offset+length], nil
+	}
+	return nil, nil
+}
+
+// cmsSigner returns the subject of the signer certificate in a
+// PKCS#7 SignedData structure
+func cmsSigner(der []byte) (string, error) {
+	p7, err := pkcs7.Parse(der)
+	if err != nil {
+		return "", fmt.Errorf("parsing signature: %w", err)
+	}
+	cert := p7.GetOnlySigner()
+	if cert == nil {
+		return "", errors.New("unable to find the signer certificate")
+	}
+	return cert.Subject.String(), nil
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/digitorus/pkcs7"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// testSignature returns a PKCS#7 signature made with a
+// self-signed certificate with the subject common name
+func testSignature(t *testing.T, cn string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Tejolote"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	sd, err := pkcs7.NewSignedData([]byte("code"))
+	require.NoError(t, err)
+	require.NoError(t, sd.AddSigner(cert, key, pkcs7.SignerInfoConfig{}))
+	sig, err := sd.Finish()
+	require.NoError(t, err)
+	return sig
+}
+
+// testPEFile builds a minimal PE32+ image with the signature
+// in its certificate table
+func testPEFile(t *testing.T, signature []byte) []byte {
+	var buf bytes.Buffer
+	dos := make([]byte, 0x40)
+	copy(dos, "MZ")
+	binary.LittleEndian.PutUint32(dos[0x3c:], 0x40)
+	buf.Write(dos)
+	buf.WriteString("PE\x00\x00")
+
+	oh := pe.OptionalHeader64{Magic: 0x20b, NumberOfRvaAndSizes: 16}
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, pe.FileHeader{
+		Machine:              pe.IMAGE_FILE_MACHINE_AMD64,
+		SizeOfOptionalHeader: uint16(binary.Size(oh)),
+	}))
+	certOffset := buf.Len() + binary.Size(oh)
+	oh.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_SECURITY] = pe.DataDirectory{
+		VirtualAddress: uint32(certOffset),
+		Size:           uint32(8 + len(signature)),
+	}
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, oh))
+
+	// WIN_CERTIFICATE header followed by the signature
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint32(8+len(signature))))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint16(0x0200)))
+	require.NoError(t, binary.Write(&buf, binary.LittleEndian, uint16(winCertTypePKCSSignedData)))
+	buf.Write(signature)
+	return buf.Bytes()
+}
+
+func TestCodeSigner(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "app.exe"), testPEFile(t, testSignature(t, "Example Corp")), os.FileMode(0o644),
+	))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("text"), os.FileMode(0o644)))
+
+	signer, err := codeSigner(filepath.Join(dir, "app.exe"))
+	require.NoError(t, err)
+	require.Equal(t, "CN=Example Corp,O=Tejolote", signer)
+
+	signer, err = codeSigner(filepath.Join(dir, "README"))
+	require.NoError(t, err)
+	require.Empty(t, signer)
+
+	// Signers are only annotated when enabled
+	d, err := NewDirectory("file://" + dir)
+	require.NoError(t, err)
+	snap, err := d.Snap()
+	require.NoError(t, err)
+	require.Nil(t, (*snap)["app.exe"].Annotations)
+
+	d, err = NewDirectory("file://" + dir + "?code-signers=true")
+	require.NoError(t, err)
+	snap, err = d.Snap()
+	require.NoError(t, err)
+	require.Equal(t, "CN=Example Corp,O=Tejolote", (*snap)["app.exe"].Annotations[run.AnnotationCodeSigner])
+	require.Nil(t, (*snap)["README"].Annotations)
+}
+
+func TestSuperBlobCMS(t *testing.T) {
+	signature := testSignature(t, "Developer ID Application: Example")
+
+	// A super blob with a code directory and the signature wrapper
+	blob := binary.BigEndian.AppendUint32(nil, csMagicEmbeddedSig)
+	blob = binary.BigEndian.AppendUint32(blob, 0)
+	blob = binary.BigEndian.AppendUint32(blob, 2)
+	blob = binary.BigEndian.AppendUint32(blob, 0)
+	blob = binary.BigEndian.AppendUint32(blob, 28)
+	blob = binary.BigEndian.AppendUint32(blob, csSlotSignature)
+	blob = binary.BigEndian.AppendUint32(blob, 36)
+	blob = binary.BigEndian.AppendUint32(blob, 0xfade0c02)
+	blob = binary.BigEndian.AppendUint32(blob, 8)
+	blob = binary.BigEndian.AppendUint32(blob, csMagicBlobWrapper)
+	blob = binary.BigEndian.AppendUint32(blob, uint32(8+len(signature)))
+	blob = append(blob, signature...)
+
+	cms, err := superBlobCMS(blob)
+	require.NoError(t, err)
+	require.Equal(t, signature, cms)
+	signer, err := cmsSigner(cms)
+	require.NoError(t, err)
+	require.Equal(t, "CN=Developer ID Application: Example,O=Tejolote", signer)
+
+	_, err = superBlobCMS([]byte("not a signature blob"))
+	require.Error(t, err)
+}
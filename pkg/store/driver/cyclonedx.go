@@ -0,0 +1,112 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"strings"
+
+	cdx "github.com/CycloneDX/cyclonedx-go"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+type CycloneDX struct {
+	URL string
+}
+
+func NewCycloneDX(specURL string) (*CycloneDX, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing cyclonedx spec url: %w", err)
+	}
+	if !strings.HasPrefix(u.Scheme, "cyclonedx+") {
+		return nil, fmt.Errorf("spec URL %s is not a cyclonedx url", u.Scheme)
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new CycloneDX SBOM storage backend")
+
+	return &CycloneDX{
+		URL: strings.TrimPrefix(specURL, "cyclonedx+"),
+	}, nil
+}
+
+func (c *CycloneDX) Snap() (*snapshot.Snapshot, error) {
+	var b bytes.Buffer
+	if err := downloadURL(c.URL, &b); err != nil {
+		return nil, fmt.Errorf("downloading sbom: %w", err)
+	}
+	return cycloneDXSnapshot(b.Bytes())
+}
+
+// cycloneDXSnapshot reads the components of a CycloneDX document
+// into a snapshot
+func cycloneDXSnapshot(data []byte) (*snapshot.Snapshot, error) {
+	// CycloneDX documents can be encoded in JSON or XML
+	format := cdx.BOMFileFormatJSON
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("<")) {
+		format = cdx.BOMFileFormatXML
+	}
+
+	bom := cdx.NewBOM()
+	if err := cdx.NewBOMDecoder(bytes.NewReader(data), format).Decode(bom); err != nil {
+		return nil, fmt.Errorf("parsing cyclonedx sbom: %w", err)
+	}
+
+	snap := snapshot.Snapshot{}
+	if bom.Components != nil {
+		addCycloneDXComponents(*bom.Components, snap)
+	}
+	return &snap, nil
+}
+
+// addCycloneDXComponents adds the components to the snapshot,
+// including any nested components
+func addCycloneDXComponents(components []cdx.Component, snap snapshot.Snapshot) {
+	for _, c := range components {
+		if c.Components != nil {
+			addCycloneDXComponents(*c.Components, snap)
+		}
+
+		// Prefer the purl, fall back to the component name
+		identifier := c.PackageURL
+		if identifier == "" {
+			identifier = c.Name
+		}
+
+		if c.Hashes == nil || len(*c.Hashes) == 0 {
+			logrus.Warnf("CycloneDX component %s has no hashes", identifier)
+			continue
+		}
+
+		artifact := run.Artifact{
+			Path:     identifier,
+			Checksum: map[string]string{},
+		}
+		for _, h := range *c.Hashes {
+			// Normalize the algorithm names to match the SPDX
+			// driver (SHA-256 -> SHA256)
+			artifact.Checksum[strings.ReplaceAll(string(h.Algorithm), "-", "")] = h.Value
+		}
+
+		snap[identifier] = artifact
+	}
+}
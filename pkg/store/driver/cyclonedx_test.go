@@ -0,0 +1,79 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testCycloneDX = `{
+  "bomFormat": "CycloneDX",
+  "specVersion": "1.5",
+  "version": 1,
+  "components": [
+    {
+      "type": "library",
+      "name": "left-pad",
+      "version": "1.3.0",
+      "purl": "pkg:npm/left-pad@1.3.0",
+      "hashes": [
+        {"alg": "SHA-256", "content": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}
+      ],
+      "components": [
+        {
+          "type": "file",
+          "name": "index.js",
+          "hashes": [
+            {"alg": "SHA-1", "content": "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"}
+          ]
+        }
+      ]
+    },
+    {
+      "type": "library",
+      "name": "nohash"
+    }
+  ]
+}`
+
+func TestCycloneDXSnap(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "sbom.cdx.json")
+	require.NoError(t, os.WriteFile(path, []byte(testCycloneDX), os.FileMode(0o644)))
+
+	c, err := NewCycloneDX("cyclonedx+file://" + path)
+	require.NoError(t, err)
+
+	snap, err := c.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+	require.Equal(t,
+		"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		(*snap)["pkg:npm/left-pad@1.3.0"].Checksum["SHA256"],
+	)
+	require.Equal(t,
+		"a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		(*snap)["index.js"].Checksum["SHA1"],
+	)
+}
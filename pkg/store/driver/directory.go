@@ -18,12 +18,15 @@ package driver
 
 import (
 	"fmt"
+	"maps"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 
-	"sigs.k8s.io/release-utils/hash"
+	"github.com/sirupsen/logrus"
 
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
@@ -34,13 +37,69 @@ func NewDirectory(specURL string) (*Directory, error) {
 	if err != nil {
 		return nil, fmt.Errorf("parsing SpecURL %s: %w", specURL, err)
 	}
+	opts := DefaultDirectoryOptions
+	if signers := u.Query().Get("code-signers"); signers != "" {
+		opts.CodeSigners, err = strconv.ParseBool(signers)
+		if err != nil {
+			return nil, fmt.Errorf("parsing code-signers option: %w", err)
+		}
+	}
+	if algorithms := u.Query().Get("algorithms"); algorithms != "" {
+		opts.Algorithms, err = parseAlgorithms(algorithms)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts.ChecksumsFile = u.Query().Get("checksums")
+	opts.HashCache = u.Query().Get("hash-cache")
 	return &Directory{
-		Path: u.Path,
+		Path:    u.Path,
+		Options: opts,
 	}, nil
 }
 
 type Directory struct {
-	Path string
+	Path    string
+	Options DirectoryOptions
+
+	// cacheKey computes the key of a file in the hash cache from
+	// its absolute path. When nil, the path is the key.
+	cacheKey func(string) string
+}
+
+type DirectoryOptions struct {
+	// Algorithms is the list of digest algorithms computed for each
+	// file. It is set with the algorithms query parameter.
+	Algorithms []string
+
+	// CodeSigners records the signer of Authenticode and macOS
+	// codesign signed executables in the artifact annotations
+	CodeSigners bool
+
+	// ChecksumsFile is the name of a checksums manifest in the format
+	// written by sha256sum (eg SHA256SUMS) at the root of the directory.
+	// When the file exists, the files listed in it must match their
+	// published SHA256 digest or the snapshot fails.
+	ChecksumsFile string
+
+	// HashCache is the path of a file caching the digests of the
+	// files by path, modification time and size. When empty, the
+	// TEJOLOTE_HASH_CACHE environment variable is used. It can be
+	// set with the hash-cache query parameter of the spec URL.
+	HashCache string
+
+	// ReadOnlyHashCache uses the hash cache without saving
+	// the digests computed, eg in dry runs
+	ReadOnlyHashCache bool
+}
+
+var DefaultDirectoryOptions = DirectoryOptions{
+	Algorithms: []string{"SHA256"},
+}
+
+// SetReadOnlyHashCache stops the driver from saving its hash cache
+func (d *Directory) SetReadOnlyHashCache(readOnly bool) {
+	d.Options.ReadOnlyHashCache = readOnly
 }
 
 // Snap takes a snapshot of the directory
@@ -49,8 +108,30 @@ func (d *Directory) Snap() (*snapshot.Snapshot, error) {
 		return nil, fmt.Errorf("directory watcher has no path defined")
 	}
 
+	algorithms := d.Options.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = DefaultDirectoryOptions.Algorithms
+	}
+
 	snap := snapshot.Snapshot{}
 
+	// Resolve the absolute path of the directory to make sure
+	// all paths in the snapshot are relative to it
+	root, err := filepath.Abs(d.Path)
+	if err != nil {
+		return nil, fmt.Errorf("resolving directory path: %w", err)
+	}
+
+	published, err := d.readChecksumsFile()
+	if err != nil {
+		return nil, err
+	}
+	if published != nil && !slices.Contains(algorithms, "SHA256") {
+		algorithms = append(slices.Clone(algorithms), "SHA256")
+	}
+
+	cache := openHashCache(hashCachePath(d.Options.HashCache))
+
 	// Walk the files in the directory
 	if err := filepath.Walk(d.Path,
 		func(path string, info os.FileInfo, err error) error {
@@ -61,31 +142,106 @@ func (d *Directory) Snap() (*snapshot.Snapshot, error) {
 				return nil
 			}
 
-			// Hash the file
-			sha, err := hash.SHA256ForFile(path)
-			if err != nil {
-				return fmt.Errorf("hashing %s: %w", path, err)
-			}
-
 			// Normalize the path....
 			path, err = filepath.Abs(path)
 			if err != nil {
 				return fmt.Errorf("normalizing path %s: %w", path, err)
 			}
 
+			// Hash the file, unless its digests are cached
+			key := path
+			if d.cacheKey != nil {
+				key = d.cacheKey(path)
+			}
+			checksums, cached := cache.get(key, info, algorithms)
+			if !cached {
+				checksums, err = hashFile(path, algorithms)
+				if err != nil {
+					return fmt.Errorf("hashing %s: %w", path, err)
+				}
+				cache.put(key, info, checksums)
+			}
+
 			// .. and trim the working directory to make it relative
-			path = strings.TrimPrefix(path, d.Path+"/")
+			path = strings.TrimPrefix(path, root+string(filepath.Separator))
+
+			// Check the digest against the checksums manifest
+			if sum, ok := published[filepath.ToSlash(path)]; ok {
+				if checksums["SHA256"] != sum {
+					return fmt.Errorf(
+						"SHA256 of %s (%s) does not match the one in %s (%s)",
+						path, checksums["SHA256"], d.Options.ChecksumsFile, sum,
+					)
+				}
+				delete(published, filepath.ToSlash(path))
+			}
 
 			// Register the file with the path normalized
-			snap[path] = run.Artifact{
+			artifact := run.Artifact{
 				Path:     path,
-				Checksum: map[string]string{"SHA256": sha},
+				Checksum: checksums,
 				Time:     info.ModTime(),
 			}
+
+			// Detecting signers is best effort, failures are only logged
+			if d.Options.CodeSigners {
+				signer, err := codeSigner(filepath.Join(root, path))
+				if err != nil {
+					logrus.Debugf("unable to read code signature of %s: %v", path, err)
+				} else if signer != "" {
+					artifact.Annotations = map[string]string{run.AnnotationCodeSigner: signer}
+				}
+			}
+
+			// Record the metadata of OS packages, also best effort
+			pkg, err := packageMetadata(filepath.Join(root, path))
+			if err != nil {
+				logrus.Debugf("unable to read package metadata of %s: %v", path, err)
+			} else if pkg != nil {
+				if artifact.Annotations == nil {
+					artifact.Annotations = map[string]string{}
+				}
+				maps.Copy(artifact.Annotations, pkg.annotations())
+			}
+			snap[path] = artifact
 			return nil
 		}); err != nil {
 		return nil, fmt.Errorf("walking directory: %w", err)
 	}
 
+	if !d.Options.ReadOnlyHashCache {
+		if err := cache.save(); err != nil {
+			logrus.Warnf("unable to save hash cache: %v", err)
+		}
+	}
+
+	if len(published) > 0 {
+		return nil, fmt.Errorf(
+			"files listed in %s not found: %s",
+			d.Options.ChecksumsFile, strings.Join(slices.Sorted(maps.Keys(published)), ", "),
+		)
+	}
+
 	return &snap, nil
 }
+
+// readChecksumsFile reads the checksums manifest of the directory. It
+// returns nil if no manifest is configured or it does not exist.
+func (d *Directory) readChecksumsFile() (map[string]string, error) {
+	if d.Options.ChecksumsFile == "" {
+		return nil, nil
+	}
+	f, err := os.Open(filepath.Join(d.Path, d.Options.ChecksumsFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("opening checksums file: %w", err)
+	}
+	defer f.Close()
+	sums, err := parseChecksumsFile(f)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", d.Options.ChecksumsFile, err)
+	}
+	return sums, nil
+}
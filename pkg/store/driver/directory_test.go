@@ -129,3 +129,127 @@ func TestDirectorySnap(t *testing.T) {
 		require.Equal(t, delta, tc.expect)
 	}
 }
+
+func TestDirectorySnapAlgorithms(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.txt"), []byte("test"), os.FileMode(0o644)))
+
+	// Default is SHA256 only
+	sut, err := NewDirectory("file://" + dir)
+	require.NoError(t, err)
+	snap, err := sut.Snap()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"SHA256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}, (*snap)["test.txt"].Checksum)
+
+	sut, err = NewDirectory("file://" + dir + "?algorithms=sha256,sha512")
+	require.NoError(t, err)
+	require.Equal(t, []string{"SHA256", "SHA512"}, sut.Options.Algorithms)
+	snap, err = sut.Snap()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"SHA256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"SHA512": "ee26b0dd4af7e749aa1a8ee3c10ae9923f618980772e473f8819a5d4940e0db27ac185f8a0e1d5f84f88bc887fd67b143732c304cc5fa9ad8e6f57f50028a8ff",
+	}, (*snap)["test.txt"].Checksum)
+
+	sut.Options.Algorithms = []string{"MD4"}
+	_, err = sut.Snap()
+	require.Error(t, err)
+
+	// Unknown algorithms are rejected when parsing the spec URL
+	_, err = NewDirectory("file://" + dir + "?algorithms=sha256,md4")
+	require.Error(t, err)
+	_, err = NewDirectory("file://" + dir + "?algorithms=,")
+	require.Error(t, err)
+}
+
+func TestDirectorySnapChecksumsFile(t *testing.T) {
+	dir := t.TempDir()
+	testSum := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "bin"), os.FileMode(0o755)))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bin", "test.txt"), []byte("test"), os.FileMode(0o644)))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "SHA256SUMS"), []byte(testSum+"  ./bin/test.txt\n"), os.FileMode(0o644),
+	))
+
+	sut, err := NewDirectory("file://" + dir + "?checksums=SHA256SUMS")
+	require.NoError(t, err)
+	require.Equal(t, "SHA256SUMS", sut.Options.ChecksumsFile)
+
+	// The published digest is recorded even when not computing SHA256
+	sut.Options.Algorithms = []string{"SHA512"}
+	snap, err := sut.Snap()
+	require.NoError(t, err)
+	require.Equal(t, testSum, (*snap)["bin/test.txt"].Checksum["SHA256"])
+
+	// A file that does not match the manifest fails the snapshot
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bin", "test.txt"), []byte("tampered"), os.FileMode(0o644)))
+	_, err = sut.Snap()
+	require.Error(t, err)
+
+	// As do files listed but missing
+	require.NoError(t, os.Remove(filepath.Join(dir, "bin", "test.txt")))
+	_, err = sut.Snap()
+	require.Error(t, err)
+
+	// Without a manifest there is nothing to check
+	require.NoError(t, os.Remove(filepath.Join(dir, "SHA256SUMS")))
+	_, err = sut.Snap()
+	require.NoError(t, err)
+}
+
+func TestDirectorySnapHashCache(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(t.TempDir(), "cache", "hashes.json")
+	file := filepath.Join(dir, "test.txt")
+	require.NoError(t, os.WriteFile(file, []byte("test"), os.FileMode(0o644)))
+
+	d, err := NewDirectory("file://" + dir + "?hash-cache=" + cachePath)
+	require.NoError(t, err)
+	require.Equal(t, cachePath, d.Options.HashCache)
+	snap, err := d.Snap()
+	require.NoError(t, err)
+	sum := (*snap)["test.txt"].Checksum["SHA256"]
+	require.FileExists(t, cachePath)
+
+	// Tamper with the cached digest to check it is used
+	abs, err := filepath.Abs(file)
+	require.NoError(t, err)
+	cache := openHashCache(cachePath)
+	require.Contains(t, cache.Entries, abs)
+	entry := cache.Entries[abs]
+	entry.Checksums["SHA256"] = "cached"
+	cache.Entries[abs] = entry
+	cache.dirty = true
+	require.NoError(t, cache.save())
+
+	snap, err = d.Snap()
+	require.NoError(t, err)
+	require.Equal(t, "cached", (*snap)["test.txt"].Checksum["SHA256"])
+
+	// Algorithms missing in the cache are computed
+	d.Options.Algorithms = []string{"SHA256", "SHA512"}
+	snap, err = d.Snap()
+	require.NoError(t, err)
+	require.NotEmpty(t, (*snap)["test.txt"].Checksum["SHA512"])
+
+	// Changing the file invalidates the entry
+	require.NoError(t, os.WriteFile(file, []byte("changed"), os.FileMode(0o644)))
+	snap, err = d.Snap()
+	require.NoError(t, err)
+	require.NotEqual(t, "cached", (*snap)["test.txt"].Checksum["SHA256"])
+	require.NotEqual(t, sum, (*snap)["test.txt"].Checksum["SHA256"])
+
+	// The cache path can be set in the environment
+	envCache := filepath.Join(t.TempDir(), "env.json")
+	t.Setenv(HashCacheEnvVar, envCache)
+	d, err = NewDirectory("file://" + dir)
+	require.NoError(t, err)
+	_, err = d.Snap()
+	require.NoError(t, err)
+	require.FileExists(t, envCache)
+}
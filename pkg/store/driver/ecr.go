@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+// ECR is a store that lists the tagged images of an Amazon ECR
+// repository: ecr://region/repository. The DescribeImages API returns
+// the digests and push times of the images, so tags don't need to be
+// resolved one by one. The registry-id query parameter selects the
+// account of the registry, it defaults to the one of the credentials.
+type ECR struct {
+	Region     string
+	Repository string
+	RegistryID string
+	client     ecr.DescribeImagesAPIClient
+}
+
+func NewECR(specURL string) (*ECR, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing ECR spec URL: %w", err)
+	}
+	if u.Scheme != "ecr" {
+		return nil, errors.New("spec url is not an ecr url")
+	}
+	repository := strings.Trim(u.Path, "/")
+	if u.Hostname() == "" || repository == "" {
+		return nil, errors.New("ecr spec url must be ecr://region/repository")
+	}
+
+	httpClient, err := httpclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating http client: %w", err)
+	}
+	cfg, err := config.LoadDefaultConfig(
+		context.Background(), config.WithRegion(u.Hostname()), config.WithHTTPClient(httpClient),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("loading AWS configuration: %w", err)
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new ECR storage backend")
+
+	return &ECR{
+		Region:     u.Hostname(),
+		Repository: repository,
+		RegistryID: u.Query().Get("registry-id"),
+		client:     ecr.NewFromConfig(cfg),
+	}, nil
+}
+
+// Snap returns an artifact for each tag in the repository, named
+// like the tags listed by the OCI store
+func (e *ECR) Snap() (*snapshot.Snapshot, error) {
+	input := &ecr.DescribeImagesInput{RepositoryName: aws.String(e.Repository)}
+	if e.RegistryID != "" {
+		input.RegistryId = aws.String(e.RegistryID)
+	}
+
+	snap := snapshot.Snapshot{}
+	paginator := ecr.NewDescribeImagesPaginator(e.client, input)
+	for paginator.HasMorePages() {
+		ctx, cancel := httpclient.WithTimeout(context.Background())
+		page, err := paginator.NextPage(ctx)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("listing images of %s: %w", e.Repository, err)
+		}
+		for _, image := range page.ImageDetails {
+			algo, value, ok := strings.Cut(aws.ToString(image.ImageDigest), ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid digest %q", aws.ToString(image.ImageDigest))
+			}
+			repo := fmt.Sprintf(
+				"%s.dkr.ecr.%s.amazonaws.com/%s",
+				aws.ToString(image.RegistryId), e.Region, aws.ToString(image.RepositoryName),
+			)
+			for _, t := range image.ImageTags {
+				snap["oci://"+t] = run.Artifact{
+					Path:     "oci://" + repo + ":" + t,
+					Checksum: map[string]string{strings.ToUpper(algo): value},
+					Time:     aws.ToTime(image.ImagePushedAt),
+				}
+			}
+		}
+	}
+	return &snap, nil
+}
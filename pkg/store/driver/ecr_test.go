@@ -0,0 +1,83 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	"github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeECR returns the image details in pages of one image
+type fakeECR struct {
+	images []types.ImageDetail
+	input  *ecr.DescribeImagesInput
+}
+
+func (f *fakeECR) DescribeImages(
+	_ context.Context, input *ecr.DescribeImagesInput, _ ...func(*ecr.Options),
+) (*ecr.DescribeImagesOutput, error) {
+	f.input = input
+	i := 0
+	if input.NextToken != nil {
+		i = int(aws.ToString(input.NextToken)[0] - '0')
+	}
+	out := &ecr.DescribeImagesOutput{ImageDetails: f.images[i : i+1]}
+	if i+1 < len(f.images) {
+		out.NextToken = aws.String(string(rune('0' + i + 1)))
+	}
+	return out, nil
+}
+
+func TestECRSnap(t *testing.T) {
+	pushed := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	client := &fakeECR{images: []types.ImageDetail{
+		{
+			RegistryId:     aws.String("123456789012"),
+			RepositoryName: aws.String("team/app"),
+			ImageDigest:    aws.String("sha256:aaaa"),
+			ImageTags:      []string{"v1.0.0", "latest"},
+			ImagePushedAt:  aws.Time(pushed),
+		},
+		{
+			RegistryId:     aws.String("123456789012"),
+			RepositoryName: aws.String("team/app"),
+			ImageDigest:    aws.String("sha256:bbbb"),
+		},
+	}}
+	e := &ECR{Region: "us-east-1", Repository: "team/app", RegistryID: "123456789012", client: client}
+	snap, err := e.Snap()
+	require.NoError(t, err)
+	require.Equal(t, "123456789012", aws.ToString(client.input.RegistryId))
+
+	// Untagged images are not listed
+	require.Len(t, *snap, 2)
+	a := (*snap)["oci://v1.0.0"]
+	require.Equal(t, "oci://123456789012.dkr.ecr.us-east-1.amazonaws.com/team/app:v1.0.0", a.Path)
+	require.Equal(t, map[string]string{"SHA256": "aaaa"}, a.Checksum)
+	require.Equal(t, pushed, a.Time)
+
+	for _, specURL := range []string{"ecr://us-east-1", "ecr:///team/app", "oci://us-east-1/team/app"} {
+		_, err := NewECR(specURL)
+		require.Error(t, err, specURL)
+	}
+}
@@ -19,37 +19,39 @@ package driver
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/url"
-	"os"
 	"strings"
 	"sync"
+	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/option"
 
-	"sigs.k8s.io/release-utils/hash"
-
+	"sigs.k8s.io/tejolote/pkg/httpclient"
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
 )
 
 type GCB struct {
 	ProjectID string
+	Region    string
 	BuildID   string
 	client    *storage.Client
 }
 
 func NewGCB(specURL string) (*GCB, error) {
-	u, err := url.Parse(specURL)
+	project, region, buildID, err := ParseGCBURL(specURL)
 	if err != nil {
-		return nil, fmt.Errorf("parsing GCB spec URL: %w", err)
+		return nil, err
 	}
 
 	ctx := context.Background()
@@ -59,35 +61,133 @@ func NewGCB(specURL string) (*GCB, error) {
 	}
 
 	return &GCB{
-		ProjectID: u.Hostname(),
-		BuildID:   strings.TrimPrefix(u.Path, "/"),
+		ProjectID: project,
+		Region:    region,
+		BuildID:   buildID,
 		client:    client,
 	}, nil
 }
 
-func (gcb *GCB) readArtifacts() ([]run.Artifact, error) {
-	ctx := context.Background()
-	cloudbuildService, err := cloudbuild.NewService(ctx)
+// ParseGCBURL reads the project, region and build ID from a Cloud
+// Build spec URL. Builds in the global region are identified as
+// gcb://project/buildID and regional builds with their resource
+// name, gcb://project/locations/region/builds/buildID. The region
+// can also be set with the region query parameter.
+func ParseGCBURL(specURL string) (project, region, buildID string, err error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return "", "", "", fmt.Errorf("parsing GCB spec URL: %w", err)
+	}
+	project = u.Hostname()
+	region = u.Query().Get("region")
+	buildID = strings.Trim(u.Path, "/")
+	if buildID == "locations" {
+		return "", "", "", fmt.Errorf("missing region in %s", specURL)
+	}
+	if rest, ok := strings.CutPrefix(buildID, "locations/"); ok {
+		parts := strings.Split(rest, "/")
+		switch {
+		case len(parts) == 1 && parts[0] != "":
+			buildID = ""
+		case len(parts) == 3 && parts[0] != "" && parts[1] == "builds" && parts[2] != "":
+			buildID = parts[2]
+		default:
+			return "", "", "", fmt.Errorf("invalid GCB build name in %s", specURL)
+		}
+		region = parts[0]
+	}
+	if region == "global" {
+		region = ""
+	}
+	return project, region, buildID, nil
+}
+
+// GCBSpecURL returns the spec URL of a Cloud Build build
+func GCBSpecURL(project, region, buildID string) string {
+	if region == "" {
+		return fmt.Sprintf("gcb://%s/%s", project, buildID)
+	}
+	return fmt.Sprintf("gcb://%s/locations/%s/builds/%s", project, region, buildID)
+}
+
+// GCBEndpoint returns the endpoint of the Cloud Build API serving
+// the builds of a region, empty for the global one. Regional builds
+// are not found in the global endpoint.
+func GCBEndpoint(region string) string {
+	if region == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://%s-cloudbuild.googleapis.com/", region)
+}
+
+// getGCBBuild fetches a build from the Cloud Build API of its region
+func getGCBBuild(
+	ctx context.Context, service *cloudbuild.Service, project, region, buildID string,
+) (*cloudbuild.Build, error) {
+	if region == "" {
+		return service.Projects.Builds.Get(project, buildID).Context(ctx).Do()
+	}
+	return service.Projects.Locations.Builds.Get(
+		fmt.Sprintf("projects/%s/locations/%s/builds/%s", project, region, buildID),
+	).Context(ctx).Do()
+}
+
+// GetGCBBuild fetches a build from the Cloud Build API, from the
+// regional endpoint for regional builds. The options are added to
+// those of the client and override its endpoint.
+func GetGCBBuild(
+	ctx context.Context, project, region, buildID string, opts ...option.ClientOption,
+) (*cloudbuild.Build, error) {
+	service, err := NewGCBService(ctx, region, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return getGCBBuild(ctx, service, project, region, buildID)
+}
+
+// NewGCBService returns a client of the Cloud Build API of a region
+func NewGCBService(ctx context.Context, region string, opts ...option.ClientOption) (*cloudbuild.Service, error) {
+	if endpoint := GCBEndpoint(region); endpoint != "" {
+		opts = append([]option.ClientOption{option.WithEndpoint(endpoint)}, opts...)
+	}
+	service, err := cloudbuild.NewService(ctx, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("creating cloudbuild client: %w", err)
 	}
-	build, err := cloudbuildService.Projects.Builds.Get(gcb.ProjectID, gcb.BuildID).Do()
+	return service, nil
+}
+
+func (gcb *GCB) readArtifacts() ([]run.Artifact, error) {
+	ctx, cancel := httpclient.WithTimeout(context.Background())
+	defer cancel()
+	build, err := GetGCBBuild(ctx, gcb.ProjectID, gcb.Region, gcb.BuildID)
 	if err != nil {
 		return nil, fmt.Errorf("getting build %s from GCB: %w", gcb.BuildID, err)
 	}
-	manifest := build.Results.ArtifactManifest
-	if manifest == "" {
-		logrus.Info("no artifact manifest in run, assuming no artifacts")
+	if build.Results == nil {
+		logrus.Info("build has no results, assuming no artifacts")
 		return []run.Artifact{}, nil
 	}
 
-	logrus.Infof("pulling artifact manifest from %s", manifest)
-
-	// Get the artifacts list from th build service
-	gcbArtifacts, err := gcb.readArtifactManifest(manifest)
-	if err != nil {
-		return nil, fmt.Errorf("reading build artifact manifest: %w", err)
+	// Read all the artifact manifests referenced by the build
+	manifests := gcbArtifactManifests(build)
+	if len(manifests) == 0 {
+		logrus.Info("no artifact manifest in run")
+	}
+	gcbArtifacts := []ghcsManifestArtifact{}
+	for _, manifest := range manifests {
+		logrus.Infof("pulling artifact manifest from %s", manifest)
+		manifestArtifacts, err := gcb.readArtifactManifest(manifest)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			logrus.Warnf("artifact manifest %s not found, skipping", manifest)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading build artifact manifest: %w", err)
+		}
+		gcbArtifacts = append(gcbArtifacts, manifestArtifacts...)
 	}
+	gcbArtifacts = dedupeManifestArtifacts(gcbArtifacts)
 	logrus.Debugf("%+v", gcbArtifacts)
 
 	// Hash the artifacts list
@@ -97,14 +197,11 @@ func (gcb *GCB) readArtifacts() ([]run.Artifact, error) {
 	for _, artifactData := range gcbArtifacts {
 		artifactData := artifactData
 		wg.Go(func() error {
-			f, err := os.CreateTemp("", "artifact-temp-")
+			checksums, err := hashDownload(func(w io.Writer) error {
+				return downloadGCSObject(gcb.client, artifactData.Location, w)
+			}, []string{"SHA256"})
 			if err != nil {
-				return fmt.Errorf("creating temporary artifact file")
-			}
-			defer os.Remove(f.Name())
-
-			if err := downloadGCSObject(gcb.client, artifactData.Location, f); err != nil {
-				return fmt.Errorf("downloading artifact: %w", err)
+				return fmt.Errorf("downloading and hashing artifact: %w", err)
 			}
 
 			attrs, err := readGCSObjectAttributes(gcb.client, artifactData.Location)
@@ -112,17 +209,11 @@ func (gcb *GCB) readArtifacts() ([]run.Artifact, error) {
 				return fmt.Errorf("reading object artifacts: %w", err)
 			}
 
-			hashValue, err := hash.SHA256ForFile(f.Name())
-			if err != nil {
-				return fmt.Errorf("hashing artifact: %w", err)
-			}
 			mtx.Lock()
 			artifacts = append(artifacts, run.Artifact{
-				Path: artifactData.Location,
-				Checksum: map[string]string{
-					"SHA256": hashValue,
-				},
-				Time: attrs.Updated,
+				Path:     artifactData.Location,
+				Checksum: checksums,
+				Time:     attrs.Updated,
 			})
 			mtx.Unlock()
 			return nil
@@ -131,9 +222,113 @@ func (gcb *GCB) readArtifacts() ([]run.Artifact, error) {
 	if err := wg.Wait(); err != nil {
 		return nil, fmt.Errorf("hashing artifacts: %w", err)
 	}
+
+	// Add the packages uploaded to registries. These are not listed in
+	// the artifact manifests but the results include their digests.
+	seen := map[string]struct{}{}
+	for _, a := range artifacts {
+		seen[a.Path] = struct{}{}
+	}
+	for _, a := range gcbUploadedArtifacts(build.Results) {
+		if _, ok := seen[a.Path]; ok {
+			continue
+		}
+		seen[a.Path] = struct{}{}
+		artifacts = append(artifacts, a)
+	}
 	return artifacts, nil
 }
 
+// gcbArtifactManifests returns the locations of the artifact manifests
+// referenced by a build. Besides the manifest in the build results, GCB
+// writes a manifest named after the build to the objects location.
+func gcbArtifactManifests(build *cloudbuild.Build) []string {
+	manifests := []string{}
+	seen := map[string]struct{}{}
+	add := func(m string) {
+		if _, ok := seen[m]; ok || m == "" {
+			return
+		}
+		seen[m] = struct{}{}
+		manifests = append(manifests, m)
+	}
+	if build.Results != nil {
+		add(build.Results.ArtifactManifest)
+	}
+	if build.Artifacts != nil && build.Artifacts.Objects != nil &&
+		build.Artifacts.Objects.Location != "" && build.Id != "" {
+		add(strings.TrimSuffix(build.Artifacts.Objects.Location, "/") + "/artifacts-" + build.Id + ".json")
+	}
+	return manifests
+}
+
+// dedupeManifestArtifacts removes artifacts listed more than once
+// in the manifests, keeping the first one by location
+func dedupeManifestArtifacts(artifacts []ghcsManifestArtifact) []ghcsManifestArtifact {
+	ret := []ghcsManifestArtifact{}
+	seen := map[string]struct{}{}
+	for _, a := range artifacts {
+		if _, ok := seen[a.Location]; ok {
+			continue
+		}
+		seen[a.Location] = struct{}{}
+		ret = append(ret, a)
+	}
+	return ret
+}
+
+// gcbUploadedArtifacts returns the maven, python and npm packages
+// uploaded by the build with the hashes reported in the results
+func gcbUploadedArtifacts(results *cloudbuild.Results) []run.Artifact {
+	type upload struct {
+		uri    string
+		hashes *cloudbuild.FileHashes
+		timing *cloudbuild.TimeSpan
+	}
+	uploads := []upload{}
+	for _, a := range results.MavenArtifacts {
+		uploads = append(uploads, upload{a.Uri, a.FileHashes, a.PushTiming})
+	}
+	for _, a := range results.PythonPackages {
+		uploads = append(uploads, upload{a.Uri, a.FileHashes, a.PushTiming})
+	}
+	for _, a := range results.NpmPackages {
+		uploads = append(uploads, upload{a.Uri, a.FileHashes, a.PushTiming})
+	}
+
+	artifacts := []run.Artifact{}
+	for _, u := range uploads {
+		if u.uri == "" {
+			continue
+		}
+		a := run.Artifact{
+			Path:     u.uri,
+			Checksum: map[string]string{},
+		}
+		if u.hashes != nil {
+			for _, h := range u.hashes.FileHash {
+				if h == nil || h.Type == "" || h.Type == "NONE" {
+					continue
+				}
+				// Hash values are base64 encoded bytes in the API
+				value, err := base64.StdEncoding.DecodeString(h.Value)
+				if err != nil {
+					logrus.Warnf("unable to decode %s hash of %s: %v", h.Type, u.uri, err)
+					continue
+				}
+				a.Checksum[h.Type] = hex.EncodeToString(value)
+			}
+		}
+		if u.timing != nil && u.timing.EndTime != "" {
+			if t, err := time.Parse(time.RFC3339Nano, u.timing.EndTime); err == nil {
+				a.Time = t
+			}
+		}
+		artifacts = append(artifacts, a)
+	}
+	return artifacts
+}
+
 func parseGCSObjectURL(objectURL string) (bucket, path string, err error) {
 	u, err := url.Parse(objectURL)
 	if err != nil {
@@ -199,17 +394,27 @@ func (gcb *GCB) readArtifactManifest(manifestURL string) ([]ghcsManifestArtifact
 		return nil, fmt.Errorf("reading manifest from GCS: %w", err)
 	}
 
-	dec := json.NewDecoder(strings.NewReader(b.String()))
+	logrus.Debugf("Artifact manifest %s: %s", manifestURL, b.String())
+	ret, err := parseArtifactManifest(&b)
+	if err != nil {
+		return nil, fmt.Errorf("parsing manifest %s: %w", manifestURL, err)
+	}
+	return ret, nil
+}
+
+// parseArtifactManifest decodes the stream of JSON objects
+// in an artifact manifest
+func parseArtifactManifest(r io.Reader) ([]ghcsManifestArtifact, error) {
+	dec := json.NewDecoder(r)
 	ret := []ghcsManifestArtifact{}
-	logrus.Infof("JSON: %s", b.String())
 	for {
 		var a ghcsManifestArtifact
 		if err := dec.Decode(&a); err == io.EOF {
 			break
 		} else if err != nil {
-			log.Fatal(err)
+			return nil, fmt.Errorf("decoding manifest entry: %w", err)
 		}
-		fmt.Printf("%s\n", a.Location)
+		logrus.Debugf("Manifest artifact: %s", a.Location)
 		ret = append(ret, a)
 	}
 	return ret, nil
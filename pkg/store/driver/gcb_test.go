@@ -18,10 +18,16 @@ package driver
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
 	"testing"
 
 	"cloud.google.com/go/storage"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/api/cloudbuild/v1"
+	"google.golang.org/api/option"
 )
 
 func TestGCB(t *testing.T) {
@@ -43,3 +49,124 @@ func TestGCSAttrs(t *testing.T) {
 	require.Error(t, err)
 	require.NotNil(t, attrs)
 }
+
+func TestGCBArtifactManifests(t *testing.T) {
+	build := &cloudbuild.Build{
+		Id: "1234",
+		Artifacts: &cloudbuild.Artifacts{
+			Objects: &cloudbuild.ArtifactObjects{Location: "gs://bucket/path/"},
+		},
+		Results: &cloudbuild.Results{ArtifactManifest: "gs://bucket/other/artifacts-1234.json"},
+	}
+	require.Equal(t, []string{
+		"gs://bucket/other/artifacts-1234.json", "gs://bucket/path/artifacts-1234.json",
+	}, gcbArtifactManifests(build))
+
+	// The same manifest is only listed once
+	build.Results.ArtifactManifest = "gs://bucket/path/artifacts-1234.json"
+	require.Equal(t, []string{"gs://bucket/path/artifacts-1234.json"}, gcbArtifactManifests(build))
+
+	require.Empty(t, gcbArtifactManifests(&cloudbuild.Build{Results: &cloudbuild.Results{}}))
+}
+
+func TestDedupeManifestArtifacts(t *testing.T) {
+	artifacts := dedupeManifestArtifacts([]ghcsManifestArtifact{
+		{Location: "gs://bucket/a"}, {Location: "gs://bucket/b"}, {Location: "gs://bucket/a"},
+	})
+	require.Len(t, artifacts, 2)
+	require.Equal(t, "gs://bucket/a", artifacts[0].Location)
+	require.Equal(t, "gs://bucket/b", artifacts[1].Location)
+}
+
+func TestGCBUploadedArtifacts(t *testing.T) {
+	sha := sha256.Sum256([]byte("package"))
+	hashes := &cloudbuild.FileHashes{FileHash: []*cloudbuild.Hash{
+		{Type: "SHA256", Value: base64.StdEncoding.EncodeToString(sha[:])},
+		{Type: "NONE"},
+	}}
+	artifacts := gcbUploadedArtifacts(&cloudbuild.Results{
+		MavenArtifacts: []*cloudbuild.UploadedMavenArtifact{
+			{Uri: "https://maven.example/lib.jar", FileHashes: hashes, PushTiming: &cloudbuild.TimeSpan{EndTime: "2024-01-02T03:04:05Z"}},
+		},
+		PythonPackages: []*cloudbuild.UploadedPythonPackage{
+			{Uri: "https://pypi.example/pkg.whl", FileHashes: hashes},
+			{FileHashes: hashes},
+		},
+		NpmPackages: []*cloudbuild.UploadedNpmPackage{
+			{Uri: "https://npm.example/pkg.tgz"},
+		},
+	})
+	require.Len(t, artifacts, 3)
+	require.Equal(t, "https://maven.example/lib.jar", artifacts[0].Path)
+	require.Equal(t, map[string]string{"SHA256": hex.EncodeToString(sha[:])}, artifacts[0].Checksum)
+	require.Equal(t, 2024, artifacts[0].Time.Year())
+	require.Equal(t, "https://pypi.example/pkg.whl", artifacts[1].Path)
+	require.Equal(t, "https://npm.example/pkg.tgz", artifacts[2].Path)
+	require.Empty(t, artifacts[2].Checksum)
+}
+
+func TestParseArtifactManifest(t *testing.T) {
+	artifacts, err := parseArtifactManifest(strings.NewReader(
+		`{"location":"gs://bucket/a.tar.gz","file_hash":[]}` + "\n" +
+			`{"location":"gs://bucket/b.tar.gz","file_hash":[]}` + "\n",
+	))
+	require.NoError(t, err)
+	require.Len(t, artifacts, 2)
+	require.Equal(t, "gs://bucket/b.tar.gz", artifacts[1].Location)
+
+	// A broken manifest returns an error instead of exiting
+	_, err = parseArtifactManifest(strings.NewReader(
+		`{"location":"gs://bucket/a.tar.gz"}` + "\n" + `{"location":`,
+	))
+	require.Error(t, err)
+}
+
+func TestParseGCBURL(t *testing.T) {
+	for _, tc := range []struct {
+		spec, project, region, build string
+		shouldErr                    bool
+	}{
+		{spec: "gcb://project/1234", project: "project", build: "1234"},
+		{spec: "gcb://project/locations/us-central1/builds/1234", project: "project", region: "us-central1", build: "1234"},
+		{spec: "gcb://project/locations/global/builds/1234", project: "project", build: "1234"},
+		{spec: "gcb://project/1234?region=europe-west1", project: "project", region: "europe-west1", build: "1234"},
+		{spec: "gcb://project/locations/us-east1?filter=tags=release", project: "project", region: "us-east1"},
+		{spec: "gcb://project", project: "project"},
+		{spec: "gcb://project/locations/us-east1/1234", shouldErr: true},
+		{spec: "gcb://project/locations/", shouldErr: true},
+	} {
+		project, region, build, err := ParseGCBURL(tc.spec)
+		if tc.shouldErr {
+			require.Error(t, err, tc.spec)
+			continue
+		}
+		require.NoError(t, err, tc.spec)
+		require.Equal(t, tc.project, project, tc.spec)
+		require.Equal(t, tc.region, region, tc.spec)
+		require.Equal(t, tc.build, build, tc.spec)
+	}
+
+	require.Equal(t, "gcb://project/1234", GCBSpecURL("project", "", "1234"))
+	require.Equal(t, "gcb://project/locations/us-central1/builds/1234", GCBSpecURL("project", "us-central1", "1234"))
+}
+
+func TestGCBEndpoint(t *testing.T) {
+	ctx := context.Background()
+
+	// Regional builds are served by the regional endpoint
+	service, err := NewGCBService(ctx, "us-central1", option.WithoutAuthentication())
+	require.NoError(t, err)
+	require.Equal(t, "https://us-central1-cloudbuild.googleapis.com/", service.BasePath)
+
+	// Without a region, the client uses the global endpoint
+	service, err = NewGCBService(ctx, "", option.WithoutAuthentication())
+	require.NoError(t, err)
+	require.Equal(t, "https://cloudbuild.googleapis.com/", service.BasePath)
+
+	// Options passed by the caller override the regional endpoint
+	service, err = NewGCBService(
+		ctx, "us-central1", option.WithEndpoint("http://localhost/"), option.WithoutAuthentication(),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost/", service.BasePath)
+}
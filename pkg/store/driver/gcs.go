@@ -18,12 +18,16 @@ package driver
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
+	"hash/crc32"
+	"io"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
@@ -31,6 +35,7 @@ import (
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/api/iterator"
 
+	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
 )
 
@@ -51,11 +56,42 @@ func NewGCS(specURL string) (*GCS, error) {
 		return nil, fmt.Errorf("creating temporary directory")
 	}
 	logrus.Infof("GCS driver init: Bucket: %s Path: %s", u.Hostname(), u.Path)
+	opts := DefaultGCSOptions
+	opts.Pointers = pointerOptionsFromQuery(u.Query())
+	opts.HashCache = u.Query().Get("hash-cache")
+	if v := u.Query().Get("file-mode"); v != "" {
+		mode, err := strconv.ParseUint(v, 8, 32)
+		if err != nil || os.FileMode(mode).Perm() != os.FileMode(mode) {
+			return nil, fmt.Errorf("invalid file-mode value %q", v)
+		}
+		opts.FileMode = os.FileMode(mode)
+	}
+	for param, value := range map[string]*int{"concurrency": &opts.Concurrency, "retries": &opts.Retries} {
+		if v := u.Query().Get(param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid %s value %q", param, v)
+			}
+			*value = n
+		}
+	}
+	// A persistent work directory lets later snapshots
+	// resume from the files synced before
+	tempWorkDir := true
+	if dir := u.Query().Get("workdir"); dir != "" {
+		if err := os.RemoveAll(tmpdir); err != nil {
+			return nil, fmt.Errorf("removing temporary directory: %w", err)
+		}
+		tmpdir = dir
+		tempWorkDir = false
+	}
 	return &GCS{
-		Bucket:  u.Hostname(),
-		Path:    u.Path,
-		WorkDir: tmpdir,
-		client:  client,
+		Bucket:      u.Hostname(),
+		Path:        u.Path,
+		WorkDir:     tmpdir,
+		Options:     opts,
+		client:      client,
+		tempWorkDir: tempWorkDir,
 	}, nil
 }
 
@@ -71,19 +107,129 @@ type GCS struct {
 	Bucket  string
 	Path    string
 	WorkDir string
+	Options GCSOptions
 	client  *storage.Client
+
+	// tempWorkDir is set when WorkDir was created by the
+	// driver and has to be removed when closing it
+	tempWorkDir bool
+}
+
+// Close removes the temporary work directory of the driver, work
+// directories set with the workdir query parameter are kept
+func (gcs *GCS) Close() error {
+	if !gcs.tempWorkDir {
+		return nil
+	}
+	if err := os.RemoveAll(gcs.WorkDir); err != nil {
+		return fmt.Errorf("removing temporary directory: %w", err)
+	}
+	return nil
+}
+
+// SetReadOnlyHashCache stops the driver from saving its hash cache
+func (gcs *GCS) SetReadOnlyHashCache(readOnly bool) {
+	gcs.Options.ReadOnlyHashCache = readOnly
+}
+
+type GCSOptions struct {
+	// Pointers controls the resolution of pointer files, they
+	// can be set with the pointers and pointer-target query
+	// parameters of the spec URL
+	Pointers PointerOptions
+
+	// HashCache is the path of a file caching the digests of the
+	// synced objects (see DirectoryOptions.HashCache). It can be set
+	// with the hash-cache query parameter.
+	HashCache string
+
+	// ReadOnlyHashCache uses the hash cache without saving it
+	// (see DirectoryOptions.ReadOnlyHashCache)
+	ReadOnlyHashCache bool
+
+	// Concurrency is the maximum number of objects downloaded
+	// at the same time (concurrency query parameter)
+	Concurrency int
+
+	// Retries is the number of times a failed download is retried
+	// (retries query parameter). The wait between attempts starts
+	// at RetryBackoff and doubles after each one.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// FileMode is the mode of the synced files (file-mode query
+	// parameter, in octal). Objects with a mode in their metadata
+	// or an executable content type get their own mode instead,
+	// see gcsObjectMode.
+	FileMode os.FileMode
+}
+
+var DefaultGCSOptions = GCSOptions{
+	Concurrency:  8,
+	Retries:      3,
+	RetryBackoff: time.Second,
+	FileMode:     os.FileMode(0o644),
+}
+
+// gcsModeMetadataKeys are the object metadata keys holding the file
+// mode of the object: x-goog-meta-mode and the one gsutil rsync -P
+// records
+var gcsModeMetadataKeys = []string{"mode", "goog-reserved-posix-mode"}
+
+// executableContentTypes are the content types of objects synced
+// as executables when their metadata has no mode
+var executableContentTypes = []string{
+	"application/x-executable",
+	"application/x-elf",
+	"application/x-mach-binary",
+	"application/x-sharedlib",
+	"application/x-sh",
+	"text/x-shellscript",
+}
+
+// gcsObjectMode returns the local file mode of an object. The mode in
+// the object metadata is used when present, otherwise objects with an
+// executable content type get the default mode with the execute bits
+// of its read bits set. A zero default mode is 0644.
+func gcsObjectMode(attrs *storage.ObjectAttrs, defaultMode os.FileMode) os.FileMode {
+	for _, key := range gcsModeMetadataKeys {
+		value, ok := attrs.Metadata[key]
+		if !ok {
+			continue
+		}
+		mode, err := strconv.ParseUint(value, 8, 32)
+		if err != nil {
+			logrus.WithField("driver", "gcs").Warnf("Invalid mode %q in metadata of %s", value, attrs.Name)
+			continue
+		}
+		return os.FileMode(mode).Perm()
+	}
+	if defaultMode == 0 {
+		defaultMode = DefaultGCSOptions.FileMode
+	}
+	contentType, _, _ := strings.Cut(attrs.ContentType, ";")
+	for _, t := range executableContentTypes {
+		if strings.TrimSpace(contentType) == t {
+			return defaultMode | (defaultMode&0o444)>>2
+		}
+	}
+	return defaultMode
 }
 
 // syncGCSPrefix synchs a prefix in the bucket (a directory) and
-// calls itself recursively for internal prefixes
-func (gcs *GCS) syncGCSPrefix(ctx context.Context, prefix string, seen map[string]struct{}) error {
+// calls itself recursively for internal prefixes. The names of the
+// objects synced are recorded in listed.
+func (gcs *GCS) syncGCSPrefix(
+	ctx context.Context, prefix string, seen, listed map[string]struct{},
+) error {
 	logrus.WithField("driver", "gcs").Debugf("Synching bucket prefix %s", prefix)
 	it := gcs.client.Bucket(gcs.Bucket).Objects(ctx, &storage.Query{
 		Delimiter: "/",
 		Prefix:    strings.TrimPrefix(prefix, "/"),
 	})
 	seen[prefix] = struct{}{}
-	filesToSync := []string{}
+	filesToSync := []*storage.ObjectAttrs{}
+	errs := []error{}
 	for {
 		attrs, err := it.Next()
 		if err == iterator.Done {
@@ -91,12 +237,14 @@ func (gcs *GCS) syncGCSPrefix(ctx context.Context, prefix string, seen map[strin
 			break
 		}
 		if err != nil {
-			log.Fatal(err)
+			return fmt.Errorf("listing objects in gs://%s/%s: %w", gcs.Bucket, prefix, err)
 		}
 
 		// If name is empty, then it is a new prefix, lets index it:
 		if _, ok := seen[attrs.Prefix]; !ok && attrs.Name == "" {
-			_ = gcs.syncGCSPrefix(ctx, attrs.Prefix, seen) //nolint: errcheck
+			if err := gcs.syncGCSPrefix(ctx, attrs.Prefix, seen, listed); err != nil {
+				errs = append(errs, err)
+			}
 			continue
 		}
 
@@ -105,7 +253,9 @@ func (gcs *GCS) syncGCSPrefix(ctx context.Context, prefix string, seen map[strin
 		if strings.HasSuffix(attrs.Name, "/") {
 			trimmed := strings.TrimSuffix(attrs.Name, "/")
 			if _, ok := seen[trimmed]; !ok {
-				_ = gcs.syncGCSPrefix(ctx, trimmed, seen) //nolint: errcheck
+				if err := gcs.syncGCSPrefix(ctx, trimmed, seen, listed); err != nil {
+					errs = append(errs, err)
+				}
 				continue
 			}
 		}
@@ -114,66 +264,131 @@ func (gcs *GCS) syncGCSPrefix(ctx context.Context, prefix string, seen map[strin
 		// If we did not catch it before as a directory, then
 		// we need to skip these or the fs sync will not work. It may
 		// be worth saving these and synching them if there is not a
-		// directory with the same name.
-		if attrs.Name != "" && attrs.Size > 0 && attrs.ContentType == "text/plain" {
+		// directory with the same name. Pointer files are text too,
+		// so they are always synced.
+		if attrs.Name != "" && attrs.Size > 0 && attrs.ContentType == "text/plain" &&
+			!gcs.Options.Pointers.matches(attrs.Name) {
 			continue
 		}
 
 		// If there is a name, it is a file
 		if attrs.Name != "" {
-			// TODO: Check file md5 to see if it needs sync
-			filesToSync = append(filesToSync, attrs.Prefix+attrs.Name)
+			filesToSync = append(filesToSync, attrs)
+			listed[attrs.Name] = struct{}{}
 		}
 	}
 
+	// Failed files don't stop the sync of the rest, all
+	// errors are returned together when it finishes
 	var wg errgroup.Group
-	for _, filename := range filesToSync {
-		filename := filename
+	if gcs.Options.Concurrency > 0 {
+		wg.SetLimit(gcs.Options.Concurrency)
+	}
+	var mtx sync.Mutex
+	for _, attrs := range filesToSync {
+		attrs := attrs
 		wg.Go(func() error {
-			if err := gcs.syncGSFile(filename); err != nil {
-				return fmt.Errorf("synching file: %w", err)
+			if err := gcs.syncGSObject(attrs); err != nil {
+				mtx.Lock()
+				errs = append(errs, fmt.Errorf("synching %s: %w", attrs.Name, err))
+				mtx.Unlock()
 			}
 			return nil
 		})
 	}
-	if err := wg.Wait(); err != nil {
-		return fmt.Errorf("synching files: %w", err)
-	}
-	return nil
+	_ = wg.Wait() //nolint: errcheck
+	return errors.Join(errs...)
 }
 
 // syncGSFile copies a file from the bucket to local workdir
 func (gcs *GCS) syncGSFile(filePath string) error {
-	logrus.WithField("driver", "gcs").Debugf("Copying file from bucket: %s", filePath)
-	localpath := filepath.Join(gcs.WorkDir, filePath)
-	// Ensure the directory exists
-	_ = os.MkdirAll(filepath.Dir(localpath), os.FileMode(0o755)) //nolint: errcheck
-
-	// Open the local file
-	f, err := os.OpenFile(localpath, os.O_RDWR|os.O_CREATE, 0o644)
+	attrs, err := readGCSObjectAttributes(gcs.client, fmt.Sprintf("gs://%s/%s", gcs.Bucket, filePath))
 	if err != nil {
-		return fmt.Errorf("opening localfile: %w", err)
+		return fmt.Errorf("reading file attributes: %w", err)
 	}
-	defer f.Close()
+	return gcs.syncGSObject(attrs)
+}
 
-	objectURL := fmt.Sprintf("gs://%s/%s", gcs.Bucket, filePath)
-	if err := downloadGCSObject(gcs.client, objectURL, f); err != nil {
-		return fmt.Errorf("downloading object: %w", err)
+// syncGSObject copies an object to the local workdir. Files already
+// synced (same size and CRC32C) are not downloaded again and failed
+// downloads are retried with exponential backoff.
+func (gcs *GCS) syncGSObject(attrs *storage.ObjectAttrs) error {
+	localpath := filepath.Join(gcs.WorkDir, attrs.Name)
+	if localFileMatches(localpath, attrs) {
+		logrus.WithField("driver", "gcs").Debugf("File %s already synced", attrs.Name)
+	} else {
+		// Ensure the directory exists
+		if err := os.MkdirAll(filepath.Dir(localpath), os.FileMode(0o755)); err != nil {
+			return fmt.Errorf("creating local directory: %w", err)
+		}
+		backoff := gcs.Options.RetryBackoff
+		var err error
+		for attempt := 0; ; attempt++ {
+			if err = gcs.downloadGSObject(attrs, localpath); err == nil {
+				break
+			}
+			if attempt >= gcs.Options.Retries {
+				return err
+			}
+			logrus.WithField("driver", "gcs").Warnf(
+				"Downloading %s failed (attempt %d), retrying in %s: %v", attrs.Name, attempt+1, backoff, err,
+			)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
 	}
 
-	attrs, err := readGCSObjectAttributes(gcs.client, objectURL)
-	if err != nil {
-		return fmt.Errorf("reading file attributes: %w", err)
+	if err := os.Chmod(localpath, gcsObjectMode(attrs, gcs.Options.FileMode)); err != nil {
+		return fmt.Errorf("updating local file mode: %w", err)
 	}
 
 	// Set the local file time to match
-	if err := os.Chtimes(f.Name(), time.Now(), attrs.Updated); err != nil {
+	if err := os.Chtimes(localpath, time.Now(), attrs.Updated); err != nil {
 		return fmt.Errorf("updating local file modification time: %w", err)
 	}
+	return nil
+}
 
+// downloadGSObject copies an object to a local file and checks
+// its CRC32C checksum when the object has one
+func (gcs *GCS) downloadGSObject(attrs *storage.ObjectAttrs, localpath string) error {
+	logrus.WithField("driver", "gcs").Debugf("Copying file from bucket: %s", attrs.Name)
+	f, err := os.OpenFile(localpath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening localfile: %w", err)
+	}
+	defer f.Close()
+
+	hasher := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	objectURL := fmt.Sprintf("gs://%s/%s", gcs.Bucket, attrs.Name)
+	if err := downloadGCSObject(gcs.client, objectURL, io.MultiWriter(f, hasher)); err != nil {
+		return fmt.Errorf("downloading object: %w", err)
+	}
+	if attrs.CRC32C != 0 && hasher.Sum32() != attrs.CRC32C {
+		return fmt.Errorf("crc32c checksum mismatch downloading %s", objectURL)
+	}
 	return nil
 }
 
+// localFileMatches returns true if a file was already synced
+// from the object: it has the same size and CRC32C checksum
+func localFileMatches(localpath string, attrs *storage.ObjectAttrs) bool {
+	info, err := os.Stat(localpath)
+	if err != nil || info.Size() != attrs.Size || attrs.CRC32C == 0 {
+		return false
+	}
+	f, err := os.Open(localpath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	hasher := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	if _, err := io.Copy(hasher, f); err != nil {
+		return false
+	}
+	return hasher.Sum32() == attrs.CRC32C
+}
+
 // Snap takes a snapshot of the directory
 func (gcs *GCS) Snap() (*snapshot.Snapshot, error) {
 	if gcs.Path == "" {
@@ -184,8 +399,9 @@ func (gcs *GCS) Snap() (*snapshot.Snapshot, error) {
 		return nil, fmt.Errorf("gcs store has no bucket defined")
 	}
 
+	listed := map[string]struct{}{}
 	if err := gcs.syncGCSPrefix(
-		context.Background(), strings.TrimPrefix(gcs.Path, "/"), map[string]struct{}{},
+		context.Background(), strings.TrimPrefix(gcs.Path, "/"), map[string]struct{}{}, listed,
 	); err != nil {
 		return nil, fmt.Errorf("synching bucket: %w", err)
 	}
@@ -196,6 +412,14 @@ func (gcs *GCS) Snap() (*snapshot.Snapshot, error) {
 	if err != nil {
 		return nil, fmt.Errorf("creating temp directory store: %w", err)
 	}
+	// The work directory changes on each run, so the files are
+	// cached by their object URL. Synced files get the update time
+	// of the object, so changed objects invalidate their entry.
+	dir.Options.HashCache = gcs.Options.HashCache
+	dir.Options.ReadOnlyHashCache = gcs.Options.ReadOnlyHashCache
+	dir.cacheKey = func(localPath string) string {
+		return "gs://" + filepath.Join(gcs.Bucket, strings.TrimPrefix(localPath, gcs.WorkDir))
+	}
 	snapDir, err := dir.Snap()
 	if err != nil {
 		return nil, fmt.Errorf("snapshotting work directory: %w", err)
@@ -203,10 +427,71 @@ func (gcs *GCS) Snap() (*snapshot.Snapshot, error) {
 	snap := snapshot.Snapshot{}
 
 	for _, a := range *snapDir {
+		// A persistent work directory keeps the files of objects
+		// deleted since, only the objects in the listing are current
+		name := strings.TrimPrefix(strings.TrimPrefix(a.Path, gcs.WorkDir), "/")
+		if _, ok := listed[name]; !ok {
+			logrus.WithField("driver", "gcs").Debugf("Skipping %s, the object is no longer in the bucket", name)
+			continue
+		}
 		path := "gs://" + filepath.Join(gcs.Bucket, strings.TrimPrefix(a.Path, gcs.WorkDir))
+		if gcs.Options.Pointers.matches(a.Path) {
+			artifact, err := gcs.resolvePointer(path, filepath.Join(gcs.WorkDir, a.Path))
+			if err != nil {
+				return nil, fmt.Errorf("resolving pointer %s: %w", path, err)
+			}
+			snap[artifact.Path] = *artifact
+			continue
+		}
 		a.Path = path
 		// Perhaps we should null the artifact dates
 		snap[path] = a
 	}
 	return &snap, nil
 }
+
+// resolvePointer reads the synced copy of a pointer file and
+// returns the artifact it points to
+func (gcs *GCS) resolvePointer(pointerURL, localPath string) (*run.Artifact, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("opening pointer: %w", err)
+	}
+	contents, err := io.ReadAll(io.LimitReader(f, maxPointerSize+1))
+	f.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading pointer: %w", err)
+	}
+	target, err := gcs.Options.Pointers.target(contents)
+	if err != nil {
+		return nil, err
+	}
+	targetURL, err := resolvePointerURL(pointerURL, target)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := os.CreateTemp("", "pointer-target-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := downloadGCSObject(gcs.client, targetURL, tmp); err != nil {
+		return nil, fmt.Errorf("downloading pointer target: %w", err)
+	}
+	attrs, err := readGCSObjectAttributes(gcs.client, targetURL)
+	if err != nil {
+		return nil, fmt.Errorf("reading pointer target attributes: %w", err)
+	}
+	checksums, err := hashFile(tmp.Name(), DefaultDirectoryOptions.Algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("hashing %s: %w", targetURL, err)
+	}
+	return &run.Artifact{
+		Path:        targetURL,
+		Checksum:    checksums,
+		Time:        attrs.Updated,
+		Annotations: map[string]string{run.AnnotationPointer: pointerURL},
+	}, nil
+}
@@ -17,9 +17,19 @@ limitations under the License.
 package driver
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/storage"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/api/option"
 )
 
 func TestGCSSnap(t *testing.T) {
@@ -38,3 +48,180 @@ func TestSyncGSFile(t *testing.T) {
 	require.NoError(t, err)
 	require.NoError(t, gcs.syncGSFile("release/v1.24.4/bin/windows/386/kubectl.exe.sha256"))
 }
+
+func TestGCSSnapListError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"error":{"code":403,"message":"denied"}}`)) //nolint: errcheck
+	}))
+	defer server.Close()
+
+	client, err := storage.NewClient(
+		context.Background(),
+		option.WithEndpoint(server.URL+"/storage/v1/"),
+		option.WithoutAuthentication(),
+	)
+	require.NoError(t, err)
+
+	// Listing errors are returned instead of exiting
+	gcs := &GCS{Bucket: "bucket", Path: "/release/", WorkDir: t.TempDir(), client: client}
+	_, err = gcs.Snap()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "denied")
+}
+
+// Listing of gs://bucket/release/, the CRC32C values are
+// those of the contents served by newFakeGCSServer
+const testGCSListing = `{"items":[
+  {"name":"release/a.txt","bucket":"bucket","size":"5","crc32c":"mnG7TA==","updated":"2024-05-01T10:00:00Z"},
+  {"name":"release/b.txt","bucket":"bucket","size":"3","crc32c":"bPKCjA==","updated":"2024-05-01T10:00:00Z","metadata":{"mode":"0755"}}
+]}`
+
+// newFakeGCSServer serves the test listing. The first download of
+// a.txt is corrupted and b.txt fails while brokenB is true. It
+// returns the number of downloads of each object.
+func newFakeGCSServer(t *testing.T, brokenB *bool) (*storage.Client, map[string]int) {
+	var mtx sync.Mutex
+	downloads := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		switch r.URL.Path {
+		case "/storage/v1/b/bucket/o":
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, testGCSListing)
+		case "/bucket/release/a.txt":
+			downloads["a.txt"]++
+			if downloads["a.txt"] == 1 {
+				fmt.Fprint(w, "hellx")
+				return
+			}
+			fmt.Fprint(w, "hello")
+		case "/bucket/release/b.txt":
+			downloads["b.txt"]++
+			if *brokenB {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, "bye")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := storage.NewClient(
+		context.Background(),
+		option.WithEndpoint(server.URL+"/storage/v1/"),
+		option.WithoutAuthentication(),
+	)
+	require.NoError(t, err)
+	return client, downloads
+}
+
+func TestGCSSyncRetryResume(t *testing.T) {
+	brokenB := true
+	client, downloads := newFakeGCSServer(t, &brokenB)
+	opts := DefaultGCSOptions
+	opts.RetryBackoff = time.Millisecond
+	opts.Retries = 2
+	gcs := &GCS{Bucket: "bucket", Path: "/release/", WorkDir: t.TempDir(), client: client, Options: opts}
+
+	// The corrupted download of a.txt is retried while b.txt fails
+	// after all its attempts without stopping the rest of the sync
+	_, err := gcs.Snap()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "release/b.txt")
+	require.Equal(t, 2, downloads["a.txt"])
+	require.Equal(t, 3, downloads["b.txt"])
+	data, err := os.ReadFile(filepath.Join(gcs.WorkDir, "release", "a.txt"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(data))
+
+	// Resuming only downloads the missing file
+	brokenB = false
+	snap, err := gcs.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+	require.Equal(t, 2, downloads["a.txt"])
+	require.Equal(t, 4, downloads["b.txt"])
+	require.Equal(t, time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), (*snap)["gs://bucket/release/a.txt"].Time.UTC())
+}
+
+func TestGCSSnapStaleWorkDir(t *testing.T) {
+	brokenB := false
+	client, _ := newFakeGCSServer(t, &brokenB)
+	opts := DefaultGCSOptions
+	opts.RetryBackoff = time.Millisecond
+	gcs := &GCS{Bucket: "bucket", Path: "/release/", WorkDir: t.TempDir(), client: client, Options: opts}
+
+	// Files of objects no longer in the bucket are not reported
+	require.NoError(t, os.MkdirAll(filepath.Join(gcs.WorkDir, "release"), os.FileMode(0o755)))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(gcs.WorkDir, "release", "deleted.txt"), []byte("old"), os.FileMode(0o644),
+	))
+	snap, err := gcs.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+	require.NotContains(t, *snap, "gs://bucket/release/deleted.txt")
+	require.Contains(t, *snap, "gs://bucket/release/a.txt")
+}
+
+func TestGCSSyncFileMode(t *testing.T) {
+	brokenB := false
+	client, _ := newFakeGCSServer(t, &brokenB)
+	opts := DefaultGCSOptions
+	opts.RetryBackoff = time.Millisecond
+	gcs := &GCS{Bucket: "bucket", Path: "/release/", WorkDir: t.TempDir(), client: client, Options: opts}
+	_, err := gcs.Snap()
+	require.NoError(t, err)
+
+	// b.txt is marked executable in its metadata
+	mode := func(name string) os.FileMode {
+		info, err := os.Stat(filepath.Join(gcs.WorkDir, "release", name))
+		require.NoError(t, err)
+		return info.Mode().Perm()
+	}
+	require.Equal(t, os.FileMode(0o644), mode("a.txt"))
+	require.Equal(t, os.FileMode(0o755), mode("b.txt"))
+
+	// Files already synced get the configured mode too
+	gcs.Options.FileMode = os.FileMode(0o600)
+	_, err = gcs.Snap()
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), mode("a.txt"))
+	require.Equal(t, os.FileMode(0o755), mode("b.txt"))
+}
+
+func TestGCSObjectMode(t *testing.T) {
+	for _, tc := range []struct {
+		attrs    storage.ObjectAttrs
+		mode     os.FileMode
+		expected os.FileMode
+	}{
+		{storage.ObjectAttrs{}, 0o644, 0o644},
+		{storage.ObjectAttrs{}, 0, 0o644},
+		{storage.ObjectAttrs{Metadata: map[string]string{"mode": "0750"}}, 0o644, 0o750},
+		{storage.ObjectAttrs{Metadata: map[string]string{"goog-reserved-posix-mode": "100755"}}, 0o644, 0o755},
+		{storage.ObjectAttrs{Metadata: map[string]string{"mode": "rwx"}}, 0o644, 0o644},
+		{storage.ObjectAttrs{ContentType: "application/x-executable"}, 0o644, 0o755},
+		{storage.ObjectAttrs{ContentType: "text/x-shellscript; charset=utf-8"}, 0o640, 0o750},
+		{storage.ObjectAttrs{ContentType: "application/octet-stream"}, 0o644, 0o644},
+	} {
+		require.Equal(t, tc.expected, gcsObjectMode(&tc.attrs, tc.mode), "%+v", tc.attrs)
+	}
+}
+
+func TestGCSClose(t *testing.T) {
+	// Temporary work directories are removed
+	gcs := &GCS{WorkDir: filepath.Join(t.TempDir(), "tejolote-gcs"), tempWorkDir: true}
+	require.NoError(t, os.Mkdir(gcs.WorkDir, os.FileMode(0o755)))
+	require.NoError(t, gcs.Close())
+	require.NoDirExists(t, gcs.WorkDir)
+
+	// the ones set by the user are kept
+	gcs = &GCS{WorkDir: t.TempDir()}
+	require.NoError(t, gcs.Close())
+	require.DirExists(t, gcs.WorkDir)
+}
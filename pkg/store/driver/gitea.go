@@ -0,0 +1,271 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/gitea"
+	"sigs.k8s.io/tejolote/pkg/github"
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+// GiteaActions is a store driver that reads the artifacts uploaded
+// by a Gitea or Forgejo Actions run, their API mimics GitHub's
+type GiteaActions struct {
+	Host         string
+	Organization string
+	Repository   string
+	RunID        int64
+	apiURL       string
+}
+
+// GiteaRelease is a store driver that reads the files
+// attached to a release in Gitea or Forgejo
+type GiteaRelease struct {
+	Host       string
+	Owner      string
+	Repository string
+	Tag        string
+	Options    GiteaReleaseOptions
+	apiURL     string
+}
+
+type GiteaReleaseOptions struct {
+	IgnoreExtensions []string
+
+	// SourceArchives adds the source code archives generated
+	// for the release, annotated with the tag's commit
+	SourceArchives bool
+
+	// MaxArtifactSize is the maximum size in bytes of the
+	// attachments downloaded. Zero means no limit.
+	MaxArtifactSize int64
+}
+
+var DefaultGiteaReleaseOptions = GiteaReleaseOptions{
+	IgnoreExtensions: DefaultGitHubReleaseOptions.IgnoreExtensions,
+	SourceArchives:   true,
+}
+
+// parseGiteaURL splits a spec URL of the form scheme://host/owner/repo/id
+func parseGiteaURL(specURL, scheme string) (host, owner, repo, id string, err error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return "", "", "", "", fmt.Errorf("parsing %s spec url: %w", scheme, err)
+	}
+	if u.Scheme != scheme {
+		return "", "", "", "", fmt.Errorf("spec url is not a %s url", scheme)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return "", "", "", "", fmt.Errorf("%s url must have the form %s://host/owner/repo/id", scheme, scheme)
+	}
+	return u.Host, parts[0], parts[1], parts[2], nil
+}
+
+// NewGiteaActions returns a driver for the artifacts of a
+// run specified as gitea-actions://host/org/repo/runID
+func NewGiteaActions(specURL string) (*GiteaActions, error) {
+	host, org, repo, id, err := parseGiteaURL(specURL, "gitea-actions")
+	if err != nil {
+		return nil, err
+	}
+	runID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("parsing run ID from %s: %w", specURL, err)
+	}
+	logrus.WithField("store", specURL).Info("Initialized new Gitea Actions storage backend")
+	return &GiteaActions{
+		Host:         host,
+		Organization: org,
+		Repository:   repo,
+		RunID:        runID,
+		apiURL:       gitea.APIURL(host),
+	}, nil
+}
+
+// Snap downloads the run artifacts and hashes them as they are streamed
+func (ga *GiteaActions) Snap() (*snapshot.Snapshot, error) {
+	artifactsURL := fmt.Sprintf(actionsArtifactsURL, ga.apiURL, ga.Organization, ga.Repository, ga.RunID)
+	artifacts := struct {
+		Artifacts []github.Artifact `json:"artifacts"`
+	}{}
+	if err := gitea.APIGet(artifactsURL, &artifacts); err != nil {
+		return nil, fmt.Errorf("listing run artifacts: %w", err)
+	}
+
+	snap := snapshot.Snapshot{}
+	for _, a := range artifacts.Artifacts {
+		if a.Expired {
+			logrus.Warnf("artifact %s of run %d has expired", a.Name, ga.RunID)
+			continue
+		}
+		checksums, err := hashDownload(func(w io.Writer) error {
+			return gitea.DownloadWithOptions(a.URL, w, httpclient.CopyOptions{AllowEmpty: a.Size == 0})
+		}, []string{"SHA256"})
+		if err != nil {
+			return nil, fmt.Errorf("downloading artifact from %s: %w", a.URL, err)
+		}
+		path := artifactsURL + "/" + a.Name
+		snap[path] = run.Artifact{
+			Path:     path,
+			Checksum: checksums,
+			Time:     a.UpdatedAt,
+		}
+	}
+	return &snap, nil
+}
+
+// NewGiteaRelease returns a driver for the files of a
+// release specified as gitea-release://host/owner/repo/tag
+func NewGiteaRelease(specURL string) (*GiteaRelease, error) {
+	host, owner, repo, tag, err := parseGiteaURL(specURL, "gitea-release")
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing gitea release spec url: %w", err)
+	}
+	gr := &GiteaRelease{
+		Host:       host,
+		Owner:      owner,
+		Repository: repo,
+		Tag:        tag,
+		Options:    DefaultGiteaReleaseOptions,
+		apiURL:     gitea.APIURL(host),
+	}
+	gr.Options.MaxArtifactSize, err = maxArtifactSizeFromQuery(u.Query())
+	if err != nil {
+		return nil, err
+	}
+	logrus.WithField("store", specURL).Info("Initialized new Gitea release storage backend")
+	return gr, nil
+}
+
+// repoURL returns the API URL of the repository
+func (gr *GiteaRelease) repoURL() string {
+	return fmt.Sprintf("%s/repos/%s/%s", gr.apiURL, url.PathEscape(gr.Owner), url.PathEscape(gr.Repository))
+}
+
+// Snap downloads the release attachments and hashes them
+func (gr *GiteaRelease) Snap() (*snapshot.Snapshot, error) {
+	release := &gitea.Release{}
+	if err := gitea.APIGet(gr.repoURL()+"/releases/tags/"+url.PathEscape(gr.Tag), release); err != nil {
+		return nil, fmt.Errorf("getting release from tag %s: %w", gr.Tag, err)
+	}
+
+	snap := snapshot.Snapshot{}
+	for _, a := range release.Attachments {
+		if gr.isIgnored(a.Name) {
+			continue
+		}
+		maxSize := gr.Options.MaxArtifactSize
+		if maxSize > 0 && a.Size > maxSize {
+			return nil, fmt.Errorf("release attachment %s: %w of %d bytes", a.Name, ErrArtifactTooLarge, maxSize)
+		}
+		checksums, err := gr.hashDownload(a.DownloadURL, a.Size == 0)
+		if err != nil {
+			return nil, fmt.Errorf("hashing release attachment %s: %w", a.Name, err)
+		}
+		snap[a.Name] = run.Artifact{
+			Path:     a.Name,
+			Checksum: checksums,
+			Time:     a.CreatedAt,
+		}
+	}
+
+	if gr.Options.SourceArchives {
+		if err := gr.addSourceArchives(release, snap); err != nil {
+			return nil, fmt.Errorf("adding source archives: %w", err)
+		}
+	}
+	return &snap, nil
+}
+
+// hashDownload hashes a file as it is downloaded, aborting
+// downloads larger than the maximum artifact size. Empty downloads
+// fail unless allowEmpty is set.
+func (gr *GiteaRelease) hashDownload(downloadURL string, allowEmpty bool) (map[string]string, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(gitea.DownloadWithOptions(
+			downloadURL, pw, httpclient.CopyOptions{AllowEmpty: allowEmpty},
+		))
+	}()
+	body := newSizeCappedReader(pr, gr.Options.MaxArtifactSize, nil)
+	defer body.Close()
+	return hashReader(body, []string{"SHA256"})
+}
+
+// addSourceArchives hashes the source archives of the release and
+// adds them to the snapshot annotated with the commit of the tag
+func (gr *GiteaRelease) addSourceArchives(release *gitea.Release, snap snapshot.Snapshot) error {
+	archives := map[string]string{}
+	if release.ZipballURL != "" {
+		archives["zip"] = release.ZipballURL
+	}
+	if release.TarballURL != "" {
+		archives["tar.gz"] = release.TarballURL
+	}
+	if len(archives) == 0 {
+		return nil
+	}
+
+	tag := struct {
+		Commit gitea.Commit `json:"commit"`
+	}{}
+	if err := gitea.APIGet(gr.repoURL()+"/tags/"+url.PathEscape(gr.Tag), &tag); err != nil {
+		return fmt.Errorf("getting commit of tag %s: %w", gr.Tag, err)
+	}
+	if tag.Commit.SHA == "" {
+		return errors.New("tag has no commit")
+	}
+
+	for format, archiveURL := range archives {
+		checksums, err := gr.hashDownload(archiveURL, false)
+		if err != nil {
+			return fmt.Errorf("hashing %s source archive: %w", format, err)
+		}
+		name := fmt.Sprintf("%s-%s.%s", gr.Repository, gr.Tag, format)
+		snap[name] = run.Artifact{
+			Path:     name,
+			Checksum: checksums,
+			Annotations: map[string]string{
+				run.AnnotationSourceArchive: format,
+				run.AnnotationSourceURI:     fmt.Sprintf("git+https://%s/%s/%s", gr.Host, gr.Owner, gr.Repository),
+				run.AnnotationSourceCommit:  tag.Commit.SHA,
+			},
+		}
+	}
+	return nil
+}
+
+// isIgnored returns true if the attachment has one of the ignored extensions
+func (gr *GiteaRelease) isIgnored(name string) bool {
+	return !extensionFilter(gr.Options.IgnoreExtensions).Matches(name)
+}
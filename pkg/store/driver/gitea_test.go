@@ -0,0 +1,168 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/gitea"
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// Recorded from /api/v1/repos/octo-org/app/actions/runs/42/artifacts,
+// the server URL is replaced in the test
+const giteaArtifactsResponse = `{
+  "artifacts": [
+    {
+      "id": 5, "name": "binaries", "size_in_bytes": 4,
+      "url": "%[1]s/api/v1/repos/octo-org/app/actions/artifacts/5",
+      "archive_download_url": "%[1]s/api/v1/repos/octo-org/app/actions/artifacts/5/zip",
+      "expired": false,
+      "created_at": "2024-05-01T10:04:00Z", "updated_at": "2024-05-01T10:04:00Z"
+    },
+    {
+      "id": 4, "name": "old", "size_in_bytes": 4,
+      "archive_download_url": "%[1]s/api/v1/repos/octo-org/app/actions/artifacts/4/zip",
+      "expired": true,
+      "created_at": "2024-01-01T10:04:00Z", "updated_at": "2024-01-01T10:04:00Z"
+    }
+  ],
+  "total_count": 2
+}`
+
+// Recorded from /api/v1/repos/octo-org/app/releases/tags/v1.0.0
+const giteaReleaseResponse = `{
+  "id": 12,
+  "tag_name": "v1.0.0",
+  "target_commitish": "main",
+  "name": "v1.0.0",
+  "zipball_url": "%[1]s/octo-org/app/archive/v1.0.0.zip",
+  "tarball_url": "%[1]s/octo-org/app/archive/v1.0.0.tar.gz",
+  "assets": [
+    {
+      "id": 30, "name": "app-linux-amd64", "size": 4, "download_count": 0,
+      "created_at": "2024-05-01T10:05:00Z",
+      "uuid": "9a7d3c1e-0000-4000-8000-000000000000",
+      "browser_download_url": "%[1]s/octo-org/app/releases/download/v1.0.0/app-linux-amd64"
+    },
+    {
+      "id": 31, "name": "app-linux-amd64.sig", "size": 4, "download_count": 0,
+      "created_at": "2024-05-01T10:05:00Z",
+      "uuid": "9a7d3c1e-0000-4000-8000-000000000001",
+      "browser_download_url": "%[1]s/octo-org/app/releases/download/v1.0.0/app-linux-amd64.sig"
+    }
+  ]
+}`
+
+// Recorded from /api/v1/repos/octo-org/app/tags/v1.0.0
+const giteaTagResponse = `{
+  "name": "v1.0.0",
+  "message": "v1.0.0",
+  "id": "5e1c3f9d2b7a4c6e8f0a1b2c3d4e5f60718293a4",
+  "commit": {
+    "url": "https://gitea.example.com/api/v1/repos/octo-org/app/git/commits/3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d",
+    "sha": "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d"
+  }
+}`
+
+// sha256 of "test"
+const giteaTestDigest = "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+func newGiteaServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token gitea-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/api/v1/repos/octo-org/app/actions/runs/42/artifacts":
+			fmt.Fprintf(w, giteaArtifactsResponse, server.URL)
+		case r.URL.Path == "/api/v1/repos/octo-org/app/releases/tags/v1.0.0":
+			fmt.Fprintf(w, giteaReleaseResponse, server.URL)
+		case r.URL.Path == "/api/v1/repos/octo-org/app/tags/v1.0.0":
+			fmt.Fprint(w, giteaTagResponse)
+		case r.URL.Path == "/api/v1/repos/octo-org/app/actions/artifacts/5/zip",
+			strings.HasPrefix(r.URL.Path, "/octo-org/app/"):
+			fmt.Fprint(w, "test")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGiteaActionsSnap(t *testing.T) {
+	t.Setenv(gitea.TokenEnvVar, "gitea-test")
+	server := newGiteaServer(t)
+
+	ga, err := NewGiteaActions("gitea-actions://gitea.example.com/octo-org/app/42")
+	require.NoError(t, err)
+	require.Equal(t, "https://gitea.example.com/api/v1", ga.apiURL)
+	ga.apiURL = server.URL + "/api/v1"
+
+	snap, err := ga.Snap()
+	require.NoError(t, err)
+
+	// Expired artifacts cannot be downloaded and are skipped
+	require.Len(t, *snap, 1)
+	path := server.URL + "/api/v1/repos/octo-org/app/actions/runs/42/artifacts/binaries"
+	require.Equal(t, giteaTestDigest, (*snap)[path].Checksum["SHA256"])
+
+	for _, u := range []string{
+		"gitea-actions://gitea.example.com/octo-org/app",
+		"gitea-actions://gitea.example.com/octo-org/app/latest",
+		"actions://gitea.example.com/octo-org/app/42",
+	} {
+		_, err := NewGiteaActions(u)
+		require.Error(t, err, u)
+	}
+}
+
+func TestGiteaReleaseSnap(t *testing.T) {
+	t.Setenv(gitea.TokenEnvVar, "gitea-test")
+	server := newGiteaServer(t)
+
+	gr, err := NewGiteaRelease("gitea-release://gitea.example.com/octo-org/app/v1.0.0")
+	require.NoError(t, err)
+	gr.apiURL = server.URL + "/api/v1"
+
+	snap, err := gr.Snap()
+	require.NoError(t, err)
+
+	// Signatures are ignored, the source archives are added
+	require.Len(t, *snap, 3)
+	require.Equal(t, giteaTestDigest, (*snap)["app-linux-amd64"].Checksum["SHA256"])
+	archive := (*snap)["app-v1.0.0.tar.gz"]
+	require.Equal(t, giteaTestDigest, archive.Checksum["SHA256"])
+	require.Equal(t, "git+https://gitea.example.com/octo-org/app", archive.Annotations[run.AnnotationSourceURI])
+	require.Equal(t, "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d", archive.Annotations[run.AnnotationSourceCommit])
+
+	// Attachments over the size limit are not downloaded
+	gr, err = NewGiteaRelease("gitea-release://gitea.example.com/octo-org/app/v1.0.0?max-artifact-size=2")
+	require.NoError(t, err)
+	gr.apiURL = server.URL + "/api/v1"
+	_, err = gr.Snap()
+	require.ErrorIs(t, err, ErrArtifactTooLarge)
+}
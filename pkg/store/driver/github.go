@@ -17,23 +17,27 @@ limitations under the License.
 package driver
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"io/fs"
+	"io"
 	"net/url"
-	"os"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
-	"time"
 
+	"golang.org/x/sync/errgroup"
 	"sigs.k8s.io/release-sdk/github"
-	"sigs.k8s.io/release-utils/hash"
+
+	ghapi "sigs.k8s.io/tejolote/pkg/github"
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
 )
 
 type GitHubRelease struct {
+	// Host is the GitHub server hosting the repository, it is
+	// derived from the GITHUB_API_URL environment variable
+	Host       string
 	Owner      string
 	Repository string
 	Tag        string
@@ -43,10 +47,22 @@ type GitHubRelease struct {
 
 type GitHubReleaseOptions struct {
 	IgnoreExtensions []string
+	Concurrency      int // Maximum number of assets downloaded at a time
+
+	// SourceArchives adds the source code archives generated by
+	// GitHub for the release, annotated with the tag's commit. It
+	// is set with the source-archives query parameter.
+	SourceArchives bool
+
+	// MaxArtifactSize is the maximum size in bytes of the assets
+	// downloaded, larger downloads are aborted as soon as the limit
+	// is crossed. Zero means no limit.
+	MaxArtifactSize int64
 }
 
 var DefaultGitHubReleaseOptions = GitHubReleaseOptions{
 	IgnoreExtensions: []string{".pem", ".sig", ".cert"},
+	Concurrency:      4,
 }
 
 func NewGithub(specURL string) (*GitHubRelease, error) {
@@ -65,64 +81,172 @@ func NewGithub(specURL string) (*GitHubRelease, error) {
 		return nil, fmt.Errorf("unable to find repo/tag in %s", u.Path)
 	}
 
+	// Releases in GitHub Enterprise Server are read from the
+	// API set in GITHUB_API_URL
+	host := ghapi.HostForAPIURL(ghapi.APIURL())
+	var gh *github.GitHub
+	if host == ghapi.DefaultHost {
+		gh, err = github.NewWithToken(ghapi.Token(host))
+	} else {
+		gh, err = github.NewEnterpriseWithToken(ghapi.APIURL(), ghapi.APIURL(), ghapi.Token(host))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("creating github client: %w", err)
+	}
 	ghr := &GitHubRelease{
+		Host:       host,
 		Owner:      u.Hostname(),
 		Repository: parts[0],
 		Tag:        parts[1],
 		Options:    DefaultGitHubReleaseOptions,
-		gh:         github.New(),
+		gh:         gh,
+	}
+	ghr.Options.MaxArtifactSize, err = maxArtifactSizeFromQuery(u.Query())
+	if err != nil {
+		return nil, err
+	}
+	if archives := u.Query().Get("source-archives"); archives != "" {
+		ghr.Options.SourceArchives, err = strconv.ParseBool(archives)
+		if err != nil {
+			return nil, fmt.Errorf("parsing source-archives option: %w", err)
+		}
 	}
 
 	return ghr, nil
 }
 
+// Snap downloads the release assets and hashes them as they are
+// streamed, no more than Options.Concurrency assets are fetched at a time
 func (ghr *GitHubRelease) Snap() (*snapshot.Snapshot, error) {
-	// Download assets to temporary directory
-	tmp, err := os.MkdirTemp("", "github-assets-")
+	ctx := context.Background()
+	release, _, err := ghr.gh.Client().GetReleaseByTag(
+		ctx, ghr.Owner, ghr.Repository, ghr.Tag,
+	)
 	if err != nil {
-		return nil, fmt.Errorf("creating temp dir: %w", err)
+		return nil, fmt.Errorf("getting release from tag %s: %w", ghr.Tag, err)
 	}
-	defer os.RemoveAll(tmp)
 
-	if err := ghr.gh.DownloadReleaseAssets(
-		ghr.Owner, ghr.Repository, []string{ghr.Tag}, tmp,
-	); err != nil {
-		return nil, fmt.Errorf("downloading release assets: %w", err)
+	assets, err := ghr.gh.ListReleaseAssets(ghr.Owner, ghr.Repository, release.GetID())
+	if err != nil {
+		return nil, fmt.Errorf("listing release assets: %w", err)
+	}
+
+	concurrency := ghr.Options.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
 	}
 
-	// Hash EVERYTHING
 	snap := snapshot.Snapshot{}
 	var mtx sync.Mutex
-	if err := filepath.WalkDir(tmp, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
+	var wg errgroup.Group
+	wg.SetLimit(concurrency)
+	for _, asset := range assets {
+		asset := asset
+		if ghr.isIgnored(asset.GetName()) {
+			continue
 		}
-		if d.IsDir() {
+		wg.Go(func() error {
+			maxSize := ghr.Options.MaxArtifactSize
+			if maxSize > 0 && int64(asset.GetSize()) > maxSize {
+				return fmt.Errorf("release asset %s: %w of %d bytes", asset.GetName(), ErrArtifactTooLarge, maxSize)
+			}
+			assetCtx, cancel := context.WithCancel(ctx)
+			rawBody, _, err := ghr.gh.Client().DownloadReleaseAsset(
+				assetCtx, ghr.Owner, ghr.Repository, asset.GetID(),
+			)
+			if err != nil {
+				cancel()
+				return fmt.Errorf("downloading release asset %s: %w", asset.GetName(), err)
+			}
+			body := newSizeCappedReader(rawBody, maxSize, cancel)
+			defer body.Close()
+
+			checksums, err := hashReader(body, []string{"SHA256"})
+			if err != nil {
+				return fmt.Errorf("hashing artifact: %w", err)
+			}
+
+			mtx.Lock()
+			snap[asset.GetName()] = run.Artifact{
+				Path:     asset.GetName(),
+				Checksum: checksums,
+				Time:     asset.GetUpdatedAt().Time,
+			}
+			mtx.Unlock()
 			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, fmt.Errorf("hashing release assets: %w", err)
+	}
+
+	if ghr.Options.SourceArchives {
+		if err := ghr.addSourceArchives(ctx, release.GetZipballURL(), release.GetTarballURL(), snap); err != nil {
+			return nil, fmt.Errorf("adding source archives: %w", err)
 		}
-		hashValue, err := hash.SHA256ForFile(path)
-		if err != nil {
-			return fmt.Errorf("hashing artifact: %w", err)
-		}
+	}
+	return &snap, nil
+}
 
-		for _, ext := range ghr.Options.IgnoreExtensions {
-			if strings.HasSuffix(path, ext) {
-				return nil
-			}
+// addSourceArchives hashes the zip and tar.gz archives that GitHub
+// generates for the release and adds them to the snapshot annotated
+// with the commit the release tag points to.
+func (ghr *GitHubRelease) addSourceArchives(ctx context.Context, zipballURL, tarballURL string, snap snapshot.Snapshot) error {
+	archives := map[string]string{}
+	if zipballURL != "" {
+		archives["zip"] = zipballURL
+	}
+	if tarballURL != "" {
+		archives["tar.gz"] = tarballURL
+	}
+	if len(archives) == 0 {
+		return nil
+	}
+
+	commit, _, err := ghr.gh.Client().GetRepoCommit(ctx, ghr.Owner, ghr.Repository, ghr.Tag)
+	if err != nil {
+		return fmt.Errorf("getting commit of tag %s: %w", ghr.Tag, err)
+	}
+
+	for format, archiveURL := range archives {
+		// Closing the reader makes the download fail on its next
+		// write, so oversized archives are not read to the end
+		pr, pw := io.Pipe()
+		go func() {
+			pw.CloseWithError(ghapi.Download(archiveURL, pw))
+		}()
+		body := newSizeCappedReader(pr, ghr.Options.MaxArtifactSize, nil)
+		checksums, err := hashReader(body, []string{"SHA256"})
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("hashing %s source archive: %w", format, err)
 		}
 
-		mtx.Lock()
-		snap[filepath.Base(path)] = run.Artifact{
-			Path: filepath.Base(path),
-			Checksum: map[string]string{
-				"SHA256": hashValue,
+		name := fmt.Sprintf("%s-%s.%s", ghr.Repository, ghr.Tag, format)
+		snap[name] = run.Artifact{
+			Path:     name,
+			Checksum: checksums,
+			Annotations: map[string]string{
+				run.AnnotationSourceArchive: format,
+				run.AnnotationSourceURI:     fmt.Sprintf("git+https://%s/%s/%s", ghr.Host, ghr.Owner, ghr.Repository),
+				run.AnnotationSourceCommit:  commit.GetSHA(),
 			},
-			Time: time.Now(), // TODO: This needs to be set properly for future
 		}
-		mtx.Unlock()
-		return nil
-	}); err != nil {
-		return nil, fmt.Errorf("walking path: %w", err)
 	}
-	return &snap, nil
+	return nil
+}
+
+// isIgnored returns true if the asset has one of the ignored extensions
+func (ghr *GitHubRelease) isIgnored(name string) bool {
+	return !extensionFilter(ghr.Options.IgnoreExtensions).Matches(name)
+}
+
+// extensionFilter returns a path filter that excludes the files
+// with any of the extensions
+func extensionFilter(extensions []string) *run.PathFilter {
+	f := &run.PathFilter{}
+	for _, ext := range extensions {
+		f.Exclude = append(f.Exclude, "*"+ext)
+	}
+	return f
 }
@@ -17,10 +17,19 @@ limitations under the License.
 package driver
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/release-sdk/github"
+
+	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
 )
 
@@ -38,3 +47,113 @@ func TestGitHubRelease(t *testing.T) {
 		(*snap)["sbom.spdx"].Checksum["SHA256"],
 	)
 }
+
+func TestGitHubReleaseConcurrency(t *testing.T) {
+	const numAssets = 20
+	var running, maxRunning int32
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/org/repo/releases/tags/v1.0.0", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"id": 1, "tag_name": "v1.0.0"}`)
+	})
+	mux.HandleFunc("/api/v3/repos/org/repo/releases/1/assets", func(w http.ResponseWriter, _ *http.Request) {
+		assets := []string{`{"id": 100, "name": "test.sig"}`}
+		for i := 1; i <= numAssets; i++ {
+			assets = append(assets, fmt.Sprintf(
+				`{"id": %d, "name": "asset-%d.txt", "updated_at": "2023-01-01T00:00:00Z"}`, i, i,
+			))
+		}
+		fmt.Fprintf(w, "[%s]", strings.Join(assets, ","))
+	})
+	mux.HandleFunc("/api/v3/repos/org/repo/releases/assets/", func(w http.ResponseWriter, _ *http.Request) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		fmt.Fprint(w, "test")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	gh, err := github.NewEnterpriseWithToken(server.URL, server.URL, "")
+	require.NoError(t, err)
+
+	sut, err := NewGithub("github://org/repo/v1.0.0")
+	require.NoError(t, err)
+	sut.gh = gh
+	sut.Options.Concurrency = 3
+
+	snap, err := sut.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, numAssets)
+	require.NotContains(t, *snap, "test.sig")
+	require.Equal(
+		t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		(*snap)["asset-1.txt"].Checksum["SHA256"],
+	)
+	require.LessOrEqual(t, atomic.LoadInt32(&maxRunning), int32(3))
+}
+
+func TestGitHubReleaseSourceArchives(t *testing.T) {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/repos/org/repo/releases/tags/v1.0.0", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w,
+			`{"id": 1, "tag_name": "v1.0.0", "zipball_url": "%[1]s/archive/v1.0.0.zip", "tarball_url": "%[1]s/archive/v1.0.0.tar.gz"}`,
+			server.URL,
+		)
+	})
+	mux.HandleFunc("/api/v3/repos/org/repo/releases/1/assets", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `[{"id": 10, "name": "app.txt"}]`)
+	})
+	mux.HandleFunc("/api/v3/repos/org/repo/releases/assets/10", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "app")
+	})
+	mux.HandleFunc("/api/v3/repos/org/repo/commits/v1.0.0", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, `{"sha": "009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d"}`)
+	})
+	mux.HandleFunc("/archive/", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "test")
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	// The release is read from the GitHub Enterprise Server API
+	// set in the environment, its archives point to the server
+	t.Setenv("GITHUB_API_URL", server.URL+"/api/v3")
+	host := strings.TrimPrefix(server.URL, "http://")
+	sut, err := NewGithub("github://org/repo/v1.0.0?source-archives=true")
+	require.NoError(t, err)
+	require.Equal(t, host, sut.Host)
+	require.True(t, sut.Options.SourceArchives)
+
+	snap, err := sut.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 3)
+	require.Empty(t, (*snap)["app.txt"].Annotations)
+
+	for name, format := range map[string]string{"repo-v1.0.0.zip": "zip", "repo-v1.0.0.tar.gz": "tar.gz"} {
+		require.Contains(t, *snap, name)
+		archive := (*snap)[name]
+		require.Equal(t, "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", archive.Checksum["SHA256"])
+		require.Equal(t, format, archive.Annotations[run.AnnotationSourceArchive])
+		require.Equal(t, "git+https://"+host+"/org/repo", archive.Annotations[run.AnnotationSourceURI])
+		require.Equal(t, "009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d", archive.Annotations[run.AnnotationSourceCommit])
+	}
+
+	// Generated archives are left out by default
+	sut, err = NewGithub("github://org/repo/v1.0.0")
+	require.NoError(t, err)
+	require.False(t, sut.Options.SourceArchives)
+	snap, err = sut.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 1)
+
+	_, err = NewGithub("github://org/repo/v1.0.0?source-archives=maybe")
+	require.Error(t, err)
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const (
+	gitLabPackageScheme = "gitlab-pkg"
+
+	// gitLabTokenEnvVar holds the token to authenticate to GitLab
+	gitLabTokenEnvVar = "GITLAB_TOKEN"
+)
+
+// GitLabPackage is a store driver that reads the files of a package
+// in the generic package registry of a GitLab project
+type GitLabPackage struct {
+	Host    string
+	Project string
+	Package string
+	Version string
+	Options GitLabPackageOptions
+	apiURL  string
+}
+
+type GitLabPackageOptions struct {
+	// Digests controls which of the checksums reported by
+	// GitLab are trusted without downloading the file. It is
+	// set with the trusted-digests and algorithms query parameters.
+	Digests DigestOptions
+}
+
+var DefaultGitLabPackageOptions = GitLabPackageOptions{
+	Digests: DefaultDigestOptions,
+}
+
+type gitLabPackage struct {
+	ID      int64  `json:"id"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type gitLabPackageFile struct {
+	FileName   string    `json:"file_name"`
+	CreatedAt  time.Time `json:"created_at"`
+	FileSHA1   string    `json:"file_sha1"`
+	FileSHA256 string    `json:"file_sha256"`
+}
+
+// NewGitLabPackage returns a driver for a spec URL of the form
+// gitlab-pkg://host/project/package/version. The project can be
+// its numeric ID or its full path (eg group/project).
+func NewGitLabPackage(specURL string) (*GitLabPackage, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing gitlab package spec url: %w", err)
+	}
+	if u.Scheme != gitLabPackageScheme {
+		return nil, errors.New("spec url is not a gitlab package url")
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("gitlab package url must have the form %s://host/project/package/version", gitLabPackageScheme)
+	}
+
+	opts := DefaultGitLabPackageOptions
+	opts.Digests, err = digestOptionsFromQuery(u.Query(), opts.Digests)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new GitLab package storage backend")
+	return &GitLabPackage{
+		Host:    u.Host,
+		Project: strings.Join(parts[:len(parts)-2], "/"),
+		Package: parts[len(parts)-2],
+		Version: parts[len(parts)-1],
+		Options: opts,
+		apiURL:  "https://" + u.Host + "/api/v4",
+	}, nil
+}
+
+// Snap lists the files of the package version. Checksums are taken from
+// the package file metadata, files without a checksum in one of the
+// trusted algorithms are downloaded and hashed.
+func (g *GitLabPackage) Snap() (*snapshot.Snapshot, error) {
+	packages, err := g.findPackages()
+	if err != nil {
+		return nil, fmt.Errorf("finding package %s %s: %w", g.Package, g.Version, err)
+	}
+	if len(packages) == 0 {
+		return nil, fmt.Errorf("package %s %s not found in project %s", g.Package, g.Version, g.Project)
+	}
+
+	snap := snapshot.Snapshot{}
+	for _, pkg := range packages {
+		files := []gitLabPackageFile{}
+		listURL := fmt.Sprintf("%s/packages/%d/package_files", g.projectURL(), pkg.ID)
+		if err := g.getPages(listURL, func(r io.Reader) error {
+			page := []gitLabPackageFile{}
+			if err := json.NewDecoder(r).Decode(&page); err != nil {
+				return err
+			}
+			files = append(files, page...)
+			return nil
+		}); err != nil {
+			return nil, fmt.Errorf("listing package files: %w", err)
+		}
+
+		// Files are listed oldest first, a file uploaded
+		// again replaces the previous upload
+		for _, file := range files {
+			checksums, trusted := g.Options.Digests.reportedDigests(map[string]string{
+				"SHA1":   file.FileSHA1,
+				"SHA256": file.FileSHA256,
+			})
+			if !trusted {
+				logrus.Debugf("gitlab package file %s has no trusted checksums, downloading", file.FileName)
+				checksums, err = g.hashFile(file.FileName)
+				if err != nil {
+					return nil, fmt.Errorf("hashing %s: %w", file.FileName, err)
+				}
+			}
+			snap[file.FileName] = run.Artifact{
+				Path:     file.FileName,
+				Checksum: checksums,
+				Time:     file.CreatedAt,
+			}
+		}
+	}
+	return &snap, nil
+}
+
+// projectURL returns the API URL of the project
+func (g *GitLabPackage) projectURL() string {
+	return g.apiURL + "/projects/" + url.PathEscape(g.Project)
+}
+
+// findPackages returns the generic packages matching the name and
+// version. The API matches names partially so they are checked again.
+func (g *GitLabPackage) findPackages() ([]gitLabPackage, error) {
+	query := url.Values{}
+	query.Set("package_type", "generic")
+	query.Set("package_name", g.Package)
+	matches := []gitLabPackage{}
+	err := g.getPages(g.projectURL()+"/packages?"+query.Encode(), func(r io.Reader) error {
+		page := []gitLabPackage{}
+		if err := json.NewDecoder(r).Decode(&page); err != nil {
+			return err
+		}
+		for _, pkg := range page {
+			if pkg.Name == g.Package && pkg.Version == g.Version {
+				matches = append(matches, pkg)
+			}
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// getPages fetches all the pages of a list endpoint, passing
+// the body of each of them to decode
+func (g *GitLabPackage) getPages(listURL string, decode func(io.Reader) error) error {
+	separator := "?"
+	if strings.Contains(listURL, "?") {
+		separator = "&"
+	}
+	page := "1"
+	for page != "" {
+		body, next, err := g.get(listURL + separator + "per_page=100&page=" + page)
+		if err != nil {
+			return err
+		}
+		err = decode(body)
+		body.Close()
+		if err != nil {
+			return fmt.Errorf("decoding response: %w", err)
+		}
+		page = next
+	}
+	return nil
+}
+
+// hashFile downloads a file of the package and computes its digests
+func (g *GitLabPackage) hashFile(fileName string) (map[string]string, error) {
+	body, _, err := g.get(fmt.Sprintf(
+		"%s/packages/generic/%s/%s/%s", g.projectURL(),
+		url.PathEscape(g.Package), url.PathEscape(g.Version), url.PathEscape(fileName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return hashReader(body, g.Options.Digests.Algorithms)
+}
+
+// get performs an authenticated request to the GitLab API. It returns
+// the response body and the next page number of paginated responses.
+func (g *GitLabPackage) get(requestURL string) (body io.ReadCloser, nextPage string, err error) {
+	req, err := http.NewRequest(http.MethodGet, requestURL, http.NoBody)
+	if err != nil {
+		return nil, "", fmt.Errorf("creating http request: %w", err)
+	}
+	if token := os.Getenv(gitLabTokenEnvVar); token != "" {
+		req.Header.Set("PRIVATE-TOKEN", token)
+	} else {
+		logrus.Warn("making unauthenticated request to gitlab")
+	}
+
+	client, err := httpclient.New()
+	if err != nil {
+		return nil, "", fmt.Errorf("creating http client: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", fmt.Errorf("executing http request to gitlab: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, "", fmt.Errorf("http error from gitlab: %s", resp.Status)
+	}
+	return resp.Body, resp.Header.Get("X-Next-Page"), nil
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Recorded from /api/v4/projects/42/packages?package_type=generic&package_name=app
+const gitLabPackagesResponse = `[
+  {"id": 7, "name": "app", "version": "1.0.0", "package_type": "generic", "status": "default"},
+  {"id": 8, "name": "app", "version": "1.1.0", "package_type": "generic", "status": "default"},
+  {"id": 9, "name": "app-docs", "version": "1.1.0", "package_type": "generic", "status": "default"}
+]`
+
+// Recorded from /api/v4/projects/42/packages/8/package_files
+const gitLabPackageFilesPage1 = `[
+  {
+    "id": 101, "package_id": 8, "created_at": "2023-05-10T12:00:00.000Z",
+    "file_name": "app-linux-amd64", "size": 4,
+    "file_md5": null,
+    "file_sha1": "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+    "file_sha256": "0000000000000000000000000000000000000000000000000000000000000000"
+  },
+  {
+    "id": 102, "package_id": 8, "created_at": "2023-05-10T12:01:00.000Z",
+    "file_name": "app-linux-arm64", "size": 4,
+    "file_md5": null,
+    "file_sha1": "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+    "file_sha256": null
+  }
+]`
+
+const gitLabPackageFilesPage2 = `[
+  {
+    "id": 103, "package_id": 8, "created_at": "2023-05-10T12:02:00.000Z",
+    "file_name": "app-linux-amd64", "size": 4,
+    "file_md5": null,
+    "file_sha1": "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+    "file_sha256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+  }
+]`
+
+func TestGitLabPackageSnap(t *testing.T) {
+	t.Setenv(gitLabTokenEnvVar, "glpat-test")
+
+	downloads := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v4/projects/42/packages", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("PRIVATE-TOKEN") != "glpat-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, "generic", r.URL.Query().Get("package_type"))
+		require.Equal(t, "app", r.URL.Query().Get("package_name"))
+		fmt.Fprint(w, gitLabPackagesResponse)
+	})
+	mux.HandleFunc("/api/v4/projects/42/packages/8/package_files", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "1" {
+			w.Header().Set("X-Next-Page", "2")
+			fmt.Fprint(w, gitLabPackageFilesPage1)
+			return
+		}
+		fmt.Fprint(w, gitLabPackageFilesPage2)
+	})
+	mux.HandleFunc("/api/v4/projects/42/packages/generic/app/1.1.0/", func(w http.ResponseWriter, r *http.Request) {
+		downloads[r.URL.Path]++
+		fmt.Fprint(w, "test")
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	g, err := NewGitLabPackage("gitlab-pkg://gitlab.example.com/42/app/1.1.0")
+	require.NoError(t, err)
+	g.apiURL = server.URL + "/api/v4"
+
+	snap, err := g.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+
+	// The latest upload of a file is recorded with the server checksums
+	require.Equal(t, map[string]string{
+		"SHA1":   "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		"SHA256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}, (*snap)["app-linux-amd64"].Checksum)
+	require.Zero(t, downloads["/api/v4/projects/42/packages/generic/app/1.1.0/app-linux-amd64"])
+
+	// Files without a sha256 are downloaded and hashed
+	require.Equal(t, map[string]string{
+		"SHA256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}, (*snap)["app-linux-arm64"].Checksum)
+	require.Equal(t, 1, downloads["/api/v4/projects/42/packages/generic/app/1.1.0/app-linux-arm64"])
+
+	// Missing versions are an error
+	g.Version = "2.0.0"
+	_, err = g.Snap()
+	require.Error(t, err)
+}
+
+func TestNewGitLabPackage(t *testing.T) {
+	g, err := NewGitLabPackage("gitlab-pkg://gitlab.example.com/group/sub/project/app/1.0.0")
+	require.NoError(t, err)
+	require.Equal(t, "group/sub/project", g.Project)
+	require.Equal(t, "app", g.Package)
+	require.Equal(t, "1.0.0", g.Version)
+	require.Equal(t, "https://gitlab.example.com/api/v4/projects/group%2Fsub%2Fproject", g.projectURL())
+
+	for _, specURL := range []string{
+		"gitlab-pkg://gitlab.example.com/42/app",
+		"gitlab-pkg://gitlab.example.com/42//1.0.0",
+		"gitlab://gitlab.example.com/42/app/1.0.0",
+	} {
+		_, err := NewGitLabPackage(specURL)
+		require.Error(t, err, specURL)
+	}
+}
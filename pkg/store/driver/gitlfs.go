@@ -0,0 +1,208 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/git"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const (
+	// lfsMaxPointerSize is the largest pointer file git-lfs writes
+	lfsMaxPointerSize = 1024
+	lfsSpecVersion    = "https://git-lfs.github.com/spec/v1"
+	// lfsLegacyVersion is the version of pointers written by
+	// the pre-release versions of git-lfs
+	lfsLegacyVersion = "https://hawser.github.com/spec/v1"
+)
+
+var lfsOIDRegex = regexp.MustCompile(`^sha256:([a-f0-9]{64})$`)
+
+// errNotLFSPointer is returned when a file is not a Git LFS pointer
+var errNotLFSPointer = errors.New("file is not a git lfs pointer")
+
+// GitLFS is a store driver that reads the Git LFS pointers committed
+// to a repository. The objects are recorded with the digest declared
+// in their pointers, their contents are never downloaded.
+type GitLFS struct {
+	Host       string
+	Repository string
+	Ref        string
+	Path       string
+	// cloneURL is the URL the repository is cloned from, it
+	// defaults to https:// followed by the host and repository
+	cloneURL string
+}
+
+// NewGitLFS returns a driver for a spec URL in the form
+// gitlfs://host/org/repo@ref/path. The ref cannot contain slashes,
+// the path can be a single pointer or a directory.
+func NewGitLFS(specURL string) (*GitLFS, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing gitlfs spec url: %w", err)
+	}
+	if u.Scheme != "gitlfs" {
+		return nil, errors.New("spec url is not a gitlfs url")
+	}
+
+	repo, rest, ok := strings.Cut(strings.Trim(u.Path, "/"), "@")
+	if !ok || u.Host == "" || repo == "" {
+		return nil, fmt.Errorf("gitlfs URL %s is not in the form gitlfs://host/org/repo@ref/path", specURL)
+	}
+	ref, path, _ := strings.Cut(rest, "/")
+	if ref == "" {
+		return nil, fmt.Errorf("unable to find git ref in %s", specURL)
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new Git LFS storage backend")
+	return &GitLFS{
+		Host:       u.Host,
+		Repository: repo,
+		Ref:        ref,
+		Path:       strings.Trim(path, "/"),
+		cloneURL:   fmt.Sprintf("https://%s/%s", u.Host, repo),
+	}, nil
+}
+
+// Snap clones the repository at the ref and records the objects of
+// the LFS pointers under the path. Files that are not pointers
+// are skipped.
+func (lfs *GitLFS) Snap() (*snapshot.Snapshot, error) {
+	dir, err := os.MkdirTemp("", "tejolote-gitlfs-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// go-git does not run the LFS smudge filter so the
+	// checkout has the pointers, not the objects
+	if _, err := git.Clone(lfs.cloneURL, dir, git.CloneOptions{Ref: lfs.Ref, Depth: 1}); err != nil {
+		return nil, fmt.Errorf("cloning repository: %w", err)
+	}
+
+	snap := snapshot.Snapshot{}
+	root := filepath.Join(dir, filepath.FromSlash(lfs.Path))
+	if err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("getting path of %s: %w", path, err)
+		}
+		rel = filepath.ToSlash(rel)
+
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", rel, err)
+		}
+		defer f.Close()
+		oid, size, err := parseLFSPointer(f)
+		if err != nil {
+			if errors.Is(err, errNotLFSPointer) {
+				logrus.Debugf("skipping %s: %v", rel, err)
+				return nil
+			}
+			return fmt.Errorf("reading %s: %w", rel, err)
+		}
+
+		snap[rel] = run.Artifact{
+			Path:     rel,
+			Checksum: map[string]string{"SHA256": oid},
+			Annotations: map[string]string{
+				run.AnnotationPointer: lfs.pointerURL(rel),
+				run.AnnotationLFSSize: strconv.FormatInt(size, 10),
+			},
+		}
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("reading lfs pointers: %w", err)
+	}
+	return &snap, nil
+}
+
+// pointerURL returns the location of a pointer file in the repository
+func (lfs *GitLFS) pointerURL(path string) string {
+	return fmt.Sprintf("gitlfs://%s/%s@%s/%s", lfs.Host, lfs.Repository, lfs.Ref, path)
+}
+
+// parseLFSPointer reads a Git LFS pointer file and returns the sha256
+// object ID and size it declares. Files that don't look like a pointer
+// return errNotLFSPointer.
+func parseLFSPointer(r io.Reader) (oid string, size int64, err error) {
+	data, err := io.ReadAll(io.LimitReader(r, lfsMaxPointerSize+1))
+	if err != nil {
+		return "", 0, fmt.Errorf("reading pointer: %w", err)
+	}
+	if len(data) > lfsMaxPointerSize {
+		return "", 0, fmt.Errorf("%w: larger than %d bytes", errNotLFSPointer, lfsMaxPointerSize)
+	}
+
+	values := map[string]string{}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	first := true
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			return "", 0, fmt.Errorf("%w: invalid line %q", errNotLFSPointer, scanner.Text())
+		}
+		// The spec requires the version to be the first key
+		if first && key != "version" {
+			return "", 0, fmt.Errorf("%w: missing version", errNotLFSPointer)
+		}
+		first = false
+		values[key] = value
+	}
+	if v := values["version"]; v != lfsSpecVersion && v != lfsLegacyVersion {
+		return "", 0, fmt.Errorf("%w: unsupported version %q", errNotLFSPointer, v)
+	}
+
+	m := lfsOIDRegex.FindStringSubmatch(values["oid"])
+	if m == nil {
+		return "", 0, fmt.Errorf("invalid lfs object id %q", values["oid"])
+	}
+	size, err = strconv.ParseInt(values["size"], 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("invalid lfs object size %q", values["size"])
+	}
+	return m[1], size, nil
+}
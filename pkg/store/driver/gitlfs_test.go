@@ -0,0 +1,126 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+const (
+	testLFSOID     = "4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393"
+	testLFSPointer = "version https://git-lfs.github.com/spec/v1\n" +
+		"oid sha256:" + testLFSOID + "\n" +
+		"size 12345\n"
+)
+
+func TestParseGitLFSURL(t *testing.T) {
+	lfs, err := NewGitLFS("gitlfs://github.com/octo-org/app@v1.0.0/assets/model.bin")
+	require.NoError(t, err)
+	require.Equal(t, "github.com", lfs.Host)
+	require.Equal(t, "octo-org/app", lfs.Repository)
+	require.Equal(t, "v1.0.0", lfs.Ref)
+	require.Equal(t, "assets/model.bin", lfs.Path)
+	require.Equal(t, "https://github.com/octo-org/app", lfs.cloneURL)
+
+	for _, u := range []string{
+		"gitlfs://github.com/octo-org/app",
+		"gitlfs://github.com/octo-org/app@/assets",
+		"gitlfs:///octo-org/app@main",
+		"gs://github.com/octo-org/app@main",
+	} {
+		_, err := NewGitLFS(u)
+		require.Error(t, err, u)
+	}
+}
+
+func TestParseLFSPointer(t *testing.T) {
+	oid, size, err := parseLFSPointer(strings.NewReader(testLFSPointer))
+	require.NoError(t, err)
+	require.Equal(t, testLFSOID, oid)
+	require.Equal(t, int64(12345), size)
+
+	// Files that are not pointers
+	for _, contents := range []string{
+		"",
+		"# README\n",
+		"oid sha256:" + testLFSOID + "\nversion https://git-lfs.github.com/spec/v1\nsize 1\n",
+		"version https://git-lfs.github.com/spec/v2\noid sha256:" + testLFSOID + "\nsize 1\n",
+		strings.Repeat("x", lfsMaxPointerSize+1),
+	} {
+		_, _, err := parseLFSPointer(strings.NewReader(contents))
+		require.True(t, errors.Is(err, errNotLFSPointer), contents)
+	}
+
+	// Pointers with invalid values
+	for _, contents := range []string{
+		"version https://git-lfs.github.com/spec/v1\noid sha1:abcd\nsize 1\n",
+		"version https://git-lfs.github.com/spec/v1\noid sha256:" + testLFSOID + "\nsize -1\n",
+	} {
+		_, _, err := parseLFSPointer(strings.NewReader(contents))
+		require.Error(t, err)
+		require.False(t, errors.Is(err, errNotLFSPointer), contents)
+	}
+}
+
+func TestGitLFSSnap(t *testing.T) {
+	src := t.TempDir()
+	repo, err := gogit.PlainInitWithOptions(src, &gogit.PlainInitOptions{
+		InitOptions: gogit.InitOptions{DefaultBranch: plumbing.NewBranchReferenceName("main")},
+	})
+	require.NoError(t, err)
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "assets"), os.FileMode(0o755)))
+	for name, contents := range map[string]string{
+		"README.md":        "# app\n",
+		"assets/model.bin": testLFSPointer,
+		"assets/notes.txt": "not a pointer\n",
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(src, name), []byte(contents), os.FileMode(0o644)))
+		_, err := wt.Add(name)
+		require.NoError(t, err)
+	}
+	_, err = wt.Commit("add assets", &gogit.CommitOptions{
+		Author: &object.Signature{Name: "Tejolote", Email: "tejolote@example.com", When: time.Now()},
+	})
+	require.NoError(t, err)
+
+	lfs, err := NewGitLFS("gitlfs://github.com/octo-org/app@main/assets")
+	require.NoError(t, err)
+	lfs.cloneURL = src
+
+	snap, err := lfs.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 1)
+	artifact := (*snap)["assets/model.bin"]
+	require.Equal(t, map[string]string{"SHA256": testLFSOID}, artifact.Checksum)
+	require.Equal(t, "12345", artifact.Annotations[run.AnnotationLFSSize])
+	require.Equal(t, "gitlfs://github.com/octo-org/app@main/assets/model.bin", artifact.Annotations[run.AnnotationPointer])
+}
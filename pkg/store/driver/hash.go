@@ -0,0 +1,192 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"crypto/sha1" //nolint: gosec // Used for legacy digests only
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// hashers maps the names of the supported digest algorithms
+// to their implementations
+var hashers = map[string]func() hash.Hash{
+	"SHA1":   sha1.New,
+	"SHA256": sha256.New,
+	"SHA512": sha512.New,
+}
+
+// DigestOptions control how the digests reported natively by a store
+// are used. Stores record the reported digests when at least one of
+// them is in a trusted algorithm, otherwise the artifact is downloaded
+// and hashed.
+type DigestOptions struct {
+	// TrustedAlgorithms are the algorithms of the reported
+	// digests that avoid downloading the artifact
+	TrustedAlgorithms []string
+
+	// Algorithms are the digests computed when the store
+	// does not report any trusted digest
+	Algorithms []string
+}
+
+var DefaultDigestOptions = DigestOptions{
+	TrustedAlgorithms: []string{"SHA256", "SHA512"},
+	Algorithms:        []string{"SHA256"},
+}
+
+// digestOptionsFromQuery reads the digest options from the spec URL
+// query over the store defaults: trusted-digests is a comma separated
+// list of the trusted algorithms and algorithms the list of digests
+// computed when downloading.
+func digestOptionsFromQuery(query url.Values, defaults DigestOptions) (DigestOptions, error) {
+	opts := defaults
+	if value := query.Get("trusted-digests"); value != "" {
+		opts.TrustedAlgorithms = splitAlgorithms(value)
+		if len(opts.TrustedAlgorithms) == 0 {
+			return opts, fmt.Errorf("invalid trusted-digests value %q", value)
+		}
+	}
+	if value := query.Get("algorithms"); value != "" {
+		algorithms, err := parseAlgorithms(value)
+		if err != nil {
+			return opts, err
+		}
+		opts.Algorithms = algorithms
+	}
+	return opts, nil
+}
+
+// parseAlgorithms reads a comma separated list of the algorithms
+// tejolote can compute
+func parseAlgorithms(value string) ([]string, error) {
+	algorithms := splitAlgorithms(value)
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("invalid algorithms value %q", value)
+	}
+	for _, algo := range algorithms {
+		if _, ok := hashers[algo]; !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm %s", algo)
+		}
+	}
+	return algorithms, nil
+}
+
+// splitAlgorithms splits a comma separated list of algorithm names
+func splitAlgorithms(value string) []string {
+	algorithms := []string{}
+	for _, algo := range strings.Split(value, ",") {
+		if algo = strings.ToUpper(strings.TrimSpace(algo)); algo != "" {
+			algorithms = append(algorithms, algo)
+		}
+	}
+	return algorithms
+}
+
+// reportedDigests returns the digests reported by a store that can be
+// recorded and true if any of them is in a trusted algorithm. Digests
+// in algorithms tejolote does not compute are kept only if trusted.
+func (o *DigestOptions) reportedDigests(reported map[string]string) (digests map[string]string, trusted bool) {
+	digests = map[string]string{}
+	for algo, value := range reported {
+		algo = strings.ToUpper(algo)
+		if value == "" {
+			continue
+		}
+		isTrusted := false
+		for _, t := range o.TrustedAlgorithms {
+			if strings.ToUpper(t) == algo {
+				isTrusted = true
+				break
+			}
+		}
+		if _, ok := hashers[algo]; !ok && !isTrusted {
+			continue
+		}
+		digests[algo] = value
+		trusted = trusted || isTrusted
+	}
+	return digests, trusted
+}
+
+// hashFile computes the digests of a file in all the specified
+// algorithms reading its contents only once
+func hashFile(path string, algorithms []string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+	return hashReader(f, algorithms)
+}
+
+// hashReader computes the digests of the data read from r in
+// all the specified algorithms
+func hashReader(r io.Reader, algorithms []string) (map[string]string, error) {
+	hashes := map[string]hash.Hash{}
+	writers := []io.Writer{}
+	for _, algo := range algorithms {
+		algo = strings.ToUpper(algo)
+		newHasher, ok := hashers[algo]
+		if !ok {
+			return nil, fmt.Errorf("unsupported hash algorithm %s", algo)
+		}
+		hashes[algo] = newHasher()
+		writers = append(writers, hashes[algo])
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, fmt.Errorf("reading data: %w", err)
+	}
+
+	checksums := map[string]string{}
+	for algo, h := range hashes {
+		checksums[algo] = hex.EncodeToString(h.Sum(nil))
+	}
+	return checksums, nil
+}
+
+// hashDownload computes the digests of the data written by a download
+// function as it streams, without storing the artifact anywhere
+func hashDownload(download func(io.Writer) error, algorithms []string) (map[string]string, error) {
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		err := download(pw)
+		pw.CloseWithError(err)
+		errc <- err
+	}()
+
+	checksums, err := hashReader(pr, algorithms)
+	// Closing the reader unblocks the download if hashing stopped early
+	pr.Close()
+	if derr := <-errc; derr != nil && !errors.Is(derr, io.ErrClosedPipe) {
+		return nil, derr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return checksums, nil
+}
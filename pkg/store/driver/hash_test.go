@@ -0,0 +1,60 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDigestOptionsFromQuery(t *testing.T) {
+	nexus, err := NewNexus("nexus://nexus.example.com/releases?trusted-digests=sha512,%20sha256&algorithms=sha512")
+	require.NoError(t, err)
+	require.Equal(t, []string{"SHA512", "SHA256"}, nexus.Options.Digests.TrustedAlgorithms)
+	require.Equal(t, []string{"SHA512"}, nexus.Options.Digests.Algorithms)
+
+	// Options not in the query keep the store defaults
+	artifactory, err := NewArtifactory("artifactory://jfrog.example.com/libs?trusted-digests=sha512")
+	require.NoError(t, err)
+	require.Equal(t, []string{"SHA512"}, artifactory.Options.Digests.TrustedAlgorithms)
+	require.Equal(t, DefaultArtifactoryOptions.Digests.Algorithms, artifactory.Options.Digests.Algorithms)
+
+	gitlab, err := NewGitLabPackage("gitlab-pkg://gitlab.example.com/group/project/pkg/1.0.0?algorithms=sha1,sha256")
+	require.NoError(t, err)
+	require.Equal(t, DefaultDigestOptions.TrustedAlgorithms, gitlab.Options.Digests.TrustedAlgorithms)
+	require.Equal(t, []string{"SHA1", "SHA256"}, gitlab.Options.Digests.Algorithms)
+
+	index, err := NewHTTPIndex("https://downloads.example.com/v1/?trusted-digests=sha256")
+	require.NoError(t, err)
+	require.Equal(t, []string{"SHA256"}, index.Options.Digests.TrustedAlgorithms)
+	require.Equal(t, "https://downloads.example.com/v1/", index.URL)
+
+	// The defaults are not modified by the parsed options
+	require.Equal(t, []string{"SHA256", "SHA512"}, DefaultDigestOptions.TrustedAlgorithms)
+
+	// Algorithms must be known and lists not empty
+	_, err = NewNexus("nexus://nexus.example.com/releases?algorithms=md5")
+	require.Error(t, err)
+	_, err = NewArtifactory("artifactory://jfrog.example.com/libs?trusted-digests=,")
+	require.Error(t, err)
+	_, err = NewGitLabPackage("gitlab-pkg://gitlab.example.com/project/pkg/1.0.0?algorithms=,")
+	require.Error(t, err)
+	_, err = NewHTTPIndex("https://downloads.example.com/v1/?algorithms=sha256,crc32")
+	require.Error(t, err)
+}
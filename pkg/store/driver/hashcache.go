@@ -0,0 +1,166 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// HashCacheEnvVar is the environment variable that sets the path of
+// the hash cache when the store options don't define one
+const HashCacheEnvVar = "TEJOLOTE_HASH_CACHE"
+
+const hashCacheVersion = 1
+
+// hashCache stores the digests of files on disk keyed by their path,
+// so files that have not changed (same modification time and size)
+// are not hashed again. A nil cache is valid and caches nothing.
+type hashCache struct {
+	path    string
+	mtx     sync.Mutex
+	dirty   bool
+	Version int                       `json:"version"`
+	Entries map[string]hashCacheEntry `json:"entries"`
+}
+
+type hashCacheEntry struct {
+	ModTime   time.Time         `json:"mtime"`
+	Size      int64             `json:"size"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+// hashCachePath returns the configured cache path, falling
+// back to the value of the environment variable
+func hashCachePath(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	return os.Getenv(HashCacheEnvVar)
+}
+
+// openHashCache loads the cache file. An empty path returns a nil
+// cache. Missing or unreadable caches start empty.
+func openHashCache(path string) *hashCache {
+	if path == "" {
+		return nil
+	}
+	cache := &hashCache{path: path, Version: hashCacheVersion, Entries: map[string]hashCacheEntry{}}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("unable to read hash cache, starting empty: %v", err)
+		}
+		return cache
+	}
+	loaded := &hashCache{}
+	if err := json.Unmarshal(data, loaded); err != nil || loaded.Version != hashCacheVersion {
+		logrus.Warnf("ignoring invalid hash cache %s", path)
+		return cache
+	}
+	if loaded.Entries != nil {
+		cache.Entries = loaded.Entries
+	}
+	return cache
+}
+
+// get returns the cached digests of a file if its modification
+// time and size did not change and all algorithms are cached
+func (c *hashCache) get(key string, info os.FileInfo, algorithms []string) (map[string]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry, ok := c.Entries[key]
+	if !ok || !entry.ModTime.Equal(info.ModTime()) || entry.Size != info.Size() {
+		return nil, false
+	}
+	checksums := map[string]string{}
+	for _, algo := range algorithms {
+		algo = strings.ToUpper(algo)
+		v, ok := entry.Checksums[algo]
+		if !ok {
+			return nil, false
+		}
+		checksums[algo] = v
+	}
+	return checksums, true
+}
+
+// put records the digests of a file, replacing any stale entry
+func (c *hashCache) put(key string, info os.FileInfo, checksums map[string]string) {
+	if c == nil {
+		return
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	entry := c.Entries[key]
+	if !entry.ModTime.Equal(info.ModTime()) || entry.Size != info.Size() || entry.Checksums == nil {
+		entry = hashCacheEntry{ModTime: info.ModTime(), Size: info.Size(), Checksums: map[string]string{}}
+	}
+	for algo, v := range checksums {
+		entry.Checksums[algo] = v
+	}
+	c.Entries[key] = entry
+	c.dirty = true
+}
+
+// save writes the cache to disk if it changed. The file is replaced
+// atomically so concurrent runs never read a partial cache.
+func (c *hashCache) save() error {
+	if c == nil {
+		return nil
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("marshaling hash cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), os.FileMode(0o755)); err != nil {
+		return fmt.Errorf("creating hash cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(c.path), ".hashcache-*")
+	if err != nil {
+		return fmt.Errorf("creating temporary hash cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing hash cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing hash cache: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return fmt.Errorf("moving hash cache into place: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
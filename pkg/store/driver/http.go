@@ -0,0 +1,327 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/html"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+// HTTPIndex is a store driver that reads the files listed in
+// a web server directory index (eg Apache or Nginx autoindex)
+type HTTPIndex struct {
+	URL     string
+	Options HTTPIndexOptions
+}
+
+type HTTPIndexOptions struct {
+	// Digests are the algorithms used to hash the downloaded files
+	Digests DigestOptions
+
+	// ChecksumsFile is the name of a sidecar file in the index
+	// (eg SHA256SUMS) listing the SHA256 digests of the files. Files
+	// listed in it are not downloaded.
+	ChecksumsFile string
+
+	// Pointers controls the resolution of pointer files
+	Pointers PointerOptions
+
+	// MaxArtifactSize is the maximum size in bytes of the files
+	// downloaded to hash them, larger downloads are aborted as soon
+	// as the limit is crossed. Zero means no limit.
+	MaxArtifactSize int64
+}
+
+var DefaultHTTPIndexOptions = HTTPIndexOptions{
+	Digests: DefaultDigestOptions,
+}
+
+// NewHTTPIndex returns a driver that reads a directory index. The
+// spec URL must point to a directory (end with a slash), the name of
+// a checksums file can be set with the checksums query parameter,
+// pointer files with the pointers and pointer-target parameters, the
+// size limit of the downloads with max-artifact-size and the digests
+// with trusted-digests and algorithms.
+func NewHTTPIndex(specURL string) (*HTTPIndex, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing http spec url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, errors.New("spec url is not an http url")
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		return nil, fmt.Errorf("http store url %s must point to a directory index ending in /", specURL)
+	}
+
+	opts := DefaultHTTPIndexOptions
+	opts.ChecksumsFile = u.Query().Get("checksums")
+	opts.Pointers = pointerOptionsFromQuery(u.Query())
+	opts.MaxArtifactSize, err = maxArtifactSizeFromQuery(u.Query())
+	if err != nil {
+		return nil, err
+	}
+	opts.Digests, err = digestOptionsFromQuery(u.Query(), opts.Digests)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = ""
+	u.Fragment = ""
+
+	logrus.WithField("store", specURL).Info("Initialized new HTTP directory index storage backend")
+	return &HTTPIndex{
+		URL:     u.String(),
+		Options: opts,
+	}, nil
+}
+
+// Snap lists the files linked from the index, downloading and
+// hashing those without a digest in the checksums file
+func (h *HTTPIndex) Snap() (*snapshot.Snapshot, error) {
+	files, err := h.listFiles()
+	if err != nil {
+		return nil, fmt.Errorf("reading directory index: %w", err)
+	}
+
+	sums := map[string]string{}
+	if h.Options.ChecksumsFile != "" {
+		body, _, err := h.get(h.URL+h.Options.ChecksumsFile, 0)
+		if err != nil {
+			return nil, fmt.Errorf("fetching checksums file: %w", err)
+		}
+		sums, err = parseChecksumsFile(body)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("parsing checksums file: %w", err)
+		}
+	}
+
+	snap := snapshot.Snapshot{}
+	for _, fileURL := range files {
+		name := strings.TrimPrefix(fileURL, h.URL)
+		if h.Options.Pointers.matches(name) {
+			artifact, err := h.resolvePointer(fileURL)
+			if err != nil {
+				return nil, fmt.Errorf("resolving pointer %s: %w", name, err)
+			}
+			snap[artifact.Path] = *artifact
+			continue
+		}
+		if sum, ok := sums[name]; ok {
+			snap[fileURL] = run.Artifact{
+				Path:     fileURL,
+				Checksum: map[string]string{"SHA256": sum},
+			}
+			continue
+		}
+
+		body, modified, err := h.get(fileURL, h.Options.MaxArtifactSize)
+		if err != nil {
+			return nil, fmt.Errorf("downloading %s: %w", name, err)
+		}
+		checksums, err := hashReader(body, h.Options.Digests.Algorithms)
+		body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("hashing %s: %w", name, err)
+		}
+		snap[fileURL] = run.Artifact{
+			Path:     fileURL,
+			Checksum: checksums,
+			Time:     modified,
+		}
+	}
+	return &snap, nil
+}
+
+// resolvePointer reads a pointer file and returns the
+// artifact it points to
+func (h *HTTPIndex) resolvePointer(pointerURL string) (*run.Artifact, error) {
+	body, _, err := h.get(pointerURL, 0)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := io.ReadAll(io.LimitReader(body, maxPointerSize+1))
+	body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading pointer: %w", err)
+	}
+	target, err := h.Options.Pointers.target(contents)
+	if err != nil {
+		return nil, err
+	}
+	targetURL, err := resolvePointerURL(pointerURL, target)
+	if err != nil {
+		return nil, err
+	}
+
+	body, modified, err := h.get(targetURL, h.Options.MaxArtifactSize)
+	if err != nil {
+		return nil, fmt.Errorf("downloading pointer target: %w", err)
+	}
+	defer body.Close()
+	checksums, err := hashReader(body, h.Options.Digests.Algorithms)
+	if err != nil {
+		return nil, fmt.Errorf("hashing %s: %w", targetURL, err)
+	}
+	return &run.Artifact{
+		Path:        targetURL,
+		Checksum:    checksums,
+		Time:        modified,
+		Annotations: map[string]string{run.AnnotationPointer: pointerURL},
+	}, nil
+}
+
+// listFiles parses the links in the index page and returns the full
+// URLs of the files in the directory. Links to other directories,
+// other hosts or sorting links (?C=N;O=D) are ignored.
+func (h *HTTPIndex) listFiles() ([]string, error) {
+	base, err := url.Parse(h.URL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing index url: %w", err)
+	}
+	body, _, err := h.get(h.URL, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	seen := map[string]struct{}{}
+	files := []string{}
+	tokenizer := html.NewTokenizer(body)
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			if errors.Is(tokenizer.Err(), io.EOF) {
+				return files, nil
+			}
+			return nil, fmt.Errorf("parsing index html: %w", tokenizer.Err())
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := tokenizer.TagName()
+			if string(name) != "a" || !hasAttr {
+				continue
+			}
+			for {
+				key, value, more := tokenizer.TagAttr()
+				if string(key) == "href" {
+					if link := indexFileLink(base, string(value)); link != "" {
+						if _, ok := seen[link]; !ok {
+							seen[link] = struct{}{}
+							files = append(files, link)
+						}
+					}
+				}
+				if !more {
+					break
+				}
+			}
+		}
+	}
+}
+
+// indexFileLink resolves an href from the index and returns it if
+// it points to a file directly inside the base directory
+func indexFileLink(base *url.URL, href string) string {
+	ref, err := url.Parse(href)
+	if err != nil || ref.RawQuery != "" || (ref.Path == "" && ref.Fragment != "") {
+		return ""
+	}
+	link := base.ResolveReference(ref)
+	link.Fragment = ""
+	if link.Scheme != base.Scheme || link.Host != base.Host {
+		return ""
+	}
+	if strings.HasSuffix(link.Path, "/") || path.Dir(link.Path) != path.Clean(base.Path) {
+		return ""
+	}
+	return link.String()
+}
+
+// parseChecksumsFile reads a file in the format written by sha256sum
+// and returns the digests by file name
+func parseChecksumsFile(r io.Reader) (map[string]string, error) {
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("malformed checksums line: %q", line)
+		}
+		// Binary mode entries prefix the file name with an asterisk
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		sums[strings.TrimPrefix(name, "./")] = strings.ToLower(sum)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading checksums: %w", err)
+	}
+	return sums, nil
+}
+
+// get fetches a URL and returns its body and last modification time.
+// If maxSize is set, responses larger than it fail before or while
+// reading the body.
+func (h *HTTPIndex) get(requestURL string, maxSize int64) (io.ReadCloser, time.Time, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, http.NoBody)
+	if err != nil {
+		cancel()
+		return nil, time.Time{}, fmt.Errorf("creating http request: %w", err)
+	}
+	client, err := httpclient.New()
+	if err != nil {
+		cancel()
+		return nil, time.Time{}, fmt.Errorf("creating http client: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, time.Time{}, fmt.Errorf("executing http request: %w", err)
+	}
+	body := newSizeCappedReader(resp.Body, maxSize, cancel)
+	if resp.StatusCode != http.StatusOK {
+		body.Close()
+		return nil, time.Time{}, fmt.Errorf("http error fetching %s: %s", requestURL, resp.Status)
+	}
+	// Don't even start reading if the server reports a larger size
+	if maxSize > 0 && resp.ContentLength > maxSize {
+		body.Close()
+		return nil, time.Time{}, fmt.Errorf("fetching %s: %w", requestURL, body.tooLarge())
+	}
+	modified, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		modified = time.Time{}
+	}
+	return body, modified, nil
+}
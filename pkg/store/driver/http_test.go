@@ -0,0 +1,218 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+const testIndexPage = `<html><body><h1>Index of /v1.0/</h1>
+<a href="?C=N;O=D">Name</a>
+<a href="../">Parent Directory</a>
+<a href="app-linux-amd64">app-linux-amd64</a>
+<a href="/v1.0/app.tar.gz">app.tar.gz</a>
+<a href="SHA256SUMS">SHA256SUMS</a>
+<a href="docs/">docs/</a>
+<a href="https://elsewhere.example.com/file">mirror</a>
+<a href="app-linux-amd64">app-linux-amd64</a>
+</body></html>`
+
+func newTestIndexServer(t *testing.T, page string, files map[string]string, downloads *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1.0/" {
+			fmt.Fprint(w, page)
+			return
+		}
+		content, ok := files[strings.TrimPrefix(r.URL.Path, "/v1.0/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		*downloads = append(*downloads, r.URL.Path)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		fmt.Fprint(w, content)
+	}))
+}
+
+func TestHTTPIndex(t *testing.T) {
+	appSum := sha256.Sum256([]byte("binary"))
+	tarSum := sha256.Sum256([]byte("tarball"))
+	files := map[string]string{
+		"app-linux-amd64": "binary",
+		"app.tar.gz":      "tarball",
+		"SHA256SUMS":      fmt.Sprintf("%x  app-linux-amd64\n%x *app.tar.gz\n", appSum, tarSum),
+	}
+	downloads := []string{}
+	server := newTestIndexServer(t, testIndexPage, files, &downloads)
+	defer server.Close()
+
+	h, err := NewHTTPIndex(server.URL + "/v1.0/")
+	require.NoError(t, err)
+	snap, err := h.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 3)
+	appURL := server.URL + "/v1.0/app-linux-amd64"
+	require.Contains(t, *snap, appURL)
+	require.Equal(t, appURL, (*snap)[appURL].Path)
+	require.Equal(t, fmt.Sprintf("%x", appSum), (*snap)[appURL].Checksum["SHA256"])
+	require.Equal(t, 2006, (*snap)[appURL].Time.Year())
+	require.Len(t, downloads, 3)
+
+	// With a checksums file only the unlisted files are downloaded
+	downloads = []string{}
+	h, err = NewHTTPIndex(server.URL + "/v1.0/?checksums=SHA256SUMS")
+	require.NoError(t, err)
+	require.Equal(t, server.URL+"/v1.0/", h.URL)
+	snap, err = h.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 3)
+	require.Equal(t, fmt.Sprintf("%x", tarSum), (*snap)[server.URL+"/v1.0/app.tar.gz"].Checksum["SHA256"])
+	require.Equal(t, []string{"/v1.0/SHA256SUMS", "/v1.0/SHA256SUMS"}, downloads)
+
+	_, err = NewHTTPIndex(server.URL + "/v1.0/app.tar.gz")
+	require.Error(t, err)
+}
+
+func TestHTTPIndexPointers(t *testing.T) {
+	downloads := []string{}
+	files := map[string]string{
+		"latest.txt":      "v1.1\n",
+		"v1.1/app.tar.gz": "new tarball",
+	}
+	page := `<html><body><a href="latest.txt">latest.txt</a><a href="v1.1/">v1.1/</a></body></html>`
+	server := newTestIndexServer(t, page, files, &downloads)
+	defer server.Close()
+
+	h, err := NewHTTPIndex(server.URL + "/v1.0/?pointers=latest*.txt&pointer-target={}/app.tar.gz")
+	require.NoError(t, err)
+	require.Equal(t, PointerOptions{Patterns: []string{"latest*.txt"}, Target: "{}/app.tar.gz"}, h.Options.Pointers)
+
+	// The pointer is replaced by its target
+	snap, err := h.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 1)
+	targetURL := server.URL + "/v1.0/v1.1/app.tar.gz"
+	require.Contains(t, *snap, targetURL)
+	sum := sha256.Sum256([]byte("new tarball"))
+	require.Equal(t, fmt.Sprintf("%x", sum), (*snap)[targetURL].Checksum["SHA256"])
+	require.Equal(t, server.URL+"/v1.0/latest.txt", (*snap)[targetURL].Annotations[run.AnnotationPointer])
+
+	// Pointers to missing files fail
+	h.Options.Pointers.Target = "{}/missing"
+	_, err = h.Snap()
+	require.Error(t, err)
+}
+
+func TestPointerTarget(t *testing.T) {
+	opts := PointerOptions{Patterns: []string{"latest*.txt", "stable.txt"}}
+	require.True(t, opts.matches("release/latest-1.30.txt"))
+	require.True(t, opts.matches("stable.txt"))
+	require.False(t, opts.matches("release/v1.30.0/kubectl"))
+
+	target, err := opts.target([]byte("v1.30.0\n"))
+	require.NoError(t, err)
+	require.Equal(t, "v1.30.0", target)
+
+	opts.Target = "/release/{}/bin/linux/amd64/kubectl"
+	target, err = opts.target([]byte("v1.30.0"))
+	require.NoError(t, err)
+	resolved, err := resolvePointerURL("gs://bucket/release/latest.txt", target)
+	require.NoError(t, err)
+	require.Equal(t, "gs://bucket/release/v1.30.0/bin/linux/amd64/kubectl", resolved)
+
+	resolved, err = resolvePointerURL("gs://bucket/release/latest.txt", "v1.30.0/kubectl")
+	require.NoError(t, err)
+	require.Equal(t, "gs://bucket/release/v1.30.0/kubectl", resolved)
+
+	for _, contents := range []string{"", "two\nlines", strings.Repeat("x", maxPointerSize+1)} {
+		_, err := opts.target([]byte(contents))
+		require.Error(t, err)
+	}
+}
+
+func TestHTTPIndexMaxArtifactSize(t *testing.T) {
+	const maxSize = 1 << 20
+	const total = 1 << 30
+	var written atomic.Int64
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/":
+			fmt.Fprint(w, `<a href="small">small</a><a href="huge">huge</a>`)
+		case "/small":
+			fmt.Fprint(w, "small file")
+		case "/announced":
+			w.Header().Set("Content-Length", fmt.Sprint(total))
+			fmt.Fprint(w, "never read")
+		case "/huge":
+			// Stream the data without a content length until the
+			// client goes away
+			defer close(done)
+			chunk := make([]byte, 32<<10)
+			for written.Load() < total {
+				n, err := w.Write(chunk)
+				written.Add(int64(n))
+				if err != nil {
+					return
+				}
+				w.(http.Flusher).Flush()
+			}
+		}
+	}))
+	defer server.Close()
+
+	h, err := NewHTTPIndex(fmt.Sprintf("%s/?max-artifact-size=%d", server.URL, maxSize))
+	require.NoError(t, err)
+	require.Equal(t, int64(maxSize), h.Options.MaxArtifactSize)
+
+	_, err = h.Snap()
+	require.ErrorIs(t, err, ErrArtifactTooLarge)
+
+	// The transfer must be cut short, not read to the end
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("server kept sending data after the limit was crossed")
+	}
+	require.Less(t, written.Load(), int64(total/8))
+
+	// Sizes announced in the headers fail before reading
+	_, _, err = h.get(server.URL+"/announced", maxSize)
+	require.ErrorIs(t, err, ErrArtifactTooLarge)
+
+	// Files under the limit are read normally
+	body, _, err := h.get(server.URL+"/small", maxSize)
+	require.NoError(t, err)
+	sums, err := hashReader(body, []string{"SHA256"})
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("small file"))), sums["SHA256"])
+
+	_, err = NewHTTPIndex(server.URL + "/?max-artifact-size=lots")
+	require.Error(t, err)
+}
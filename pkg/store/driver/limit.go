@@ -0,0 +1,98 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"sync"
+)
+
+// ErrArtifactTooLarge is returned when an artifact being downloaded
+// is larger than the maximum size configured in the store
+var ErrArtifactTooLarge = errors.New("artifact exceeds the maximum size")
+
+// maxArtifactSizeFromQuery reads the max-artifact-size query
+// parameter of a spec URL (in bytes). Zero means no limit.
+func maxArtifactSizeFromQuery(query url.Values) (int64, error) {
+	value := query.Get("max-artifact-size")
+	if value == "" {
+		return 0, nil
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid max-artifact-size value %q", value)
+	}
+	return size, nil
+}
+
+// sizeCappedReader reads the body of a download and aborts the
+// transfer as soon as more than max bytes arrive: the request context
+// is cancelled and the body closed so the rest of the response is
+// never read and the connection is released.
+type sizeCappedReader struct {
+	body      io.ReadCloser
+	max       int64
+	remaining int64
+	cancel    context.CancelFunc
+	once      sync.Once
+}
+
+// newSizeCappedReader wraps a response body. A max of zero or less
+// does not limit the size but still cancels the request on Close.
+func newSizeCappedReader(body io.ReadCloser, maxSize int64, cancel context.CancelFunc) *sizeCappedReader {
+	return &sizeCappedReader{body: body, max: maxSize, remaining: maxSize, cancel: cancel}
+}
+
+func (c *sizeCappedReader) Read(p []byte) (int, error) {
+	if c.max > 0 && c.remaining < 0 {
+		return 0, c.tooLarge()
+	}
+	n, err := c.body.Read(p)
+	if c.max <= 0 {
+		return n, err
+	}
+	c.remaining -= int64(n)
+	if c.remaining < 0 {
+		c.abort()
+		return n, c.tooLarge()
+	}
+	return n, err
+}
+
+func (c *sizeCappedReader) tooLarge() error {
+	return fmt.Errorf("%w of %d bytes", ErrArtifactTooLarge, c.max)
+}
+
+// abort cancels the request and closes the body
+func (c *sizeCappedReader) abort() {
+	c.once.Do(func() {
+		if c.cancel != nil {
+			c.cancel()
+		}
+		c.body.Close()
+	})
+}
+
+func (c *sizeCappedReader) Close() error {
+	c.abort()
+	return nil
+}
@@ -0,0 +1,220 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const (
+	nexusSearchPath = "/service/rest/v1/search/assets"
+
+	// Environment variables holding the Nexus credentials
+	nexusUserEnvVar     = "NEXUS_USERNAME"
+	nexusPasswordEnvVar = "NEXUS_PASSWORD"
+)
+
+// Nexus is a store driver that reads artifacts from a repository
+// in a Sonatype Nexus instance
+type Nexus struct {
+	Host       string
+	Repository string
+	Path       string
+	Options    NexusOptions
+	apiURL     string
+}
+
+type NexusOptions struct {
+	// Digests controls which of the checksums reported by
+	// Nexus are trusted without downloading the asset. It is
+	// set with the trusted-digests and algorithms query parameters.
+	Digests DigestOptions
+}
+
+var DefaultNexusOptions = NexusOptions{
+	Digests: DigestOptions{
+		TrustedAlgorithms: DefaultDigestOptions.TrustedAlgorithms,
+		Algorithms:        []string{"SHA1", "SHA256"},
+	},
+}
+
+// nexusSearchResponse is a page of results from the Nexus asset search API
+type nexusSearchResponse struct {
+	Items             []nexusAsset `json:"items"`
+	ContinuationToken string       `json:"continuationToken"`
+}
+
+type nexusAsset struct {
+	DownloadURL  string            `json:"downloadUrl"`
+	Path         string            `json:"path"`
+	Repository   string            `json:"repository"`
+	Checksum     map[string]string `json:"checksum"`
+	LastModified time.Time         `json:"lastModified"`
+}
+
+func NewNexus(specURL string) (*Nexus, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing nexus spec url: %w", err)
+	}
+	if u.Scheme != "nexus" {
+		return nil, errors.New("spec url is not a nexus url")
+	}
+
+	repo, path, _ := strings.Cut(strings.Trim(u.Path, "/"), "/")
+	if repo == "" {
+		return nil, fmt.Errorf("unable to find nexus repository in %s", specURL)
+	}
+
+	opts := DefaultNexusOptions
+	opts.Digests, err = digestOptionsFromQuery(u.Query(), opts.Digests)
+	if err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new Nexus storage backend")
+	return &Nexus{
+		Host:       u.Host,
+		Repository: repo,
+		Path:       path,
+		Options:    opts,
+		apiURL:     "https://" + u.Host,
+	}, nil
+}
+
+// inPath returns true if the asset is the path of the store or under
+// it. Paths are matched by their elements, com/example does not
+// match com/example-tools.
+func (n *Nexus) inPath(assetPath string) bool {
+	prefix := strings.Trim(n.Path, "/")
+	if prefix == "" {
+		return true
+	}
+	return assetPath == prefix || strings.HasPrefix(assetPath, prefix+"/")
+}
+
+// Snap lists the assets in the repository under the path. Checksums are
+// taken from the search results, assets without a checksum in one of
+// the trusted algorithms are downloaded and hashed.
+func (n *Nexus) Snap() (*snapshot.Snapshot, error) {
+	snap := snapshot.Snapshot{}
+	continuationToken := ""
+	for {
+		page, err := n.search(continuationToken)
+		if err != nil {
+			return nil, fmt.Errorf("searching nexus assets: %w", err)
+		}
+
+		for _, asset := range page.Items {
+			assetPath := strings.TrimPrefix(asset.Path, "/")
+			if !n.inPath(assetPath) {
+				continue
+			}
+
+			checksums, trusted := n.Options.Digests.reportedDigests(asset.Checksum)
+			if !trusted {
+				logrus.Debugf("nexus asset %s has no trusted checksums, downloading", assetPath)
+				checksums, err = n.hashAsset(asset.DownloadURL)
+				if err != nil {
+					return nil, fmt.Errorf("hashing %s: %w", assetPath, err)
+				}
+			}
+
+			snap[assetPath] = run.Artifact{
+				Path:     assetPath,
+				Checksum: checksums,
+				Time:     asset.LastModified,
+			}
+		}
+
+		if page.ContinuationToken == "" {
+			break
+		}
+		continuationToken = page.ContinuationToken
+	}
+	return &snap, nil
+}
+
+// search fetches a page of assets from the repository
+func (n *Nexus) search(continuationToken string) (*nexusSearchResponse, error) {
+	query := url.Values{}
+	query.Set("repository", n.Repository)
+	if continuationToken != "" {
+		query.Set("continuationToken", continuationToken)
+	}
+
+	body, err := n.get(n.apiURL + nexusSearchPath + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	page := &nexusSearchResponse{}
+	if err := json.NewDecoder(body).Decode(page); err != nil {
+		return nil, fmt.Errorf("decoding search response: %w", err)
+	}
+	return page, nil
+}
+
+// hashAsset downloads an asset and computes its digests
+func (n *Nexus) hashAsset(downloadURL string) (map[string]string, error) {
+	body, err := n.get(downloadURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return hashReader(body, n.Options.Digests.Algorithms)
+}
+
+// get performs an authenticated request to the Nexus server
+func (n *Nexus) get(requestURL string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, requestURL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("creating http request: %w", err)
+	}
+	if os.Getenv(nexusUserEnvVar) != "" {
+		req.SetBasicAuth(os.Getenv(nexusUserEnvVar), os.Getenv(nexusPasswordEnvVar))
+	}
+
+	client, err := httpclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating http client: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing http request to nexus: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("http error from nexus: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
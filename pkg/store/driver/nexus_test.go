@@ -0,0 +1,188 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Recorded from /service/rest/v1/search/assets?repository=maven-releases
+const nexusSearchPage1 = `{
+  "items" : [ {
+    "downloadUrl" : "%[1]s/repository/maven-releases/com/example/app/1.0.0/app-1.0.0.jar",
+    "path" : "com/example/app/1.0.0/app-1.0.0.jar",
+    "id" : "bWF2ZW4tcmVsZWFzZXM6MTM2OTAyYjc0N2E5ZGQ1NjRjYzEzYWM3MTcyNmJlZDM",
+    "repository" : "maven-releases",
+    "format" : "maven2",
+    "checksum" : {
+      "sha1" : "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+      "sha256" : "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+      "md5" : "098f6bcd4621d373cade4e832627b4f6"
+    },
+    "contentType" : "application/java-archive",
+    "lastModified" : "2023-05-10T12:00:00.000+00:00"
+  }, {
+    "downloadUrl" : "%[1]s/repository/maven-releases/com/example-tools/cli/1.0.0/cli-1.0.0.jar",
+    "path" : "com/example-tools/cli/1.0.0/cli-1.0.0.jar",
+    "repository" : "maven-releases",
+    "format" : "maven2",
+    "checksum" : {
+      "sha1" : "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"
+    },
+    "lastModified" : "2023-05-10T12:00:00.000+00:00"
+  }, {
+    "downloadUrl" : "%[1]s/repository/maven-releases/org/other/lib/2.0.0/lib-2.0.0.jar",
+    "path" : "org/other/lib/2.0.0/lib-2.0.0.jar",
+    "repository" : "maven-releases",
+    "format" : "maven2",
+    "checksum" : {
+      "sha1" : "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"
+    },
+    "lastModified" : "2023-05-10T12:00:00.000+00:00"
+  } ],
+  "continuationToken" : "88491cd1d185dd136f143f20c4e7d50c"
+}`
+
+const nexusSearchPage2 = `{
+  "items" : [ {
+    "downloadUrl" : "%[1]s/repository/maven-releases/com/example/app/1.0.0/app-1.0.0.pom",
+    "path" : "com/example/app/1.0.0/app-1.0.0.pom",
+    "repository" : "maven-releases",
+    "format" : "maven2",
+    "checksum" : { },
+    "lastModified" : "2023-05-10T12:00:00.000+00:00"
+  } ],
+  "continuationToken" : null
+}`
+
+func TestNexusSnap(t *testing.T) {
+	t.Setenv(nexusUserEnvVar, "user")
+	t.Setenv(nexusPasswordEnvVar, "secret")
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc(nexusSearchPath, func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		require.Equal(t, "maven-releases", r.URL.Query().Get("repository"))
+		if r.URL.Query().Get("continuationToken") == "" {
+			fmt.Fprintf(w, nexusSearchPage1, server.URL)
+			return
+		}
+		fmt.Fprintf(w, nexusSearchPage2, server.URL)
+	})
+	mux.HandleFunc("/repository/maven-releases/com/example/app/1.0.0/app-1.0.0.pom", func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "test")
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	n, err := NewNexus("nexus://nexus.example.com/maven-releases/com/example")
+	require.NoError(t, err)
+	require.Equal(t, "maven-releases", n.Repository)
+	require.Equal(t, "com/example", n.Path)
+	n.apiURL = server.URL
+
+	// Assets in paths sharing the prefix (com/example-tools) are not listed
+	snap, err := n.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+
+	// Checksums from the search results are used as is
+	require.Equal(t, map[string]string{
+		"SHA1":   "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		"SHA256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}, (*snap)["com/example/app/1.0.0/app-1.0.0.jar"].Checksum)
+
+	// Assets without checksums are downloaded and hashed
+	require.Equal(t,
+		"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		(*snap)["com/example/app/1.0.0/app-1.0.0.pom"].Checksum["SHA256"],
+	)
+}
+
+const nexusSearchWeakDigests = `{
+  "items" : [ {
+    "downloadUrl" : "%[1]s/repository/raw/app.tar.gz",
+    "path" : "app.tar.gz",
+    "repository" : "raw",
+    "format" : "raw",
+    "checksum" : {
+      "crc32c" : "wT2Gsw==",
+      "md5" : "098f6bcd4621d373cade4e832627b4f6"
+    },
+    "lastModified" : "2023-05-10T12:00:00.000+00:00"
+  }, {
+    "downloadUrl" : "%[1]s/repository/raw/app.tar.gz.sig",
+    "path" : "app.tar.gz.sig",
+    "repository" : "raw",
+    "format" : "raw",
+    "checksum" : {
+      "sha1" : "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"
+    },
+    "lastModified" : "2023-05-10T12:00:00.000+00:00"
+  } ],
+  "continuationToken" : null
+}`
+
+func TestNexusTrustedDigests(t *testing.T) {
+	downloads := map[string]int{}
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc(nexusSearchPath, func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprintf(w, nexusSearchWeakDigests, server.URL)
+	})
+	mux.HandleFunc("/repository/raw/", func(w http.ResponseWriter, r *http.Request) {
+		downloads[r.URL.Path]++
+		fmt.Fprint(w, "test")
+	})
+	server = httptest.NewServer(mux)
+	defer server.Close()
+
+	n, err := NewNexus("nexus://nexus.example.com/raw")
+	require.NoError(t, err)
+	n.apiURL = server.URL
+	n.Options.Digests.Algorithms = []string{"SHA256"}
+
+	// With the defaults, weak digests are recomputed as sha256
+	snap, err := n.Snap()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"SHA256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}, (*snap)["app.tar.gz"].Checksum)
+	require.Equal(t, 1, downloads["/repository/raw/app.tar.gz"])
+	require.Equal(t, 1, downloads["/repository/raw/app.tar.gz.sig"])
+
+	// Trusting the store's crc32c and sha1 avoids the downloads
+	n.Options.Digests.TrustedAlgorithms = []string{"CRC32C", "SHA1"}
+	snap, err = n.Snap()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"CRC32C": "wT2Gsw=="}, (*snap)["app.tar.gz"].Checksum)
+	require.Equal(t, map[string]string{
+		"SHA1": "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+	}, (*snap)["app.tar.gz.sig"].Checksum)
+	require.Equal(t, 1, downloads["/repository/raw/app.tar.gz"])
+	require.Equal(t, 1, downloads["/repository/raw/app.tar.gz.sig"])
+}
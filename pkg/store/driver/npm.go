@@ -0,0 +1,211 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const (
+	npmScheme = "npm"
+
+	// DefaultNPMRegistry is the registry used when the
+	// spec URL does not specify one
+	DefaultNPMRegistry = "registry.npmjs.org"
+
+	// npmTokenEnvVar holds the token to authenticate to the registry
+	npmTokenEnvVar = "NPM_TOKEN"
+)
+
+// NPM is a store driver that reads the tarball of a package version
+// published to an npm registry. The digests are taken from the
+// registry metadata, the tarball is never downloaded.
+type NPM struct {
+	Registry string
+	Package  string
+	Version  string
+}
+
+// npmPackument is the subset of the package metadata document
+// returned by the registry that tejolote reads
+type npmPackument struct {
+	Name     string                       `json:"name"`
+	Versions map[string]npmPackageVersion `json:"versions"`
+	Time     map[string]time.Time         `json:"time"`
+}
+
+type npmPackageVersion struct {
+	Dist struct {
+		Tarball   string `json:"tarball"`
+		Shasum    string `json:"shasum"`
+		Integrity string `json:"integrity"`
+	} `json:"dist"`
+}
+
+// NewNPM returns a driver for a spec URL of the form
+// npm://registry/@scope/name/version or npm://registry/name/version.
+// Leading path elements before the package are kept as part of the
+// registry URL. Without a host, the public npm registry is used.
+func NewNPM(specURL string) (*NPM, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing npm spec url: %w", err)
+	}
+	if u.Scheme != npmScheme {
+		return nil, errors.New("spec url is not an npm url")
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || slices.Contains(parts, "") {
+		return nil, fmt.Errorf("npm url must have the form %s://registry/[@scope/]name/version", npmScheme)
+	}
+	version := parts[len(parts)-1]
+	name := parts[len(parts)-2]
+	prefix := parts[:len(parts)-2]
+	if len(prefix) > 0 && strings.HasPrefix(prefix[len(prefix)-1], "@") {
+		name = prefix[len(prefix)-1] + "/" + name
+		prefix = prefix[:len(prefix)-1]
+	}
+	if strings.HasPrefix(name, "@") && !strings.Contains(name, "/") {
+		return nil, fmt.Errorf("scoped npm package %s has no name", name)
+	}
+
+	registry := u.Host
+	if registry == "" {
+		registry = DefaultNPMRegistry
+	}
+	registryURL := "https://" + registry
+	if len(prefix) > 0 {
+		registryURL += "/" + strings.Join(prefix, "/")
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new npm storage backend")
+	return &NPM{
+		Registry: registryURL,
+		Package:  name,
+		Version:  version,
+	}, nil
+}
+
+// Snap returns a snapshot with the tarball of the package version,
+// recording the digests published in the registry metadata
+func (n *NPM) Snap() (*snapshot.Snapshot, error) {
+	packument, err := n.fetchPackument()
+	if err != nil {
+		return nil, fmt.Errorf("fetching metadata of %s: %w", n.Package, err)
+	}
+	version, ok := packument.Versions[n.Version]
+	if !ok {
+		return nil, fmt.Errorf("version %s of %s not found in registry", n.Version, n.Package)
+	}
+	if version.Dist.Tarball == "" {
+		return nil, fmt.Errorf("registry has no tarball for %s %s", n.Package, n.Version)
+	}
+
+	checksums, err := parseSRIDigests(version.Dist.Integrity)
+	if err != nil {
+		return nil, fmt.Errorf("parsing integrity of %s %s: %w", n.Package, n.Version, err)
+	}
+	if version.Dist.Shasum != "" {
+		checksums["SHA1"] = strings.ToLower(version.Dist.Shasum)
+	}
+	if len(checksums) == 0 {
+		return nil, fmt.Errorf("registry has no digests for %s %s", n.Package, n.Version)
+	}
+
+	return &snapshot.Snapshot{
+		version.Dist.Tarball: run.Artifact{
+			Path:     version.Dist.Tarball,
+			Checksum: checksums,
+			Time:     packument.Time[n.Version],
+		},
+	}, nil
+}
+
+// fetchPackument downloads the metadata document of the package
+func (n *NPM) fetchPackument() (*npmPackument, error) {
+	req, err := http.NewRequest(
+		http.MethodGet, n.Registry+"/"+url.PathEscape(n.Package), http.NoBody,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("creating http request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if token := os.Getenv(npmTokenEnvVar); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client, err := httpclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating http client: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing http request to npm registry: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http error from npm registry: %s", resp.Status)
+	}
+
+	packument := &npmPackument{}
+	if err := json.NewDecoder(resp.Body).Decode(packument); err != nil {
+		return nil, fmt.Errorf("decoding package metadata: %w", err)
+	}
+	return packument, nil
+}
+
+// parseSRIDigests converts a subresource integrity string, eg
+// "sha512-<base64>", to hex encoded checksums. Digests in algorithms
+// tejolote does not know are ignored.
+func parseSRIDigests(integrity string) (map[string]string, error) {
+	checksums := map[string]string{}
+	for _, entry := range strings.Fields(integrity) {
+		algo, value, ok := strings.Cut(entry, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid integrity entry %q", entry)
+		}
+		algo = strings.ToUpper(algo)
+		if _, ok := hashers[algo]; !ok {
+			continue
+		}
+		// Options may follow the digest, eg sha512-<base64>?opt
+		value, _, _ = strings.Cut(value, "?")
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("decoding %s digest: %w", algo, err)
+		}
+		checksums[algo] = hex.EncodeToString(sum)
+	}
+	return checksums, nil
+}
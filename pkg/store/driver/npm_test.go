@@ -0,0 +1,130 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Trimmed from https://registry.npmjs.org/@scope%2fapp
+const npmPackumentResponse = `{
+  "name": "@scope/app",
+  "versions": {
+    "1.0.0": {
+      "name": "@scope/app", "version": "1.0.0",
+      "dist": {
+        "tarball": "https://registry.example.com/@scope/app/-/app-1.0.0.tgz",
+        "shasum": "A94A8FE5CCB19BA61C4C0873D391E987982FBBD3",
+        "integrity": "sha512-7iaw3Ur350mqGo7jwQrpkj9hiYB3Lkc/iBml1JQODbJ6wYX4oOHV+E+IvIh/1nsUNzLDBMxfqa2Ob1f1ACio/w=="
+      }
+    },
+    "2.0.0": {
+      "name": "@scope/app", "version": "2.0.0",
+      "dist": {"tarball": "https://registry.example.com/@scope/app/-/app-2.0.0.tgz"}
+    }
+  },
+  "time": {
+    "1.0.0": "2023-05-10T12:00:00.000Z"
+  }
+}`
+
+func TestNPMSnap(t *testing.T) {
+	t.Setenv(npmTokenEnvVar, "npm-test")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer npm-test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.EscapedPath() != "/@scope%2Fapp" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, npmPackumentResponse)
+	}))
+	defer server.Close()
+
+	n, err := NewNPM("npm://registry.example.com/@scope/app/1.0.0")
+	require.NoError(t, err)
+	n.Registry = server.URL
+
+	snap, err := n.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 1)
+	artifact := (*snap)["https://registry.example.com/@scope/app/-/app-1.0.0.tgz"]
+	require.Equal(t, "https://registry.example.com/@scope/app/-/app-1.0.0.tgz", artifact.Path)
+	require.Equal(t, map[string]string{
+		"SHA1":   "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		"SHA512": "ee26b0dd4af7e749aa1a8ee3c10ae9923f618980772e473f8819a5d4940e0db27ac185f8a0e1d5f84f88bc887fd67b143732c304cc5fa9ad8e6f57f50028a8ff",
+	}, artifact.Checksum)
+	require.Equal(t, 2023, artifact.Time.Year())
+
+	// Versions without digests are an error
+	n.Version = "2.0.0"
+	_, err = n.Snap()
+	require.Error(t, err)
+
+	// Missing versions are an error
+	n.Version = "3.0.0"
+	_, err = n.Snap()
+	require.Error(t, err)
+}
+
+func TestNewNPM(t *testing.T) {
+	for _, tc := range []struct {
+		specURL, registry, pkg, version string
+	}{
+		{"npm:///left-pad/1.3.0", "https://registry.npmjs.org", "left-pad", "1.3.0"},
+		{"npm://registry.example.com/@scope/app/1.0.0", "https://registry.example.com", "@scope/app", "1.0.0"},
+		{"npm://example.com/api/npm/npm-local/@scope/app/1.0.0", "https://example.com/api/npm/npm-local", "@scope/app", "1.0.0"},
+	} {
+		n, err := NewNPM(tc.specURL)
+		require.NoError(t, err, tc.specURL)
+		require.Equal(t, tc.registry, n.Registry, tc.specURL)
+		require.Equal(t, tc.pkg, n.Package, tc.specURL)
+		require.Equal(t, tc.version, n.Version, tc.specURL)
+	}
+
+	for _, specURL := range []string{
+		"npm://registry.example.com/app",
+		"npm://registry.example.com/@scope/1.0.0",
+		"npm://registry.example.com//1.0.0",
+		"https://registry.example.com/app/1.0.0",
+	} {
+		_, err := NewNPM(specURL)
+		require.Error(t, err, specURL)
+	}
+}
+
+func TestParseSRIDigests(t *testing.T) {
+	checksums, err := parseSRIDigests("sha1-qUqP5cyxm6YcTAhz05Hph5gvu9M= md5-ignored sha256-n4bQgYhMfWWaL+qgxVrQFaO/TxsrC4Is0V1sFbDwCgg=?opt")
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"SHA1":   "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3",
+		"SHA256": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}, checksums)
+
+	_, err = parseSRIDigests("sha512")
+	require.Error(t, err)
+	_, err = parseSRIDigests("sha512-not base64")
+	require.Error(t, err)
+}
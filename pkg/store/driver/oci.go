@@ -19,22 +19,87 @@ package driver
 import (
 	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/docker/cli/cli/config"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/google"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sirupsen/logrus"
 
+	"sigs.k8s.io/tejolote/pkg/httpclient"
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
 )
 
+const (
+	ociUserEnvVar         = "OCI_USERNAME"
+	ociPasswordEnvVar     = "OCI_PASSWORD"
+	ociTokenEnvVar        = "OCI_TOKEN"
+	ociDockerConfigEnvVar = "OCI_DOCKER_CONFIG"
+)
+
 type OCI struct {
 	Repository string
 	Image      string
+	Options    OCIOptions
+}
+
+// OCIOptions control how the driver authenticates to the registry.
+// When none of the credentials are set, the default docker keychain
+// is used.
+type OCIOptions struct {
+	// Username and Password are used for basic auth
+	Username string
+	Password string
+
+	// Token is a registry bearer token
+	Token string
+
+	// DockerConfig is the path to a directory containing a docker
+	// config.json to read the credentials from
+	DockerConfig string
+
+	// Anonymous makes the driver try to list the tags anonymously
+	// first, falling back to the credentials if the registry
+	// rejects the request
+	Anonymous bool
+
+	// TagFilter limits the snapshot to the matching tags. It is a
+	// glob pattern (eg v1.*, or a single tag) or, when prefixed
+	// with regexp:, a regular expression.
+	TagFilter string
+
+	// Transport is the HTTP transport used to talk to the registry,
+	// when nil the default of the registry client is used
+	Transport http.RoundTripper
+
+	// GoogleListing reads the tags with the extended tag listing of
+	// Google Container Registry and Artifact Registry, which includes
+	// the digest of every tag. It is enabled for their hosts.
+	GoogleListing bool
 }
 
+// tagFilterRegexpPrefix marks tag filters that are regular expressions
+const tagFilterRegexpPrefix = "regexp:"
+
+var DefaultOCIOptions = OCIOptions{}
+
+// NewOCI returns a new OCI driver. Credentials are read from the
+// OCI_USERNAME, OCI_PASSWORD, OCI_TOKEN and OCI_DOCKER_CONFIG environment
+// variables. The docker config directory can also be set with the
+// docker-config query parameter, anonymous=true enables trying
+// without credentials first and tag sets the tag filter.
 func NewOCI(specURL string) (*OCI, error) {
 	u, err := url.Parse(specURL)
 	if err != nil {
@@ -43,21 +108,201 @@ func NewOCI(specURL string) (*OCI, error) {
 	if u.Path == "" {
 		return nil, errors.New("spec url is not wel formed")
 	}
-	oci := &OCI{}
+	oci := &OCI{Options: DefaultOCIOptions}
 	parts := strings.Split(u.Path, "/")
 	oci.Image = parts[len(parts)-1]
-	oci.Repository = u.Hostname()
+	oci.Repository = u.Host
 	if len(parts) > 1 {
 		oci.Repository += strings.Join(parts[0:len(parts)-1], "/")
 	}
+
+	if err := oci.Options.readAuth(u.Query()); err != nil {
+		return nil, err
+	}
+	oci.Options.TagFilter = u.Query().Get("tag")
+	oci.Options.GoogleListing = isGoogleRegistry(u.Host)
+	if _, err := oci.Options.matchTag(""); err != nil {
+		return nil, err
+	}
 	return oci, nil
 }
 
+// readAuth sets the registry credentials from the OCI_* environment
+// variables and the docker-config and anonymous query parameters. It
+// also sets the transport when a custom CA bundle is configured.
+func (oo *OCIOptions) readAuth(query url.Values) (err error) {
+	oo.Username = os.Getenv(ociUserEnvVar)
+	oo.Password = os.Getenv(ociPasswordEnvVar)
+	oo.Token = os.Getenv(ociTokenEnvVar)
+	oo.DockerConfig = os.Getenv(ociDockerConfigEnvVar)
+	if dir := query.Get("docker-config"); dir != "" {
+		oo.DockerConfig = dir
+	}
+	if anon := query.Get("anonymous"); anon != "" {
+		oo.Anonymous, err = strconv.ParseBool(anon)
+		if err != nil {
+			return fmt.Errorf("parsing anonymous option: %w", err)
+		}
+	}
+	// The registry client already uses the proxies set in the
+	// environment, its transport is only replaced to trust a custom CA
+	if os.Getenv(httpclient.CABundleEnvVar) != "" {
+		oo.Transport, err = httpclient.Transport()
+		if err != nil {
+			return fmt.Errorf("creating registry transport: %w", err)
+		}
+	}
+	return nil
+}
+
+// matchTag returns true if a tag matches the tag filter. All
+// tags match when there is no filter.
+func (oo *OCIOptions) matchTag(tag string) (bool, error) {
+	if oo.TagFilter == "" {
+		return true, nil
+	}
+	if expr, ok := strings.CutPrefix(oo.TagFilter, tagFilterRegexpPrefix); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return false, fmt.Errorf("invalid tag filter regular expression: %w", err)
+		}
+		return re.MatchString(tag), nil
+	}
+	match, err := path.Match(oo.TagFilter, tag)
+	if err != nil {
+		return false, fmt.Errorf("invalid tag filter pattern %q: %w", oo.TagFilter, err)
+	}
+	return match, nil
+}
+
+// authOption returns the crane option to authenticate with the
+// configured credentials
+func (oo *OCIOptions) authOption() crane.Option {
+	switch {
+	case oo.Token != "":
+		return crane.WithAuth(&authn.Bearer{Token: oo.Token})
+	case oo.Username != "":
+		return crane.WithAuth(&authn.Basic{Username: oo.Username, Password: oo.Password})
+	case oo.DockerConfig != "":
+		return crane.WithAuthFromKeychain(&dockerConfigKeychain{dir: oo.DockerConfig})
+	default:
+		return crane.WithAuthFromKeychain(authn.DefaultKeychain)
+	}
+}
+
+// transportOption returns the crane option to use the configured
+// transport, if any
+func (oo *OCIOptions) transportOption() crane.Option {
+	if oo.Transport == nil {
+		return func(*crane.Options) {}
+	}
+	return crane.WithTransport(oo.Transport)
+}
+
+// googleAuthOption returns the option to authenticate the Google
+// tag listing with the configured credentials
+func (oo *OCIOptions) googleAuthOption() google.Option {
+	switch {
+	case oo.Token != "":
+		return google.WithAuth(&authn.Bearer{Token: oo.Token})
+	case oo.Username != "":
+		return google.WithAuth(&authn.Basic{Username: oo.Username, Password: oo.Password})
+	case oo.DockerConfig != "":
+		return google.WithAuthFromKeychain(&dockerConfigKeychain{dir: oo.DockerConfig})
+	default:
+		return google.WithAuthFromKeychain(authn.DefaultKeychain)
+	}
+}
+
+// googleOptions returns the options of the Google tag listing
+// using auth and the configured transport, if any
+func (oo *OCIOptions) googleOptions(auth google.Option) []google.Option {
+	options := []google.Option{auth}
+	if oo.Transport != nil {
+		options = append(options, google.WithTransport(oo.Transport))
+	}
+	return options
+}
+
+// remoteAuthOption returns the option to authenticate the registry
+// client with the configured credentials
+func (oo *OCIOptions) remoteAuthOption() remote.Option {
+	switch {
+	case oo.Token != "":
+		return remote.WithAuth(&authn.Bearer{Token: oo.Token})
+	case oo.Username != "":
+		return remote.WithAuth(&authn.Basic{Username: oo.Username, Password: oo.Password})
+	case oo.DockerConfig != "":
+		return remote.WithAuthFromKeychain(&dockerConfigKeychain{dir: oo.DockerConfig})
+	default:
+		return remote.WithAuthFromKeychain(authn.DefaultKeychain)
+	}
+}
+
+// remoteOptions returns the options of the registry client using
+// auth and the configured transport, if any
+func (oo *OCIOptions) remoteOptions(auth remote.Option) []remote.Option {
+	options := []remote.Option{auth}
+	if oo.Transport != nil {
+		options = append(options, remote.WithTransport(oo.Transport))
+	}
+	return options
+}
+
+// remoteAuth returns the registry client options that succeed in
+// the first request to the registry, made by calling probe. When
+// anonymous access is enabled it is tried before the credentials.
+func (oo *OCIOptions) remoteAuth(probe func(options ...remote.Option) error) ([]remote.Option, error) {
+	if oo.Anonymous {
+		options := oo.remoteOptions(remote.WithAuth(authn.Anonymous))
+		err := probe(options...)
+		if err == nil {
+			return options, nil
+		}
+		logrus.Debugf("anonymous registry request failed, retrying with credentials: %v", err)
+	}
+	options := oo.remoteOptions(oo.remoteAuthOption())
+	return options, probe(options...)
+}
+
+// dockerConfigKeychain resolves credentials from the config.json
+// in a docker config directory
+type dockerConfigKeychain struct {
+	dir string
+}
+
+func (dk *dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	cf, err := config.Load(dk.dir)
+	if err != nil {
+		return nil, fmt.Errorf("loading docker config from %s: %w", dk.dir, err)
+	}
+	key := target.RegistryStr()
+	if key == name.DefaultRegistry {
+		key = authn.DefaultAuthKey
+	}
+	cfg, err := cf.GetAuthConfig(key)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials for %s: %w", key, err)
+	}
+	if cfg.Username == "" && cfg.Password == "" && cfg.Auth == "" &&
+		cfg.IdentityToken == "" && cfg.RegistryToken == "" {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		Auth:          cfg.Auth,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}), nil
+}
+
 // Snap
 func (oci *OCI) Snap() (*snapshot.Snapshot, error) {
-	tags, err := crane.ListTags(
-		oci.Repository+"/"+oci.Image, crane.WithAuthFromKeychain(authn.DefaultKeychain),
-	)
+	if oci.Options.GoogleListing {
+		return oci.snapGoogle()
+	}
+	tags, err := oci.listTags()
 	if err != nil {
 		return nil, fmt.Errorf("fetching tags from registry: %w", err)
 	}
@@ -71,3 +316,132 @@ func (oci *OCI) Snap() (*snapshot.Snapshot, error) {
 	}
 	return snap, nil
 }
+
+// isGoogleRegistry returns true if a registry host is served by
+// Google Container Registry or Artifact Registry
+func isGoogleRegistry(host string) bool {
+	return host == "gcr.io" || strings.HasSuffix(host, ".gcr.io") ||
+		strings.HasSuffix(host, "-docker.pkg.dev")
+}
+
+// snapGoogle snapshots the image tags using the Google tag listing.
+// A single request returns the tags with their digests, so they don't
+// need to be resolved one by one.
+func (oci *OCI) snapGoogle() (*snapshot.Snapshot, error) {
+	repo, err := name.NewRepository(oci.Repository + "/" + oci.Image)
+	if err != nil {
+		return nil, fmt.Errorf("parsing repository: %w", err)
+	}
+
+	var listing *google.Tags
+	if oci.Options.Anonymous {
+		listing, err = google.List(repo, oci.Options.googleOptions(google.WithAuth(authn.Anonymous))...)
+		if err != nil {
+			logrus.Debugf("anonymous tag listing failed, retrying with credentials: %v", err)
+		}
+	}
+	if listing == nil {
+		listing, err = google.List(repo, oci.Options.googleOptions(oci.Options.googleAuthOption())...)
+		if err != nil {
+			return nil, fmt.Errorf("fetching tags from registry: %w", err)
+		}
+	}
+
+	snap := &snapshot.Snapshot{}
+	for digest, manifest := range listing.Manifests {
+		algo, value, ok := strings.Cut(digest, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid digest %q", digest)
+		}
+		tags, err := oci.filterTags(manifest.Tags)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tags {
+			(*snap)["oci://"+t] = run.Artifact{
+				Path:     "oci://" + oci.Repository + "/" + oci.Image + ":" + t,
+				Checksum: map[string]string{strings.ToUpper(algo): value},
+				Time:     manifest.Uploaded,
+			}
+		}
+	}
+	return snap, nil
+}
+
+// ResolveDigest returns the digest of the manifest of an image
+// tag listed in the snapshot
+func (oci *OCI) ResolveDigest(path string) (map[string]string, error) {
+	digest, err := crane.Digest(strings.TrimPrefix(path, "oci://"), oci.Options.authOption(), oci.Options.transportOption())
+	if err != nil {
+		return nil, fmt.Errorf("fetching digest of %s: %w", path, err)
+	}
+	algo, value, ok := strings.Cut(digest, ":")
+	if !ok {
+		return nil, fmt.Errorf("invalid digest %q", digest)
+	}
+	return map[string]string{strings.ToUpper(algo): value}, nil
+}
+
+// download writes the contents of a single file stored in the
+// registry (eg pushed with oras) to w. The image or artifact in
+// the spec URL must have exactly one layer.
+func (oci *OCI) download(w io.Writer) error {
+	ref := oci.Repository + "/" + oci.Image
+	img, err := crane.Pull(ref, oci.Options.authOption(), oci.Options.transportOption())
+	if err != nil {
+		return fmt.Errorf("pulling %s: %w", ref, err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return fmt.Errorf("reading layers of %s: %w", ref, err)
+	}
+	if len(layers) != 1 {
+		return fmt.Errorf("%s has %d layers, downloads need exactly one", ref, len(layers))
+	}
+	blob, err := layers[0].Compressed()
+	if err != nil {
+		return fmt.Errorf("fetching blob: %w", err)
+	}
+	defer blob.Close()
+	if _, err := io.Copy(w, blob); err != nil {
+		return fmt.Errorf("reading blob data: %w", err)
+	}
+	return nil
+}
+
+// listTags lists the image tags matching the tag filter, trying
+// anonymously first when configured to do so
+func (oci *OCI) listTags() ([]string, error) {
+	ref := oci.Repository + "/" + oci.Image
+	if oci.Options.Anonymous {
+		tags, err := crane.ListTags(ref, crane.WithAuth(authn.Anonymous), oci.Options.transportOption())
+		if err == nil {
+			return oci.filterTags(tags)
+		}
+		logrus.Debugf("anonymous tag listing failed, retrying with credentials: %v", err)
+	}
+	tags, err := crane.ListTags(ref, oci.Options.authOption(), oci.Options.transportOption())
+	if err != nil {
+		return nil, err
+	}
+	return oci.filterTags(tags)
+}
+
+// filterTags returns the tags that match the tag filter
+func (oci *OCI) filterTags(tags []string) ([]string, error) {
+	if oci.Options.TagFilter == "" {
+		return tags, nil
+	}
+	filtered := []string{}
+	for _, t := range tags {
+		match, err := oci.Options.matchTag(t)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			filtered = append(filtered, t)
+		}
+	}
+	logrus.Debugf("%d of %d tags match the filter %s", len(filtered), len(tags), oci.Options.TagFilter)
+	return filtered, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+// ociRefNameAnnotation is the annotation holding the name of
+// an image in the index of an OCI layout
+const ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// OCILayout is a store that reads the images in an OCI image
+// layout directory written to disk by the build
+type OCILayout struct {
+	Path string
+}
+
+func NewOCILayout(specURL string) (*OCILayout, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing oci-layout spec url: %w", err)
+	}
+	if u.Scheme != "oci-layout+file" {
+		return nil, fmt.Errorf("spec URL %s is not an oci-layout url", specURL)
+	}
+	if u.Path == "" {
+		return nil, fmt.Errorf("oci-layout spec url has no path")
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new OCI layout storage backend")
+
+	return &OCILayout{
+		Path: u.Path,
+	}, nil
+}
+
+// Snap returns an artifact for each of the manifests in the layout.
+// Images are named after their ref name annotation if present, the
+// manifests of nested indexes are named after their digest.
+func (ol *OCILayout) Snap() (*snapshot.Snapshot, error) {
+	index, err := layout.ImageIndexFromPath(ol.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading oci layout: %w", err)
+	}
+	snap := snapshot.Snapshot{}
+	if err := addOCILayoutManifests(index, snap); err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// addOCILayoutManifests adds the manifests in the index to the
+// snapshot, descending into any nested indexes
+func addOCILayoutManifests(index v1.ImageIndex, snap snapshot.Snapshot) error {
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("reading index manifest: %w", err)
+	}
+	for _, desc := range manifest.Manifests {
+		path := desc.Digest.String()
+		if refName, ok := desc.Annotations[ociRefNameAnnotation]; ok && refName != "" {
+			path = refName
+		}
+		artifact := run.Artifact{
+			Path: path,
+			Checksum: map[string]string{
+				strings.ToUpper(desc.Digest.Algorithm): desc.Digest.Hex,
+			},
+			Annotations: map[string]string{},
+		}
+		if desc.ArtifactType != "" {
+			artifact.Annotations[run.AnnotationArtifactType] = desc.ArtifactType
+		}
+		snap[path] = artifact
+
+		if !desc.MediaType.IsIndex() {
+			continue
+		}
+		child, err := index.ImageIndex(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("reading index %s: %w", desc.Digest, err)
+		}
+		if err := addOCILayoutManifests(child, snap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOCILayout(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// A named image and a nested multi-image index
+	lp, err := layout.Write(dir, empty.Index)
+	require.NoError(t, err)
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	require.NoError(t, lp.AppendImage(img, layout.WithAnnotations(map[string]string{
+		ociRefNameAnnotation: "app:v1.0.0",
+	})))
+	idx, err := random.Index(512, 1, 2)
+	require.NoError(t, err)
+	require.NoError(t, lp.AppendIndex(idx))
+
+	sut, err := NewOCILayout("oci-layout+file://" + dir)
+	require.NoError(t, err)
+	snap, err := sut.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 4)
+
+	imgDigest, err := img.Digest()
+	require.NoError(t, err)
+	require.Contains(t, *snap, "app:v1.0.0")
+	require.Equal(t, imgDigest.Hex, (*snap)["app:v1.0.0"].Checksum["SHA256"])
+
+	idxDigest, err := idx.Digest()
+	require.NoError(t, err)
+	require.Contains(t, *snap, idxDigest.String())
+	manifest, err := idx.IndexManifest()
+	require.NoError(t, err)
+	for _, desc := range manifest.Manifests {
+		require.Contains(t, *snap, desc.Digest.String())
+	}
+
+	_, err = NewOCILayout("file://" + dir)
+	require.Error(t, err)
+}
@@ -0,0 +1,108 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const ociReferrersScheme = "oci-referrers://"
+
+// OCIReferrers is a store that lists the artifacts attached to an
+// image (signatures, SBOMs, attestations) using the OCI referrers API
+type OCIReferrers struct {
+	Digest  name.Digest
+	Options OCIOptions
+}
+
+// NewOCIReferrers returns a driver for the referrers of an image
+// digest. Registry credentials are read like in the oci:// driver.
+func NewOCIReferrers(specURL string) (*OCIReferrers, error) {
+	if !strings.HasPrefix(specURL, ociReferrersScheme) {
+		return nil, errors.New("spec url is not an oci-referrers url")
+	}
+	ref, query, err := splitOCIQuery(strings.TrimPrefix(specURL, ociReferrersScheme))
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(ref, "@") {
+		return nil, fmt.Errorf("oci-referrers url must point to a digest (repo@sha256:...)")
+	}
+	digest, err := name.NewDigest(ref)
+	if err != nil {
+		return nil, fmt.Errorf("parsing image digest: %w", err)
+	}
+	oci := &OCIReferrers{Digest: digest, Options: DefaultOCIOptions}
+	if err := oci.Options.readAuth(query); err != nil {
+		return nil, err
+	}
+	return oci, nil
+}
+
+// splitOCIQuery splits the query parameters from an image reference
+func splitOCIQuery(ref string) (string, url.Values, error) {
+	ref, rawQuery, _ := strings.Cut(ref, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", nil, fmt.Errorf("parsing spec url query: %w", err)
+	}
+	return ref, query, nil
+}
+
+// Snap returns each of the referrers of the image as an artifact,
+// the artifact type is recorded in the artifact annotations
+func (oci *OCIReferrers) Snap() (*snapshot.Snapshot, error) {
+	var index v1.ImageIndex
+	_, err := oci.Options.remoteAuth(func(options ...remote.Option) (err error) {
+		index, err = remote.Referrers(oci.Digest, options...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("fetching referrers of %s: %w", oci.Digest, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("reading referrers index: %w", err)
+	}
+
+	snap := snapshot.Snapshot{}
+	for _, desc := range manifest.Manifests {
+		path := "oci://" + oci.Digest.Context().Name() + "@" + desc.Digest.String()
+		artifact := run.Artifact{
+			Path: path,
+			Checksum: map[string]string{
+				strings.ToUpper(desc.Digest.Algorithm): desc.Digest.Hex,
+			},
+			Annotations: map[string]string{},
+		}
+		if desc.ArtifactType != "" {
+			artifact.Annotations[run.AnnotationArtifactType] = desc.ArtifactType
+		}
+		snap[path] = artifact
+	}
+	return &snap, nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+func TestOCIReferrers(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test/image"
+
+	// Push an image and an SBOM artifact attached to it
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	imgRef, err := name.ParseReference(repo + ":latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(imgRef, img))
+	imgDigest, err := img.Digest()
+	require.NoError(t, err)
+	desc, err := partial.Descriptor(img)
+	require.NoError(t, err)
+
+	sbom := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	sbom = mutate.ConfigMediaType(sbom, "application/spdx+json")
+	sbom = mutate.Subject(sbom, *desc).(v1.Image)
+	sbomDigest, err := sbom.Digest()
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(imgRef.Context().Digest(sbomDigest.String()), sbom))
+
+	sut, err := NewOCIReferrers("oci-referrers://" + repo + "@" + imgDigest.String())
+	require.NoError(t, err)
+	snap, err := sut.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 1)
+
+	path := "oci://" + repo + "@" + sbomDigest.String()
+	require.Contains(t, *snap, path)
+	require.Equal(t, sbomDigest.Hex, (*snap)[path].Checksum["SHA256"])
+	require.Equal(t, "application/spdx+json", (*snap)[path].Annotations[run.AnnotationArtifactType])
+
+	_, err = NewOCIReferrers("oci-referrers://" + repo + ":latest")
+	require.Error(t, err)
+}
+
+func TestOCIReferrersAuth(t *testing.T) {
+	server := httptest.NewServer(basicAuthRegistry("user", "secret", registry.WithReferrersSupport(true)))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test/image"
+
+	img, err := random.Image(512, 1)
+	require.NoError(t, err)
+	imgRef, err := name.ParseReference(repo + ":latest")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(
+		imgRef, img, remote.WithAuth(&authn.Basic{Username: "user", Password: "secret"}),
+	))
+	imgDigest, err := img.Digest()
+	require.NoError(t, err)
+	spec := "oci-referrers://" + repo + "@" + imgDigest.String()
+
+	// Without credentials the registry rejects the request
+	t.Setenv(ociUserEnvVar, "")
+	t.Setenv(ociPasswordEnvVar, "")
+	t.Setenv(ociTokenEnvVar, "")
+	t.Setenv(ociDockerConfigEnvVar, "")
+	sut, err := NewOCIReferrers(spec + "?docker-config=" + url.QueryEscape(t.TempDir()))
+	require.NoError(t, err)
+	require.Equal(t, imgDigest.String(), sut.Digest.DigestStr())
+	_, err = sut.Snap()
+	require.Error(t, err)
+
+	// Credentials are read from the environment like in the oci driver
+	t.Setenv(ociUserEnvVar, "user")
+	t.Setenv(ociPasswordEnvVar, "secret")
+	for _, s := range []string{spec, spec + "?anonymous=true"} {
+		sut, err = NewOCIReferrers(s)
+		require.NoError(t, err)
+		snap, err := sut.Snap()
+		require.NoError(t, err, s)
+		require.Empty(t, *snap)
+	}
+
+	_, err = NewOCIReferrers(spec + "?anonymous=maybe")
+	require.Error(t, err)
+}
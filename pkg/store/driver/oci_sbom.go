@@ -0,0 +1,191 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const ociSBOMScheme = "oci-sbom://"
+
+// OCISBOM is a store that reads the packages of the SBOM attached to
+// an image. The SBOM is looked up in the tag cosign attaches it to
+// (sha256-<digest>.sbom) and, if there is none, in the referrers of
+// the image.
+type OCISBOM struct {
+	Reference name.Reference
+	Options   OCIOptions
+}
+
+// NewOCISBOM returns a driver for the SBOM attached to an image.
+// Registry credentials are read like in the oci:// driver.
+func NewOCISBOM(specURL string) (*OCISBOM, error) {
+	if !strings.HasPrefix(specURL, ociSBOMScheme) {
+		return nil, errors.New("spec url is not an oci-sbom url")
+	}
+	imageRef, query, err := splitOCIQuery(strings.TrimPrefix(specURL, ociSBOMScheme))
+	if err != nil {
+		return nil, err
+	}
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return nil, fmt.Errorf("parsing image reference: %w", err)
+	}
+	oci := &OCISBOM{Reference: ref, Options: DefaultOCIOptions}
+	if err := oci.Options.readAuth(query); err != nil {
+		return nil, err
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new OCI SBOM storage backend")
+
+	return oci, nil
+}
+
+// Snap downloads the SBOM attached to the image and returns its
+// packages as artifacts. SPDX and CycloneDX documents are supported.
+func (oci *OCISBOM) Snap() (*snapshot.Snapshot, error) {
+	var desc *v1.Descriptor
+	options, err := oci.Options.remoteAuth(func(options ...remote.Option) (err error) {
+		desc, err = remote.Head(oci.Reference, options...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("resolving image digest: %w", err)
+	}
+	digest := oci.Reference.Context().Digest(desc.Digest.String())
+
+	mediaType, data, err := oci.fetchAttachedSBOM(digest, desc.Digest, options)
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		mediaType, data, err = oci.fetchReferrerSBOM(digest, options)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if data == nil {
+		return nil, fmt.Errorf("no sbom attached to %s", digest)
+	}
+
+	if strings.Contains(mediaType, "cyclonedx") {
+		return cycloneDXSnapshot(data)
+	}
+
+	// The SPDX parser reads documents from disk
+	f, err := os.CreateTemp("", "temp-sbom-")
+	if err != nil {
+		return nil, fmt.Errorf("creating temporary sbom file: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return nil, fmt.Errorf("writing sbom to temp file: %w", err)
+	}
+	return spdxSnapshot(f.Name(), false)
+}
+
+// fetchAttachedSBOM reads the SBOM cosign attaches to the image in
+// the sha256-<digest>.sbom tag. It returns nil data if the tag does
+// not exist.
+func (oci *OCISBOM) fetchAttachedSBOM(digest name.Digest, hash v1.Hash, options []remote.Option) (string, []byte, error) {
+	tag := digest.Context().Tag(fmt.Sprintf("%s-%s.sbom", hash.Algorithm, hash.Hex))
+	img, err := remote.Image(tag, options...)
+	if err != nil {
+		var terr *transport.Error
+		if errors.As(err, &terr) && terr.StatusCode == http.StatusNotFound {
+			return "", nil, nil
+		}
+		return "", nil, fmt.Errorf("fetching attached sbom %s: %w", tag, err)
+	}
+	return readSBOMLayer(img, "")
+}
+
+// fetchReferrerSBOM reads the first SBOM found in the referrers of
+// the image. It returns nil data if none of them is an SBOM.
+func (oci *OCISBOM) fetchReferrerSBOM(digest name.Digest, options []remote.Option) (string, []byte, error) {
+	index, err := remote.Referrers(digest, options...)
+	if err != nil {
+		return "", nil, fmt.Errorf("fetching referrers of %s: %w", digest, err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		return "", nil, fmt.Errorf("reading referrers index: %w", err)
+	}
+	for _, desc := range manifest.Manifests {
+		if !isSBOMMediaType(desc.ArtifactType) {
+			continue
+		}
+		img, err := remote.Image(digest.Context().Digest(desc.Digest.String()), options...)
+		if err != nil {
+			return "", nil, fmt.Errorf("fetching sbom %s: %w", desc.Digest, err)
+		}
+		return readSBOMLayer(img, desc.ArtifactType)
+	}
+	return "", nil, nil
+}
+
+// readSBOMLayer returns the media type and contents of the first
+// layer of an SBOM artifact. The artifact type is used when the
+// layer media type does not identify the document format.
+func readSBOMLayer(img v1.Image, artifactType string) (string, []byte, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return "", nil, fmt.Errorf("reading sbom layers: %w", err)
+	}
+	if len(layers) == 0 {
+		return "", nil, errors.New("sbom artifact has no layers")
+	}
+	layerType, err := layers[0].MediaType()
+	if err != nil {
+		return "", nil, fmt.Errorf("reading sbom media type: %w", err)
+	}
+	mediaType := string(layerType)
+	if !isSBOMMediaType(mediaType) {
+		mediaType = artifactType
+	}
+	rc, err := layers[0].Compressed()
+	if err != nil {
+		return "", nil, fmt.Errorf("opening sbom layer: %w", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return "", nil, fmt.Errorf("reading sbom layer: %w", err)
+	}
+	return mediaType, data, nil
+}
+
+// isSBOMMediaType returns true if the media type is one of an SPDX
+// or CycloneDX document
+func isSBOMMediaType(mediaType string) bool {
+	mediaType = strings.ToLower(mediaType)
+	return strings.Contains(mediaType, "spdx") || strings.Contains(mediaType, "cyclonedx")
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOCISBOM(t *testing.T) {
+	server := httptest.NewServer(registry.New(registry.WithReferrersSupport(true)))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/test/image"
+
+	// Push two images, one with the SBOM attached by cosign
+	// and one with the SBOM as a referrer
+	push := func(tag string) (v1.Image, v1.Hash) {
+		img, err := random.Image(1024, 1)
+		require.NoError(t, err)
+		ref, err := name.ParseReference(repo + ":" + tag)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, img))
+		digest, err := img.Digest()
+		require.NoError(t, err)
+		return img, digest
+	}
+
+	_, attachedDigest := push("attached")
+	sbom, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: static.NewLayer([]byte(testExternalRefsSBOM), "text/spdx"),
+	})
+	require.NoError(t, err)
+	sbomTag, err := name.NewTag(repo + ":sha256-" + attachedDigest.Hex + ".sbom")
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(sbomTag, sbom))
+
+	img, _ := push("referrer")
+	desc, err := partial.Descriptor(img)
+	require.NoError(t, err)
+	referrer, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: static.NewLayer([]byte(testCycloneDX), "application/vnd.cyclonedx+json"),
+	})
+	require.NoError(t, err)
+	referrer = mutate.MediaType(referrer, types.OCIManifestSchema1)
+	referrer = mutate.ConfigMediaType(referrer, "application/vnd.cyclonedx+json")
+	referrer = mutate.Subject(referrer, *desc).(v1.Image)
+	referrerDigest, err := referrer.Digest()
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(sbomTag.Context().Digest(referrerDigest.String()), referrer))
+
+	sut, err := NewOCISBOM("oci-sbom://" + repo + ":attached")
+	require.NoError(t, err)
+	snap, err := sut.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+	require.Contains(t, *snap, "pkg:golang/sigs.k8s.io/yaml@v1.4.0")
+
+	sut, err = NewOCISBOM("oci-sbom://" + repo + ":referrer")
+	require.NoError(t, err)
+	snap, err = sut.Snap()
+	require.NoError(t, err)
+	require.Contains(t, *snap, "pkg:npm/left-pad@1.3.0")
+
+	// Images without an SBOM fail
+	push("none")
+	sut, err = NewOCISBOM("oci-sbom://" + repo + ":none")
+	require.NoError(t, err)
+	_, err = sut.Snap()
+	require.Error(t, err)
+
+	// Registry options are read from the spec URL query
+	sut, err = NewOCISBOM("oci-sbom://" + repo + ":attached?anonymous=true")
+	require.NoError(t, err)
+	require.Equal(t, repo+":attached", sut.Reference.String())
+	require.True(t, sut.Options.Anonymous)
+	snap, err = sut.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+}
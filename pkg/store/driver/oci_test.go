@@ -17,9 +17,30 @@ limitations under the License.
 package driver
 
 import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
 )
 
 func TestOCISnapshot(t *testing.T) {
@@ -32,3 +53,254 @@ func TestOCISnapshot(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, *snap, 5)
 }
+
+// basicAuthRegistry wraps a registry requiring the user and password
+func basicAuthRegistry(user, pass string, opts ...registry.Option) http.Handler {
+	reg := registry.New(opts...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != user || p != pass {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	})
+}
+
+func TestOCIAuth(t *testing.T) {
+	server := httptest.NewServer(basicAuthRegistry("user", "secret"))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img, err := random.Image(512, 1)
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(
+		img, host+"/test/image:v1", crane.WithAuth(&authn.Basic{Username: "user", Password: "secret"}),
+	))
+
+	for _, tc := range []struct {
+		name    string
+		options OCIOptions
+		mustErr bool
+	}{
+		{"no credentials", OCIOptions{DockerConfig: t.TempDir()}, true},
+		{"basic auth", OCIOptions{Username: "user", Password: "secret"}, false},
+		{"wrong password", OCIOptions{Username: "user", Password: "wrong"}, true},
+		{"anonymous with fallback", OCIOptions{Username: "user", Password: "secret", Anonymous: true}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			oci, err := NewOCI("oci://" + host + "/test/image")
+			require.NoError(t, err)
+			require.Equal(t, host+"/test", oci.Repository)
+			oci.Options = tc.options
+			snap, err := oci.Snap()
+			if tc.mustErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Contains(t, *snap, "oci://v1")
+		})
+	}
+
+	// Credentials read from a docker config directory
+	dir := t.TempDir()
+	auth := base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "config.json"),
+		[]byte(`{"auths":{"`+host+`":{"auth":"`+auth+`"}}}`), os.FileMode(0o600),
+	))
+	oci, err := NewOCI("oci://" + host + "/test/image?docker-config=" + url.QueryEscape(dir))
+	require.NoError(t, err)
+	require.Equal(t, dir, oci.Options.DockerConfig)
+	snap, err := oci.Snap()
+	require.NoError(t, err)
+	require.Contains(t, *snap, "oci://v1")
+}
+
+func TestNewOCIOptions(t *testing.T) {
+	t.Setenv(ociUserEnvVar, "user")
+	t.Setenv(ociPasswordEnvVar, "secret")
+	t.Setenv(ociTokenEnvVar, "")
+	t.Setenv(ociDockerConfigEnvVar, "/etc/docker")
+	oci, err := NewOCI("oci://harbor.example.com:8443/project/image?anonymous=true")
+	require.NoError(t, err)
+	require.Equal(t, "harbor.example.com:8443/project", oci.Repository)
+	require.Equal(t, "image", oci.Image)
+	require.Equal(t, OCIOptions{
+		Username: "user", Password: "secret", DockerConfig: "/etc/docker", Anonymous: true,
+	}, oci.Options)
+
+	_, err = NewOCI("oci://harbor.example.com/project/image?anonymous=maybe")
+	require.Error(t, err)
+}
+
+func TestOCITagFilter(t *testing.T) {
+	oci := &OCI{Repository: "registry.example.com/project", Image: "image"}
+	tags := []string{"v1.0.0", "v1.0.1", "v1.1.0", "v2.0.0", "latest", "sha256-abc.sig"}
+	for _, tc := range []struct {
+		filter   string
+		expected []string
+	}{
+		{"", tags},
+		{"v1.*", []string{"v1.0.0", "v1.0.1", "v1.1.0"}},
+		{"v1.0.1", []string{"v1.0.1"}},
+		{"v3.*", []string{}},
+		{`regexp:^v\d+\.0\.0$`, []string{"v1.0.0", "v2.0.0"}},
+	} {
+		oci.Options.TagFilter = tc.filter
+		filtered, err := oci.filterTags(tags)
+		require.NoError(t, err, tc.filter)
+		require.Equal(t, tc.expected, filtered, tc.filter)
+	}
+
+	// Filters are read from the tag query parameter and checked
+	oci, err := NewOCI("oci://registry.example.com/project/image?tag=" + url.QueryEscape("v1.*"))
+	require.NoError(t, err)
+	require.Equal(t, "v1.*", oci.Options.TagFilter)
+	_, err = NewOCI("oci://registry.example.com/project/image?tag=" + url.QueryEscape("v1.["))
+	require.Error(t, err)
+	_, err = NewOCI("oci://registry.example.com/project/image?tag=" + url.QueryEscape("regexp:v1.("))
+	require.Error(t, err)
+
+	// Only the matching tags are snapshotted
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	img, err := random.Image(512, 1)
+	require.NoError(t, err)
+	for _, tag := range []string{"v1.0.0", "v1.1.0", "v2.0.0"} {
+		require.NoError(t, crane.Push(img, host+"/test/image:"+tag))
+	}
+	oci, err = NewOCI("oci://" + host + "/test/image?anonymous=true&tag=" + url.QueryEscape("v1.*"))
+	require.NoError(t, err)
+	snap, err := oci.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+	require.Contains(t, *snap, "oci://v1.0.0")
+	require.NotContains(t, *snap, "oci://v2.0.0")
+}
+
+func TestOCIDownload(t *testing.T) {
+	server := httptest.NewServer(registry.New())
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	data := []byte(`{"_type":"https://in-toto.io/Statement/v0.1"}`)
+	img, err := mutate.AppendLayers(empty.Image, static.NewLayer(data, types.MediaType("application/vnd.in-toto+json")))
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(img, host+"/test/attestation:partial"))
+
+	var b bytes.Buffer
+	require.NoError(t, DownloadURL("intoto+oci://"+host+"/test/attestation:partial", &b))
+	require.Equal(t, data, b.Bytes())
+
+	// Images with more than one layer are not single files
+	multi, err := random.Image(512, 2)
+	require.NoError(t, err)
+	require.NoError(t, crane.Push(multi, host+"/test/image:v1"))
+	require.Error(t, DownloadURL("oci://"+host+"/test/image:v1", &bytes.Buffer{}))
+}
+
+// googleRegistry serves the tags of an image in the extended format
+// of the Google tag listing, along with the manifest digests
+func googleRegistry(repo string, numTags int) http.Handler {
+	tags := []string{}
+	manifests := map[string]interface{}{}
+	for i := 0; i < numTags; i++ {
+		tag := fmt.Sprintf("v%d", i)
+		digest := fmt.Sprintf("sha256:%064x", i)
+		tags = append(tags, tag)
+		manifests[digest] = map[string]interface{}{
+			"imageSizeBytes": "1024",
+			"mediaType":      string(types.OCIManifestSchema1),
+			"tag":            []string{tag},
+			"timeCreatedMs":  "1683720000000",
+			"timeUploadedMs": "1683720000000",
+		}
+	}
+	listing, err := json.Marshal(map[string]interface{}{
+		"child": []string{}, "manifest": manifests, "name": repo, "tags": tags,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case r.URL.Path == "/v2/"+repo+"/tags/list":
+			w.Write(listing) //nolint: errcheck
+		case strings.HasPrefix(r.URL.Path, "/v2/"+repo+"/manifests/v"):
+			var i int
+			fmt.Sscanf(strings.TrimPrefix(r.URL.Path, "/v2/"+repo+"/manifests/v"), "%d", &i) //nolint: errcheck
+			w.Header().Set("Docker-Content-Digest", fmt.Sprintf("sha256:%064x", i))
+			w.Header().Set("Content-Type", string(types.OCIManifestSchema1))
+			w.Header().Set("Content-Length", "1024")
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+}
+
+func TestOCIGoogleListing(t *testing.T) {
+	require.True(t, isGoogleRegistry("gcr.io"))
+	require.True(t, isGoogleRegistry("us.gcr.io"))
+	require.True(t, isGoogleRegistry("europe-west1-docker.pkg.dev"))
+	require.False(t, isGoogleRegistry("ghcr.io"))
+	require.False(t, isGoogleRegistry("registry.example.com"))
+
+	server := httptest.NewServer(googleRegistry("project/image", 3))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	oci, err := NewOCI("oci://" + host + "/project/image?tag=" + url.QueryEscape("v[01]"))
+	require.NoError(t, err)
+	require.False(t, oci.Options.GoogleListing)
+	oci.Options.GoogleListing = true
+	oci.Options.DockerConfig = t.TempDir()
+
+	// The digests come from the listing, filtered by tag
+	snap, err := oci.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+	require.Equal(t, run.Artifact{
+		Path:     "oci://" + host + "/project/image:v1",
+		Checksum: map[string]string{"SHA256": fmt.Sprintf("%064x", 1)},
+		Time:     time.UnixMilli(1683720000000),
+	}, (*snap)["oci://v1"])
+}
+
+// BenchmarkOCISnapDigests compares snapshotting 500 tags with their
+// digests using the Google listing and resolving each tag
+func BenchmarkOCISnapDigests(b *testing.B) {
+	server := httptest.NewServer(googleRegistry("project/image", 500))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	oci, err := NewOCI("oci://" + host + "/project/image")
+	require.NoError(b, err)
+	oci.Options.DockerConfig = b.TempDir()
+
+	b.Run("google", func(b *testing.B) {
+		oci.Options.GoogleListing = true
+		for i := 0; i < b.N; i++ {
+			snap, err := oci.Snap()
+			require.NoError(b, err)
+			require.Len(b, *snap, 500)
+		}
+	})
+
+	b.Run("generic", func(b *testing.B) {
+		oci.Options.GoogleListing = false
+		for i := 0; i < b.N; i++ {
+			snap, err := oci.Snap()
+			require.NoError(b, err)
+			for _, a := range *snap {
+				_, err := oci.ResolveDigest(a.Path)
+				require.NoError(b, err)
+			}
+		}
+	})
+}
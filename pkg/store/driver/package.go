@@ -0,0 +1,300 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+const (
+	// ar archive magic and member header length of .deb files
+	arMagic        = "!<arch>\n"
+	arHeaderLength = 60
+
+	// Header magic and tags of rpm packages
+	rpmLeadLength   = 96
+	rpmTagName      = 1000
+	rpmTagVersion   = 1001
+	rpmTagRelease   = 1002
+	rpmTagEpoch     = 1003
+	rpmTagArch      = 1022
+	rpmTypeInt32    = 4
+	rpmTypeString   = 6
+	rpmMaxIndexSize = 1 << 16
+	rpmMaxStoreSize = 64 << 20
+
+	// debControlMaxLength caps the size of the control file we read
+	debControlMaxLength = 1 << 20
+)
+
+var rpmHeaderMagic = []byte{0x8e, 0xad, 0xe8, 0x01}
+
+// packageInfo is the metadata of an OS package
+type packageInfo struct {
+	Name    string
+	Version string
+	Arch    string
+}
+
+// annotations returns the package metadata as artifact annotations
+func (p *packageInfo) annotations() map[string]string {
+	return map[string]string{
+		run.AnnotationPackageName:    p.Name,
+		run.AnnotationPackageVersion: p.Version,
+		run.AnnotationPackageArch:    p.Arch,
+	}
+}
+
+// packageMetadata reads the name, version and architecture of a
+// Debian or RPM package. Files without a .deb or .rpm extension
+// return nil.
+func packageMetadata(path string) (*packageInfo, error) {
+	var parse func(io.Reader) (*packageInfo, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".deb":
+		parse = debMetadata
+	case ".rpm":
+		parse = rpmMetadata
+	default:
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening package: %w", err)
+	}
+	defer f.Close()
+	return parse(bufio.NewReader(f))
+}
+
+// debMetadata reads the control file from the control.tar member
+// of a .deb package
+func debMetadata(r io.Reader) (*packageInfo, error) {
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return nil, fmt.Errorf("reading ar magic: %w", err)
+	}
+	if string(magic) != arMagic {
+		return nil, errors.New("package is not an ar archive")
+	}
+
+	header := make([]byte, arHeaderLength)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("control archive not found in package")
+			}
+			return nil, fmt.Errorf("reading ar header: %w", err)
+		}
+		name := strings.TrimSuffix(strings.TrimSpace(string(header[0:16])), "/")
+		size, err := strconv.ParseInt(strings.TrimSpace(string(header[48:58])), 10, 64)
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid size of ar member %s", name)
+		}
+		member := io.LimitReader(r, size)
+
+		if strings.HasPrefix(name, "control.tar") {
+			return debControlTar(name, member)
+		}
+
+		// Members are padded to an even length
+		if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+			return nil, fmt.Errorf("skipping ar member %s: %w", name, err)
+		}
+	}
+}
+
+// debControlTar finds and parses the control file in the
+// (possibly compressed) control tarball of a .deb
+func debControlTar(name string, r io.Reader) (*packageInfo, error) {
+	var plain io.Reader
+	if strings.HasSuffix(name, ".zst") {
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("opening zstd control archive: %w", err)
+		}
+		defer zr.Close()
+		plain = zr
+	} else {
+		var err error
+		plain, err = decompressReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing control archive: %w", err)
+		}
+	}
+
+	tr := tar.NewReader(plain)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("control file not found in package")
+			}
+			return nil, fmt.Errorf("reading control archive: %w", err)
+		}
+		if strings.TrimPrefix(hdr.Name, "./") != "control" {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(tr, debControlMaxLength))
+		if err != nil {
+			return nil, fmt.Errorf("reading control file: %w", err)
+		}
+		return parseDebControl(data)
+	}
+}
+
+// parseDebControl reads the package fields from a control file
+func parseDebControl(data []byte) (*packageInfo, error) {
+	info := &packageInfo{}
+	for _, line := range strings.Split(string(data), "\n") {
+		// Skip continuation lines of multiline fields
+		if line == "" || line[0] == ' ' || line[0] == '\t' {
+			continue
+		}
+		field, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch strings.ToLower(field) {
+		case "package":
+			info.Name = value
+		case "version":
+			info.Version = value
+		case "architecture":
+			info.Arch = value
+		}
+	}
+	if info.Name == "" {
+		return nil, errors.New("control file has no package name")
+	}
+	return info, nil
+}
+
+// rpmMetadata reads the package tags from the main header of
+// an RPM, skipping the lead and the signature header
+func rpmMetadata(r io.Reader) (*packageInfo, error) {
+	if _, err := io.CopyN(io.Discard, r, rpmLeadLength); err != nil {
+		return nil, fmt.Errorf("reading rpm lead: %w", err)
+	}
+
+	// The signature header is padded to a multiple of 8 bytes
+	_, storeSize, err := readRPMHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading signature header: %w", err)
+	}
+	if pad := (8 - storeSize%8) % 8; pad > 0 {
+		if _, err := io.CopyN(io.Discard, r, int64(pad)); err != nil {
+			return nil, fmt.Errorf("reading signature padding: %w", err)
+		}
+	}
+
+	tags, _, err := readRPMHeader(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading main header: %w", err)
+	}
+
+	info := &packageInfo{
+		Name: tags[rpmTagName],
+		Arch: tags[rpmTagArch],
+	}
+	if info.Name == "" {
+		return nil, errors.New("rpm header has no package name")
+	}
+	info.Version = tags[rpmTagVersion]
+	if tags[rpmTagRelease] != "" {
+		info.Version += "-" + tags[rpmTagRelease]
+	}
+	if tags[rpmTagEpoch] != "" {
+		info.Version = tags[rpmTagEpoch] + ":" + info.Version
+	}
+	return info, nil
+}
+
+// rpmIndexEntry is an entry of the index of an rpm header
+type rpmIndexEntry struct {
+	Tag    uint32
+	Type   uint32
+	Offset uint32
+	Count  uint32
+}
+
+// readRPMHeader reads an rpm header structure and returns the string
+// and int32 values of the tags we use, and the size of its data store
+func readRPMHeader(r io.Reader) (tags map[int]string, storeSize uint32, err error) {
+	var intro struct {
+		Magic    [4]byte
+		Reserved [4]byte
+		Entries  uint32
+		Size     uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &intro); err != nil {
+		return nil, 0, fmt.Errorf("reading header intro: %w", err)
+	}
+	if !bytes.Equal(intro.Magic[:], rpmHeaderMagic) {
+		return nil, 0, errors.New("invalid rpm header magic")
+	}
+	if intro.Entries > rpmMaxIndexSize || intro.Size > rpmMaxStoreSize {
+		return nil, 0, errors.New("rpm header too large")
+	}
+
+	entries := make([]rpmIndexEntry, intro.Entries)
+	if err := binary.Read(r, binary.BigEndian, entries); err != nil {
+		return nil, 0, fmt.Errorf("reading header index: %w", err)
+	}
+	data := make([]byte, intro.Size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, 0, fmt.Errorf("reading header store: %w", err)
+	}
+
+	tags = map[int]string{}
+	for _, e := range entries {
+		switch e.Tag {
+		case rpmTagName, rpmTagVersion, rpmTagRelease, rpmTagEpoch, rpmTagArch:
+		default:
+			continue
+		}
+		if e.Offset >= intro.Size {
+			return nil, 0, fmt.Errorf("tag %d points outside the header", e.Tag)
+		}
+		switch e.Type {
+		case rpmTypeString:
+			value, _, _ := bytes.Cut(data[e.Offset:], []byte{0})
+			tags[int(e.Tag)] = string(value)
+		case rpmTypeInt32:
+			if int(e.Offset)+4 > len(data) {
+				return nil, 0, fmt.Errorf("tag %d points outside the header", e.Tag)
+			}
+			tags[int(e.Tag)] = strconv.FormatUint(uint64(binary.BigEndian.Uint32(data[e.Offset:])), 10)
+		}
+	}
+	return tags, intro.Size, nil
+}
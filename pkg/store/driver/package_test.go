@@ -0,0 +1,173 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+const testControl = `Package: tejolote
+Version: 1.2.3-1
+Architecture: amd64
+Description: attests to build runs
+ Version: this continuation line is not a field
+`
+
+// testDeb builds a .deb with the control file in a control
+// tarball compressed with the specified format (gz or zst)
+func testDeb(t *testing.T, compression string) []byte {
+	var tarball bytes.Buffer
+	tw := tar.NewWriter(&tarball)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0o755}))
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "./control", Size: int64(len(testControl)), Mode: 0o644}))
+	_, err := tw.Write([]byte(testControl))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+
+	var control bytes.Buffer
+	switch compression {
+	case "gz":
+		gw := gzip.NewWriter(&control)
+		_, err = gw.Write(tarball.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+	case "zst":
+		zw, err := zstd.NewWriter(&control)
+		require.NoError(t, err)
+		_, err = zw.Write(tarball.Bytes())
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+	}
+
+	var deb bytes.Buffer
+	deb.WriteString(arMagic)
+	for _, member := range []struct {
+		name string
+		data []byte
+	}{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar." + compression, control.Bytes()},
+		{"data.tar.xz", []byte("not read")},
+	} {
+		fmt.Fprintf(&deb, "%-16s%-12s%-6s%-6s%-8s%-10d`\n", member.name, "0", "0", "0", "100644", len(member.data))
+		deb.Write(member.data)
+		if len(member.data)%2 == 1 {
+			deb.WriteByte('\n')
+		}
+	}
+	return deb.Bytes()
+}
+
+// testRPMHeader serializes an rpm header structure with string and int32 tags
+func testRPMHeader(t *testing.T, strs map[uint32]string, ints map[uint32]uint32) []byte {
+	var index, store bytes.Buffer
+	entries := 0
+	for tag, value := range ints {
+		require.NoError(t, binary.Write(&index, binary.BigEndian, rpmIndexEntry{
+			Tag: tag, Type: rpmTypeInt32, Offset: uint32(store.Len()), Count: 1,
+		}))
+		require.NoError(t, binary.Write(&store, binary.BigEndian, value))
+		entries++
+	}
+	for tag, value := range strs {
+		require.NoError(t, binary.Write(&index, binary.BigEndian, rpmIndexEntry{
+			Tag: tag, Type: rpmTypeString, Offset: uint32(store.Len()), Count: 1,
+		}))
+		store.WriteString(value + "\x00")
+		entries++
+	}
+
+	var header bytes.Buffer
+	header.Write(rpmHeaderMagic)
+	header.Write(make([]byte, 4))
+	require.NoError(t, binary.Write(&header, binary.BigEndian, uint32(entries)))
+	require.NoError(t, binary.Write(&header, binary.BigEndian, uint32(store.Len())))
+	header.Write(index.Bytes())
+	header.Write(store.Bytes())
+	return header.Bytes()
+}
+
+// testRPM builds an rpm with a lead, a signature header and
+// the main header with the package tags
+func testRPM(t *testing.T) []byte {
+	var rpm bytes.Buffer
+	rpm.Write(make([]byte, rpmLeadLength))
+	sig := testRPMHeader(t, map[uint32]string{1004: "odd"}, nil)
+	rpm.Write(sig)
+	rpm.Write(make([]byte, (8-(len(sig)-16-16)%8)%8))
+	rpm.Write(testRPMHeader(t, map[uint32]string{
+		rpmTagName:    "tejolote",
+		rpmTagVersion: "1.2.3",
+		rpmTagRelease: "1.el9",
+		rpmTagArch:    "x86_64",
+	}, map[uint32]uint32{rpmTagEpoch: 2}))
+	rpm.WriteString("payload")
+	return rpm.Bytes()
+}
+
+func TestPackageMetadata(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"tejolote_1.2.3-1_amd64.deb":      testDeb(t, "gz"),
+		"tejolote_zstd.deb":               testDeb(t, "zst"),
+		"tejolote-1.2.3-1.el9.x86_64.rpm": testRPM(t),
+		"README":                          []byte("not a package"),
+	} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o644))
+	}
+
+	for _, tc := range []struct {
+		file     string
+		expected *packageInfo
+	}{
+		{"tejolote_1.2.3-1_amd64.deb", &packageInfo{Name: "tejolote", Version: "1.2.3-1", Arch: "amd64"}},
+		{"tejolote_zstd.deb", &packageInfo{Name: "tejolote", Version: "1.2.3-1", Arch: "amd64"}},
+		{"tejolote-1.2.3-1.el9.x86_64.rpm", &packageInfo{Name: "tejolote", Version: "2:1.2.3-1.el9", Arch: "x86_64"}},
+		{"README", nil},
+	} {
+		info, err := packageMetadata(filepath.Join(dir, tc.file))
+		require.NoError(t, err, tc.file)
+		require.Equal(t, tc.expected, info, tc.file)
+	}
+
+	// Files with a package extension that are not packages fail
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "fake.deb"), []byte("nope"), 0o644))
+	_, err := packageMetadata(filepath.Join(dir, "fake.deb"))
+	require.Error(t, err)
+
+	// The directory store annotates the packages and ignores the errors
+	d, err := NewDirectory("file://" + dir)
+	require.NoError(t, err)
+	snap, err := d.Snap()
+	require.NoError(t, err)
+	require.Equal(t, "x86_64", (*snap)["tejolote-1.2.3-1.el9.x86_64.rpm"].Annotations[run.AnnotationPackageArch])
+	require.Equal(t, "1.2.3-1", (*snap)["tejolote_1.2.3-1_amd64.deb"].Annotations[run.AnnotationPackageVersion])
+	require.Nil(t, (*snap)["fake.deb"].Annotations)
+	require.Nil(t, (*snap)["README"].Annotations)
+}
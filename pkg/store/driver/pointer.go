@@ -0,0 +1,104 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+)
+
+const (
+	// maxPointerSize is the largest file read as a pointer
+	maxPointerSize = 1024
+
+	// pointerPlaceholder is replaced with the pointer contents
+	// in the target template
+	pointerPlaceholder = "{}"
+)
+
+// PointerOptions configure the resolution of pointer files. Pointers
+// are small text files (eg latest.txt) holding the version or path of
+// a release artifact. When resolved, the artifact they point to is
+// recorded instead of the pointer.
+type PointerOptions struct {
+	// Patterns are the glob patterns matching the names of the
+	// pointer files. Pointers are not resolved when empty.
+	Patterns []string
+
+	// Target is the template of the target location, {} is replaced
+	// with the contents of the pointer. Relative locations are resolved
+	// from the directory of the pointer. Defaults to the contents.
+	Target string
+}
+
+// pointerOptionsFromQuery reads the pointer options from the spec URL
+// query: pointers is a comma separated list of patterns and
+// pointer-target the target template.
+func pointerOptionsFromQuery(query url.Values) PointerOptions {
+	opts := PointerOptions{Target: query.Get("pointer-target")}
+	for _, p := range strings.Split(query.Get("pointers"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			opts.Patterns = append(opts.Patterns, p)
+		}
+	}
+	return opts
+}
+
+// matches returns true if the file name matches the pointer patterns
+func (po *PointerOptions) matches(name string) bool {
+	for _, p := range po.Patterns {
+		if ok, err := path.Match(p, path.Base(name)); err == nil && ok {
+			return true
+		}
+	}
+	return false
+}
+
+// target returns the location the pointer contents refer to
+func (po *PointerOptions) target(contents []byte) (string, error) {
+	if len(contents) > maxPointerSize {
+		return "", fmt.Errorf("pointer is larger than %d bytes", maxPointerSize)
+	}
+	value := strings.TrimSpace(string(contents))
+	if value == "" {
+		return "", errors.New("pointer is empty")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return "", errors.New("pointer has more than one line")
+	}
+	if po.Target == "" {
+		return value, nil
+	}
+	return strings.ReplaceAll(po.Target, pointerPlaceholder, value), nil
+}
+
+// resolvePointerURL resolves the target of a pointer relative
+// to the URL of the pointer file
+func resolvePointerURL(pointerURL, target string) (string, error) {
+	base, err := url.Parse(pointerURL)
+	if err != nil {
+		return "", fmt.Errorf("parsing pointer url: %w", err)
+	}
+	ref, err := url.Parse(target)
+	if err != nil {
+		return "", fmt.Errorf("parsing pointer target: %w", err)
+	}
+	return base.ResolveReference(ref).String(), nil
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+const (
+	pypiScheme = "pypi"
+
+	// DefaultPyPIIndex is the index used when the
+	// spec URL does not specify one
+	DefaultPyPIIndex = "pypi.org"
+
+	// Environment variables holding the credentials of private indexes
+	pypiUserEnvVar     = "PYPI_USERNAME"
+	pypiPasswordEnvVar = "PYPI_PASSWORD"
+)
+
+// pypiDigests maps the digest names of the PyPI JSON
+// API to the algorithm names recorded in the artifacts
+var pypiDigests = map[string]string{
+	"sha256":      "SHA256",
+	"blake2b_256": "BLAKE2B-256",
+}
+
+// PyPI is a store driver that reads the files of a release published
+// to a Python package index. The digests are taken from the JSON API,
+// the files are never downloaded.
+type PyPI struct {
+	Index   string
+	Project string
+	Version string
+}
+
+// pypiRelease is the subset of the release document
+// returned by the JSON API that tejolote reads
+type pypiRelease struct {
+	URLs []pypiReleaseFile `json:"urls"`
+}
+
+type pypiReleaseFile struct {
+	Filename   string            `json:"filename"`
+	URL        string            `json:"url"`
+	Digests    map[string]string `json:"digests"`
+	UploadTime time.Time         `json:"upload_time_iso_8601"`
+}
+
+// NewPyPI returns a driver for a spec URL of the form
+// pypi://index/project/name/version. Leading path elements before
+// project are kept as part of the index URL to support private
+// indexes. Without a host, the public PyPI index is used.
+func NewPyPI(specURL string) (*PyPI, error) {
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing pypi spec url: %w", err)
+	}
+	if u.Scheme != pypiScheme {
+		return nil, errors.New("spec url is not a pypi url")
+	}
+
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 || slices.Contains(parts, "") || parts[len(parts)-3] != "project" {
+		return nil, fmt.Errorf("pypi url must have the form %s://index/project/name/version", pypiScheme)
+	}
+
+	index := u.Host
+	if index == "" {
+		index = DefaultPyPIIndex
+	}
+	indexURL := "https://" + index
+	if prefix := parts[:len(parts)-3]; len(prefix) > 0 {
+		indexURL += "/" + strings.Join(prefix, "/")
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new PyPI storage backend")
+	return &PyPI{
+		Index:   indexURL,
+		Project: parts[len(parts)-2],
+		Version: parts[len(parts)-1],
+	}, nil
+}
+
+// Snap returns a snapshot with the files of the release, recording
+// the digests published in the index
+func (p *PyPI) Snap() (*snapshot.Snapshot, error) {
+	release, err := p.fetchRelease()
+	if err != nil {
+		return nil, fmt.Errorf("fetching release %s %s: %w", p.Project, p.Version, err)
+	}
+	if len(release.URLs) == 0 {
+		return nil, fmt.Errorf("release %s %s has no files", p.Project, p.Version)
+	}
+
+	snap := snapshot.Snapshot{}
+	for _, file := range release.URLs {
+		checksums := map[string]string{}
+		for name, value := range file.Digests {
+			if algo, ok := pypiDigests[name]; ok && value != "" {
+				checksums[algo] = strings.ToLower(value)
+			}
+		}
+		if len(checksums) == 0 {
+			return nil, fmt.Errorf("index has no digests for %s", file.Filename)
+		}
+		snap[file.URL] = run.Artifact{
+			Path:     file.URL,
+			Checksum: checksums,
+			Time:     file.UploadTime,
+		}
+	}
+	return &snap, nil
+}
+
+// fetchRelease downloads the JSON API document of the release
+func (p *PyPI) fetchRelease() (*pypiRelease, error) {
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(
+		"%s/pypi/%s/%s/json", p.Index, url.PathEscape(p.Project), url.PathEscape(p.Version),
+	), http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("creating http request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if os.Getenv(pypiUserEnvVar) != "" {
+		req.SetBasicAuth(os.Getenv(pypiUserEnvVar), os.Getenv(pypiPasswordEnvVar))
+	}
+
+	client, err := httpclient.New()
+	if err != nil {
+		return nil, fmt.Errorf("creating http client: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("executing http request to package index: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http error from package index: %s", resp.Status)
+	}
+
+	release := &pypiRelease{}
+	if err := json.NewDecoder(resp.Body).Decode(release); err != nil {
+		return nil, fmt.Errorf("decoding release metadata: %w", err)
+	}
+	return release, nil
+}
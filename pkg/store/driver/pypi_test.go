@@ -0,0 +1,129 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Trimmed from https://pypi.org/pypi/app/1.0.0/json
+const pypiReleaseResponse = `{
+  "info": {"name": "app", "version": "1.0.0"},
+  "urls": [
+    {
+      "filename": "app-1.0.0-py3-none-any.whl",
+      "packagetype": "bdist_wheel",
+      "url": "https://files.example.com/packages/ab/cd/app-1.0.0-py3-none-any.whl",
+      "digests": {
+        "blake2b_256": "ABCD0000000000000000000000000000000000000000000000000000000000ff",
+        "md5": "098f6bcd4621d373cade4e832627b4f6",
+        "sha256": "9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"
+      },
+      "upload_time_iso_8601": "2023-05-10T12:00:00.000000Z"
+    },
+    {
+      "filename": "app-1.0.0.tar.gz",
+      "packagetype": "sdist",
+      "url": "https://files.example.com/packages/ef/01/app-1.0.0.tar.gz",
+      "digests": {
+        "sha256": "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752"
+      },
+      "upload_time_iso_8601": "2023-05-10T12:01:00.000000Z"
+    }
+  ]
+}`
+
+func TestPyPISnap(t *testing.T) {
+	t.Setenv(pypiUserEnvVar, "user")
+	t.Setenv(pypiPasswordEnvVar, "pass")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/pypi/app/1.0.0/json":
+			fmt.Fprint(w, pypiReleaseResponse)
+		case "/pypi/app/2.0.0/json":
+			fmt.Fprint(w, `{"urls": []}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewPyPI("pypi://pypi.example.com/project/app/1.0.0")
+	require.NoError(t, err)
+	p.Index = server.URL
+
+	snap, err := p.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+
+	wheel := (*snap)["https://files.example.com/packages/ab/cd/app-1.0.0-py3-none-any.whl"]
+	require.Equal(t, "https://files.example.com/packages/ab/cd/app-1.0.0-py3-none-any.whl", wheel.Path)
+	require.Equal(t, map[string]string{
+		"SHA256":      "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		"BLAKE2B-256": "abcd0000000000000000000000000000000000000000000000000000000000ff",
+	}, wheel.Checksum)
+	require.Equal(t, 2023, wheel.Time.Year())
+
+	sdist := (*snap)["https://files.example.com/packages/ef/01/app-1.0.0.tar.gz"]
+	require.Equal(t, map[string]string{
+		"SHA256": "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752",
+	}, sdist.Checksum)
+
+	// Releases without files and missing releases are an error
+	p.Version = "2.0.0"
+	_, err = p.Snap()
+	require.Error(t, err)
+	p.Version = "3.0.0"
+	_, err = p.Snap()
+	require.Error(t, err)
+}
+
+func TestNewPyPI(t *testing.T) {
+	for _, tc := range []struct {
+		specURL, index, project, version string
+	}{
+		{"pypi:///project/app/1.0.0", "https://pypi.org", "app", "1.0.0"},
+		{"pypi://pypi.org/project/app/1.0.0", "https://pypi.org", "app", "1.0.0"},
+		{"pypi://example.com/api/pypi/pypi-local/project/app/1.0.0", "https://example.com/api/pypi/pypi-local", "app", "1.0.0"},
+	} {
+		p, err := NewPyPI(tc.specURL)
+		require.NoError(t, err, tc.specURL)
+		require.Equal(t, tc.index, p.Index, tc.specURL)
+		require.Equal(t, tc.project, p.Project, tc.specURL)
+		require.Equal(t, tc.version, p.Version, tc.specURL)
+	}
+
+	for _, specURL := range []string{
+		"pypi://pypi.org/project/app",
+		"pypi://pypi.org/app/1.0.0",
+		"pypi://pypi.org/project//1.0.0",
+		"https://pypi.org/project/app/1.0.0",
+	} {
+		_, err := NewPyPI(specURL)
+		require.Error(t, err, specURL)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/sirupsen/logrus"
@@ -31,9 +32,18 @@ import (
 )
 
 type SPDX struct {
-	URL string
+	URL     string
+	Options SPDXOptions
 }
 
+type SPDXOptions struct {
+	// ExternalRefs records the external references of each
+	// package as annotations of its artifact
+	ExternalRefs bool
+}
+
+var DefaultSPDXOptions = SPDXOptions{}
+
 func NewSPDX(specURL string) (*SPDX, error) {
 	u, err := url.Parse(specURL)
 	if err != nil {
@@ -43,13 +53,27 @@ func NewSPDX(specURL string) (*SPDX, error) {
 		return nil, fmt.Errorf("spec URL %s is not an attestation url", u.Scheme)
 	}
 
-	logrus.Infof(
-		"Initialized new SPDX SBOM storage backend (%s)", specURL,
-	)
+	// The external-refs parameter is ours, it is removed
+	// from the query before downloading the document
+	opts := DefaultSPDXOptions
+	sourceURL := specURL
+	query := u.Query()
+	if value := query.Get("external-refs"); value != "" {
+		opts.ExternalRefs, err = strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid external-refs value %q", value)
+		}
+		query.Del("external-refs")
+		u.RawQuery = query.Encode()
+		sourceURL = u.String()
+	}
+
+	logrus.WithField("store", specURL).Info("Initialized new SPDX SBOM storage backend")
 
 	// TODO: Check scheme to make sure it is valid
 	return &SPDX{
-		URL: strings.TrimPrefix(specURL, "spdx+"),
+		URL:     strings.TrimPrefix(sourceURL, "spdx+"),
+		Options: opts,
 	}, nil
 }
 
@@ -64,7 +88,13 @@ func (s *SPDX) Snap() (*snapshot.Snapshot, error) {
 		return nil, fmt.Errorf("downloading sbom to temp file: %w", err)
 	}
 
-	doc, err := spdx.OpenDoc(f.Name())
+	return spdxSnapshot(f.Name(), s.Options.ExternalRefs)
+}
+
+// spdxSnapshot reads the packages of the SPDX document at path
+// into a snapshot
+func spdxSnapshot(path string, externalRefs bool) (*snapshot.Snapshot, error) {
+	doc, err := spdx.OpenDoc(path)
 	if err != nil {
 		return nil, fmt.Errorf("parsing spdx sbom: %w", err)
 	}
@@ -108,8 +138,36 @@ func (s *SPDX) Snap() (*snapshot.Snapshot, error) {
 		for algo, c := range p.Checksum {
 			artifact.Checksum[algo] = c
 		}
+		if externalRefs {
+			artifact.Annotations = externalRefAnnotations(p.ExternalRefs)
+		}
 
 		snap[identifier] = artifact
 	}
 	return &snap, nil
 }
+
+// externalRefAnnotations returns the external references of a package
+// as annotations. SPDX locators cannot contain spaces, so references
+// of the same category and type are joined with one.
+func externalRefAnnotations(refs []spdx.ExternalRef) map[string]string {
+	if len(refs) == 0 {
+		return nil
+	}
+	annotations := map[string]string{}
+	for _, ref := range refs {
+		if ref.Locator == "" {
+			continue
+		}
+		category := strings.ReplaceAll(strings.ToLower(ref.Category), "_", "-")
+		key := run.AnnotationExternalRefPrefix + category + "." + ref.Type
+		if annotations[key] != "" {
+			annotations[key] += " "
+		}
+		annotations[key] += ref.Locator
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+const testExternalRefsSBOM = `SPDXVersion: SPDX-2.3
+DataLicense: CC0-1.0
+SPDXID: SPDXRef-DOCUMENT
+DocumentName: deps
+DocumentNamespace: https://example.com/deps
+Creator: Tool: test
+
+PackageName: yaml
+SPDXID: SPDXRef-Package-yaml
+PackageVersion: 1.4.0
+PackageDownloadLocation: https://github.com/kubernetes-sigs/yaml
+FilesAnalyzed: false
+PackageChecksum: SHA256: 3a6b7f5c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f809102
+ExternalRef: PACKAGE-MANAGER purl pkg:golang/sigs.k8s.io/yaml@v1.4.0
+ExternalRef: SECURITY advisory https://github.com/advisories/GHSA-0000-0000-0001
+ExternalRef: SECURITY advisory https://github.com/advisories/GHSA-0000-0000-0002
+ExternalRef: PERSISTENT-ID swh swh:1:rev:309dc2a7d17ef4d2ce6b9d53b6e8c0f0c8a0b7d9
+
+PackageName: norefs
+SPDXID: SPDXRef-Package-norefs
+PackageDownloadLocation: https://example.com/norefs.tar.gz
+FilesAnalyzed: false
+PackageChecksum: SHA1: 85a5d5e2a76dc28f1dd5ab2e4b4e4bbbc4e1f8ad
+`
+
+func TestSPDXExternalRefs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deps.spdx")
+	require.NoError(t, os.WriteFile(path, []byte(testExternalRefsSBOM), os.FileMode(0o644)))
+
+	// By default the external references are not recorded
+	s, err := NewSPDX("spdx+file://" + path)
+	require.NoError(t, err)
+	require.False(t, s.Options.ExternalRefs)
+	snap, err := s.Snap()
+	require.NoError(t, err)
+	require.Len(t, *snap, 2)
+	require.Nil(t, (*snap)["pkg:golang/sigs.k8s.io/yaml@v1.4.0"].Annotations)
+
+	s, err = NewSPDX("spdx+file://" + path + "?external-refs=true")
+	require.NoError(t, err)
+	require.True(t, s.Options.ExternalRefs)
+	require.Equal(t, "file://"+path, s.URL)
+	snap, err = s.Snap()
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		run.AnnotationExternalRefPrefix + "package-manager.purl": "pkg:golang/sigs.k8s.io/yaml@v1.4.0",
+		run.AnnotationExternalRefPrefix + "security.advisory":    "https://github.com/advisories/GHSA-0000-0000-0001 https://github.com/advisories/GHSA-0000-0000-0002",
+		run.AnnotationExternalRefPrefix + "persistent-id.swh":    "swh:1:rev:309dc2a7d17ef4d2ce6b9d53b6e8c0f0c8a0b7d9",
+	}, (*snap)["pkg:golang/sigs.k8s.io/yaml@v1.4.0"].Annotations)
+	require.Nil(t, (*snap)["https://example.com/norefs.tar.gz"].Annotations)
+
+	_, err = NewSPDX("spdx+file://" + path + "?external-refs=maybe")
+	require.Error(t, err)
+}
@@ -16,7 +16,12 @@ limitations under the License.
 
 package snapshot
 
-import "sigs.k8s.io/tejolote/pkg/run"
+import (
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/tejolote/pkg/run"
+)
 
 type Snapshot map[string]run.Artifact
 
@@ -31,21 +36,82 @@ func (snap *Snapshot) Delta(post *Snapshot) []run.Artifact {
 			continue
 		}
 
-		// Check the file attributes to if they were changed
-		if (*snap)[path].Time != f.Time {
+		if changed((*snap)[path], f) {
 			results = append(results, f)
-			continue
 		}
+	}
+	return results
+}
+
+// DeltaResult classifies the differences between two snapshots
+type DeltaResult struct {
+	Added    []run.Artifact
+	Modified []run.Artifact
+	Removed  []run.Artifact
+}
 
-		checksum := (*snap)[path].Checksum
-		for algo, val := range checksum {
-			if fv, ok := f.Checksum[algo]; ok {
-				if fv != val {
-					results = append(results, f)
-					break
-				}
-			}
+// DeltaFull compares the snapshot with a later one, returning the
+// artifacts created, modified and removed in between. Removed
+// artifacts are returned as they were in the first snapshot. Each
+// list is sorted by path.
+func (snap *Snapshot) DeltaFull(post *Snapshot) DeltaResult {
+	result := DeltaResult{
+		Added:    []run.Artifact{},
+		Modified: []run.Artifact{},
+		Removed:  []run.Artifact{},
+	}
+	for path, f := range *post {
+		pre, ok := (*snap)[path]
+		switch {
+		case !ok:
+			result.Added = append(result.Added, f)
+		case changed(pre, f):
+			result.Modified = append(result.Modified, f)
 		}
 	}
-	return results
+	for path, f := range *snap {
+		if _, ok := (*post)[path]; !ok {
+			result.Removed = append(result.Removed, f)
+		}
+	}
+	for _, list := range [][]run.Artifact{result.Added, result.Modified, result.Removed} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Path < list[j].Path })
+	}
+	return result
+}
+
+// changed returns true if an artifact was modified between snapshots.
+// Times are compared with Equal as snapshots may be read back from JSON.
+func changed(pre, post run.Artifact) bool {
+	if !pre.Time.Equal(post.Time) {
+		return true
+	}
+	return checksumsDiffer(pre.Checksum, post.Checksum)
+}
+
+// checksumsDiffer compares two sets of checksums in the algorithms they
+// have in common. Sets without any algorithm in common (eg one store
+// hashing with SHA256 and another with SHA512) cannot tell if the file
+// changed so, to be safe, they are considered different. When neither
+// has checksums, only the times compared by the caller can be used.
+func checksumsDiffer(pre, post map[string]string) bool {
+	if len(pre) == 0 && len(post) == 0 {
+		return false
+	}
+	postChecksums := make(map[string]string, len(post))
+	for algo, val := range post {
+		postChecksums[strings.ToUpper(algo)] = val
+	}
+	compared := false
+	for algo, val := range pre {
+		postVal, ok := postChecksums[strings.ToUpper(algo)]
+		if !ok {
+			continue
+		}
+		if !strings.EqualFold(postVal, val) {
+			return true
+		}
+		compared = true
+	}
+	return !compared
 }
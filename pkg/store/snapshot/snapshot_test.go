@@ -41,6 +41,33 @@ func TestDelta(t *testing.T) {
 		Checksum: map[string]string{"SHA256": "25b89320221dda5abe3df4624d246d22d0c820ee3598e97553611d7c80abbd36"},
 		Time:     time.Date(1976, time.Month(2), 10, 23, 30, 30, 0, time.Local),
 	}
+	moreAlgosFile := run.Artifact{
+		Path: "test.txt",
+		Checksum: map[string]string{
+			"SHA256": testFile.Checksum["SHA256"],
+			"SHA512": "9b71d224bd62f3785d96d46ad3ea3d73319bfbc2890caadae2dff72519673ca72323c3d99ba5c11d7c7acc6e14b8c5da0c4663475c2e5c3adef46f73bcdec043",
+		},
+		Time: testFile.Time,
+	}
+	moreAlgosModFile := run.Artifact{
+		Path: "test.txt",
+		Checksum: map[string]string{
+			"SHA256": modHashFile.Checksum["SHA256"],
+			"SHA512": moreAlgosFile.Checksum["SHA512"],
+		},
+		Time: testFile.Time,
+	}
+	lowerCaseFile := run.Artifact{
+		Path:     "test.txt",
+		Checksum: map[string]string{"sha256": testFile.Checksum["SHA256"]},
+		Time:     testFile.Time,
+	}
+	otherAlgoFile := run.Artifact{
+		Path:     "test.txt",
+		Checksum: map[string]string{"SHA512": moreAlgosFile.Checksum["SHA512"]},
+		Time:     testFile.Time,
+	}
+	noHashFile := run.Artifact{Path: "test.txt", Time: testFile.Time}
 	for _, tc := range []struct {
 		preSnap  Snapshot
 		postSnap Snapshot
@@ -76,7 +103,65 @@ func TestDelta(t *testing.T) {
 			Snapshot{testFile.Path: modHashFile},
 			[]run.Artifact{modHashFile},
 		},
+		{
+			// Hashed in more algorithms, the common one matches
+			Snapshot{testFile.Path: testFile},
+			Snapshot{testFile.Path: moreAlgosFile},
+			[]run.Artifact{},
+		},
+		{
+			// Hashed in more algorithms, the common one differs
+			Snapshot{testFile.Path: testFile},
+			Snapshot{testFile.Path: moreAlgosModFile},
+			[]run.Artifact{moreAlgosModFile},
+		},
+		{
+			// Algorithm names in another case are compared
+			Snapshot{testFile.Path: testFile},
+			Snapshot{testFile.Path: lowerCaseFile},
+			[]run.Artifact{},
+		},
+		{
+			// No algorithm in common, considered changed to be safe
+			Snapshot{testFile.Path: testFile},
+			Snapshot{testFile.Path: otherAlgoFile},
+			[]run.Artifact{otherAlgoFile},
+		},
+		{
+			// Without checksums only the time is compared
+			Snapshot{testFile.Path: noHashFile},
+			Snapshot{testFile.Path: noHashFile},
+			[]run.Artifact{},
+		},
 	} {
 		require.Equal(t, tc.expect, tc.preSnap.Delta(&tc.postSnap))
 	}
 }
+
+func TestDeltaFull(t *testing.T) {
+	now := time.Now()
+	kept := run.Artifact{Path: "kept.txt", Checksum: map[string]string{"SHA256": "aaa"}, Time: now}
+	modified := run.Artifact{Path: "modified.txt", Checksum: map[string]string{"SHA256": "bbb"}, Time: now}
+	removed := run.Artifact{Path: "removed.txt", Checksum: map[string]string{"SHA256": "ccc"}, Time: now}
+	removed2 := run.Artifact{Path: "a-removed.txt", Checksum: map[string]string{"SHA256": "ddd"}, Time: now}
+	added := run.Artifact{Path: "added.txt", Checksum: map[string]string{"SHA256": "eee"}, Time: now}
+	modifiedPost := modified
+	modifiedPost.Checksum = map[string]string{"SHA256": "fff"}
+
+	pre := Snapshot{kept.Path: kept, modified.Path: modified, removed.Path: removed, removed2.Path: removed2}
+	post := Snapshot{kept.Path: kept, modified.Path: modifiedPost, added.Path: added}
+
+	delta := pre.DeltaFull(&post)
+	require.Equal(t, []run.Artifact{added}, delta.Added)
+	require.Equal(t, []run.Artifact{modifiedPost}, delta.Modified)
+	require.Equal(t, []run.Artifact{removed2, removed}, delta.Removed)
+
+	// Delta keeps returning only the added and modified artifacts
+	require.ElementsMatch(t, []run.Artifact{added, modifiedPost}, pre.Delta(&post))
+
+	// Identical snapshots have no differences
+	delta = pre.DeltaFull(&pre)
+	require.Empty(t, delta.Added)
+	require.Empty(t, delta.Modified)
+	require.Empty(t, delta.Removed)
+}
@@ -18,7 +18,9 @@ package store
 
 import (
 	"fmt"
+	"io"
 	"net/url"
+	"path"
 	"strings"
 
 	"sigs.k8s.io/tejolote/pkg/run"
@@ -35,6 +37,18 @@ type Implementation interface {
 	Snap() (*snapshot.Snapshot, error)
 }
 
+// DigestResolver is implemented by the drivers that can look up
+// the digest of an artifact they list without one
+type DigestResolver interface {
+	ResolveDigest(path string) (map[string]string, error)
+}
+
+// HashCacher is implemented by the drivers that keep a cache of
+// the digests they compute on disk
+type HashCacher interface {
+	SetReadOnlyHashCache(readOnly bool)
+}
+
 func New(specURL string) (s Store, err error) {
 	s = Store{}
 	u, err := url.Parse(specURL)
@@ -49,12 +63,36 @@ func New(specURL string) (s Store, err error) {
 		impl, err = driver.NewGCS(specURL)
 	case "oci":
 		impl, err = driver.NewOCI(specURL)
+	case "oci-referrers":
+		impl, err = driver.NewOCIReferrers(specURL)
+	case "oci-sbom":
+		impl, err = driver.NewOCISBOM(specURL)
+	case "ecr":
+		impl, err = driver.NewECR(specURL)
 	case "actions":
 		impl, err = driver.NewActions(specURL)
 	case "gcb":
 		impl, err = driver.NewGCB(specURL)
 	case "github":
 		impl, err = driver.NewGithub(specURL)
+	case "nexus":
+		impl, err = driver.NewNexus(specURL)
+	case "artifactory":
+		impl, err = driver.NewArtifactory(specURL)
+	case "gitlab-pkg":
+		impl, err = driver.NewGitLabPackage(specURL)
+	case "npm":
+		impl, err = driver.NewNPM(specURL)
+	case "pypi":
+		impl, err = driver.NewPyPI(specURL)
+	case "gitlfs":
+		impl, err = driver.NewGitLFS(specURL)
+	case "gitea-actions":
+		impl, err = driver.NewGiteaActions(specURL)
+	case "gitea-release":
+		impl, err = driver.NewGiteaRelease(specURL)
+	case "http", "https":
+		impl, err = driver.NewHTTPIndex(specURL)
 	default:
 		// Attestation use a composed scheme
 		format, _, ok := strings.Cut(u.Scheme, "+")
@@ -66,6 +104,12 @@ func New(specURL string) (s Store, err error) {
 			impl, err = driver.NewAttestation(specURL)
 		case "spdx":
 			impl, err = driver.NewSPDX(specURL)
+		case "cyclonedx":
+			impl, err = driver.NewCycloneDX(specURL)
+		case "oci-layout":
+			impl, err = driver.NewOCILayout(specURL)
+		case "tar", "zip":
+			impl, err = driver.NewArchive(specURL)
 		default:
 			err = fmt.Errorf("unknown storage backend %s", format)
 		}
@@ -79,6 +123,21 @@ func New(specURL string) (s Store, err error) {
 	return s, nil
 }
 
+// CanonicalID returns an identifier of the store that does not depend on
+// the machine where it was defined. Remote stores are identified by their
+// spec URL but local paths are reduced to their scheme and base name as
+// the full path is specific to each host.
+func (s *Store) CanonicalID() string {
+	u, err := url.Parse(s.SpecURL)
+	if err != nil {
+		return s.SpecURL
+	}
+	if u.Scheme == "file" || strings.HasSuffix(u.Scheme, "+file") {
+		return u.Scheme + ":" + path.Base(u.Path)
+	}
+	return s.SpecURL
+}
+
 // ReadArtifacts returns the combined list of artifacts from
 // every store attached to the watcher
 func (s *Store) ReadArtifacts() ([]run.Artifact, error) {
@@ -98,3 +157,34 @@ func (s *Store) ReadArtifacts() ([]run.Artifact, error) {
 func (s *Store) Snap() (*snapshot.Snapshot, error) {
 	return s.Driver.Snap()
 }
+
+// ResolveDigest asks the driver to compute the digest of an artifact
+// it returned without one. It fails if the driver cannot resolve digests.
+func (s *Store) ResolveDigest(a run.Artifact) (map[string]string, error) {
+	resolver, ok := s.Driver.(DigestResolver)
+	if !ok {
+		return nil, fmt.Errorf("store %s cannot resolve artifact digests", s.SpecURL)
+	}
+	return resolver.ResolveDigest(a.Path)
+}
+
+// SetReadOnlyHashCache makes drivers with a hash cache read it without
+// saving the digests they compute. Other drivers are not affected.
+func (s *Store) SetReadOnlyHashCache(readOnly bool) {
+	if cacher, ok := s.Driver.(HashCacher); ok {
+		cacher.SetReadOnlyHashCache(readOnly)
+	}
+}
+
+// Close releases the resources held by the driver, eg temporary
+// directories. Drivers that hold none don't implement io.Closer.
+func (s *Store) Close() error {
+	closer, ok := s.Driver.(io.Closer)
+	if !ok {
+		return nil
+	}
+	if err := closer.Close(); err != nil {
+		return fmt.Errorf("closing store %s: %w", s.SpecURL, err)
+	}
+	return nil
+}
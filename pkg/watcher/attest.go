@@ -0,0 +1,242 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// AttestationOptions configure an attestation generated with
+// NewAttestationRun or RunAttestation, the library counterpart of
+// the attest subcommand
+type AttestationOptions struct {
+	// SpecURLs are the runs to attest. The artifacts of the runs
+	// after the first one are merged into its attestation.
+	SpecURLs []string
+
+	// Artifacts are the URLs of the stores to collect artifacts from,
+	// in addition to the native stores of the build system
+	Artifacts []string
+
+	// VCSURL is recorded as a material of the build
+	VCSURL string
+
+	// ValidUntil is recorded as the end of the predicate validity
+	// period when set (see builder.Builder.ValidUntil)
+	ValidUntil time.Time
+
+	// ContinueFrom is the path or URL of a partial attestation
+	// written by start to complete
+	ContinueFrom string
+
+	// SnapshotsPath is the path or URL of the storage snapshots
+	// saved by start
+	SnapshotsPath string
+
+	// BaselineSnapshot is a snapshot of the stores before the run,
+	// only the artifacts that changed since are attested
+	BaselineSnapshot string
+
+	// Sign wraps the attestation in a signed DSSE envelope, with the
+	// key in SigningKey or keyless when it is empty
+	Sign       bool
+	SigningKey string
+
+	// Format of the encoded attestation (see attestation.Encode)
+	Format string
+
+	// Watcher are the options of the watcher of the runs
+	Watcher Options
+}
+
+var DefaultAttestationOptions = AttestationOptions{
+	Format:  attestation.FormatJSON,
+	Watcher: DefaultOptions,
+}
+
+// AttestationRun ties the runs being attested to their watchers.
+// Its methods run the steps of the attestation flow in order:
+// Watch, Load and Attest, then Encode to serialize the result.
+type AttestationRun struct {
+	Options     AttestationOptions
+	Watcher     *Watcher
+	Run         *run.Run
+	Attestation *attestation.Attestation
+
+	// Watchers and runs merged into the attestation
+	mergeWatchers []*Watcher
+	mergeRuns     []*run.Run
+}
+
+// NewAttestationRun creates the watchers of the runs and fetches
+// them from their build systems
+func NewAttestationRun(opts AttestationOptions) (*AttestationRun, error) {
+	if len(opts.SpecURLs) == 0 {
+		return nil, errors.New("build run spec URL not specified")
+	}
+	w, err := New(opts.SpecURLs[0])
+	if err != nil {
+		return nil, fmt.Errorf("building watcher: %w", err)
+	}
+	w.Options = opts.Watcher
+	w.Builder.VCSURL = opts.VCSURL
+	w.Builder.ValidUntil = opts.ValidUntil
+	for _, uri := range opts.Artifacts {
+		if err := w.AddArtifactSource(uri); err != nil {
+			return nil, fmt.Errorf("adding artifacts source: %w", err)
+		}
+	}
+	r, err := w.GetRun(opts.SpecURLs[0])
+	if err != nil {
+		return nil, fmt.Errorf("fetching run: %w", err)
+	}
+
+	ar := &AttestationRun{
+		Options: opts,
+		Watcher: w,
+		Run:     r,
+	}
+
+	// The rest of the runs are read from their native stores only,
+	// the artifact stores are read once by the main watcher
+	for _, specURL := range opts.SpecURLs[1:] {
+		mw, err := New(specURL)
+		if err != nil {
+			return nil, fmt.Errorf("building watcher for %s: %w", specURL, err)
+		}
+		mw.Options = opts.Watcher
+		mw.Builder.VCSURL = opts.VCSURL
+		mw.Builder.ValidUntil = opts.ValidUntil
+		mr, err := mw.GetRun(specURL)
+		if err != nil {
+			return nil, fmt.Errorf("fetching run %s: %w", specURL, err)
+		}
+		ar.mergeWatchers = append(ar.mergeWatchers, mw)
+		ar.mergeRuns = append(ar.mergeRuns, mr)
+	}
+	return ar, nil
+}
+
+// Watch waits for the runs to finish. All runs are watched at the
+// same time under a single deadline, the first failure stops the rest.
+func (ar *AttestationRun) Watch(ctx context.Context) error {
+	if ar.Options.Watcher.WatchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ar.Options.Watcher.WatchTimeout)
+		defer cancel()
+	}
+
+	wg, ctx := errgroup.WithContext(ctx)
+	wg.Go(func() error {
+		return ar.Watcher.WatchRunsContext(ctx, []*run.Run{ar.Run})
+	})
+	for i, mw := range ar.mergeWatchers {
+		wg.Go(func() error {
+			return mw.WatchRunsContext(ctx, []*run.Run{ar.mergeRuns[i]})
+		})
+	}
+	return wg.Wait()
+}
+
+// Load reads the partial attestation, the storage snapshots and
+// the baseline snapshot set in the options
+func (ar *AttestationRun) Load() error {
+	if err := ar.Watcher.LoadAttestation(ar.Options.ContinueFrom); err != nil {
+		return fmt.Errorf("loading previous attestation: %w", err)
+	}
+	if err := ar.Watcher.LoadSnapshots(ar.Options.SnapshotsPath); err != nil {
+		return fmt.Errorf("loading storage snapshots: %w", err)
+	}
+	if err := ar.Watcher.LoadBaseline(ar.Options.BaselineSnapshot); err != nil {
+		return fmt.Errorf("loading baseline snapshot: %w", err)
+	}
+	return nil
+}
+
+// Attest collects the artifacts of the runs and generates their
+// attestation, it is also stored in the Attestation field
+func (ar *AttestationRun) Attest() (*attestation.Attestation, error) {
+	if err := ar.Watcher.CollectArtifacts(ar.Run); err != nil {
+		return nil, fmt.Errorf("while collecting run artifacts: %w", err)
+	}
+
+	att, err := ar.Watcher.AttestRun(ar.Run)
+	if err != nil {
+		return nil, fmt.Errorf("generating run attestation: %w", err)
+	}
+
+	for i, mw := range ar.mergeWatchers {
+		mr := ar.mergeRuns[i]
+		if err := mw.CollectArtifacts(mr); err != nil {
+			return nil, fmt.Errorf("collecting artifacts of %s: %w", mr.SpecURL, err)
+		}
+		if err := ar.Watcher.MergeRun(att, mr); err != nil {
+			return nil, fmt.Errorf("merging %s: %w", mr.SpecURL, err)
+		}
+	}
+	ar.Attestation = att
+	return att, nil
+}
+
+// Encode serializes the attestation in the configured format,
+// signing it if enabled
+func (ar *AttestationRun) Encode() ([]byte, error) {
+	if ar.Attestation == nil {
+		return nil, errors.New("the runs have not been attested yet")
+	}
+
+	// Signed attestations are already wrapped in a DSSE envelope
+	if ar.Options.Sign {
+		data, err := ar.Attestation.Sign(attestation.NewSigner(ar.Options.SigningKey))
+		if err != nil {
+			return nil, fmt.Errorf("signing attestation: %w", err)
+		}
+		return attestation.ConvertFormat(data, ar.Options.Format)
+	}
+	return ar.Attestation.Encode(ar.Options.Format)
+}
+
+// RunAttestation watches the runs in the options until they finish
+// and returns their encoded attestation
+func RunAttestation(ctx context.Context, opts AttestationOptions) ([]byte, error) {
+	ar, err := NewAttestationRun(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := ar.Watch(ctx); err != nil {
+		return nil, fmt.Errorf("watching runs: %w", err)
+	}
+	if err := ar.Load(); err != nil {
+		return nil, err
+	}
+	if _, err := ar.Attest(); err != nil {
+		return nil, err
+	}
+	data, err := ar.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("serializing attestation: %w", err)
+	}
+	return data, nil
+}
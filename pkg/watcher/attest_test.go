@@ -0,0 +1,111 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/builder"
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+func TestRunAttestation(t *testing.T) {
+	// A Gitea server with a finished run
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/repos/org/app/actions/runs/42":
+			fmt.Fprint(w, `{"id": 42, "status": "completed", "conclusion": "success", "head_sha": "3f1b2c4d5e6f708192a3b4c5d6e7f8091a2b3c4d"}`)
+		case "/api/v1/repos/org/app/actions/runs/42/jobs":
+			fmt.Fprint(w, `{"jobs": [], "total_count": 0}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	bundle := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: server.Certificate().Raw,
+	}), os.FileMode(0o644)))
+	t.Setenv(httpclient.CABundleEnvVar, bundle)
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app"), []byte("binary"), os.FileMode(0o755)))
+
+	opts := DefaultAttestationOptions
+	opts.SpecURLs = []string{"gitea://" + strings.TrimPrefix(server.URL, "https://") + "/org/app/42"}
+	opts.Artifacts = []string{"file://" + dir}
+	opts.Watcher.DisableNativeStore = true
+	opts.Watcher.Annotations = map[string]string{"ticket": "REL-1"}
+
+	data, err := RunAttestation(context.Background(), opts)
+	require.NoError(t, err)
+
+	att := attestation.New().SLSA()
+	require.NoError(t, json.Unmarshal(data, att))
+	require.Len(t, att.Subject, 1)
+	require.Equal(t, "app", att.Subject[0].Name)
+	require.NotEmpty(t, att.Subject[0].Digest["SHA256"])
+	require.Equal(t, map[string]string{"ticket": "REL-1"}, att.Predicate.Annotations())
+
+	// Options without runs fail
+	_, err = RunAttestation(context.Background(), DefaultAttestationOptions)
+	require.Error(t, err)
+}
+
+func TestAttestationRunWatch(t *testing.T) {
+	interval := 20 * time.Millisecond
+	opts := Options{PollInterval: interval, MaxPollInterval: interval}
+	d := &pollingDriver{pending: map[string]int{"main": 10, "merged": 10}}
+	ar := &AttestationRun{
+		Options: AttestationOptions{Watcher: opts},
+		Watcher: &Watcher{Builder: builder.NewWithDriver("mock://", d), Options: opts},
+		Run:     &run.Run{SpecURL: "main", IsRunning: true},
+		mergeWatchers: []*Watcher{
+			{Builder: builder.NewWithDriver("mock://", d), Options: opts},
+		},
+		mergeRuns: []*run.Run{{SpecURL: "merged", IsRunning: true}},
+	}
+
+	// The runs are watched together (9 waits), not one after the other (18)
+	start := time.Now()
+	require.NoError(t, ar.Watch(context.Background()))
+	require.Less(t, time.Since(start), 15*interval)
+	require.False(t, ar.Run.IsRunning)
+	require.False(t, ar.mergeRuns[0].IsRunning)
+
+	// A stuck merged run fails the watch within the deadline
+	d.pending["stuck"] = 1000
+	ar.Options.Watcher.WatchTimeout = 3 * interval
+	ar.Run.IsRunning = false
+	ar.mergeRuns[0] = &run.Run{SpecURL: "stuck", IsRunning: true}
+	err := ar.Watch(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
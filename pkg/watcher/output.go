@@ -0,0 +1,145 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// writeFilesAtomic writes a set of files as a unit. The data is first
+// written to temporary files next to their destinations which are then
+// renamed into place one by one, in path order. Existing destinations
+// are hard linked to a backup before the renames. If any step fails,
+// the files already moved are removed and the backups restored, so the
+// previous contents of the set are kept.
+func writeFilesAtomic(files map[string][]byte) (err error) {
+	tmpFiles := map[string]string{}
+	backups := map[string]string{}
+	defer func() {
+		for _, tmp := range tmpFiles {
+			os.Remove(tmp)
+		}
+		for _, backup := range backups {
+			os.Remove(backup)
+		}
+	}()
+
+	paths := make([]string, 0, len(files))
+	for path, data := range files {
+		tmp, err := writeTempFile(path, data)
+		if err != nil {
+			return err
+		}
+		tmpFiles[path] = tmp
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		backup, err := backupFile(path)
+		if err != nil {
+			return err
+		}
+		if backup != "" {
+			backups[path] = backup
+		}
+	}
+
+	renamed := []string{}
+	for _, path := range paths {
+		if err := os.Rename(tmpFiles[path], path); err != nil {
+			for _, r := range renamed {
+				restoreFile(r, backups[r])
+			}
+			return fmt.Errorf("moving %s into place: %w", path, err)
+		}
+		delete(tmpFiles, path)
+		renamed = append(renamed, path)
+	}
+	return nil
+}
+
+// backupFile hard links a regular file to a temporary name in its
+// directory and returns it. It returns an empty string when there
+// is no file at path.
+func backupFile(path string) (string, error) {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("checking %s: %w", path, err)
+	}
+
+	// Reserve a unique name, then replace it with the link
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".bak-")
+	if err != nil {
+		return "", fmt.Errorf("creating backup of %s: %w", path, err)
+	}
+	backup := f.Name()
+	f.Close()
+	if err := os.Remove(backup); err != nil {
+		return "", fmt.Errorf("creating backup of %s: %w", path, err)
+	}
+	if err := os.Link(path, backup); err != nil {
+		return "", fmt.Errorf("creating backup of %s: %w", path, err)
+	}
+	return backup, nil
+}
+
+// restoreFile puts back the backup of a file replaced by a failed
+// write, or removes the file if it did not exist before
+func restoreFile(path, backup string) {
+	if backup == "" {
+		if err := os.Remove(path); err != nil {
+			logrus.Errorf("removing partially written file %s: %v", path, err)
+		}
+		return
+	}
+	if err := os.Rename(backup, path); err != nil {
+		logrus.Errorf("restoring %s from %s: %v", path, backup, err)
+	}
+}
+
+// writeTempFile writes data to a temporary file in the directory of
+// path and returns the name of the temporary file
+func writeTempFile(path string, data []byte) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing temporary file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("syncing temporary file: %w", err)
+	}
+	if err := f.Chmod(os.FileMode(0o644)); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("setting file permissions: %w", err)
+	}
+	return f.Name(), nil
+}
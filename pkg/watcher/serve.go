@@ -0,0 +1,284 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// errInvalidMessage is returned when a message cannot be decoded.
+// Those messages are acknowledged as redelivering them won't help.
+var errInvalidMessage = errors.New("invalid start message")
+
+// Subscription delivers the start messages consumed by the server,
+// it is implemented by *pubsub.Subscription
+type Subscription interface {
+	Receive(ctx context.Context, f func(context.Context, *pubsub.Message)) error
+}
+
+// DefaultServeWatchTimeout is the default time the server
+// waits for a run to finish
+const DefaultServeWatchTimeout = 2 * time.Hour
+
+// ServeLeaseMargin is the time messages are kept leased after the
+// watch timeout to collect, sign and output the attestation
+const ServeLeaseMargin = 30 * time.Minute
+
+// Server consumes the start messages published by tejolote start
+// and finishes the attestation of each run when it completes
+type Server struct {
+	// Attest finishes the attestation of the run in a start message
+	Attest func(context.Context, *StartMessage) (*attestation.Attestation, error)
+
+	// Output receives the finished attestations
+	Output func(*StartMessage, *attestation.Attestation) error
+
+	// Done optionally reports if the attestation of a run was already
+	// output, eg by a previous server handling the same message
+	Done func(*StartMessage) (bool, error)
+
+	// WatchTimeout limits the time waiting for a run to finish. Messages
+	// stay leased for the timeout plus ServeLeaseMargin (see MaxExtension),
+	// longer waits would get them redelivered while being watched.
+	WatchTimeout time.Duration
+
+	// runs tracks the spec URLs handled by the server, true
+	// when attested and false while in progress
+	mtx  sync.Mutex
+	runs map[string]bool
+}
+
+// NewServer returns a server that finishes the attestations with
+// FinishAttestation and hands them to output
+func NewServer(output func(*StartMessage, *attestation.Attestation) error) *Server {
+	s := &Server{
+		Output:       output,
+		WatchTimeout: DefaultServeWatchTimeout,
+	}
+	s.Attest = func(ctx context.Context, m *StartMessage) (*attestation.Attestation, error) {
+		return FinishAttestation(ctx, m, s.watchTimeout())
+	}
+	return s
+}
+
+// watchTimeout returns the watch timeout, always set to a limit
+func (s *Server) watchTimeout() time.Duration {
+	if s.WatchTimeout <= 0 {
+		return DefaultServeWatchTimeout
+	}
+	return s.WatchTimeout
+}
+
+// MaxExtension returns the time messages are kept leased while
+// their runs are attested
+func (s *Server) MaxExtension() time.Duration {
+	return s.watchTimeout() + ServeLeaseMargin
+}
+
+// Serve receives messages from the subscription until the context is
+// canceled. Messages are acknowledged once their attestation is out,
+// failed runs are not acknowledged so they can be retried. Messages
+// of runs already attested or in progress are acknowledged without
+// attesting them again.
+func (s *Server) Serve(ctx context.Context, sub Subscription) error {
+	if ps, ok := sub.(*pubsub.Subscription); ok {
+		ps.ReceiveSettings.MaxExtension = s.MaxExtension()
+	}
+	if err := sub.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		if err := s.handleMessage(ctx, m.Data); err != nil {
+			logrus.Errorf("processing message %s: %v", m.ID, err)
+			if !errors.Is(err, errInvalidMessage) {
+				m.Nack()
+				return
+			}
+		}
+		m.Ack()
+	}); err != nil {
+		return fmt.Errorf("receiving messages: %w", err)
+	}
+	return nil
+}
+
+// handleMessage decodes a start message and dispatches it
+func (s *Server) handleMessage(ctx context.Context, data []byte) (err error) {
+	message, err := DecodeStartMessage(data)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Received start message for %s", message.SpecURL)
+
+	if s.Done != nil {
+		done, err := s.Done(message)
+		if err != nil {
+			return fmt.Errorf("checking output of %s: %w", message.SpecURL, err)
+		}
+		if done {
+			logrus.Infof("Run %s is already attested, skipping", message.SpecURL)
+			return nil
+		}
+	}
+	if !s.claimRun(message.SpecURL) {
+		logrus.Infof("Run %s is already attested or in progress, skipping", message.SpecURL)
+		return nil
+	}
+	defer func() { s.finishRun(message.SpecURL, err == nil) }()
+
+	att, err := s.Attest(ctx, message)
+	if err != nil {
+		return fmt.Errorf("attesting %s: %w", message.SpecURL, err)
+	}
+	if err := s.Output(message, att); err != nil {
+		return fmt.Errorf("writing attestation of %s: %w", message.SpecURL, err)
+	}
+	return nil
+}
+
+// claimRun marks a run as in progress, it returns false if
+// the run was already attested or is being attested
+func (s *Server) claimRun(specURL string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.runs == nil {
+		s.runs = map[string]bool{}
+	}
+	if _, ok := s.runs[specURL]; ok {
+		return false
+	}
+	s.runs[specURL] = false
+	return true
+}
+
+// finishRun records a run as attested or, if it failed,
+// releases it so a redelivery can try again
+func (s *Server) finishRun(specURL string, success bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if success {
+		s.runs[specURL] = true
+		return
+	}
+	delete(s.runs, specURL)
+}
+
+// DecodeStartMessage parses the data of a start message
+func DecodeStartMessage(data []byte) (*StartMessage, error) {
+	message := &StartMessage{}
+	if err := json.Unmarshal(data, message); err != nil {
+		return nil, fmt.Errorf("%w: %w", errInvalidMessage, err)
+	}
+	if message.SpecURL == "" {
+		return nil, fmt.Errorf("%w: message has no run spec URL", errInvalidMessage)
+	}
+	for _, field := range []string{message.Attestation, message.Snapshots} {
+		if _, err := base64.StdEncoding.DecodeString(field); err != nil {
+			return nil, fmt.Errorf("%w: decoding message data: %w", errInvalidMessage, err)
+		}
+	}
+	if len(message.Artifacts) == 0 && message.ArtifactList != "" {
+		message.Artifacts = strings.Split(message.ArtifactList, ",")
+	}
+	return message, nil
+}
+
+// FinishAttestation rebuilds the watcher state from a start message,
+// waits for the run to finish and returns its attestation. The wait
+// stops when ctx is done or after watchTimeout, zero waits forever.
+func FinishAttestation(
+	ctx context.Context, message *StartMessage, watchTimeout time.Duration,
+) (*attestation.Attestation, error) {
+	w, err := New(message.SpecURL)
+	if err != nil {
+		return nil, fmt.Errorf("building watcher: %w", err)
+	}
+	w.Options.WatchTimeout = watchTimeout
+	for _, uri := range message.Artifacts {
+		if err := w.AddArtifactSource(uri); err != nil {
+			return nil, fmt.Errorf("adding artifacts source: %w", err)
+		}
+	}
+
+	r, err := w.GetRun(message.SpecURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching run: %w", err)
+	}
+	if err := w.WatchRunsContext(ctx, []*run.Run{r}); err != nil {
+		return nil, fmt.Errorf("watching run: %w", err)
+	}
+
+	if message.Attestation != "" {
+		path, err := writeDecodedTemp(message.Attestation, "attestation-*.intoto.json")
+		if err != nil {
+			return nil, fmt.Errorf("writing draft attestation: %w", err)
+		}
+		defer os.Remove(path)
+		if err := w.LoadAttestation(path); err != nil {
+			return nil, fmt.Errorf("loading draft attestation: %w", err)
+		}
+	}
+
+	if message.Snapshots != "" {
+		path, err := writeDecodedTemp(message.Snapshots, "snapshots-*.json")
+		if err != nil {
+			return nil, fmt.Errorf("writing snapshots: %w", err)
+		}
+		defer os.Remove(path)
+		if err := w.LoadSnapshots(path); err != nil {
+			return nil, fmt.Errorf("loading storage snapshots: %w", err)
+		}
+	}
+
+	if err := w.CollectArtifacts(r); err != nil {
+		return nil, fmt.Errorf("collecting run artifacts: %w", err)
+	}
+	att, err := w.AttestRun(r)
+	if err != nil {
+		return nil, fmt.Errorf("generating run attestation: %w", err)
+	}
+	return att, nil
+}
+
+// writeDecodedTemp writes base64 encoded data to a temporary file
+func writeDecodedTemp(encoded, pattern string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("decoding data: %w", err)
+	}
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("creating temporary file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("writing temporary file: %w", err)
+	}
+	return f.Name(), nil
+}
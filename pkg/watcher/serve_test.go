@@ -0,0 +1,118 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"cloud.google.com/go/pubsub"
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+)
+
+// fakeSubscription delivers a fixed list of messages
+type fakeSubscription struct {
+	messages [][]byte
+}
+
+func (fs *fakeSubscription) Receive(ctx context.Context, f func(context.Context, *pubsub.Message)) error {
+	for _, data := range fs.messages {
+		f(ctx, &pubsub.Message{Data: data})
+	}
+	return nil
+}
+
+func TestDecodeStartMessage(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString([]byte(`{}`))
+	message, err := DecodeStartMessage([]byte(
+		`{"spec":"github://org/repo/1","attestation":"` + encoded + `","artifacts_list":"gs://a,file:///b"}`,
+	))
+	require.NoError(t, err)
+	require.Equal(t, "github://org/repo/1", message.SpecURL)
+	require.Equal(t, []string{"gs://a", "file:///b"}, message.Artifacts)
+
+	for _, data := range []string{
+		`not json`,
+		`{"attestation":"` + encoded + `"}`,
+		`{"spec":"github://org/repo/1","snapshots":"not base64!"}`,
+	} {
+		_, err := DecodeStartMessage([]byte(data))
+		require.ErrorIs(t, err, errInvalidMessage, data)
+	}
+}
+
+func TestServe(t *testing.T) {
+	attested := []string{}
+	written := []string{}
+	s := &Server{
+		Attest: func(_ context.Context, m *StartMessage) (*attestation.Attestation, error) {
+			attested = append(attested, m.SpecURL)
+			if m.SpecURL == "github://org/repo/2" {
+				return nil, errors.New("run failed")
+			}
+			return attestation.New().SLSA(), nil
+		},
+		Output: func(m *StartMessage, _ *attestation.Attestation) error {
+			written = append(written, m.SpecURL)
+			return nil
+		},
+	}
+	sub := &fakeSubscription{messages: [][]byte{
+		[]byte(`{"spec":"github://org/repo/1"}`),
+		[]byte(`garbage`),
+		[]byte(`{"spec":"github://org/repo/2"}`),
+		[]byte(`{"spec":"github://org/repo/3","artifacts":["gs://bucket"]}`),
+	}}
+	require.NoError(t, s.Serve(context.Background(), sub))
+	require.Equal(t, []string{"github://org/repo/1", "github://org/repo/2", "github://org/repo/3"}, attested)
+	require.Equal(t, []string{"github://org/repo/1", "github://org/repo/3"}, written)
+
+	// Failed runs are reported to be retried, invalid messages are not
+	ctx := context.Background()
+	require.Error(t, s.handleMessage(ctx, []byte(`{"spec":"github://org/repo/2"}`)))
+	require.ErrorIs(t, s.handleMessage(ctx, []byte(`garbage`)), errInvalidMessage)
+
+	// Redelivered messages of attested runs are not attested again
+	require.NoError(t, s.handleMessage(ctx, []byte(`{"spec":"github://org/repo/1"}`)))
+	require.Equal(t, []string{"github://org/repo/1", "github://org/repo/3"}, written)
+	require.Len(t, attested, 4)
+
+	// Nor are runs already in the output
+	s.Done = func(m *StartMessage) (bool, error) { return m.SpecURL == "github://org/repo/4", nil }
+	require.NoError(t, s.handleMessage(ctx, []byte(`{"spec":"github://org/repo/4"}`)))
+	require.Len(t, attested, 4)
+}
+
+func TestServeContext(t *testing.T) {
+	s := NewServer(func(*StartMessage, *attestation.Attestation) error { return nil })
+	require.Equal(t, DefaultServeWatchTimeout+ServeLeaseMargin, s.MaxExtension())
+	s.WatchTimeout = 0
+	require.Equal(t, DefaultServeWatchTimeout+ServeLeaseMargin, s.MaxExtension())
+
+	// The context of the delivery reaches the attestation
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.Attest = func(ctx context.Context, _ *StartMessage) (*attestation.Attestation, error) {
+		return nil, ctx.Err()
+	}
+	err := s.handleMessage(ctx, []byte(`{"spec":"github://org/repo/1"}`))
+	require.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+// Build statuses recorded in the summary
+const (
+	BuildStatusSuccess = "success"
+	BuildStatusFailure = "failure"
+	BuildStatusRunning = "running"
+)
+
+// Summary is a machine readable digest of an attestation run,
+// intended for dashboards and CI logs
+type Summary struct {
+	SpecURL     string         `json:"specURL"`
+	BuilderID   string         `json:"builderID"`
+	BuildStatus string         `json:"buildStatus"`
+	Subjects    int            `json:"subjects"`
+	Materials   int            `json:"materials"`
+	Signed      bool           `json:"signed"`
+	Unchanged   bool           `json:"unchanged,omitempty"`
+	Stores      []StoreSummary `json:"stores"`
+	Timings     SummaryTimings `json:"timings"`
+}
+
+// StoreSummary records the artifacts collected from a store
+type StoreSummary struct {
+	SpecURL   string `json:"specURL"`
+	Artifacts int    `json:"artifacts"`
+
+	// Error is the reason the store was skipped when
+	// tolerating store errors
+	Error string `json:"error,omitempty"`
+}
+
+// SummaryTimings records when the run executed and how long
+// tejolote took to collect and attest its artifacts
+type SummaryTimings struct {
+	RunStart  time.Time `json:"runStart,omitempty"`
+	RunEnd    time.Time `json:"runEnd,omitempty"`
+	CollectMS int64     `json:"collectMs"`
+	AttestMS  int64     `json:"attestMs"`
+}
+
+// Summary returns the summary of the attestation of a run. It is
+// built from the state of the watcher after AttestRun.
+func (w *Watcher) Summary(r *run.Run, att *attestation.Attestation) *Summary {
+	summary := &Summary{
+		SpecURL:     r.SpecURL,
+		BuilderID:   att.Predicate.Builder.ID,
+		BuildStatus: BuildStatusFailure,
+		Subjects:    len(att.Subject),
+		Materials:   len(att.Predicate.Materials),
+		Stores:      append([]StoreSummary{}, w.storeSummaries...),
+		Timings: SummaryTimings{
+			RunStart:  r.StartTime,
+			RunEnd:    r.EndTime,
+			CollectMS: w.collectDuration.Milliseconds(),
+			AttestMS:  w.attestDuration.Milliseconds(),
+		},
+	}
+	switch {
+	case r.IsRunning:
+		summary.BuildStatus = BuildStatusRunning
+	case r.IsSuccess:
+		summary.BuildStatus = BuildStatusSuccess
+	}
+	return summary
+}
+
+// Write writes the summary as JSON to path. A dash
+// writes it to STDERR, keeping STDOUT for the attestation.
+func (s *Summary) Write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling summary: %w", err)
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stderr.Write(data)
+	} else {
+		err = os.WriteFile(path, data, os.FileMode(0o644))
+	}
+	if err != nil {
+		return fmt.Errorf("writing summary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+/*
+Copyright 2022 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package watcher
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/builder"
+	"sigs.k8s.io/tejolote/pkg/github"
+	"sigs.k8s.io/tejolote/pkg/run"
+)
+
+func TestSummary(t *testing.T) {
+	w := &Watcher{}
+	dirs := []string{t.TempDir(), t.TempDir()}
+	for i, files := range [][]string{{"a.txt", "b.txt"}, {"c.txt"}} {
+		for _, f := range files {
+			require.NoError(t, os.WriteFile(filepath.Join(dirs[i], f), []byte(f), os.FileMode(0o644)))
+		}
+		require.NoError(t, w.AddArtifactSource("file://"+dirs[i]))
+	}
+
+	r := &run.Run{
+		SpecURL:    "github://org/repo/1",
+		IsSuccess:  true,
+		SystemData: &github.Run{},
+	}
+	b, err := builder.New(r.SpecURL)
+	require.NoError(t, err)
+	w.Builder = b
+	require.NoError(t, w.CollectArtifacts(r))
+	att, err := w.AttestRun(r)
+	require.NoError(t, err)
+
+	summary := w.Summary(r, att)
+	require.Equal(t, len(att.Subject), summary.Subjects)
+	require.Equal(t, 3, summary.Subjects)
+	require.Equal(t, att.Predicate.Builder.ID, summary.BuilderID)
+	require.NotEmpty(t, summary.BuilderID)
+	require.Equal(t, BuildStatusSuccess, summary.BuildStatus)
+	require.Equal(t, []StoreSummary{
+		{SpecURL: "file://" + dirs[0], Artifacts: 2},
+		{SpecURL: "file://" + dirs[1], Artifacts: 1},
+	}, summary.Stores)
+
+	// The counts in the file match the attestation too
+	path := filepath.Join(t.TempDir(), "summary.json")
+	require.NoError(t, summary.Write(path))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	decoded := Summary{}
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	require.Equal(t, len(att.Subject), decoded.Subjects)
+	require.Len(t, decoded.Stores, 2)
+}
@@ -19,23 +19,29 @@ package watcher
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
-	intoto "github.com/in-toto/in-toto-golang/in_toto"
 	"github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/common"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/sync/errgroup"
 
 	"sigs.k8s.io/tejolote/pkg/attestation"
 	"sigs.k8s.io/tejolote/pkg/builder"
+	"sigs.k8s.io/tejolote/pkg/httpclient"
+	"sigs.k8s.io/tejolote/pkg/lockfile"
 	"sigs.k8s.io/tejolote/pkg/run"
 	"sigs.k8s.io/tejolote/pkg/store"
+	"sigs.k8s.io/tejolote/pkg/store/driver"
 	"sigs.k8s.io/tejolote/pkg/store/snapshot"
 )
 
@@ -44,18 +50,153 @@ type Watcher struct {
 	Builder          builder.Builder
 	ArtifactStores   []store.Store
 	Snapshots        []map[string]*snapshot.Snapshot
+	Baseline         *snapshot.Snapshot
 	Options          Options
+
+	// artifactOrigins records the store each collected artifact
+	// was read from, keyed by path
+	artifactOrigins map[string]store.Store
+
+	// storeSummaries, collectDuration and attestDuration
+	// record the last collection and attestation for the summary
+	storeSummaries  []StoreSummary
+	collectDuration time.Duration
+	attestDuration  time.Duration
+}
+
+// Policies applied to artifacts collected without a digest
+const (
+	// MissingDigestError fails the attestation
+	MissingDigestError = "error"
+	// MissingDigestSkip leaves the artifact out of the subjects
+	MissingDigestSkip = "skip"
+	// MissingDigestResolve asks the store of the artifact to
+	// resolve its digest, failing if it cannot
+	MissingDigestResolve = "resolve"
+)
+
+// MissingDigestPolicies returns the accepted missing digest policies
+func MissingDigestPolicies() []string {
+	return []string{MissingDigestError, MissingDigestSkip, MissingDigestResolve}
 }
 
 type Options struct {
 	WaitForBuild bool // When true, the watcher will keep observing the run until it's done
+
+	// GroupCompressionVariants annotates artifacts that are compressed
+	// forms of the same file (eg app, app.gz) with their group
+	GroupCompressionVariants bool
+
+	// Concurrency is the maximum number of artifact stores
+	// read at the same time
+	Concurrency int
+
+	// SubjectTransformer is the name of the transformer used to
+	// name the attestation subjects (see attestation.GetSubjectTransformer)
+	SubjectTransformer string
+
+	// PredicateTransform is a program and its arguments used to
+	// post-process the predicate (see attestation.PredicateTransformer)
+	PredicateTransform []string
+
+	// DependencySBOMs are paths or URLs of SPDX SBOMs whose
+	// packages are recorded as materials of the build
+	DependencySBOMs []string
+
+	// DependencyExternalRefs records the package manager and persistent
+	// ID external references of the dependency SBOM packages as
+	// additional materials pointing to their upstream locations
+	DependencyExternalRefs bool
+
+	// Lockfiles are paths to package manager lockfiles (see
+	// lockfile.Types) whose locked dependencies are recorded
+	// as materials of the build
+	Lockfiles []string
+
+	// Subjects are added to the attestation as is, they record
+	// artifacts pushed to places no store driver can read
+	Subjects []attestation.Subject
+
+	// MissingDigestPolicy controls what happens to artifacts
+	// without a digest (see MissingDigestPolicies). When empty,
+	// they are recorded as digestless subjects with a warning.
+	MissingDigestPolicy string
+
+	// LinkSBOMs pairs the collected artifacts with their SBOMs by
+	// name (eg app.tar.gz and app.tar.gz.spdx.json), see run.LinkSBOMs
+	LinkSBOMs bool
+
+	// SBOMMapping maps artifact paths to the path of their SBOM
+	// when it does not follow the naming convention. Setting it
+	// also enables LinkSBOMs.
+	SBOMMapping map[string]string
+
+	// PollInterval is the initial wait between checks of a
+	// running run. It doubles after each check up to MaxPollInterval.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+
+	// WatchTimeout limits the time spent waiting for runs to
+	// finish. Zero waits forever.
+	WatchTimeout time.Duration
+
+	// DisableNativeStore skips the artifact stores provided by the
+	// build system driver (eg the GCB artifact manifests), only the
+	// explicitly added stores are read
+	DisableNativeStore bool
+
+	// LinkName is the name of the step recorded in the in-toto
+	// links of the runs, it has to match the step in the layout.
+	// Defaults to DefaultLinkName.
+	LinkName string
+
+	// RecordRemoved records the artifacts in the baseline snapshot
+	// that are no longer in the stores in the predicate, under the
+	// removedArtifacts key of the invocation environment
+	RecordRemoved bool
+
+	// Annotations are key/value pairs recorded in the predicate
+	// (see attestation.SLSAPredicate.SetAnnotation). Annotations
+	// of the draft attestation are kept, these override them.
+	Annotations map[string]string
+
+	// Filter selects the collected artifacts by path, the ones left
+	// out do not become subjects (see run.PathFilter)
+	Filter run.PathFilter
+
+	// SnapshotMaxAge is the maximum age of the snapshot state loaded
+	// to continue an attestation. Zero does not check the age.
+	SnapshotMaxAge time.Duration
+
+	// TolerateStoreErrors skips the stores that fail when collecting
+	// artifacts instead of aborting. The failures are logged and
+	// recorded in the summary, at least one store has to succeed.
+	TolerateStoreErrors bool
+}
+
+// DefaultLinkName is the step name of links when none is set
+const DefaultLinkName = "build"
+
+// DefaultConcurrency is the default number of stores read in parallel
+const DefaultConcurrency = 4
+
+// Default wait times between checks of a running run
+const (
+	DefaultPollInterval    = 3 * time.Second
+	DefaultMaxPollInterval = 30 * time.Second
+)
+
+// DefaultOptions are the options of new watchers
+var DefaultOptions = Options{
+	WaitForBuild:    true, // By default we watch the build run
+	Concurrency:     DefaultConcurrency,
+	PollInterval:    DefaultPollInterval,
+	MaxPollInterval: DefaultMaxPollInterval,
 }
 
 func New(uri string) (w *Watcher, err error) {
 	w = &Watcher{
-		Options: Options{
-			WaitForBuild: true, // By default we watch the build run
-		},
+		Options: DefaultOptions,
 	}
 
 	// Get the builder
@@ -79,40 +220,90 @@ func (w *Watcher) GetRun(specURL string) (*run.Run, error) {
 
 // Watch watches a run, updating the run data as it runs
 func (w *Watcher) Watch(r *run.Run) error {
-	for {
-		if !r.IsRunning {
-			return nil
-		}
+	return w.WatchRuns([]*run.Run{r})
+}
+
+// WatchRuns watches several runs at the same time, returning when all
+// of them are done or when the watch timeout expires. Each run is
+// polled with its own backoff. Errors of all runs are returned joined.
+func (w *Watcher) WatchRuns(runs []*run.Run) error {
+	return w.WatchRunsContext(context.Background(), runs)
+}
+
+// WatchRunsContext is WatchRuns, also stopping when ctx is done
+func (w *Watcher) WatchRunsContext(ctx context.Context, runs []*run.Run) error {
+	if w.Options.WatchTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.Options.WatchTimeout)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(runs))
+	for i, r := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.watchRun(ctx, r); err != nil {
+				errs[i] = fmt.Errorf("watching %s: %w", r.SpecURL, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
 
+// watchRun refreshes a run until it stops running
+func (w *Watcher) watchRun(ctx context.Context, r *run.Run) error {
+	interval := w.Options.PollInterval
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	maxInterval := w.Options.MaxPollInterval
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+
+	log := w.Builder.Log().WithField("spec_url", r.SpecURL)
+	for r.IsRunning {
 		if !w.Options.WaitForBuild {
-			logrus.Warn("run is still running but watcher won't wait (WaitForBuild = false)")
+			log.Warn("run is still running but watcher won't wait (WaitForBuild = false)")
 		}
 
-		// Sleep to wait for a status change
 		if err := w.Builder.RefreshRun(r); err != nil {
 			return fmt.Errorf("refreshing run data: %w", err)
 		}
+		if !r.IsRunning {
+			break
+		}
 
-		// Sleep
-		time.Sleep(3 * time.Second)
+		// Wait to check again for a status change
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for run to finish: %w", ctx.Err())
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, maxInterval)
 	}
+	return nil
 }
 
 // LoadAttestation loads a partial attestation to complete
-// when a run finished running
+// when a run finished running. The path can also be a URL
+// (see readLocation).
 func (w *Watcher) LoadAttestation(path string) error {
 	if path == "" {
 		return nil
 	}
-	data, err := os.ReadFile(path)
+	data, err := readLocation(path)
 	if err != nil {
 		return fmt.Errorf("loading previous attestation: %w", err)
 	}
 
+	// Partial attestations can be written in any of the output formats
 	att := attestation.New().SLSA()
-
-	if err := json.Unmarshal(data, &att); err != nil {
-		return fmt.Errorf("unmarshaling attestation json: %w", err)
+	if err := att.Decode(data); err != nil {
+		return fmt.Errorf("decoding attestation: %w", err)
 	}
 
 	w.DraftAttestation = att
@@ -123,14 +314,22 @@ func (w *Watcher) LoadAttestation(path string) error {
 // AttestRun generates an attestation from a run tejolote can watch
 func (w *Watcher) AttestRun(r *run.Run) (att *attestation.Attestation, err error) {
 	if r.IsRunning {
-		logrus.Warn("run is still running, attestation may not capture en result")
+		w.Builder.Log().Warn("run is still running, attestation may not capture en result")
 	}
+	start := time.Now()
+	defer func() { w.attestDuration = time.Since(start) }()
 
 	att = attestation.New().SLSA()
 	if w.DraftAttestation != nil {
 		att = w.DraftAttestation
 	}
 
+	// Drivers replace the environment, save the draft annotations
+	annotations := att.Predicate.Annotations()
+	for k, v := range w.Options.Annotations {
+		annotations[k] = v
+	}
+
 	// Here, we need to check if its empty
 	pred := &att.Predicate
 	predicate, err := w.Builder.BuildPredicate(r, pred)
@@ -138,22 +337,347 @@ func (w *Watcher) AttestRun(r *run.Run) (att *attestation.Attestation, err error
 		return nil, fmt.Errorf("building predicate: %w", err)
 	}
 
+	for k, v := range annotations {
+		if err := predicate.SetAnnotation(k, v); err != nil {
+			return nil, fmt.Errorf("setting annotation %s: %w", k, err)
+		}
+	}
+
+	transform, err := attestation.GetSubjectTransformer(w.Options.SubjectTransformer)
+	if err != nil {
+		return nil, fmt.Errorf("getting subject transformer: %w", err)
+	}
+
 	// Add the run artifacts to the attestation
+	if err := w.addArtifactSubjects(att, r.Artifacts, transform); err != nil {
+		return nil, err
+	}
+	for _, s := range w.Options.Subjects {
+		att.Subject = appendSubject(att.Subject, s)
+	}
+
+	if len(r.RemovedArtifacts) > 0 {
+		if err := recordRemovedArtifacts(predicate, r.RemovedArtifacts, transform); err != nil {
+			return nil, fmt.Errorf("recording removed artifacts: %w", err)
+		}
+	}
+
+	// Source archives are built from a commit, record it as a material
 	for _, a := range r.Artifacts {
-		s := intoto.Subject{
-			Name:   a.Path,
-			Digest: common.DigestSet{},
+		uri, commit := a.Annotations[run.AnnotationSourceURI], a.Annotations[run.AnnotationSourceCommit]
+		if uri == "" || commit == "" || hasMaterial(predicate, uri) {
+			continue
 		}
-		for a, v := range a.Checksum {
-			s.Digest[a] = v
+		predicate.AddMaterial(uri, common.DigestSet{"sha1": commit})
+	}
+
+	// Import the packages listed in the dependency SBOMs
+	for _, uri := range w.Options.DependencySBOMs {
+		materials, err := sbomMaterials(uri, w.Options.DependencyExternalRefs)
+		if err != nil {
+			return nil, fmt.Errorf("reading dependencies from %s: %w", uri, err)
+		}
+		for _, m := range materials {
+			if hasMaterial(predicate, m.URI) {
+				continue
+			}
+			predicate.AddMaterial(m.URI, m.Digest)
+		}
+	}
+
+	// Record the dependencies locked in the lockfiles
+	for _, path := range w.Options.Lockfiles {
+		materials, err := lockfile.Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading dependencies from %s: %w", path, err)
+		}
+		for _, m := range materials {
+			if hasMaterial(predicate, m.URI) {
+				continue
+			}
+			predicate.AddMaterial(m.URI, m.Digest)
+		}
+	}
+
+	// Sort the lists to produce the same output on every run
+	predicate.SortMaterials()
+	attestation.SortSubjects(att.Subject)
+
+	if len(w.Options.PredicateTransform) > 0 {
+		transformer := attestation.PredicateTransformer{Command: w.Options.PredicateTransform}
+		predicate, err = transformer.Transform(predicate)
+		if err != nil {
+			return nil, fmt.Errorf("transforming predicate: %w", err)
 		}
-		att.Subject = append(att.Subject, s)
 	}
 
 	att.Predicate = *predicate
 	return att, nil
 }
 
+// addArtifactSubjects adds a subject to the attestation for each of
+// the artifacts, applying the missing digest policy to the ones
+// without checksums.
+func (w *Watcher) addArtifactSubjects(
+	att *attestation.Attestation, artifacts []run.Artifact, transform attestation.SubjectTransformer,
+) (err error) {
+	for _, a := range artifacts {
+		if len(a.Checksum) == 0 {
+			var keep bool
+			a, keep, err = w.handleMissingDigest(a)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				continue
+			}
+		}
+		s := attestation.Subject{
+			Name:        transform(a),
+			Digest:      common.DigestSet{},
+			Annotations: a.Annotations,
+		}
+		for a, v := range a.Checksum {
+			s.Digest[a] = v
+		}
+		att.Subject = appendSubject(att.Subject, s)
+	}
+	return nil
+}
+
+// mergedRunsKey is the invocation environment key where the builder
+// and invocation of the runs merged into the attestation are listed
+const mergedRunsKey = "mergedRuns"
+
+// mergedRun records a run merged into the attestation of another one
+type mergedRun struct {
+	SpecURL    string                    `json:"specURL"`
+	Builder    string                    `json:"builder"`
+	Invocation slsa.ProvenanceInvocation `json:"invocation"`
+}
+
+// MergeRun adds the artifacts of another run to an attestation so a
+// single statement covers several runs. The materials of the run are
+// combined with the ones already in the predicate and its builder and
+// invocation are listed under the mergedRuns key of the environment.
+// The run artifacts have to be collected before merging it.
+func (w *Watcher) MergeRun(att *attestation.Attestation, r *run.Run) error {
+	b, err := builder.New(r.SpecURL)
+	if err != nil {
+		return fmt.Errorf("getting builder for %s: %w", r.SpecURL, err)
+	}
+	b.VCSURL = w.Builder.VCSURL
+	predicate, err := b.BuildPredicate(r, nil)
+	if err != nil {
+		return fmt.Errorf("building predicate of %s: %w", r.SpecURL, err)
+	}
+
+	transform, err := attestation.GetSubjectTransformer(w.Options.SubjectTransformer)
+	if err != nil {
+		return fmt.Errorf("getting subject transformer: %w", err)
+	}
+	if err := w.addArtifactSubjects(att, r.Artifacts, transform); err != nil {
+		return err
+	}
+
+	for _, m := range predicate.Materials {
+		if !hasMaterial(&att.Predicate, m.URI) {
+			att.Predicate.AddMaterial(m.URI, m.Digest)
+		}
+	}
+	att.Predicate.SortMaterials()
+	attestation.SortSubjects(att.Subject)
+
+	env, err := att.Predicate.EnvironmentMap()
+	if err != nil {
+		return fmt.Errorf("reading invocation environment: %w", err)
+	}
+	runs, ok := env[mergedRunsKey].([]interface{})
+	if !ok {
+		runs = []interface{}{}
+	}
+	env[mergedRunsKey] = append(runs, mergedRun{
+		SpecURL:    r.SpecURL,
+		Builder:    predicate.Builder.ID,
+		Invocation: predicate.Invocation,
+	})
+	return nil
+}
+
+// removedArtifactsKey is the invocation environment key
+// listing the artifacts removed during the run
+const removedArtifactsKey = "removedArtifacts"
+
+// recordRemovedArtifacts lists the artifacts removed during the run in
+// the invocation environment, named and hashed like the subjects. SLSA
+// v0.2 has no field for them so they are kept next to the build data.
+func recordRemovedArtifacts(
+	predicate *attestation.SLSAPredicate, removed []run.Artifact, transform attestation.SubjectTransformer,
+) error {
+	env, ok := predicate.Invocation.Environment.(map[string]interface{})
+	if !ok {
+		return errors.New("invocation environment is not an object")
+	}
+	list := []attestation.Subject{}
+	for _, a := range removed {
+		list = append(list, attestation.Subject{
+			Name:   transform(a),
+			Digest: common.DigestSet(a.Checksum),
+		})
+	}
+	env[removedArtifactsKey] = list
+	return nil
+}
+
+// handleMissingDigest applies the missing digest policy to an
+// artifact without checksums. It returns the artifact, resolved if
+// needed, and false if it has to be left out of the attestation.
+func (w *Watcher) handleMissingDigest(a run.Artifact) (run.Artifact, bool, error) {
+	switch w.Options.MissingDigestPolicy {
+	case "":
+		w.Builder.Log().Warnf("artifact %s has no digest, subject will be recorded without one", a.Path)
+		return a, true, nil
+	case MissingDigestError:
+		return a, false, fmt.Errorf("artifact %s has no digest", a.Path)
+	case MissingDigestSkip:
+		w.Builder.Log().Warnf("skipping artifact %s as it has no digest", a.Path)
+		return a, false, nil
+	case MissingDigestResolve:
+		s, ok := w.artifactOrigins[a.Path]
+		if !ok {
+			return a, false, fmt.Errorf("unable to find the store of artifact %s to resolve its digest", a.Path)
+		}
+		digests, err := s.ResolveDigest(a)
+		if err != nil {
+			return a, false, fmt.Errorf("resolving digest of %s: %w", a.Path, err)
+		}
+		if len(digests) == 0 {
+			return a, false, fmt.Errorf("store %s returned no digest for %s", s.SpecURL, a.Path)
+		}
+		a.Checksum = digests
+		return a, true, nil
+	default:
+		return a, false, fmt.Errorf("unknown missing digest policy %q", w.Options.MissingDigestPolicy)
+	}
+}
+
+// sbomMaterials returns the packages in an SPDX SBOM as materials. The
+// SBOM is read with the spdx store driver, so it can be a local path or
+// any file://, gs:// or https:// URL the drivers can download. With
+// externalRefs, the upstream locations of each package listed in its
+// external references are returned too, with the package digest.
+func sbomMaterials(uri string, externalRefs bool) ([]common.ProvenanceMaterial, error) {
+	if !strings.Contains(uri, "://") {
+		uri = "file://" + uri
+	}
+	if externalRefs {
+		separator := "?"
+		if strings.Contains(uri, "?") {
+			separator = "&"
+		}
+		uri += separator + "external-refs=true"
+	}
+	s, err := store.New("spdx+" + uri)
+	if err != nil {
+		return nil, fmt.Errorf("creating sbom store: %w", err)
+	}
+	snap, err := s.Snap()
+	if err != nil {
+		return nil, fmt.Errorf("reading sbom packages: %w", err)
+	}
+
+	ids := []string{}
+	for id := range *snap {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	materials := []common.ProvenanceMaterial{}
+	for _, id := range ids {
+		a := (*snap)[id]
+		digest := common.DigestSet{}
+		for algo, value := range a.Checksum {
+			digest[algo] = value
+		}
+		materials = append(materials, common.ProvenanceMaterial{URI: a.Path, Digest: digest})
+		for _, location := range upstreamLocations(a) {
+			materials = append(materials, common.ProvenanceMaterial{URI: location, Digest: digest})
+		}
+	}
+	return materials, nil
+}
+
+// upstreamLocations returns the locators of the external references
+// of a package that identify the package itself (package manager
+// and persistent IDs), skipping the one used as its path
+func upstreamLocations(a run.Artifact) []string {
+	keys := []string{}
+	for key := range a.Annotations {
+		for _, category := range []string{"package-manager.", "persistent-id."} {
+			if strings.HasPrefix(key, run.AnnotationExternalRefPrefix+category) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	locations := []string{}
+	for _, key := range keys {
+		for _, locator := range strings.Fields(a.Annotations[key]) {
+			if locator != a.Path {
+				locations = append(locations, locator)
+			}
+		}
+	}
+	return locations
+}
+
+// hasMaterial returns true if the predicate already lists the material
+func hasMaterial(predicate *attestation.SLSAPredicate, uri string) bool {
+	for _, m := range predicate.Materials {
+		if m.URI == uri {
+			return true
+		}
+	}
+	return false
+}
+
+// appendSubject adds a subject to the list unless it is already there.
+// When a subject with the same name and matching digests exists, the
+// digest algorithms missing in it are merged from the new one.
+func appendSubject(subjects []attestation.Subject, s attestation.Subject) []attestation.Subject {
+	for i := range subjects {
+		if subjects[i].Name != s.Name || !digestsMatch(subjects[i].Digest, s.Digest) {
+			continue
+		}
+		if subjects[i].Digest == nil {
+			subjects[i].Digest = common.DigestSet{}
+		}
+		for algo, v := range s.Digest {
+			subjects[i].Digest[algo] = v
+		}
+		for k, v := range s.Annotations {
+			if subjects[i].Annotations == nil {
+				subjects[i].Annotations = map[string]string{}
+			}
+			if _, ok := subjects[i].Annotations[k]; !ok {
+				subjects[i].Annotations[k] = v
+			}
+		}
+		return subjects
+	}
+	return append(subjects, s)
+}
+
+// digestsMatch returns true if the digest sets don't have
+// a different value for any of the algorithms they share
+func digestsMatch(a, b common.DigestSet) bool {
+	for algo, v := range a {
+		if bv, ok := b[algo]; ok && bv != v {
+			return false
+		}
+	}
+	return true
+}
+
 // AddArtifactSource adds a new source to look for artifacts
 func (w *Watcher) AddArtifactSource(specURL string) error {
 	s, err := store.New(specURL)
@@ -167,74 +691,519 @@ func (w *Watcher) AddArtifactSource(specURL string) error {
 // CollectArtifacts queries the storage drivers attached to the run and
 // collects any artifacts found after the build is done
 func (w *Watcher) CollectArtifacts(r *run.Run) error {
+	start := time.Now()
+	defer func() { w.collectDuration = time.Since(start) }()
 	r.Artifacts = nil
-	artifactStores := w.ArtifactStores
-	// TODO: Support disabling the native driver
-	artifactStores = append(artifactStores, w.Builder.ArtifactStores()...)
-	for _, s := range artifactStores {
-		logrus.Infof("Collecting artifacts from %s", s.SpecURL)
-		artifacts, err := s.ReadArtifacts()
-		if err != nil {
-			return fmt.Errorf("collecting artfiacts from %s: %w", s.SpecURL, err)
-		}
+	artifactStores := w.collectionStores()
+
+	// Read the stores in parallel, results are kept in the order
+	// of the stores to produce deterministic output
+	var wg errgroup.Group
+	wg.SetLimit(w.concurrency())
+	var mtx sync.Mutex
+	results := make([][]run.Artifact, len(artifactStores))
+	errs := make([]error, len(artifactStores))
+	for i, s := range artifactStores {
+		i, s := i, s
+		wg.Go(func() error {
+			w.Builder.Log().WithField("store", s.SpecURL).Info("Collecting artifacts")
+			artifacts, err := s.ReadArtifacts()
+			if err != nil {
+				err = fmt.Errorf("collecting artfiacts from %s: %w", s.SpecURL, err)
+				if !w.Options.TolerateStoreErrors {
+					return err
+				}
+				w.Builder.Log().WithField("store", s.SpecURL).Warnf("Skipping failed store: %v", err)
+				errs[i] = err
+				return nil
+			}
+			mtx.Lock()
+			results[i] = artifacts
+			mtx.Unlock()
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return err
+	}
+	if err := errors.Join(errs...); err != nil && !hasSuccess(errs) {
+		return fmt.Errorf("all artifact stores failed: %w", err)
+	}
+	w.artifactOrigins = map[string]store.Store{}
+	w.storeSummaries = make([]StoreSummary, 0, len(results))
+	for i, artifacts := range results {
 		r.Artifacts = append(r.Artifacts, artifacts...)
+		summary := StoreSummary{SpecURL: artifactStores[i].SpecURL, Artifacts: len(artifacts)}
+		if errs[i] != nil {
+			summary.Error = errs[i].Error()
+		}
+		w.storeSummaries = append(w.storeSummaries, summary)
+		for _, a := range artifacts {
+			if _, ok := w.artifactOrigins[a.Path]; !ok {
+				w.artifactOrigins[a.Path] = artifactStores[i]
+			}
+		}
+	}
+
+	// If there is a baseline, only keep the artifacts that changed since
+	r.RemovedArtifacts = nil
+	if w.Baseline != nil {
+		current := snapshot.Snapshot{}
+		for _, a := range r.Artifacts {
+			current[a.Path] = a
+		}
+		r.Artifacts = w.Baseline.Delta(&current)
+		w.Builder.Log().Infof("%d artifacts changed since the baseline snapshot", len(r.Artifacts))
+		if w.Options.RecordRemoved {
+			r.RemovedArtifacts = w.Baseline.DeltaFull(&current).Removed
+			w.Builder.Log().Infof("%d artifacts removed since the baseline snapshot", len(r.RemovedArtifacts))
+		}
+	}
+
+	r.Artifacts = w.Options.Filter.Filter(r.Artifacts)
+	r.RemovedArtifacts = w.Options.Filter.Filter(r.RemovedArtifacts)
+
+	if w.Options.GroupCompressionVariants {
+		run.GroupCompressionVariants(r.Artifacts)
+	}
+	if w.Options.LinkSBOMs || len(w.Options.SBOMMapping) > 0 {
+		run.LinkSBOMs(r.Artifacts, w.Options.SBOMMapping)
 	}
-	logrus.Infof(
+	w.Builder.Log().Infof(
 		"Run produced %d artifacts collected from %d sources",
 		len(r.Artifacts), len(w.ArtifactStores),
 	)
 	return nil
 }
 
+// hasSuccess returns true if any of the stores did not fail
+func hasSuccess(errs []error) bool {
+	for _, err := range errs {
+		if err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// collectionStores returns the stores read when collecting the
+// artifacts of a run: those added to the watcher and, unless
+// disabled, the native stores of the build system
+func (w *Watcher) collectionStores() []store.Store {
+	artifactStores := append([]store.Store{}, w.ArtifactStores...)
+	if w.Options.DisableNativeStore {
+		w.Builder.Log().Info("Skipping the native artifact stores of the build system")
+	} else {
+		artifactStores = append(artifactStores, w.Builder.ArtifactStores()...)
+	}
+	return artifactStores
+}
+
+// DryRun refreshes the run data and snapshots the stores that would be
+// collected, without recording the snapshots. It returns the number of
+// artifacts in each store that could be read, the errors of the rest
+// are returned joined so all misconfigured stores are reported at once.
+// Hash caches are not saved and the stores are closed when done.
+func (w *Watcher) DryRun(r *run.Run) ([]StoreSummary, error) {
+	if err := w.Builder.RefreshRun(r); err != nil {
+		return nil, fmt.Errorf("refreshing run data: %w", err)
+	}
+
+	artifactStores := w.collectionStores()
+	for i := range artifactStores {
+		artifactStores[i].SetReadOnlyHashCache(true)
+	}
+	defer func() {
+		for i := range artifactStores {
+			if err := artifactStores[i].Close(); err != nil {
+				logrus.Warn(err)
+			}
+		}
+	}()
+	var wg errgroup.Group
+	wg.SetLimit(w.concurrency())
+	results := make([]*snapshot.Snapshot, len(artifactStores))
+	errs := make([]error, len(artifactStores))
+	for i, s := range artifactStores {
+		i, s := i, s
+		wg.Go(func() error {
+			snap, err := s.Snap()
+			if err != nil {
+				errs[i] = fmt.Errorf("snapshotting %s: %w", s.SpecURL, err)
+				return nil
+			}
+			results[i] = snap
+			return nil
+		})
+	}
+	_ = wg.Wait() //nolint: errcheck // Errors are recorded per store
+
+	summaries := []StoreSummary{}
+	for i, snap := range results {
+		if snap == nil {
+			continue
+		}
+		summaries = append(summaries, StoreSummary{
+			SpecURL: artifactStores[i].SpecURL, Artifacts: len(*snap),
+		})
+	}
+	return summaries, errors.Join(errs...)
+}
+
 // Snap adds a new snapshot set to the watcher by querying
 // each of the storage drivers
 func (w *Watcher) Snap() error {
-	snaps := map[string]*snapshot.Snapshot{}
 	for _, s := range w.ArtifactStores {
 		if s.SpecURL == "" {
 			return errors.New("artifact store has no spec url defined")
 		}
-		snap, err := s.Snap()
-		if err != nil {
-			return fmt.Errorf("snapshotting storage: %w", err)
-		}
-		snaps[s.SpecURL] = snap
+	}
+
+	var wg errgroup.Group
+	wg.SetLimit(w.concurrency())
+	var mtx sync.Mutex
+	snaps := map[string]*snapshot.Snapshot{}
+	for _, s := range w.ArtifactStores {
+		s := s
+		wg.Go(func() error {
+			snap, err := s.Snap()
+			if err != nil {
+				return fmt.Errorf("snapshotting storage: %w", err)
+			}
+			mtx.Lock()
+			snaps[s.SpecURL] = snap
+			mtx.Unlock()
+			return nil
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return err
 	}
 	// TODO: Add some metrics to measure snapshot time
 	w.Snapshots = append(w.Snapshots, snaps)
 	return nil
 }
 
+// SnapshotDelta returns the artifacts that were added or modified in
+// the stores between the last two snapshots
+func (w *Watcher) SnapshotDelta() []run.Artifact {
+	if len(w.Snapshots) < 2 {
+		return []run.Artifact{}
+	}
+	return w.snapshotSetDelta(w.Snapshots[len(w.Snapshots)-2], w.Snapshots[len(w.Snapshots)-1])
+}
+
+// snapshotSetDelta returns the artifacts added or modified in the
+// stores between two snapshot sets
+func (w *Watcher) snapshotSetDelta(before, after map[string]*snapshot.Snapshot) []run.Artifact {
+	artifacts := []run.Artifact{}
+	for _, s := range w.ArtifactStores {
+		previous := before[s.SpecURL]
+		if previous == nil {
+			previous = &snapshot.Snapshot{}
+		}
+		if after[s.SpecURL] == nil {
+			continue
+		}
+		artifacts = append(artifacts, previous.Delta(after[s.SpecURL])...)
+	}
+	return artifacts
+}
+
+// ToLink returns the in-toto link metadata of a run. The artifacts in
+// the stores when the first snapshot was taken are the materials of
+// the step and those added or modified when the last one was taken
+// are its products.
+func (w *Watcher) ToLink(r *run.Run) (*attestation.Link, error) {
+	if len(w.Snapshots) < 2 {
+		return nil, errors.New("links need the snapshots taken before and after the run")
+	}
+	transform, err := attestation.GetSubjectTransformer(w.Options.SubjectTransformer)
+	if err != nil {
+		return nil, fmt.Errorf("getting subject transformer: %w", err)
+	}
+	name := w.Options.LinkName
+	if name == "" {
+		name = DefaultLinkName
+	}
+
+	link := attestation.NewLink(name)
+	before, after := w.Snapshots[0], w.Snapshots[len(w.Snapshots)-1]
+	for _, s := range w.ArtifactStores {
+		if before[s.SpecURL] == nil {
+			continue
+		}
+		for _, a := range *before[s.SpecURL] {
+			if len(a.Checksum) == 0 {
+				w.Builder.Log().Warnf("material %s has no digest, leaving it out of the link", a.Path)
+				continue
+			}
+			link.AddMaterial(transform(a), a.Checksum)
+		}
+	}
+	for _, a := range w.snapshotSetDelta(before, after) {
+		if len(a.Checksum) == 0 {
+			w.Builder.Log().Warnf("product %s has no digest, leaving it out of the link", a.Path)
+			continue
+		}
+		link.AddProduct(transform(a), a.Checksum)
+	}
+	if r != nil && r.SpecURL != "" {
+		link.Environment["run"] = r.SpecURL
+	}
+	return link, nil
+}
+
+// concurrency returns the number of stores to read in parallel
+func (w *Watcher) concurrency() int {
+	if w.Options.Concurrency < 1 {
+		return 1
+	}
+	return w.Options.Concurrency
+}
+
+// LoadBaseline loads a snapshot from a file to use as the previous
+// state of the artifact stores. When a baseline is set, only artifacts
+// created or modified after it was captured are collected.
+func (w *Watcher) LoadBaseline(path string) error {
+	if path == "" {
+		return nil
+	}
+	rawData, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("opening baseline snapshot: %w", err)
+	}
+	snap := snapshot.Snapshot{}
+	if err := json.Unmarshal(rawData, &snap); err != nil {
+		return fmt.Errorf("unmarshaling baseline snapshot: %w", err)
+	}
+	w.Baseline = &snap
+	logrus.Infof("loaded baseline snapshot with %d artifacts from %s", len(snap), path)
+	return nil
+}
+
+// snapshotStateVersion is the version of the storage state file format
+const snapshotStateVersion = "v1"
+
+// snapshotState is the portable form of the storage snapshots written
+// to the state file. Snapshots are recorded in the same order as the
+// stores and matched by their canonical identifiers so that the state
+// can be restored on a different machine.
+type snapshotState struct {
+	Version   string                 `json:"version"`
+	Created   time.Time              `json:"created,omitempty"`
+	Stores    []storeState           `json:"stores"`
+	Snapshots [][]*snapshot.Snapshot `json:"snapshots"`
+
+	// Digest is the SHA256 hash of the rest of the state, it is
+	// checked when loading the file to catch edited or corrupt data
+	Digest string `json:"digest,omitempty"`
+}
+
+// computeDigest returns the hash of the state, without its digest
+func (state snapshotState) computeDigest() (string, error) {
+	state.Digest = ""
+	data, err := json.Marshal(state)
+	if err != nil {
+		return "", fmt.Errorf("marshaling snapshot state: %w", err)
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// verify checks the digest of the state and, if maxAge is not
+// zero, that it was not created more than maxAge ago
+func (state snapshotState) verify(maxAge time.Duration) error {
+	if state.Digest == "" {
+		logrus.Warn("snapshot state has no digest, its integrity cannot be checked")
+	} else {
+		digest, err := state.computeDigest()
+		if err != nil {
+			return err
+		}
+		if digest != state.Digest {
+			return fmt.Errorf(
+				"snapshot state digest mismatch (expected %s, got %s), the file was modified after it was written",
+				state.Digest, digest,
+			)
+		}
+	}
+	if maxAge == 0 {
+		return nil
+	}
+	if state.Created.IsZero() {
+		return errors.New("snapshot state has no creation time to check its age")
+	}
+	if age := time.Since(state.Created); age > maxAge {
+		return fmt.Errorf(
+			"snapshot state is stale, it was created %s ago (maximum age %s)",
+			age.Round(time.Second), maxAge,
+		)
+	}
+	return nil
+}
+
+// storeState records the identity of a store in the state file
+type storeState struct {
+	ID      string `json:"id"`
+	SpecURL string `json:"spec"`
+}
+
 // SaveSnapshots stores the current state of the storage locations
 // to a file which can be reused when continuing an attestation
 func (w *Watcher) SaveSnapshots(path string) error {
-	var b bytes.Buffer
-	enc := json.NewEncoder(&b)
-	enc.SetIndent("", "  ")
-	enc.SetEscapeHTML(false)
 	if len(w.Snapshots) == 0 {
 		logrus.Debug("no storage snapshots set, not saving file")
 		return nil
 	}
-	if err := enc.Encode(w.Snapshots); err != nil {
-		return fmt.Errorf("encoding snapshot data sbom: %w", err)
+
+	data, err := w.snapshotsJSON()
+	if err != nil {
+		return err
 	}
 
-	if err := os.WriteFile(path, b.Bytes(), os.FileMode(0o644)); err != nil {
+	if err := writeFilesAtomic(map[string][]byte{path: data}); err != nil {
 		return fmt.Errorf("writing file store state: %w", err)
 	}
 	return nil
 }
 
-// LoadSnapshots loads saved snapshot state from a file to continue
+// snapshotsJSON serializes the storage snapshots to the state file format
+func (w *Watcher) snapshotsJSON() ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+
+	state := snapshotState{
+		Version:   snapshotStateVersion,
+		Created:   time.Now().UTC(),
+		Stores:    []storeState{},
+		Snapshots: [][]*snapshot.Snapshot{},
+	}
+	for _, s := range w.ArtifactStores {
+		state.Stores = append(state.Stores, storeState{
+			ID:      s.CanonicalID(),
+			SpecURL: s.SpecURL,
+		})
+	}
+	for _, snapset := range w.Snapshots {
+		snaps := []*snapshot.Snapshot{}
+		for _, s := range w.ArtifactStores {
+			snaps = append(snaps, snapset[s.SpecURL])
+		}
+		state.Snapshots = append(state.Snapshots, snaps)
+	}
+
+	digest, err := state.computeDigest()
+	if err != nil {
+		return nil, err
+	}
+	state.Digest = digest
+
+	if err := enc.Encode(state); err != nil {
+		return nil, fmt.Errorf("encoding snapshot data sbom: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// WriteAttestation writes the attestation and, if snapshotPath is not
+// empty, the storage snapshot state. Both files are written to temporary
+// files first and then moved into place so that readers never find an
+// attestation without its snapshots or vice versa.
+func (w *Watcher) WriteAttestation(attestationPath string, attestationData []byte, snapshotPath string) error {
+	files := map[string][]byte{
+		attestationPath: attestationData,
+	}
+	if snapshotPath != "" && len(w.Snapshots) > 0 {
+		data, err := w.snapshotsJSON()
+		if err != nil {
+			return err
+		}
+		files[snapshotPath] = data
+	}
+	if err := writeFilesAtomic(files); err != nil {
+		return fmt.Errorf("writing attestation: %w", err)
+	}
+	return nil
+}
+
+// IsRemoteLocation returns true if the location of a saved attestation
+// or snapshot state is a URL instead of a local path
+func IsRemoteLocation(location string) bool {
+	return strings.Contains(location, "://")
+}
+
+// readLocation reads a local file or the data at a URL in any of the
+// schemes the store drivers can download (file, gs, http, https and
+// oci), optionally prefixed with intoto+
+func readLocation(location string) ([]byte, error) {
+	if !IsRemoteLocation(location) {
+		return os.ReadFile(location)
+	}
+	var b bytes.Buffer
+	if err := driver.DownloadURL(location, &b); err != nil {
+		return nil, fmt.Errorf("downloading %s: %w", location, err)
+	}
+	return b.Bytes(), nil
+}
+
+// LoadSnapshots loads saved snapshot state from a file or URL to continue
 func (w *Watcher) LoadSnapshots(path string) error {
 	if path == "" {
 		return nil
 	}
-	rawData, err := os.ReadFile(path)
+	rawData, err := readLocation(path)
 	if err != nil {
 		return fmt.Errorf("opening saved snapshot data: %w", err)
 	}
+
+	// Files written by earlier versions are a plain list of snapshot sets
+	if bytes.HasPrefix(bytes.TrimSpace(rawData), []byte("[")) {
+		return w.loadLegacySnapshots(rawData, path)
+	}
+
+	state := snapshotState{}
+	if err := json.Unmarshal(rawData, &state); err != nil {
+		return fmt.Errorf("unmarshaling snapshot data: %w", err)
+	}
+
+	if state.Version != snapshotStateVersion {
+		return fmt.Errorf("unsupported snapshot state version %q", state.Version)
+	}
+
+	if err := state.verify(w.Options.SnapshotMaxAge); err != nil {
+		return fmt.Errorf("verifying snapshot state %s: %w", path, err)
+	}
+
+	if err := w.checkStoreStateMatch(state.Stores); err != nil {
+		return fmt.Errorf("checking restored storage state: %w", err)
+	}
+
+	// Rebuild the snapshot sets keyed by the stores configured locally
+	snapData := []map[string]*snapshot.Snapshot{}
+	for i, snaps := range state.Snapshots {
+		if len(snaps) != len(w.ArtifactStores) {
+			return fmt.Errorf(
+				"snapshot set #%d has %d snapshots but %d stores are configured",
+				i, len(snaps), len(w.ArtifactStores),
+			)
+		}
+		snapset := map[string]*snapshot.Snapshot{}
+		for j, s := range w.ArtifactStores {
+			snapset[s.SpecURL] = snaps[j]
+		}
+		snapData = append(snapData, snapset)
+	}
+
+	w.Snapshots = snapData
+	logrus.Infof("loaded %d snapshot sets from %s", len(w.Snapshots), path)
+
+	return nil
+}
+
+// loadLegacySnapshots loads snapshot data saved as a list of
+// snapshot sets keyed by spec URL
+func (w *Watcher) loadLegacySnapshots(rawData []byte, path string) error {
 	snapData := []map[string]*snapshot.Snapshot{}
 	if err := json.Unmarshal(rawData, &snapData); err != nil {
 		return fmt.Errorf("unmarshaling snapshot data: %w", err)
@@ -252,6 +1221,28 @@ func (w *Watcher) LoadSnapshots(path string) error {
 	return nil
 }
 
+// checkStoreStateMatch checks that the stores recorded in a state file
+// match the stores configured in the watcher. Stores are matched in order
+// by their canonical IDs to avoid breaking on host specific paths.
+func (w *Watcher) checkStoreStateMatch(stores []storeState) error {
+	if len(stores) != len(w.ArtifactStores) {
+		return fmt.Errorf(
+			"the number of artifact stores in the watcher (%d) does not match the number in the stored set (%d)",
+			len(w.ArtifactStores), len(stores),
+		)
+	}
+
+	for i, s := range stores {
+		if w.ArtifactStores[i].CanonicalID() != s.ID {
+			return fmt.Errorf(
+				"store #%d in stored state (%s) does not match storage %s",
+				i, s.ID, w.ArtifactStores[i].SpecURL,
+			)
+		}
+	}
+	return nil
+}
+
 // checkSnapshotMatch checks that a snapshot set matches the configured
 // storage backends in the watcher. The snapshots need to match in order
 // and in the SpecURL
@@ -298,7 +1289,7 @@ func (w *Watcher) PublishToTopic(topicString string, message interface{}) (err e
 
 	client, err := pubsub.NewClient(ctx, parts[1])
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("creating pubsub client: %w", err)
 	}
 	defer client.Close()
 	topic := client.Topic(parts[3])
@@ -314,7 +1305,9 @@ func (w *Watcher) PublishToTopic(topicString string, message interface{}) (err e
 		return fmt.Errorf("marshalling message into json: %w", err)
 	}
 	logrus.Debugf("Message: %s", string(data))
-	if _, err := topic.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx); err != nil {
+	publishCtx, cancel := httpclient.WithTimeout(ctx)
+	defer cancel()
+	if _, err := topic.Publish(publishCtx, &pubsub.Message{Data: data}).Get(publishCtx); err != nil {
 		return fmt.Errorf("publishing to pubsub topic: %w", err)
 	}
 	logrus.Infof("pushed build data to topic %s", topicString)
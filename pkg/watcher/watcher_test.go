@@ -15,3 +15,804 @@ limitations under the License.
 */
 
 package watcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"sigs.k8s.io/tejolote/pkg/attestation"
+	"sigs.k8s.io/tejolote/pkg/builder"
+	"sigs.k8s.io/tejolote/pkg/github"
+	"sigs.k8s.io/tejolote/pkg/run"
+	"sigs.k8s.io/tejolote/pkg/store"
+	"sigs.k8s.io/tejolote/pkg/store/snapshot"
+)
+
+func TestLoadSnapshotsPortable(t *testing.T) {
+	// Create two workspaces with the same layout, simulating
+	// two different machines
+	workspaces := []string{}
+	for i := 0; i < 2; i++ {
+		dir, err := os.MkdirTemp("", "")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "out"), os.FileMode(0o755)))
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dir, "out", "test.txt"), []byte("test"), os.FileMode(0o644),
+		))
+		workspaces = append(workspaces, dir)
+	}
+
+	stateDir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(stateDir)
+	statePath := filepath.Join(stateDir, "state.storage-snap.json")
+
+	// Snapshot and save the state in the first workspace
+	w1 := &Watcher{}
+	require.NoError(t, w1.AddArtifactSource("file://"+filepath.Join(workspaces[0], "out")))
+	require.NoError(t, w1.Snap())
+	require.NoError(t, w1.SaveSnapshots(statePath))
+
+	// Load it under the second
+	w2 := &Watcher{}
+	specURL := "file://" + filepath.Join(workspaces[1], "out")
+	require.NoError(t, w2.AddArtifactSource(specURL))
+	require.NoError(t, w2.LoadSnapshots(statePath))
+	require.Len(t, w2.Snapshots, 1)
+	require.Contains(t, w2.Snapshots[0], specURL)
+	require.Contains(t, *w2.Snapshots[0][specURL], "test.txt")
+
+	// A different store must not match
+	w3 := &Watcher{}
+	require.NoError(t, w3.AddArtifactSource("file://"+filepath.Join(workspaces[1], "dist")))
+	require.Error(t, w3.LoadSnapshots(statePath))
+}
+
+func TestLoadSnapshotsIntegrity(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test.txt"), []byte("test"), os.FileMode(0o644)))
+	statePath := filepath.Join(t.TempDir(), "state.storage-snap.json")
+
+	w := &Watcher{}
+	require.NoError(t, w.AddArtifactSource("file://"+dir))
+	require.NoError(t, w.Snap())
+	require.NoError(t, w.SaveSnapshots(statePath))
+	data, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+
+	load := func(data []byte, maxAge time.Duration) error {
+		require.NoError(t, os.WriteFile(statePath, data, os.FileMode(0o644)))
+		w2 := &Watcher{Options: Options{SnapshotMaxAge: maxAge}}
+		require.NoError(t, w2.AddArtifactSource("file://"+dir))
+		return w2.LoadSnapshots(statePath)
+	}
+	require.NoError(t, load(data, 0))
+	require.NoError(t, load(data, time.Hour))
+
+	// Changing the recorded checksum breaks the digest
+	checksum := (*w.Snapshots[0]["file://"+dir])["test.txt"].Checksum["SHA256"]
+	tampered := bytes.ReplaceAll(data, []byte(checksum), []byte(strings.Repeat("0", len(checksum))))
+	require.NotEqual(t, data, tampered)
+	err = load(tampered, 0)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "digest mismatch")
+
+	// Stale states are rejected when there is a maximum age
+	state := snapshotState{}
+	require.NoError(t, json.Unmarshal(data, &state))
+	state.Created = time.Now().Add(-2 * time.Hour)
+	state.Digest, err = state.computeDigest()
+	require.NoError(t, err)
+	stale, err := json.Marshal(state)
+	require.NoError(t, err)
+	require.NoError(t, load(stale, 0))
+	err = load(stale, time.Hour)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "stale")
+}
+
+func TestCollectArtifactsBaseline(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "existing.txt"), []byte("test"), os.FileMode(0o644),
+	))
+
+	w := &Watcher{}
+	require.NoError(t, w.AddArtifactSource("file://"+dir))
+
+	// Capture the baseline and write it in tejolote's snapshot format
+	baseline, err := w.ArtifactStores[0].Snap()
+	require.NoError(t, err)
+	data, err := json.Marshal(baseline)
+	require.NoError(t, err)
+	baselineDir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(baselineDir)
+	baselinePath := filepath.Join(baselineDir, "baseline.json")
+	require.NoError(t, os.WriteFile(baselinePath, data, os.FileMode(0o644)))
+
+	// The build adds one file
+	require.NoError(t, os.WriteFile(
+		filepath.Join(dir, "new.txt"), []byte("new file"), os.FileMode(0o644),
+	))
+
+	require.NoError(t, w.LoadBaseline(baselinePath))
+	r := &run.Run{}
+	require.NoError(t, w.CollectArtifacts(r))
+	require.Len(t, r.Artifacts, 1)
+	require.Equal(t, "new.txt", r.Artifacts[0].Path)
+}
+
+func TestAttestRunRecordRemoved(t *testing.T) {
+	dir := t.TempDir()
+	for _, f := range []string{"kept.txt", "stale.txt"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f), []byte(f), os.FileMode(0o644)))
+	}
+	w := &Watcher{Options: Options{RecordRemoved: true}}
+	require.NoError(t, w.AddArtifactSource("file://"+dir))
+
+	baseline, err := w.ArtifactStores[0].Snap()
+	require.NoError(t, err)
+	w.Baseline = baseline
+
+	// The build cleans up a stale file
+	require.NoError(t, os.Remove(filepath.Join(dir, "stale.txt")))
+
+	r := &run.Run{SpecURL: "github://org/repo/1", SystemData: &github.Run{}}
+	require.NoError(t, w.CollectArtifacts(r))
+	require.Empty(t, r.Artifacts)
+	require.Len(t, r.RemovedArtifacts, 1)
+	require.Equal(t, "stale.txt", r.RemovedArtifacts[0].Path)
+
+	b, err := builder.New(r.SpecURL)
+	require.NoError(t, err)
+	w.Builder = b
+	att, err := w.AttestRun(r)
+	require.NoError(t, err)
+	require.Empty(t, att.Subject)
+
+	data, err := json.Marshal(att.Predicate.Invocation.Environment)
+	require.NoError(t, err)
+	env := struct {
+		Removed []attestation.Subject `json:"removedArtifacts"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &env))
+	require.Len(t, env.Removed, 1)
+	require.Equal(t, "stale.txt", env.Removed[0].Name)
+	require.Equal(t, (*baseline)["stale.txt"].Checksum["SHA256"], env.Removed[0].Digest["SHA256"])
+
+	// Removed artifacts are only recorded when enabled
+	w.Options.RecordRemoved = false
+	require.NoError(t, w.CollectArtifacts(r))
+	require.Empty(t, r.RemovedArtifacts)
+}
+
+func TestAttestRunAnnotations(t *testing.T) {
+	r := &run.Run{SpecURL: "github://org/repo/1", SystemData: &github.Run{}}
+	b, err := builder.New(r.SpecURL)
+	require.NoError(t, err)
+
+	// Annotations of the draft are kept when the driver rebuilds the environment
+	draft := attestation.New().SLSA()
+	require.NoError(t, draft.Predicate.SetAnnotation("ticket", "REL-1"))
+	require.NoError(t, draft.Predicate.SetAnnotation("env", "staging"))
+
+	w := &Watcher{
+		Builder:          b,
+		DraftAttestation: draft,
+		Options:          Options{Annotations: map[string]string{"env": "prod"}},
+	}
+	att, err := w.AttestRun(r)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"ticket": "REL-1", "env": "prod"}, att.Predicate.Annotations())
+}
+
+func TestMergeRun(t *testing.T) {
+	r1 := &run.Run{
+		SpecURL:    "github://org/repo/1",
+		SystemData: &github.Run{},
+		Artifacts: []run.Artifact{
+			{Path: "bin/a", Checksum: map[string]string{"SHA256": "aaaa"}},
+		},
+	}
+	r2 := &run.Run{
+		SpecURL:    "github://org/repo/2",
+		SystemData: &github.Run{},
+		Artifacts: []run.Artifact{
+			{Path: "bin/b", Checksum: map[string]string{"SHA256": "bbbb"}},
+			{Path: "bin/c", Checksum: map[string]string{"SHA256": "cccc"}},
+		},
+	}
+	b, err := builder.New(r1.SpecURL)
+	require.NoError(t, err)
+	w := &Watcher{Builder: b}
+
+	att, err := w.AttestRun(r1)
+	require.NoError(t, err)
+	require.NoError(t, w.MergeRun(att, r2))
+
+	names := []string{}
+	for _, s := range att.Subject {
+		names = append(names, s.Name)
+	}
+	require.ElementsMatch(t, []string{"bin/a", "bin/b", "bin/c"}, names)
+
+	data, err := json.Marshal(att.Predicate.Invocation.Environment)
+	require.NoError(t, err)
+	env := struct {
+		Runs []mergedRun `json:"mergedRuns"`
+	}{}
+	require.NoError(t, json.Unmarshal(data, &env))
+	require.Len(t, env.Runs, 1)
+	require.Equal(t, r2.SpecURL, env.Runs[0].SpecURL)
+	require.NotEmpty(t, env.Runs[0].Builder)
+}
+
+func TestCollectArtifactsConcurrent(t *testing.T) {
+	w := &Watcher{Options: Options{Concurrency: 3}}
+	for i := 0; i < 10; i++ {
+		dir, err := os.MkdirTemp("", "")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dir, fmt.Sprintf("file-%02d.txt", i)), []byte("test"), os.FileMode(0o644),
+		))
+		require.NoError(t, w.AddArtifactSource("file://"+dir))
+	}
+
+	require.NoError(t, w.Snap())
+	require.Len(t, w.Snapshots, 1)
+	require.Len(t, w.Snapshots[0], 10)
+
+	// Artifacts must be returned in the order of the stores
+	r := &run.Run{}
+	require.NoError(t, w.CollectArtifacts(r))
+	require.Len(t, r.Artifacts, 10)
+	for i, a := range r.Artifacts {
+		require.Equal(t, fmt.Sprintf("file-%02d.txt", i), a.Path)
+	}
+}
+
+func TestWriteAttestationAtomic(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	storeDir, err := os.MkdirTemp("", "")
+	require.NoError(t, err)
+	defer os.RemoveAll(storeDir)
+
+	w := &Watcher{}
+	require.NoError(t, w.AddArtifactSource("file://"+storeDir))
+	require.NoError(t, w.Snap())
+
+	// Both files are written
+	attPath := filepath.Join(dir, "attestation.json")
+	snapPath := filepath.Join(dir, "attestation.storage-snap.json")
+	require.NoError(t, w.WriteAttestation(attPath, []byte("{}"), snapPath))
+	require.FileExists(t, attPath)
+	require.FileExists(t, snapPath)
+
+	// If the snapshots cannot be moved into place, the
+	// attestation must not be left behind
+	attPath = filepath.Join(dir, "failed.json")
+	snapPath = filepath.Join(dir, "snapdir")
+	require.NoError(t, os.Mkdir(snapPath, os.FileMode(0o755)))
+	require.Error(t, w.WriteAttestation(attPath, []byte("{}"), snapPath))
+	require.NoFileExists(t, attPath)
+
+	// Failing to write the temporary files leaves nothing behind
+	attPath = filepath.Join(dir, "missing.json")
+	require.Error(t, w.WriteAttestation(attPath, []byte("{}"), filepath.Join(dir, "nodir", "snap.json")))
+	require.NoFileExists(t, attPath)
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+
+	// An existing attestation replaced before the failure is restored
+	attPath = filepath.Join(dir, "attestation.json")
+	require.NoError(t, os.WriteFile(attPath, []byte(`{"old":true}`), os.FileMode(0o644)))
+	require.Error(t, w.WriteAttestation(attPath, []byte("{}"), filepath.Join(dir, "snapdir")))
+	data, err := os.ReadFile(attPath)
+	require.NoError(t, err)
+	require.Equal(t, `{"old":true}`, string(data))
+
+	entries, err = os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 3)
+}
+
+func TestAttestRunDeduplicatesSubjects(t *testing.T) {
+	// Two stores mirroring the same file
+	w := &Watcher{}
+	for i := 0; i < 2; i++ {
+		dir, err := os.MkdirTemp("", "")
+		require.NoError(t, err)
+		defer os.RemoveAll(dir)
+		require.NoError(t, os.WriteFile(
+			filepath.Join(dir, "test.txt"), []byte("test"), os.FileMode(0o644),
+		))
+		require.NoError(t, w.AddArtifactSource("file://"+dir))
+	}
+	require.NoError(t, w.Snap())
+
+	r := &run.Run{
+		SpecURL:    "github://org/repo/1",
+		SystemData: &github.Run{},
+	}
+	require.NoError(t, w.CollectArtifacts(r))
+	require.Len(t, r.Artifacts, 2)
+
+	b, err := builder.New(r.SpecURL)
+	require.NoError(t, err)
+	w.Builder = b
+
+	// The same path hashed with another algorithm gets merged, while
+	// a different digest under the same name is kept apart
+	r.Artifacts = append(r.Artifacts,
+		run.Artifact{Path: "test.txt", Checksum: map[string]string{"SHA512": "abc"}},
+		run.Artifact{Path: "test.txt", Checksum: map[string]string{"SHA256": "def"}},
+	)
+
+	att, err := w.AttestRun(r)
+	require.NoError(t, err)
+	require.Len(t, att.Subject, 2)
+	require.Equal(t, "test.txt", att.Subject[0].Name)
+	require.Len(t, att.Subject[0].Digest, 2)
+	require.Equal(t, "abc", att.Subject[0].Digest["SHA512"])
+	require.Equal(t, "def", att.Subject[1].Digest["SHA256"])
+}
+
+func TestAttestRunSourceArchiveMaterials(t *testing.T) {
+	b, err := builder.New("github://org/repo/1")
+	require.NoError(t, err)
+	w := &Watcher{Builder: b}
+
+	annotations := map[string]string{
+		run.AnnotationSourceURI:    "git+https://github.com/org/repo",
+		run.AnnotationSourceCommit: "009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d",
+	}
+	r := &run.Run{
+		SpecURL:    "github://org/repo/1",
+		SystemData: &github.Run{},
+		Artifacts: []run.Artifact{
+			{Path: "repo-v1.0.0.zip", Checksum: map[string]string{"SHA256": "abc"}, Annotations: annotations},
+			{Path: "repo-v1.0.0.tar.gz", Checksum: map[string]string{"SHA256": "def"}, Annotations: annotations},
+			{Path: "app", Checksum: map[string]string{"SHA256": "123"}},
+		},
+	}
+
+	att, err := w.AttestRun(r)
+	require.NoError(t, err)
+	require.Len(t, att.Subject, 3)
+	require.Len(t, att.Predicate.Materials, 1)
+	require.Equal(t, "git+https://github.com/org/repo", att.Predicate.Materials[0].URI)
+	require.Equal(t, "009b8a3a9ccbb128af87f9b1c0f4c62e8a304f6d", att.Predicate.Materials[0].Digest["sha1"])
+}
+
+const dependencySBOM = `SPDXVersion: SPDX-2.3
+DataLicense: CC0-1.0
+SPDXID: SPDXRef-DOCUMENT
+DocumentName: deps
+DocumentNamespace: https://example.com/deps
+Creator: Tool: test
+
+PackageName: yaml
+SPDXID: SPDXRef-Package-yaml
+PackageVersion: 1.4.0
+PackageDownloadLocation: https://github.com/kubernetes-sigs/yaml
+FilesAnalyzed: false
+PackageChecksum: SHA256: 3a6b7f5c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f809102
+ExternalRef: PACKAGE-MANAGER purl pkg:golang/sigs.k8s.io/yaml@v1.4.0
+ExternalRef: SECURITY advisory https://github.com/advisories/GHSA-0000-0000-0001
+ExternalRef: PERSISTENT-ID swh swh:1:rev:309dc2a7d17ef4d2ce6b9d53b6e8c0f0c8a0b7d9
+
+PackageName: nochecksum
+SPDXID: SPDXRef-Package-nochecksum
+PackageDownloadLocation: NOASSERTION
+FilesAnalyzed: false
+`
+
+func TestAttestRunDependencySBOM(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "deps.spdx")
+	require.NoError(t, os.WriteFile(path, []byte(dependencySBOM), os.FileMode(0o644)))
+
+	b, err := builder.New("github://org/repo/1")
+	require.NoError(t, err)
+	w := &Watcher{Builder: b, Options: Options{DependencySBOMs: []string{path}}}
+	att, err := w.AttestRun(&run.Run{SpecURL: "github://org/repo/1", SystemData: &github.Run{}})
+	require.NoError(t, err)
+
+	require.Len(t, att.Predicate.Materials, 1)
+	require.Equal(t, "pkg:golang/sigs.k8s.io/yaml@v1.4.0", att.Predicate.Materials[0].URI)
+	require.Equal(t,
+		"3a6b7f5c9d0e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c5d6e7f809102",
+		att.Predicate.Materials[0].Digest["SHA256"],
+	)
+
+	// External references add the upstream locations of the packages
+	w.Options.DependencyExternalRefs = true
+	att, err = w.AttestRun(&run.Run{SpecURL: "github://org/repo/1", SystemData: &github.Run{}})
+	require.NoError(t, err)
+	require.Len(t, att.Predicate.Materials, 2)
+	require.Equal(t, "swh:1:rev:309dc2a7d17ef4d2ce6b9d53b6e8c0f0c8a0b7d9", att.Predicate.Materials[1].URI)
+	require.Equal(t, att.Predicate.Materials[0].Digest, att.Predicate.Materials[1].Digest)
+
+	// Lockfile dependencies already in the SBOM are not repeated
+	gosum := filepath.Join(t.TempDir(), "go.sum")
+	require.NoError(t, os.WriteFile(gosum, []byte(
+		"sigs.k8s.io/yaml v1.4.0 h1:Mk1wCc2gy/F0THH0TAp1QYyJNzRm2KCLy3o5ASXVI5E=\n"+
+			"github.com/sirupsen/logrus v1.9.3 h1:dueUQJ1C2q9oE3F7wvmSGAaVtTmUizReu6fjN8uqzbQ=\n",
+	), os.FileMode(0o644)))
+	w.Options.Lockfiles = []string{gosum}
+	att, err = w.AttestRun(&run.Run{SpecURL: "github://org/repo/1", SystemData: &github.Run{}})
+	require.NoError(t, err)
+	require.Len(t, att.Predicate.Materials, 3)
+	require.Equal(t, "pkg:golang/github.com/sirupsen/logrus@v1.9.3", att.Predicate.Materials[0].URI)
+	require.Equal(t, "pkg:golang/sigs.k8s.io/yaml@v1.4.0", att.Predicate.Materials[1].URI)
+
+	w.Options.DependencySBOMs = []string{filepath.Join(t.TempDir(), "missing.spdx")}
+	_, err = w.AttestRun(&run.Run{SpecURL: "github://org/repo/1", SystemData: &github.Run{}})
+	require.Error(t, err)
+}
+
+// digestlessDriver is a store driver that lists an artifact
+// without a digest and optionally resolves it
+type digestlessDriver struct {
+	digests map[string]string
+}
+
+func (d *digestlessDriver) Snap() (*snapshot.Snapshot, error) {
+	return &snapshot.Snapshot{
+		"image": run.Artifact{Path: "oci://registry/image:v1", Checksum: map[string]string{}},
+	}, nil
+}
+
+func (d *digestlessDriver) ResolveDigest(string) (map[string]string, error) {
+	return d.digests, nil
+}
+
+// noResolveDriver lists digestless artifacts but can't resolve them
+type noResolveDriver struct{}
+
+func (d *noResolveDriver) Snap() (*snapshot.Snapshot, error) {
+	return (&digestlessDriver{}).Snap()
+}
+
+func TestAttestRunStableOrder(t *testing.T) {
+	b, err := builder.New("github://org/repo/1")
+	require.NoError(t, err)
+	w := &Watcher{Builder: b}
+
+	var first []byte
+	for i := 0; i < 5; i++ {
+		// Stores and drivers may return the same data in any order
+		artifacts := []run.Artifact{
+			{Path: "b.txt", Checksum: map[string]string{"SHA256": "bb"}},
+			{Path: "a.txt", Checksum: map[string]string{"SHA256": "aa", "SHA512": "aaaa"}},
+			{Path: "c.txt", Checksum: map[string]string{"SHA256": "cc"}},
+		}
+		rand.Shuffle(len(artifacts), func(i, j int) { artifacts[i], artifacts[j] = artifacts[j], artifacts[i] })
+		draft := attestation.New().SLSA()
+		materials := []string{"pkg:npm/b@1.0.0", "git+https://github.com/org/repo", "pkg:npm/a@1.0.0"}
+		rand.Shuffle(len(materials), func(i, j int) { materials[i], materials[j] = materials[j], materials[i] })
+		for _, m := range materials {
+			draft.Predicate.AddMaterial(m, map[string]string{"sha1": "00"})
+		}
+		w.DraftAttestation = draft
+
+		att, err := w.AttestRun(&run.Run{SpecURL: "github://org/repo/1", SystemData: &github.Run{}, Artifacts: artifacts})
+		require.NoError(t, err)
+		require.Equal(t, "a.txt", att.Subject[0].Name)
+		require.Equal(t, "c.txt", att.Subject[2].Name)
+		require.Equal(t, "git+https://github.com/org/repo", att.Predicate.Materials[0].URI)
+		require.Equal(t, "pkg:npm/b@1.0.0", att.Predicate.Materials[2].URI)
+
+		data, err := att.ToJSON()
+		require.NoError(t, err)
+		if first == nil {
+			first = data
+		}
+		require.Equal(t, string(first), string(data))
+	}
+}
+
+func TestAttestRunMissingDigest(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		driver   store.Implementation
+		mustErr  bool
+		subjects int
+		digest   string
+	}{
+		{"", &digestlessDriver{}, false, 1, ""},
+		{MissingDigestError, &digestlessDriver{}, true, 0, ""},
+		{MissingDigestSkip, &digestlessDriver{}, false, 0, ""},
+		{MissingDigestResolve, &digestlessDriver{digests: map[string]string{"SHA256": "abc"}}, false, 1, "abc"},
+		{MissingDigestResolve, &digestlessDriver{}, true, 0, ""},
+		{MissingDigestResolve, &noResolveDriver{}, true, 0, ""},
+		{"unknown", &digestlessDriver{}, true, 0, ""},
+	} {
+		w := &Watcher{
+			ArtifactStores: []store.Store{{SpecURL: "fake://store", Driver: tc.driver}},
+			Options:        Options{MissingDigestPolicy: tc.policy},
+		}
+		r := &run.Run{SpecURL: "github://org/repo/1", SystemData: &github.Run{}}
+		require.NoError(t, w.CollectArtifacts(r))
+
+		b, err := builder.New(r.SpecURL)
+		require.NoError(t, err)
+		w.Builder = b
+
+		att, err := w.AttestRun(r)
+		if tc.mustErr {
+			require.Error(t, err, tc.policy)
+			continue
+		}
+		require.NoError(t, err, tc.policy)
+		require.Len(t, att.Subject, tc.subjects, tc.policy)
+		if tc.digest != "" {
+			require.Equal(t, tc.digest, att.Subject[0].Digest["SHA256"])
+		}
+	}
+}
+
+// pollingDriver is a build system whose runs finish after
+// being refreshed a number of times
+type pollingDriver struct {
+	mtx     sync.Mutex
+	pending map[string]int
+}
+
+func (d *pollingDriver) GetRun(string) (*run.Run, error) { return nil, nil }
+
+func (d *pollingDriver) RefreshRun(r *run.Run) error {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	d.pending[r.SpecURL]--
+	r.IsRunning = d.pending[r.SpecURL] > 0
+	return nil
+}
+
+func (d *pollingDriver) BuildPredicate(*run.Run, *attestation.SLSAPredicate) (*attestation.SLSAPredicate, error) {
+	return nil, nil
+}
+
+func (d *pollingDriver) ArtifactStores() []store.Store { return nil }
+
+func TestWatchRuns(t *testing.T) {
+	interval := 20 * time.Millisecond
+	d := &pollingDriver{pending: map[string]int{"fast": 5, "slow": 10}}
+	w := &Watcher{
+		Builder: builder.NewWithDriver("mock://", d),
+		Options: Options{PollInterval: interval, MaxPollInterval: interval},
+	}
+	runs := []*run.Run{
+		{SpecURL: "fast", IsRunning: true},
+		{SpecURL: "slow", IsRunning: true},
+	}
+
+	// Watching both runs takes as long as the slower one (9 waits),
+	// watching them one after the other would take 13
+	start := time.Now()
+	require.NoError(t, w.WatchRuns(runs))
+	require.Less(t, time.Since(start), 12*interval)
+	require.False(t, runs[0].IsRunning)
+	require.False(t, runs[1].IsRunning)
+
+	// Runs that don't finish in time make the watch fail
+	d.pending["stuck"] = 1000
+	w.Options.WatchTimeout = 3 * interval
+	err := w.WatchRuns([]*run.Run{{SpecURL: "stuck", IsRunning: true}})
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+// nativeStoreDriver is a build system with its own artifact store
+type nativeStoreDriver struct {
+	pollingDriver
+	native *countingDriver
+}
+
+func (d *nativeStoreDriver) ArtifactStores() []store.Store {
+	return []store.Store{{SpecURL: "native://store", Driver: d.native}}
+}
+
+// countingDriver is a store driver that counts its reads
+type countingDriver struct {
+	path  string
+	reads int
+}
+
+func (d *countingDriver) Snap() (*snapshot.Snapshot, error) {
+	d.reads++
+	return &snapshot.Snapshot{
+		d.path: run.Artifact{Path: d.path, Checksum: map[string]string{"SHA256": "abc"}},
+	}, nil
+}
+
+func TestCollectArtifactsNativeStore(t *testing.T) {
+	native := &countingDriver{path: "native.txt"}
+	explicit := &countingDriver{path: "explicit.txt"}
+	w := &Watcher{
+		Builder:        builder.NewWithDriver("mock://", &nativeStoreDriver{native: native}),
+		ArtifactStores: []store.Store{{SpecURL: "explicit://store", Driver: explicit}},
+	}
+
+	r := &run.Run{}
+	require.NoError(t, w.CollectArtifacts(r))
+	require.Len(t, r.Artifacts, 2)
+	require.Equal(t, 1, native.reads)
+	require.Len(t, w.ArtifactStores, 1)
+
+	// Only the explicit stores are read when disabled
+	w.Options.DisableNativeStore = true
+	require.NoError(t, w.CollectArtifacts(r))
+	require.Len(t, r.Artifacts, 1)
+	require.Equal(t, "explicit.txt", r.Artifacts[0].Path)
+	require.Equal(t, 1, native.reads)
+	require.Equal(t, 2, explicit.reads)
+}
+
+func TestToLink(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main"), os.FileMode(0o644)))
+
+	w := &Watcher{}
+	require.NoError(t, w.AddArtifactSource("file://"+dir))
+	require.NoError(t, w.Snap())
+
+	// Links need the snapshots from both sides of the run
+	_, err := w.ToLink(&run.Run{})
+	require.Error(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app"), []byte("binary"), os.FileMode(0o755)))
+	require.NoError(t, w.Snap())
+
+	link, err := w.ToLink(&run.Run{SpecURL: "gcb://project/build"})
+	require.NoError(t, err)
+	require.Equal(t, attestation.LinkType, link.Type)
+	require.Equal(t, DefaultLinkName, link.Name)
+	require.Equal(t, "gcb://project/build", link.Environment["run"])
+
+	require.Len(t, link.Materials, 1)
+	require.Contains(t, link.Materials, "main.go")
+	require.Len(t, link.Products, 1)
+	product, ok := link.Products["app"].(map[string]interface{})
+	require.True(t, ok)
+	require.Equal(t, (*w.Snapshots[1][w.ArtifactStores[0].SpecURL])["app"].Checksum["SHA256"], product["sha256"])
+}
+
+func TestLoadFromURL(t *testing.T) {
+	dir := t.TempDir()
+	att := attestation.New().SLSA()
+	att.Predicate.BuildType = "https://example.com/build@v1"
+	data, err := att.ToJSON()
+	require.NoError(t, err)
+	attPath := filepath.Join(dir, "partial.intoto.json")
+	require.NoError(t, os.WriteFile(attPath, data, os.FileMode(0o644)))
+
+	w := &Watcher{}
+	require.NoError(t, w.LoadAttestation("intoto+file://"+attPath))
+	require.NotNil(t, w.DraftAttestation)
+	require.Equal(t, "https://example.com/build@v1", w.DraftAttestation.Predicate.BuildType)
+
+	out := filepath.Join(dir, "out")
+	require.NoError(t, os.Mkdir(out, os.FileMode(0o755)))
+	require.NoError(t, os.WriteFile(filepath.Join(out, "test.txt"), []byte("test"), os.FileMode(0o644)))
+	require.NoError(t, w.AddArtifactSource("file://"+out))
+	require.NoError(t, w.Snap())
+	statePath := filepath.Join(dir, "partial.storage-snap.json")
+	require.NoError(t, w.SaveSnapshots(statePath))
+
+	w2 := &Watcher{}
+	require.NoError(t, w2.AddArtifactSource("file://"+out))
+	require.NoError(t, w2.LoadSnapshots("file://"+statePath))
+	require.Len(t, w2.Snapshots, 1)
+
+	require.Error(t, w2.LoadAttestation("file://"+filepath.Join(dir, "missing.json")))
+}
+
+// failingDriver is a store driver that cannot be read
+type failingDriver struct{}
+
+func (failingDriver) Snap() (*snapshot.Snapshot, error) {
+	return nil, errors.New("access denied")
+}
+
+func TestCollectArtifactsTolerateStoreErrors(t *testing.T) {
+	w := &Watcher{
+		Options: Options{DisableNativeStore: true},
+		ArtifactStores: []store.Store{
+			{SpecURL: "broken://store", Driver: failingDriver{}},
+			{SpecURL: "explicit://store", Driver: &countingDriver{path: "explicit.txt"}},
+		},
+	}
+
+	// By default a failing store aborts the collection
+	r := &run.Run{}
+	err := w.CollectArtifacts(r)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "broken://store")
+
+	// When tolerated, the failing store is skipped and recorded
+	w.Options.TolerateStoreErrors = true
+	require.NoError(t, w.CollectArtifacts(r))
+	require.Len(t, r.Artifacts, 1)
+	require.Equal(t, "explicit.txt", r.Artifacts[0].Path)
+	require.Len(t, w.storeSummaries, 2)
+	require.Contains(t, w.storeSummaries[0].Error, "broken://store")
+	require.Equal(t, StoreSummary{SpecURL: "explicit://store", Artifacts: 1}, w.storeSummaries[1])
+
+	// At least one store has to succeed
+	w.ArtifactStores = w.ArtifactStores[:1]
+	err = w.CollectArtifacts(r)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "all artifact stores failed")
+}
+
+// closingDriver is a store driver that records being closed
+type closingDriver struct {
+	countingDriver
+	closed bool
+}
+
+func (d *closingDriver) Close() error {
+	d.closed = true
+	return nil
+}
+
+func TestDryRun(t *testing.T) {
+	native := &countingDriver{path: "native.txt"}
+	d := &nativeStoreDriver{pollingDriver: pollingDriver{pending: map[string]int{}}, native: native}
+	closing := &closingDriver{countingDriver: countingDriver{path: "closing.txt"}}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app"), []byte("binary"), os.FileMode(0o755)))
+	cachePath := filepath.Join(t.TempDir(), "hashes.json")
+	dirStore, err := store.New("file://" + dir + "?hash-cache=" + cachePath)
+	require.NoError(t, err)
+	w := &Watcher{
+		Builder: builder.NewWithDriver("mock://", d),
+		ArtifactStores: []store.Store{
+			{SpecURL: "explicit://store", Driver: &countingDriver{path: "explicit.txt"}},
+			{SpecURL: "broken://store", Driver: failingDriver{}},
+			{SpecURL: "closing://store", Driver: closing},
+			dirStore,
+		},
+	}
+
+	r := &run.Run{SpecURL: "mock://run", IsRunning: true}
+	stores, err := w.DryRun(r)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "broken://store")
+	require.False(t, r.IsRunning)
+
+	// The readable stores are reported, nothing is recorded
+	require.Equal(t, []StoreSummary{
+		{SpecURL: "explicit://store", Artifacts: 1},
+		{SpecURL: "closing://store", Artifacts: 1},
+		{SpecURL: dirStore.SpecURL, Artifacts: 1},
+		{SpecURL: "native://store", Artifacts: 1},
+	}, stores)
+	require.Empty(t, w.Snapshots)
+
+	// The stores are closed and their hash caches left untouched
+	require.True(t, closing.closed)
+	require.NoFileExists(t, cachePath)
+}